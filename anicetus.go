@@ -2,7 +2,9 @@ package anicetus
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 )
 
 // Anicetus orchestrates the thundering herd detection and gatekeeping.
@@ -22,47 +24,125 @@ func NewAnicetus[F Fingerprinter](detector Detector, gatekeeperStorage Gatekeepe
 }
 
 // Evaluate checks if the request is a thundering herd and if it is, it will
-// gatekeep it.
-func (t Anicetus[F]) Evaluate(ctx context.Context, f F) (Status, error) {
+// gatekeep it. When the request is chosen to be processed (StatusProcess), a
+// fencing token is returned that must be informed on RequestDone or Cleanup.
+//
+// When Evaluate doesn't return an error, RequestFinished must be called once
+// the request completes, whatever the status.
+func (t Anicetus[F]) Evaluate(ctx context.Context, f F) (Status, FencingToken, error) {
 	fingerprint := f.Fingerprint()
 
+	inFlightDetector, ok := As[InFlightDetector](t.detector)
+	if !ok {
+		return t.evaluate(ctx, fingerprint)
+	}
+
+	if err := inFlightDetector.RequestStarted(ctx, fingerprint); err != nil {
+		return StatusFailed, 0, fmt.Errorf("failed to start tracking request: %w", err)
+	}
+
+	status, token, err := t.evaluate(ctx, fingerprint)
+	if err != nil {
+		// the caller isn't expected to finish a request that failed the evaluation
+		if finishErr := inFlightDetector.RequestFinished(context.WithoutCancel(ctx), fingerprint); finishErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to finish tracking request: %w", finishErr))
+		}
+	}
+	return status, token, err
+}
+
+// evaluate checks the detector and the gatekeeper for the fingerprint.
+func (t Anicetus[F]) evaluate(ctx context.Context, fingerprint Fingerprint) (Status, FencingToken, error) {
 	cooldown, err := t.detector.IsCoolDown(ctx, fingerprint)
 	if err != nil {
-		return StatusFailed, fmt.Errorf("failed to check if fingerprint is in cooldown: %w", err)
+		return StatusFailed, 0, fmt.Errorf("failed to check if fingerprint is in cooldown: %w", err)
 	} else if cooldown {
-		return StatusOpenGates, nil
+		return StatusOpenGates, 0, nil
 	}
 
 	thunderingHerd, err := t.detector.IsThunderingHerd(ctx, fingerprint)
 	if err != nil {
-		return StatusFailed, fmt.Errorf("failed to check if fingerprint is a thundering herd: %w", err)
+		return StatusFailed, 0, fmt.Errorf("failed to check if fingerprint is a thundering herd: %w", err)
 	} else if !thunderingHerd {
 		// if the thundering herd is not detected, we can open the gates
-		if err := t.gatekeeper.Remove(ctx, fingerprint); err != nil {
-			return StatusFailed, fmt.Errorf("failed to remove fingerprint: %w", err)
+		if err := t.gatekeeper.Remove(ctx, fingerprint, 0); err != nil {
+			return StatusFailed, 0, fmt.Errorf("failed to remove fingerprint: %w", err)
 		}
-		return StatusOpenGates, nil
+		return StatusOpenGates, 0, nil
 	}
 
 	return t.gatekeeper.analyze(ctx, fingerprint)
 }
 
 // RequestDone will mark the request as done. This should be called after the
-// request is processed.
-func (t Anicetus[F]) RequestDone(ctx context.Context, f F) error {
-	if err := t.gatekeeper.Store(ctx, f.Fingerprint(), true); err != nil {
-		return fmt.Errorf("failed to store fingerprint: %w", err)
+// request is processed, using the fencing token returned by Evaluate. If the
+// gate was taken over by a newer leader or removed in the meantime, a
+// StaleTokenError is returned and the gate is left untouched. The fingerprint
+// is cooled down anyway, as the request was processed.
+func (t Anicetus[F]) RequestDone(ctx context.Context, f F, token FencingToken) error {
+	var staleTokenErr *StaleTokenError
+	storeErr := t.gatekeeper.Store(ctx, f.Fingerprint(), true, token)
+	if storeErr != nil && !errors.As(storeErr, &staleTokenErr) {
+		return fmt.Errorf("failed to store fingerprint: %w", storeErr)
 	}
 	if err := t.detector.CoolDown(ctx, f.Fingerprint()); err != nil {
-		return fmt.Errorf("failed to cooldown fingerprint: %w", err)
+		return errors.Join(storeErr, fmt.Errorf("failed to cooldown fingerprint: %w", err))
+	}
+	if storeErr != nil {
+		return fmt.Errorf("failed to store fingerprint: %w", storeErr)
+	}
+	return nil
+}
+
+// RequestFinished notifies the detector that the request completed, whatever
+// the status returned by Evaluate. It must be called for every request whose
+// evaluation didn't fail, so detectors implementing InFlightDetector can keep
+// track of the requests in flight. It does nothing for other detectors.
+func (t Anicetus[F]) RequestFinished(ctx context.Context, f F) error {
+	inFlightDetector, ok := As[InFlightDetector](t.detector)
+	if !ok {
+		return nil
+	}
+	if err := inFlightDetector.RequestFinished(ctx, f.Fingerprint()); err != nil {
+		return fmt.Errorf("failed to finish tracking request: %w", err)
+	}
+	return nil
+}
+
+// ReportOutcome informs the detector of the outcome of a request processed by
+// the backend, whatever the status returned by Evaluate. It does nothing for
+// detectors not implementing OutcomeDetector.
+func (t Anicetus[F]) ReportOutcome(ctx context.Context, f F, outcome Outcome) error {
+	outcomeDetector, ok := As[OutcomeDetector](t.detector)
+	if !ok {
+		return nil
+	}
+	if err := outcomeDetector.ReportOutcome(ctx, f.Fingerprint(), outcome); err != nil {
+		return fmt.Errorf("failed to report outcome: %w", err)
+	}
+	return nil
+}
+
+// PushSignal informs the detector of a load signal reported by the backend,
+// such as a cold cache or saturated resources. It does nothing for detectors
+// not implementing SignalDetector.
+func (t Anicetus[F]) PushSignal(ctx context.Context, f F, signal Signal) error {
+	signalDetector, ok := As[SignalDetector](t.detector)
+	if !ok {
+		return nil
+	}
+	if err := signalDetector.PushSignal(ctx, f.Fingerprint(), signal); err != nil {
+		return fmt.Errorf("failed to push signal: %w", err)
 	}
 	return nil
 }
 
 // Cleanup will remove the fingerprint from the storage. This should be called
-// in case there is some error while processing the request.
-func (t Anicetus[F]) Cleanup(ctx context.Context, f F) error {
-	if err := t.gatekeeper.Remove(ctx, f.Fingerprint()); err != nil {
+// in case there is some error while processing the request, using the fencing
+// token returned by Evaluate. If the gate was taken over by a newer leader, a
+// StaleTokenError is returned and the gate is left untouched.
+func (t Anicetus[F]) Cleanup(ctx context.Context, f F, token FencingToken) error {
+	if err := t.gatekeeper.Remove(ctx, f.Fingerprint(), token); err != nil {
 		return fmt.Errorf("failed to remove fingerprint: %w", err)
 	}
 	return nil
@@ -75,6 +155,86 @@ type Detector interface {
 	IsThunderingHerd(context.Context, Fingerprint) (bool, error)
 }
 
+// InFlightDetector is an optional interface that a Detector can implement to
+// track the requests in flight. Anicetus notifies the detector when each
+// request starts to be evaluated and when it finishes (see
+// Anicetus.RequestFinished), before asking if it is a thundering herd.
+type InFlightDetector interface {
+	Detector
+
+	// RequestStarted is called for every request before evaluating it.
+	RequestStarted(context.Context, Fingerprint) error
+	// RequestFinished is called once for every started request when it
+	// completes.
+	RequestFinished(context.Context, Fingerprint) error
+}
+
+// Outcome is the result of a request processed by the backend.
+type Outcome struct {
+	// Latency is the time the backend took to answer the request.
+	Latency time.Duration
+	// Failed is true when the backend couldn't answer the request.
+	Failed bool
+}
+
+// OutcomeDetector is an optional interface that a Detector can implement to
+// receive the outcome of the requests processed by the backend (see
+// Anicetus.ReportOutcome), so the detection can take the backend health into
+// account.
+type OutcomeDetector interface {
+	Detector
+
+	// ReportOutcome is called with the outcome of each request processed by the
+	// backend.
+	ReportOutcome(context.Context, Fingerprint, Outcome) error
+}
+
+// Signal is a load signal reported by the backend.
+type Signal struct {
+	// Active is true while the backend is under pressure, and false once it
+	// recovers.
+	Active bool
+	// Global applies the signal to the whole backend, instead of only to the
+	// fingerprint of the request.
+	Global bool
+}
+
+// SignalDetector is an optional interface that a Detector can implement to
+// receive load signals reported by the backend (see Anicetus.PushSignal),
+// detecting a thundering herd while the signal is active.
+type SignalDetector interface {
+	Detector
+
+	// PushSignal is called with each load signal reported by the backend.
+	PushSignal(context.Context, Fingerprint, Signal) error
+}
+
+// DetectorState is the state kept by a detector for a fingerprint.
+type DetectorState struct {
+	// Fingerprint identifies the state.
+	Fingerprint Fingerprint
+	// CoolDownRemaining is the time left for the cooldown period. It is zero when
+	// the fingerprint is not in cooldown.
+	CoolDownRemaining time.Duration
+	// Tokens is the number of tokens available in the bucket, for token bucket
+	// detectors.
+	Tokens float64
+}
+
+// DetectorInspector is an optional interface that a Detector can implement to
+// allow listing and inspecting the tracked fingerprints, useful for
+// troubleshooting.
+type DetectorInspector interface {
+	// ListDetections returns a page of detector states starting at the cursor.
+	// An empty cursor starts from the beginning, and an empty returned cursor
+	// means that there are no more states. The limit is a hint of the page size,
+	// some detectors may return fewer or more items.
+	ListDetections(ctx context.Context, cursor string, limit int) ([]DetectorState, string, error)
+	// InspectDetection returns the detector state of the fingerprint. It returns
+	// false if the fingerprint isn't tracked.
+	InspectDetection(ctx context.Context, fingerprint Fingerprint) (DetectorState, bool, error)
+}
+
 // Fingerprint is the unique identifier for the request.
 type Fingerprint string
 
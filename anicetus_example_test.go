@@ -33,21 +33,31 @@ func ExampleAnicetus_Evaluate() {
 	ctx := context.Background()
 
 	detector := detector.NewTokenBucketInMemory(
-		detector.TokenBucketWithLimitersBurst(1),
-		detector.TokenBucketWithLimitersInterval(time.Minute),
+		detector.TokenBucketWithBurst(1),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Minute}),
 		detector.TokenBucketWithCoolDownInterval(10*time.Minute),
 	)
 
 	gatekeeperStorage := storage.NewInMemory()
 
+	var leaderToken anicetus.FencingToken
+
 	anicetus := anicetus.NewAnicetus[Request](detector, gatekeeperStorage)
 
 	evaluate := func(req Request) {
-		status, err := anicetus.Evaluate(ctx, req)
+		status, token, err := anicetus.Evaluate(ctx, req)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to evaluate request: %v", err)
 		}
+		if token != 0 {
+			// only the request chosen to be processed receives a fencing token
+			leaderToken = token
+		}
 		fmt.Printf("status: %v\n", status)
+
+		if err := anicetus.RequestFinished(ctx, req); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to finish request: %v", err)
+		}
 	}
 
 	req := Request{Input: "hello"}
@@ -56,7 +66,7 @@ func ExampleAnicetus_Evaluate() {
 	evaluate(req)
 	evaluate(req)
 
-	if err := anicetus.RequestDone(ctx, req); err != nil {
+	if err := anicetus.RequestDone(ctx, req, leaderToken); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to mark request as done: %v", err)
 	}
 
@@ -2,9 +2,14 @@ package anicetus_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/instrument"
+	"github.com/rafaeljusto/anicetus/v2/storage"
 )
 
 func TestAnicetus_Evaluate(t *testing.T) {
@@ -74,7 +79,7 @@ func TestAnicetus_Evaluate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			th := anicetus.NewAnicetus[fakeFingerprinter](tt.detector, tt.gatekeeperStorage)
 
-			status, err := th.Evaluate(t.Context(), fakeFingerprinter{})
+			status, _, err := th.Evaluate(t.Context(), fakeFingerprinter{})
 			if err != nil {
 				t.Errorf("unexpected error '%v'", err)
 			}
@@ -92,7 +97,7 @@ func TestAnicetus_Evaluate_fullCycle(t *testing.T) {
 
 	var fingerprinter fakeFingerprinter
 
-	status, err := th.Evaluate(t.Context(), fingerprinter)
+	status, token, err := th.Evaluate(t.Context(), fingerprinter)
 	if err != nil {
 		t.Fatalf("unexpected error '%v'", err)
 	}
@@ -100,11 +105,11 @@ func TestAnicetus_Evaluate_fullCycle(t *testing.T) {
 		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
 	}
 
-	if err := th.RequestDone(t.Context(), fingerprinter); err != nil {
+	if err := th.RequestDone(t.Context(), fingerprinter, token); err != nil {
 		t.Fatalf("unexpected error '%v'", err)
 	}
 
-	status, err = th.Evaluate(t.Context(), fingerprinter)
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
 	if err != nil {
 		t.Fatalf("unexpected error '%v'", err)
 	}
@@ -112,11 +117,11 @@ func TestAnicetus_Evaluate_fullCycle(t *testing.T) {
 		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusOpenGates)
 	}
 
-	if err := th.Cleanup(t.Context(), fingerprinter); err != nil {
+	if err := th.Cleanup(t.Context(), fingerprinter, 0); err != nil {
 		t.Fatalf("unexpected error '%v'", err)
 	}
 
-	status, err = th.Evaluate(t.Context(), fingerprinter)
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
 	if err != nil {
 		t.Fatalf("unexpected error '%v'", err)
 	}
@@ -125,8 +130,327 @@ func TestAnicetus_Evaluate_fullCycle(t *testing.T) {
 	}
 }
 
+func TestAnicetus_Evaluate_unsupportedFencing(t *testing.T) {
+	th := anicetus.NewAnicetus[fakeFingerprinter](fakeDetector{
+		anicetus: true,
+	}, instrument.NewGatekeeperStorage(&fakeGatekeeperStorage{}))
+
+	var fingerprinter fakeFingerprinter
+
+	status, token, err := th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusProcess {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
+	}
+	if token != 0 {
+		t.Fatalf("unexpected fencing token '%d'", token)
+	}
+
+	if err := th.RequestDone(t.Context(), fingerprinter, 1); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusOpenGates {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusOpenGates)
+	}
+
+	if err := th.Cleanup(t.Context(), fingerprinter, 1); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusProcess {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
+	}
+}
+
+func TestAnicetus_Evaluate_countWaiters(t *testing.T) {
+	gatekeeperStorage := storage.NewInMemory()
+	th := anicetus.NewAnicetus[fakeFingerprinter](fakeDetector{
+		anicetus: true,
+	}, gatekeeperStorage)
+
+	var fingerprinter fakeFingerprinter
+
+	status, token, err := th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusProcess {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
+	}
+
+	for range 3 {
+		status, _, err := th.Evaluate(t.Context(), fingerprinter)
+		if err != nil {
+			t.Fatalf("unexpected error '%v'", err)
+		}
+		if status != anicetus.StatusWait {
+			t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusWait)
+		}
+	}
+
+	if err := th.RequestDone(t.Context(), fingerprinter, token); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+
+	// requests answered with an open gate are not waiters
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusOpenGates {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusOpenGates)
+	}
+
+	gate, ok, err := gatekeeperStorage.InspectGate(t.Context(), fingerprinter.Fingerprint())
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if !ok || gate.Waiters != 3 {
+		t.Errorf("unexpected gate state %+v", gate)
+	}
+}
+
+func TestAnicetus_RequestDone_staleLeader(t *testing.T) {
+	th := anicetus.NewAnicetus[fakeFingerprinter](fakeDetector{
+		anicetus: true,
+	}, storage.NewInMemory())
+
+	var fingerprinter fakeFingerprinter
+
+	status, staleToken, err := th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusProcess {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
+	}
+
+	// the gate is cleaned up (e.g. expired) before the leader finishes, so a new
+	// leader is elected
+	if err := th.Cleanup(t.Context(), fingerprinter, staleToken); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+
+	status, token, err := th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusProcess {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := th.RequestDone(t.Context(), fingerprinter, staleToken); !errors.As(err, &staleTokenErr) {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusWait {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusWait)
+	}
+
+	if err := th.RequestDone(t.Context(), fingerprinter, token); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusOpenGates {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusOpenGates)
+	}
+}
+
+func TestAnicetus_RequestDone_gateRemoved(t *testing.T) {
+	detector := &toggleDetector{anicetus: true}
+	th := anicetus.NewAnicetus[fakeFingerprinter](detector, storage.NewInMemory())
+
+	var fingerprinter fakeFingerprinter
+
+	status, token, err := th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusProcess {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
+	}
+
+	// the herd is over while the leader is still processing, so the gate is
+	// removed by a following request
+	detector.anicetus = false
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusOpenGates {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusOpenGates)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := th.RequestDone(t.Context(), fingerprinter, token); !errors.As(err, &staleTokenErr) {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if !detector.cooldown {
+		t.Fatal("fingerprint should be in cooldown")
+	}
+}
+
+func TestAnicetus_RequestFinished(t *testing.T) {
+	detector := detector.NewConcurrencyInMemory(
+		detector.ConcurrencyWithLimit(1),
+	)
+	th := anicetus.NewAnicetus[fakeFingerprinter](detector, &fakeGatekeeperStorage{})
+
+	var fingerprinter fakeFingerprinter
+
+	status, _, err := th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusOpenGates {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusOpenGates)
+	}
+
+	status, _, err = th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusProcess {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
+	}
+	if n := detector.InFlight(fingerprinter.Fingerprint()); n != 2 {
+		t.Fatalf("unexpected requests in flight %d", n)
+	}
+
+	for range 2 {
+		if err := th.RequestFinished(t.Context(), fingerprinter); err != nil {
+			t.Fatalf("unexpected error '%v'", err)
+		}
+	}
+	if n := detector.InFlight(fingerprinter.Fingerprint()); n != 0 {
+		t.Fatalf("unexpected requests in flight %d", n)
+	}
+
+	// a failed evaluation doesn't leave the request in flight
+	th = anicetus.NewAnicetus[fakeFingerprinter](detector, &failingGatekeeperStorage{})
+	if _, _, err := th.Evaluate(t.Context(), fingerprinter); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if _, _, err := th.Evaluate(t.Context(), fingerprinter); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := detector.InFlight(fingerprinter.Fingerprint()); n != 1 {
+		t.Fatalf("unexpected requests in flight %d", n)
+	}
+}
+
+func TestAnicetus_ReportOutcome(t *testing.T) {
+	detector := detector.NewLatencyInMemory(
+		detector.LatencyWithLimit(10),
+		detector.LatencyWithTargetLatency(100*time.Millisecond),
+	)
+	th := anicetus.NewAnicetus[fakeFingerprinter](detector, &fakeGatekeeperStorage{})
+
+	var fingerprinter fakeFingerprinter
+	if err := th.ReportOutcome(t.Context(), fingerprinter, anicetus.Outcome{Latency: time.Second}); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if limit := detector.Limit(fingerprinter.Fingerprint()); limit != 1 {
+		t.Fatalf("unexpected limit %v", limit)
+	}
+
+	// detectors not interested in outcomes are ignored
+	th = anicetus.NewAnicetus[fakeFingerprinter](fakeDetector{}, &fakeGatekeeperStorage{})
+	if err := th.ReportOutcome(t.Context(), fingerprinter, anicetus.Outcome{Failed: true}); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+}
+
+func TestAnicetus_PushSignal(t *testing.T) {
+	detector := detector.NewSignalInMemory(detector.NewTokenBucketInMemory())
+	th := anicetus.NewAnicetus[fakeFingerprinter](detector, &fakeGatekeeperStorage{})
+
+	var fingerprinter fakeFingerprinter
+	if err := th.PushSignal(t.Context(), fingerprinter, anicetus.Signal{Active: true}); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+
+	status, _, err := th.Evaluate(t.Context(), fingerprinter)
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if status != anicetus.StatusProcess {
+		t.Fatalf("unexpected status '%v', want '%v'", status, anicetus.StatusProcess)
+	}
+
+	// detectors not interested in signals are ignored
+	th = anicetus.NewAnicetus[fakeFingerprinter](fakeDetector{}, &fakeGatekeeperStorage{})
+	if err := th.PushSignal(t.Context(), fingerprinter, anicetus.Signal{Active: true}); err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+}
+
+func TestAs(t *testing.T) {
+	concurrency := detector.NewConcurrencyInMemory()
+	tokenBucket := detector.NewTokenBucketInMemory()
+
+	tests := []struct {
+		name     string
+		detector anicetus.Detector
+		want     bool
+	}{{
+		name:     "it should support a detector implementing the interface",
+		detector: concurrency,
+		want:     true,
+	}, {
+		name:     "it should not support a detector not implementing the interface",
+		detector: tokenBucket,
+		want:     false,
+	}, {
+		name:     "it should support a decorator wrapping a detector implementing the interface",
+		detector: instrument.NewDetector(instrument.NewDetector(concurrency)),
+		want:     true,
+	}, {
+		name:     "it should not support a decorator wrapping a detector not implementing the interface",
+		detector: instrument.NewDetector(instrument.NewDetector(tokenBucket)),
+		want:     false,
+	}, {
+		name:     "it should support a composite with any child implementing the interface",
+		detector: detector.NewAnyOf([]anicetus.Detector{instrument.NewDetector(tokenBucket), concurrency}),
+		want:     true,
+	}, {
+		name:     "it should not support a composite without children implementing the interface",
+		detector: detector.NewAnyOf([]anicetus.Detector{instrument.NewDetector(tokenBucket), tokenBucket}),
+		want:     false,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := anicetus.As[anicetus.InFlightDetector](tt.detector); ok != tt.want {
+				t.Errorf("unexpected support %t, want %t", ok, tt.want)
+			}
+		})
+	}
+}
+
 var _ anicetus.Fingerprinter = fakeFingerprinter{}
 var _ anicetus.Detector = fakeDetector{}
+var _ anicetus.Detector = &toggleDetector{}
 var _ anicetus.GatekeeperStorage = &fakeGatekeeperStorage{}
 
 // fakeFingerprinter is a fake implementation of Fingerprinter.
@@ -154,6 +478,26 @@ func (d fakeDetector) IsThunderingHerd(context.Context, anicetus.Fingerprint) (b
 	return d.anicetus, nil
 }
 
+// toggleDetector is a fake implementation of Detector that can change the
+// detection between evaluations, recording the cooldowns.
+type toggleDetector struct {
+	cooldown bool
+	anicetus bool
+}
+
+func (d *toggleDetector) IsCoolDown(context.Context, anicetus.Fingerprint) (bool, error) {
+	return d.cooldown, nil
+}
+
+func (d *toggleDetector) CoolDown(context.Context, anicetus.Fingerprint) error {
+	d.cooldown = true
+	return nil
+}
+
+func (d *toggleDetector) IsThunderingHerd(context.Context, anicetus.Fingerprint) (bool, error) {
+	return d.anicetus, nil
+}
+
 // fakeGatekeeperStorage is a fake implementation of GatekeeperStorage.
 type fakeGatekeeperStorage struct {
 	exists    bool
@@ -179,3 +523,13 @@ func (gs *fakeGatekeeperStorage) Remove(context.Context, anicetus.Fingerprint) e
 	gs.processed = false
 	return nil
 }
+
+// failingGatekeeperStorage is a GatekeeperStorage always failing to check the
+// gates.
+type failingGatekeeperStorage struct {
+	fakeGatekeeperStorage
+}
+
+func (failingGatekeeperStorage) Exists(context.Context, anicetus.Fingerprint) (bool, error) {
+	return false, errors.New("failed")
+}
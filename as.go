@@ -0,0 +1,40 @@
+package anicetus
+
+// As returns v as the optional interface T (like InFlightDetector or
+// FencedGatekeeperStorage) when it is supported.
+//
+// Decorators usually implement all optional interfaces, forwarding the calls
+// to the value they wrap. When they expose that value with an Unwrap method
+// (returning a Detector, a GatekeeperStorage or a []Detector), As only reports
+// the interface as supported if the wrapped value (or, for []Detector, at
+// least one of them) supports it too.
+func As[T any](v any) (T, bool) {
+	var empty T
+	t, ok := v.(T)
+	if !ok {
+		return empty, false
+	}
+
+	switch wrapper := v.(type) {
+	case interface{ Unwrap() Detector }:
+		if _, ok := As[T](wrapper.Unwrap()); !ok {
+			return empty, false
+		}
+	case interface{ Unwrap() GatekeeperStorage }:
+		if _, ok := As[T](wrapper.Unwrap()); !ok {
+			return empty, false
+		}
+	case interface{ Unwrap() []Detector }:
+		var supported bool
+		for _, inner := range wrapper.Unwrap() {
+			if _, ok := As[T](inner); ok {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return empty, false
+		}
+	}
+	return t, true
+}
@@ -36,7 +36,23 @@ func main() {
 		exit(exitCodeInvalidInput)
 	}
 
-	resources := anicetushttp.NewResources(config)
+	resources, err := anicetushttp.NewResources(config)
+	if err != nil {
+		logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelError,
+		}))
+		logger.Error("failed to initialize resources",
+			slog.String("error", err.Error()),
+		)
+		exit(exitCodeSetupFailure)
+	}
+	defer func() {
+		if err := resources.Close(); err != nil {
+			resources.Logger.Error("failed to close resources",
+				slog.String("error", err.Error()),
+			)
+		}
+	}()
 
 	listener, err := net.Listen("tcp", ":"+strconv.FormatInt(config.Port, 10))
 	if err != nil {
@@ -69,7 +85,19 @@ func main() {
 		}
 	}()
 
-	<-done
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+wait:
+	for {
+		select {
+		case <-reload:
+			reloadConfig(resources)
+		case <-done:
+			break wait
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
 		cancel()
@@ -79,9 +107,30 @@ func main() {
 			slog.String("error", err.Error()),
 		)
 	}
+	if err := resources.SaveSnapshots(); err != nil {
+		resources.Logger.Error("failed to save snapshots",
+			slog.String("error", err.Error()),
+		)
+	}
 	resources.Logger.Info("server stopped")
 }
 
+// reloadConfig parses the configuration again, applying the detector settings
+// to the running resources. An invalid configuration is ignored.
+func reloadConfig(resources *anicetushttp.Resources) {
+	config, errs := anicetushttp.ParseFromEnvs()
+	if errs != nil {
+		for _, err := range multierr(errs) {
+			resources.Logger.Error("failed to reload configuration",
+				slog.String("error", err.Error()),
+			)
+		}
+		return
+	}
+	resources.Reload(config)
+	resources.Logger.Info("configuration reloaded")
+}
+
 type exitCode int
 
 const (
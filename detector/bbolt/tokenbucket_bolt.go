@@ -0,0 +1,231 @@
+package bbolt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ anicetus.Detector = &TokenBucketBolt{}
+
+var (
+	// coolDownsBucketName is the bbolt bucket where the cooldowns are stored.
+	coolDownsBucketName = []byte("anicetus_cooldowns")
+	// tokenBucketsBucketName is the bbolt bucket where the token buckets are
+	// stored.
+	tokenBucketsBucketName = []byte("anicetus_token_buckets")
+)
+
+// TokenBucketBolt is a token bucket detector strategy that stores the state in
+// a file using bbolt. Every write is executed in a bbolt transaction, which is
+// synced to disk before returning, so the state survives crashes and restarts.
+// Concurrent requests are grouped in batch transactions to reduce the number of
+// disk syncs.
+type TokenBucketBolt struct {
+	db     *bolt.DB
+	logger *slog.Logger
+
+	options atomic.Pointer[detector.TokenBucketOptions]
+	// optionsMutex avoids losing concurrent updates.
+	optionsMutex sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTokenBucketBolt creates a new token bucket detector strategy using the
+// bbolt database. Expired cooldowns and token buckets are purged periodically
+// until Stop is called. The database is not closed by the detector.
+func NewTokenBucketBolt(db *bolt.DB, options ...detector.TokenBucketOption) (*TokenBucketBolt, error) {
+	o := detector.NewTokenBucketOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{coolDownsBucketName, tokenBucketsBucketName} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bbolt buckets: %w", err)
+	}
+
+	t := &TokenBucketBolt{
+		db:     db,
+		logger: o.Logger(),
+		stop:   make(chan struct{}),
+	}
+	t.options.Store(o)
+	t.start()
+	return t, nil
+}
+
+// Update applies the options on top of the current ones. The token buckets and
+// cooldowns stored in the database are kept, and the new options are used from
+// the next request on. The purge interval defined on creation is not changed.
+func (t *TokenBucketBolt) Update(options ...detector.TokenBucketOption) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+
+	o := *t.options.Load()
+	for _, opt := range options {
+		opt(&o)
+	}
+	t.options.Store(&o)
+}
+
+// CoolDown will cool down the fingerprint.
+func (t *TokenBucketBolt) CoolDown(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	o := t.options.Load()
+	err := t.db.Batch(func(tx *bolt.Tx) error {
+		expiresAt := make([]byte, 8)
+		binary.BigEndian.PutUint64(expiresAt, uint64(time.Now().Add(o.CoolDownInterval()).UnixNano()))
+		return tx.Bucket(coolDownsBucketName).Put([]byte(fingerprint), expiresAt)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write bbolt key: %w", err)
+	}
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (t *TokenBucketBolt) IsCoolDown(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	var cooldown bool
+	err := t.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(coolDownsBucketName).Get([]byte(fingerprint))
+		cooldown = len(data) == 8 && time.Now().Before(decodeTime(data))
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read bbolt key: %w", err)
+	}
+	return cooldown, nil
+}
+
+// IsThunderingHerd checks if the fingerprint is a thundering herd. When the
+// bucket is empty, the penalty is applied to it.
+func (t *TokenBucketBolt) IsThunderingHerd(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	o := t.options.Load()
+	var thunderingHerd bool
+	err := t.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tokenBucketsBucketName)
+		now := time.Now()
+		maxTokens := float64(o.Burst())
+
+		tokens, lastRefreshed := maxTokens, now
+		if data := bucket.Get([]byte(fingerprint)); len(data) == 16 {
+			tokens = math.Float64frombits(binary.BigEndian.Uint64(data[:8]))
+			lastRefreshed = decodeTime(data[8:])
+		}
+
+		// refill the tokens based on elapsed time
+		elapsed := max(now.Sub(lastRefreshed), 0)
+		tokens = min(maxTokens, tokens+elapsed.Seconds()*o.Rate().PerSecond())
+
+		if thunderingHerd = tokens < 1; thunderingHerd {
+			// apply penalty for thundering herd
+			tokens, lastRefreshed = o.Penalty().Apply(tokens, lastRefreshed, now)
+		} else {
+			tokens--
+			lastRefreshed = now
+		}
+
+		data := make([]byte, 16)
+		binary.BigEndian.PutUint64(data[:8], math.Float64bits(tokens))
+		binary.BigEndian.PutUint64(data[8:], uint64(lastRefreshed.UnixNano()))
+		return bucket.Put([]byte(fingerprint), data)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to write bbolt key: %w", err)
+	}
+	return thunderingHerd, nil
+}
+
+// Stop stops purging the expired cooldowns and token buckets.
+func (t *TokenBucketBolt) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+}
+
+// fullBucketPeriod is the time needed to refill an empty bucket, including any
+// pause of the penalty. After that the stored bucket has no effect and can
+// be removed.
+func fullBucketPeriod(o *detector.TokenBucketOptions) time.Duration {
+	period := o.Rate().DurationOf(o.Burst())
+	if pause := o.Penalty().Pause(); period <= math.MaxInt64-pause {
+		period += pause
+	}
+	return period
+}
+
+func (t *TokenBucketBolt) start() {
+	o := t.options.Load()
+	interval := min(o.CoolDownInterval(), fullBucketPeriod(o))
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			}
+
+			if err := t.purge(time.Now()); err != nil && t.logger != nil {
+				t.logger.Error("failed to purge expired detector state", slog.String("error", err.Error()))
+			}
+		}
+	}()
+}
+
+// purge removes the expired cooldowns and the token buckets that are already
+// full.
+func (t *TokenBucketBolt) purge(now time.Time) error {
+	return t.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(coolDownsBucketName).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if len(value) == 8 && now.Before(decodeTime(value)) {
+				continue
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+
+		period := fullBucketPeriod(t.options.Load())
+		cursor = tx.Bucket(tokenBucketsBucketName).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if len(value) == 16 && now.Before(decodeTime(value[8:]).Add(period)) {
+				continue
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// decodeTime decodes a time stored as unix nanoseconds.
+func decodeTime(data []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+}
@@ -0,0 +1,171 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/bbolt"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestTokenBucketBolt_IsThunderingHerd(t *testing.T) {
+	tests := []struct {
+		burst      int64
+		interval   time.Duration
+		cycles     int
+		cycleSleep func(cycle int) time.Duration
+		want       func(cycle int) bool
+	}{{
+		burst:    1,
+		interval: time.Second,
+		cycles:   2,
+		want: func(cycle int) bool {
+			return cycle == 2
+		},
+	}, {
+		burst:    4,
+		interval: 500 * time.Millisecond,
+		cycles:   7,
+		cycleSleep: func(cycle int) time.Duration {
+			if cycle == 6 {
+				// sleep longer to allow populating 1 token and avoid thundering herd
+				return 500 * time.Millisecond
+			}
+			return 100 * time.Millisecond
+		},
+		want: func(cycle int) bool {
+			return slices.Contains([]int{5, 6}, cycle)
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("interval %s and burst %d", tt.interval, tt.burst), func(t *testing.T) {
+			detector := newTokenBucketBolt(t, openDB(t, filepath.Join(t.TempDir(), "anicetus.db")),
+				detector.TokenBucketWithBurst(tt.burst),
+				detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: tt.interval}),
+			)
+
+			for i := 1; i <= tt.cycles; i++ {
+				t.Run("cycle"+strconv.Itoa(i), func(t *testing.T) {
+					ok, err := detector.IsThunderingHerd(t.Context(), anicetus.Fingerprint("test"))
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					if want := tt.want(i); ok != want {
+						t.Errorf("unexpected result: got %v, want %v", ok, want)
+					}
+					if tt.cycleSleep != nil {
+						if sleep := tt.cycleSleep(i); sleep > 0 {
+							time.Sleep(sleep)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestTokenBucketBolt_CoolDown(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	detector := newTokenBucketBolt(t, openDB(t, filepath.Join(t.TempDir(), "anicetus.db")),
+		detector.TokenBucketWithCoolDownInterval(100*time.Millisecond),
+	)
+
+	if ok, err := detector.IsCoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be in cooldown")
+	}
+
+	if err := detector.CoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := detector.IsCoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be in cooldown")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if ok, err := detector.IsCoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint cooldown should be expired")
+	}
+}
+
+func TestTokenBucketBolt_persistence(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+	path := filepath.Join(t.TempDir(), "anicetus.db")
+	options := []detector.TokenBucketOption{
+		detector.TokenBucketWithBurst(1),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+	}
+
+	db := openDB(t, path)
+	tokenBucket := newTokenBucketBolt(t, db, options...)
+	if ok, err := tokenBucket.IsThunderingHerd(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be a thundering herd")
+	}
+	if err := tokenBucket.CoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	tokenBucket.Stop()
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+
+	tokenBucket = newTokenBucketBolt(t, openDB(t, path), options...)
+	if ok, err := tokenBucket.IsCoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("cooldown should survive a restart")
+	}
+	if ok, err := tokenBucket.IsThunderingHerd(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("token bucket should survive a restart")
+	}
+}
+
+func openDB(t *testing.T, path string) *bolt.DB {
+	t.Helper()
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func newTokenBucketBolt(t *testing.T, db *bolt.DB, options ...detector.TokenBucketOption) *bbolt.TokenBucketBolt {
+	t.Helper()
+
+	detector, err := bbolt.NewTokenBucketBolt(db, options...)
+	if err != nil {
+		t.Fatalf("failed to create detector: %v", err)
+	}
+	t.Cleanup(detector.Stop)
+	return detector
+}
+
+func TestTokenBucketBolt_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(t *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		return newTokenBucketBolt(t, openDB(t, filepath.Join(t.TempDir(), "anicetus.db")), options...)
+	})
+}
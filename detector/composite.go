@@ -0,0 +1,225 @@
+package detector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+var (
+	_ anicetus.InFlightDetector = &Composite{}
+	_ anicetus.OutcomeDetector  = &Composite{}
+	_ anicetus.SignalDetector   = &Composite{}
+)
+
+// Composite is a detector that combines the decisions of its children. A
+// fingerprint is a thundering herd when at least the required number of
+// children detect it, so it can require any (AnyOf), all (AllOf) or some
+// (Threshold) of them.
+//
+// All children are checked on every request, even when the decision is already
+// known, as detectors usually count the request when checking it. The cooldown
+// applies to all children, and a fingerprint is in cooldown when any child is
+// cooling it down.
+//
+// The optional anicetus.InFlightDetector, anicetus.OutcomeDetector and
+// anicetus.SignalDetector interfaces are forwarded to the children implementing
+// them, returning errors.ErrUnsupported when none does.
+type Composite struct {
+	children       []anicetus.Detector
+	required       int
+	logger         *slog.Logger
+	triggerHandler func(context.Context, anicetus.Fingerprint, []int)
+}
+
+// NewAnyOf creates a composite detector where a fingerprint is a thundering
+// herd when any child detects it. It panics without children.
+func NewAnyOf(children []anicetus.Detector, options ...CompositeOption) *Composite {
+	return NewThreshold(1, children, options...)
+}
+
+// NewAllOf creates a composite detector where a fingerprint is a thundering
+// herd only when all children detect it. It panics without children.
+func NewAllOf(children []anicetus.Detector, options ...CompositeOption) *Composite {
+	return NewThreshold(len(children), children, options...)
+}
+
+// NewThreshold creates a composite detector where a fingerprint is a
+// thundering herd when at least the required number of children (k-of-n)
+// detect it. It panics when the required number isn't between 1 and the number
+// of children, as the detector would report every request or none of them.
+func NewThreshold(required int, children []anicetus.Detector, options ...CompositeOption) *Composite {
+	if len(children) == 0 {
+		panic("detector: composite without children")
+	}
+	if required < 1 || required > len(children) {
+		panic(fmt.Sprintf("detector: composite requires %d of %d children", required, len(children)))
+	}
+
+	o := NewCompositeOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &Composite{
+		children:       children,
+		required:       required,
+		logger:         o.Logger(),
+		triggerHandler: o.TriggerHandler(),
+	}
+}
+
+// Unwrap returns the children.
+func (c *Composite) Unwrap() []anicetus.Detector {
+	return c.children
+}
+
+// CoolDown will cool down the fingerprint in all children.
+func (c *Composite) CoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	var errs error
+	for i, child := range c.children {
+		if err := child.CoolDown(ctx, fingerprint); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to cool down in detector %d: %w", i, err))
+		}
+	}
+	return errs
+}
+
+// IsCoolDown checks if any child is cooling down the fingerprint.
+func (c *Composite) IsCoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	for i, child := range c.children {
+		cooldown, err := child.IsCoolDown(ctx, fingerprint)
+		if err != nil {
+			return false, fmt.Errorf("failed to check cooldown in detector %d: %w", i, err)
+		} else if cooldown {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IsThunderingHerd checks the fingerprint in all children, detecting a
+// thundering herd when at least the required number of them detect it. The
+// trigger handler is informed of the children that detected it.
+func (c *Composite) IsThunderingHerd(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	var triggered []int
+	var errs error
+	for i, child := range c.children {
+		thunderingHerd, err := child.IsThunderingHerd(ctx, fingerprint)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to check thundering herd in detector %d: %w", i, err))
+		} else if thunderingHerd {
+			triggered = append(triggered, i)
+		}
+	}
+	if errs != nil {
+		return false, errs
+	}
+
+	if len(triggered) < c.required {
+		return false, nil
+	}
+
+	if c.logger != nil {
+		c.logger.Debug("thundering herd detected",
+			slog.String("fingerprint", fingerprint.String()),
+			slog.Any("triggered", triggered),
+		)
+	}
+	c.triggerHandler(ctx, fingerprint, triggered)
+	return true, nil
+}
+
+// RequestStarted notifies the children tracking requests in flight that a
+// request started. If any of them fails, the others are notified that the
+// request finished.
+func (c *Composite) RequestStarted(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	var started []anicetus.InFlightDetector
+	for i, child := range c.children {
+		inFlightDetector, ok := anicetus.As[anicetus.InFlightDetector](child)
+		if !ok {
+			continue
+		}
+		if err := inFlightDetector.RequestStarted(ctx, fingerprint); err != nil {
+			errs := fmt.Errorf("failed to start tracking request in detector %d: %w", i, err)
+			for _, inFlightDetector := range started {
+				if err := inFlightDetector.RequestFinished(context.WithoutCancel(ctx), fingerprint); err != nil {
+					errs = errors.Join(errs, fmt.Errorf("failed to finish tracking request: %w", err))
+				}
+			}
+			return errs
+		}
+		started = append(started, inFlightDetector)
+	}
+	if len(started) == 0 {
+		return fmt.Errorf("failed to start tracking request: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+// RequestFinished notifies the children tracking requests in flight that a
+// request finished.
+func (c *Composite) RequestFinished(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	return c.forward(func(i int, child anicetus.Detector) (bool, error) {
+		inFlightDetector, ok := anicetus.As[anicetus.InFlightDetector](child)
+		if !ok {
+			return false, nil
+		}
+		if err := inFlightDetector.RequestFinished(ctx, fingerprint); err != nil {
+			return true, fmt.Errorf("failed to finish tracking request in detector %d: %w", i, err)
+		}
+		return true, nil
+	}, "failed to finish tracking request")
+}
+
+// ReportOutcome informs the children interested in outcomes of the outcome of
+// a request.
+func (c *Composite) ReportOutcome(ctx context.Context, fingerprint anicetus.Fingerprint, outcome anicetus.Outcome) error {
+	return c.forward(func(i int, child anicetus.Detector) (bool, error) {
+		outcomeDetector, ok := anicetus.As[anicetus.OutcomeDetector](child)
+		if !ok {
+			return false, nil
+		}
+		if err := outcomeDetector.ReportOutcome(ctx, fingerprint, outcome); err != nil {
+			return true, fmt.Errorf("failed to report outcome in detector %d: %w", i, err)
+		}
+		return true, nil
+	}, "failed to report outcome")
+}
+
+// PushSignal informs the children interested in load signals of a signal
+// reported by the backend.
+func (c *Composite) PushSignal(ctx context.Context, fingerprint anicetus.Fingerprint, signal anicetus.Signal) error {
+	return c.forward(func(i int, child anicetus.Detector) (bool, error) {
+		signalDetector, ok := anicetus.As[anicetus.SignalDetector](child)
+		if !ok {
+			return false, nil
+		}
+		if err := signalDetector.PushSignal(ctx, fingerprint, signal); err != nil {
+			return true, fmt.Errorf("failed to push signal in detector %d: %w", i, err)
+		}
+		return true, nil
+	}, "failed to push signal")
+}
+
+// forward calls f for every child, joining the errors. errors.ErrUnsupported
+// is returned when no child supports the operation.
+func (c *Composite) forward(f func(int, anicetus.Detector) (bool, error), errMessage string) error {
+	var supported bool
+	var errs error
+	for i, child := range c.children {
+		ok, err := f(i, child)
+		if !ok {
+			continue
+		}
+		supported = true
+		errs = errors.Join(errs, err)
+	}
+	if !supported {
+		return fmt.Errorf("%s: %w", errMessage, errors.ErrUnsupported)
+	}
+	return errs
+}
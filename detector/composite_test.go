@@ -0,0 +1,215 @@
+package detector_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+)
+
+func TestComposite_IsThunderingHerd(t *testing.T) {
+	tests := []struct {
+		name          string
+		newComposite  func([]anicetus.Detector, ...detector.CompositeOption) *detector.Composite
+		children      []bool
+		want          bool
+		wantTriggered []int
+	}{{
+		name:          "any of with one child",
+		newComposite:  detector.NewAnyOf,
+		children:      []bool{false, true, false},
+		want:          true,
+		wantTriggered: []int{1},
+	}, {
+		name:         "any of with no child",
+		newComposite: detector.NewAnyOf,
+		children:     []bool{false, false},
+	}, {
+		name:         "all of with one child",
+		newComposite: detector.NewAllOf,
+		children:     []bool{true, false},
+	}, {
+		name:          "all of with all children",
+		newComposite:  detector.NewAllOf,
+		children:      []bool{true, true},
+		want:          true,
+		wantTriggered: []int{0, 1},
+	}, {
+		name: "2 of 3 with one child",
+		newComposite: func(children []anicetus.Detector, options ...detector.CompositeOption) *detector.Composite {
+			return detector.NewThreshold(2, children, options...)
+		},
+		children: []bool{false, false, true},
+	}, {
+		name: "2 of 3 with two children",
+		newComposite: func(children []anicetus.Detector, options ...detector.CompositeOption) *detector.Composite {
+			return detector.NewThreshold(2, children, options...)
+		},
+		children:      []bool{true, false, true},
+		want:          true,
+		wantTriggered: []int{0, 2},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var children []anicetus.Detector
+			var stubs []*stubDetector
+			for _, thunderingHerd := range tt.children {
+				stub := &stubDetector{thunderingHerd: thunderingHerd}
+				children = append(children, stub)
+				stubs = append(stubs, stub)
+			}
+
+			var triggered []int
+			composite := tt.newComposite(children,
+				detector.CompositeWithTriggerHandler(func(_ context.Context, _ anicetus.Fingerprint, t []int) {
+					triggered = t
+				}),
+			)
+
+			if ok, err := composite.IsThunderingHerd(t.Context(), "test"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if ok != tt.want {
+				t.Errorf("unexpected result: got %v, want %v", ok, tt.want)
+			}
+			if !slices.Equal(triggered, tt.wantTriggered) {
+				t.Errorf("unexpected triggered children: got %v, want %v", triggered, tt.wantTriggered)
+			}
+
+			// every child counts the request
+			for i, stub := range stubs {
+				if stub.checks != 1 {
+					t.Errorf("detector %d checked %d times", i, stub.checks)
+				}
+			}
+		})
+	}
+}
+
+func TestComposite_invalid(t *testing.T) {
+	children := []anicetus.Detector{&stubDetector{}, &stubDetector{}}
+
+	tests := []struct {
+		name         string
+		newComposite func() *detector.Composite
+	}{{
+		name:         "any of without children",
+		newComposite: func() *detector.Composite { return detector.NewAnyOf(nil) },
+	}, {
+		name:         "all of without children",
+		newComposite: func() *detector.Composite { return detector.NewAllOf(nil) },
+	}, {
+		name:         "threshold without children",
+		newComposite: func() *detector.Composite { return detector.NewThreshold(1, nil) },
+	}, {
+		name:         "threshold of zero",
+		newComposite: func() *detector.Composite { return detector.NewThreshold(0, children) },
+	}, {
+		name:         "negative threshold",
+		newComposite: func() *detector.Composite { return detector.NewThreshold(-1, children) },
+	}, {
+		name:         "threshold above the children",
+		newComposite: func() *detector.Composite { return detector.NewThreshold(3, children) },
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("composite should not be created")
+				}
+			}()
+			tt.newComposite()
+		})
+	}
+}
+
+func TestComposite_CoolDown(t *testing.T) {
+	first, second := &stubDetector{}, &stubDetector{}
+	composite := detector.NewAllOf([]anicetus.Detector{first, second})
+
+	if ok, err := composite.IsCoolDown(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be in cooldown")
+	}
+
+	second.cooldown = true
+	if ok, err := composite.IsCoolDown(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be in cooldown when any child is cooling it down")
+	}
+
+	second.cooldown = false
+	if err := composite.CoolDown(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !first.cooldown || !second.cooldown {
+		t.Error("cooldown should apply to all children")
+	}
+}
+
+func TestComposite_RequestStarted(t *testing.T) {
+	concurrency := detector.NewConcurrencyInMemory(
+		detector.ConcurrencyWithLimit(1),
+	)
+	composite := detector.NewAllOf([]anicetus.Detector{
+		concurrency,
+		&stubDetector{thunderingHerd: true},
+	})
+
+	for range 2 {
+		if err := composite.RequestStarted(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if ok, err := composite.IsThunderingHerd(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be a thundering herd")
+	}
+
+	if err := composite.RequestFinished(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := concurrency.InFlight("test"); n != 1 {
+		t.Errorf("unexpected requests in flight: %d", n)
+	}
+
+	// children not tracking requests in flight are ignored
+	composite = detector.NewAnyOf([]anicetus.Detector{&stubDetector{}})
+	if _, ok := anicetus.As[anicetus.InFlightDetector](composite); ok {
+		t.Error("requests in flight should not be supported")
+	}
+	if err := composite.RequestStarted(t.Context(), "test"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := composite.ReportOutcome(t.Context(), "test", anicetus.Outcome{}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// stubDetector is a detector with fixed decisions.
+type stubDetector struct {
+	cooldown       bool
+	thunderingHerd bool
+	checks         int
+}
+
+func (s *stubDetector) CoolDown(context.Context, anicetus.Fingerprint) error {
+	s.cooldown = true
+	return nil
+}
+
+func (s *stubDetector) IsCoolDown(context.Context, anicetus.Fingerprint) (bool, error) {
+	return s.cooldown, nil
+}
+
+func (s *stubDetector) IsThunderingHerd(context.Context, anicetus.Fingerprint) (bool, error) {
+	s.checks++
+	return s.thunderingHerd, nil
+}
@@ -0,0 +1,86 @@
+package detector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/mapexp"
+)
+
+var _ anicetus.InFlightDetector = &ConcurrencyInMemory{}
+
+// ConcurrencyInMemory is a detector strategy that stores the state in memory
+// and tracks the requests in flight of each fingerprint. A fingerprint with
+// more requests in flight than the limit is a thundering herd.
+type ConcurrencyInMemory struct {
+	cooldowns     *mapexp.Map[anicetus.Fingerprint, bool]
+	inFlight      map[anicetus.Fingerprint]int64
+	inFlightMutex sync.Mutex
+	limit         int64
+}
+
+// NewConcurrencyInMemory creates a new concurrency detector strategy.
+func NewConcurrencyInMemory(options ...ConcurrencyOption) *ConcurrencyInMemory {
+	o := NewConcurrencyOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &ConcurrencyInMemory{
+		cooldowns: mapexp.New[anicetus.Fingerprint, bool](o.CoolDownInterval()),
+		inFlight:  make(map[anicetus.Fingerprint]int64),
+		limit:     o.Limit(),
+	}
+}
+
+// CoolDown will cool down the fingerprint.
+func (c *ConcurrencyInMemory) CoolDown(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	c.cooldowns.Set(fingerprint, true)
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (c *ConcurrencyInMemory) IsCoolDown(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	cooldown, ok := c.cooldowns.Peek(fingerprint)
+	return cooldown && ok, nil
+}
+
+// IsThunderingHerd checks if the fingerprint has more requests in flight than
+// the limit.
+func (c *ConcurrencyInMemory) IsThunderingHerd(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	return c.inFlight[fingerprint] > c.limit, nil
+}
+
+// RequestStarted increments the requests in flight of the fingerprint.
+func (c *ConcurrencyInMemory) RequestStarted(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	c.inFlight[fingerprint]++
+	return nil
+}
+
+// RequestFinished decrements the requests in flight of the fingerprint.
+func (c *ConcurrencyInMemory) RequestFinished(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	if c.inFlight[fingerprint] <= 1 {
+		delete(c.inFlight, fingerprint)
+	} else {
+		c.inFlight[fingerprint]--
+	}
+	return nil
+}
+
+// InFlight returns the number of requests in flight of the fingerprint.
+func (c *ConcurrencyInMemory) InFlight(fingerprint anicetus.Fingerprint) int64 {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	return c.inFlight[fingerprint]
+}
@@ -0,0 +1,57 @@
+package detector_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+)
+
+func TestConcurrencyInMemory_IsThunderingHerd(t *testing.T) {
+	detector := detector.NewConcurrencyInMemory(
+		detector.ConcurrencyWithLimit(2),
+	)
+
+	for i := 1; i <= 3; i++ {
+		if err := detector.RequestStarted(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if want := i > 2; ok != want {
+			t.Errorf("unexpected result with %d requests in flight: got %v, want %v", i, ok, want)
+		}
+	}
+
+	if ok, err := detector.IsThunderingHerd(t.Context(), "other"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("other fingerprint should not be a thundering herd")
+	}
+
+	if err := detector.RequestFinished(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be a thundering herd after a request finished")
+	}
+
+	// finishing more requests than started must not go below zero
+	for range 5 {
+		if err := detector.RequestFinished(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := detector.InFlight("test"); n != 0 {
+		t.Errorf("unexpected requests in flight: %d", n)
+	}
+}
+
+func TestConcurrencyInMemory_CoolDown(t *testing.T) {
+	detectortest.TestCoolDown(t, detector.NewConcurrencyInMemory(
+		detector.ConcurrencyWithCoolDownInterval(200*time.Millisecond),
+	), 200*time.Millisecond)
+}
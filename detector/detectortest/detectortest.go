@@ -0,0 +1,423 @@
+package detectortest
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+)
+
+// concurrentRequests is the number of requests evaluated at the same time in
+// the concurrency tests.
+const concurrentRequests = 30
+
+// TokenBucketUpdater is a token bucket detector that can be reconfigured
+// without losing its state.
+type TokenBucketUpdater interface {
+	anicetus.Detector
+	Update(options ...detector.TokenBucketOption)
+}
+
+// NewTokenBucket creates an empty token bucket detector with the options for
+// each test. Resources should be released using t.Cleanup.
+type NewTokenBucket func(t *testing.T, options ...detector.TokenBucketOption) anicetus.Detector
+
+// RunTokenBucket runs the conformance test suite against a token bucket
+// detector.
+func RunTokenBucket(t *testing.T, newDetector NewTokenBucket) {
+	t.Helper()
+
+	t.Run("CoolDown", func(t *testing.T) {
+		TestCoolDown(t, newDetector(t,
+			detector.TokenBucketWithCoolDownInterval(200*time.Millisecond),
+		), 200*time.Millisecond)
+	})
+	t.Run("Burst", func(t *testing.T) {
+		TestTokenBucketBurst(t, newDetector(t,
+			detector.TokenBucketWithBurst(3),
+			detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		), 3)
+	})
+	t.Run("Penalty", func(t *testing.T) {
+		TestTokenBucketPenalty(t, newDetector(t,
+			detector.TokenBucketWithBurst(2),
+			detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: 200 * time.Millisecond}),
+		), 200*time.Millisecond)
+	})
+	t.Run("NoPenalty", func(t *testing.T) {
+		TestTokenBucketNoPenalty(t, newDetector(t,
+			detector.TokenBucketWithBurst(2),
+			detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: 200 * time.Millisecond}),
+			detector.TokenBucketWithPenalty(detector.Penalty{Strategy: detector.PenaltyNone}),
+		), 200*time.Millisecond)
+	})
+	t.Run("HalvePenalty", func(t *testing.T) {
+		TestTokenBucketHalvePenalty(t, newDetector(t,
+			detector.TokenBucketWithBurst(2),
+			detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: 200 * time.Millisecond}),
+			detector.TokenBucketWithPenalty(detector.Penalty{Strategy: detector.PenaltyHalve}),
+		), 200*time.Millisecond)
+	})
+	t.Run("FixedPenalty", func(t *testing.T) {
+		TestTokenBucketFixedPenalty(t, newDetector(t,
+			detector.TokenBucketWithBurst(2),
+			detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: 200 * time.Millisecond}),
+			detector.TokenBucketWithPenalty(detector.Penalty{
+				Strategy: detector.PenaltyFixed,
+				Duration: 600 * time.Millisecond,
+			}),
+		), 200*time.Millisecond, 600*time.Millisecond)
+	})
+	t.Run("Rate", func(t *testing.T) {
+		rate := detector.Rate{Events: 5, Period: time.Second}
+		TestTokenBucketRate(t, newDetector(t,
+			detector.TokenBucketWithBurst(2),
+			detector.TokenBucketWithRate(rate),
+		), rate)
+	})
+	t.Run("Update", func(t *testing.T) {
+		d := newDetector(t,
+			detector.TokenBucketWithBurst(1),
+			detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+			detector.TokenBucketWithCoolDownInterval(time.Hour),
+		)
+		updater, ok := d.(TokenBucketUpdater)
+		if !ok {
+			t.Skip("detector can't be updated")
+		}
+		TestTokenBucketUpdate(t, updater)
+	})
+	t.Run("IndependentFingerprints", func(t *testing.T) {
+		TestIndependentFingerprints(t, newDetector(t,
+			detector.TokenBucketWithBurst(1),
+			detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		), 1)
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		TestTokenBucketConcurrency(t, newDetector(t,
+			detector.TokenBucketWithBurst(10),
+			detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		), 10)
+	})
+}
+
+// NewSlidingWindow creates an empty sliding window detector with the options
+// for each test. Resources should be released using t.Cleanup.
+type NewSlidingWindow func(t *testing.T, options ...detector.SlidingWindowOption) anicetus.Detector
+
+// RunSlidingWindow runs the conformance test suite against a sliding window
+// detector.
+func RunSlidingWindow(t *testing.T, newDetector NewSlidingWindow) {
+	t.Helper()
+
+	t.Run("CoolDown", func(t *testing.T) {
+		TestCoolDown(t, newDetector(t,
+			detector.SlidingWindowWithCoolDownInterval(200*time.Millisecond),
+		), 200*time.Millisecond)
+	})
+	t.Run("Limit", func(t *testing.T) {
+		TestSlidingWindowLimit(t, newDetector(t,
+			detector.SlidingWindowWithLimit(3),
+			detector.SlidingWindowWithWindow(time.Hour),
+		), 3)
+	})
+	t.Run("Slide", func(t *testing.T) {
+		TestSlidingWindowSlide(t, newDetector(t,
+			detector.SlidingWindowWithLimit(2),
+			detector.SlidingWindowWithWindow(200*time.Millisecond),
+		), 200*time.Millisecond)
+	})
+	t.Run("IndependentFingerprints", func(t *testing.T) {
+		TestIndependentFingerprints(t, newDetector(t,
+			detector.SlidingWindowWithLimit(1),
+			detector.SlidingWindowWithWindow(time.Hour),
+		), 1)
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		TestTokenBucketConcurrency(t, newDetector(t,
+			detector.SlidingWindowWithLimit(10),
+			detector.SlidingWindowWithWindow(time.Hour),
+		), 10)
+	})
+}
+
+// TestCoolDown checks that the cooldown expires after the interval.
+func TestCoolDown(t *testing.T, d anicetus.Detector, interval time.Duration) {
+	fingerprint := anicetus.Fingerprint("cooldown")
+
+	expectCoolDown(t, d, fingerprint, false)
+	if err := d.CoolDown(t.Context(), fingerprint); err != nil {
+		t.Fatalf("unexpected error cooling down: %v", err)
+	}
+	expectCoolDown(t, d, fingerprint, true)
+	expectCoolDown(t, d, "other", false)
+
+	time.Sleep(interval + interval/2)
+	expectCoolDown(t, d, fingerprint, false)
+}
+
+// TestTokenBucketBurst checks that a burst of requests is allowed before
+// detecting the thundering herd. The detector must not refill tokens during
+// the test.
+func TestTokenBucketBurst(t *testing.T, d anicetus.Detector, burst int) {
+	fingerprint := anicetus.Fingerprint("burst")
+
+	for i := range burst {
+		if expectThunderingHerd(t, d, fingerprint, false) {
+			t.Fatalf("request %d of the burst detected as thundering herd", i+1)
+		}
+	}
+	expectThunderingHerd(t, d, fingerprint, true)
+	expectThunderingHerd(t, d, fingerprint, true)
+}
+
+// TestTokenBucketPenalty checks that the bucket is drained when a thundering
+// herd is detected, so partially refilled tokens are lost. The detector must
+// have a burst of 2 and refill one token per interval.
+func TestTokenBucketPenalty(t *testing.T, d anicetus.Detector, interval time.Duration) {
+	fingerprint := anicetus.Fingerprint("penalty")
+
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// half a token is refilled and drained again by the detection
+	time.Sleep(interval / 2)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// without the penalty a full token would be available now
+	time.Sleep(interval / 2)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	time.Sleep(interval + interval/4)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+}
+
+// TestTokenBucketNoPenalty checks that the tokens are kept when a thundering
+// herd is detected, so partially refilled tokens are not lost. The detector must
+// have a burst of 2, refill one token per interval and use the PenaltyNone
+// strategy.
+func TestTokenBucketNoPenalty(t *testing.T, d anicetus.Detector, interval time.Duration) {
+	fingerprint := anicetus.Fingerprint("no-penalty")
+
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// half a token is refilled and kept after the detection
+	time.Sleep(interval / 2)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// with the drain penalty only 3/4 of a token would be available now
+	time.Sleep(interval/2 + interval/4)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+}
+
+// TestTokenBucketHalvePenalty checks that half of the tokens are removed when a
+// thundering herd is detected, delaying the next request allowed more than
+// without penalty and less than draining the bucket. The detector must have a
+// burst of 2, refill one token per interval and use the PenaltyHalve strategy.
+func TestTokenBucketHalvePenalty(t *testing.T, d anicetus.Detector, interval time.Duration) {
+	fingerprint := anicetus.Fingerprint("halve-penalty")
+
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+
+	// 3/4 of a token is refilled and halved by the detection
+	time.Sleep(interval * 3 / 4)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// without penalty 1.15 tokens would be available now, but there are only
+	// 0.775 tokens, halved again by the detection
+	time.Sleep(interval * 2 / 5)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// with the drain penalty only 3/4 of a token would be available now
+	time.Sleep(interval * 3 / 4)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+}
+
+// TestTokenBucketFixedPenalty checks that the bucket is drained and not
+// refilled for the penalty duration when a thundering herd is detected, and
+// that detections during the penalty don't extend it. The detector must have a
+// burst of 2, refill one token per interval and use the PenaltyFixed strategy
+// with a duration of at least 2 intervals.
+func TestTokenBucketFixedPenalty(t *testing.T, d anicetus.Detector, interval, duration time.Duration) {
+	fingerprint := anicetus.Fingerprint("fixed-penalty")
+
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// with the drain penalty the bucket would be full now
+	time.Sleep(2 * interval)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// a token is refilled after the penalty, that wasn't extended
+	time.Sleep(duration - 2*interval + interval + interval/4)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+}
+
+// TestTokenBucketUpdate checks that updated options are applied to the token
+// buckets and cooldowns of existing and new fingerprints, without losing their
+// state. The detector must have a burst of 1, refill one token per hour and
+// cool down for an hour.
+func TestTokenBucketUpdate(t *testing.T, d TokenBucketUpdater) {
+	fingerprint := anicetus.Fingerprint("update")
+
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+	if err := d.CoolDown(t.Context(), fingerprint); err != nil {
+		t.Fatalf("unexpected error cooling down: %v", err)
+	}
+
+	d.Update(
+		detector.TokenBucketWithBurst(2),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: 100 * time.Millisecond}),
+		detector.TokenBucketWithCoolDownInterval(100*time.Millisecond),
+	)
+
+	other := anicetus.Fingerprint("update-other")
+	if err := d.CoolDown(t.Context(), other); err != nil {
+		t.Fatalf("unexpected error cooling down: %v", err)
+	}
+
+	// the bucket is refilled at the new rate up to the new burst
+	time.Sleep(250 * time.Millisecond)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// the cooldown started before the update keeps its expiration
+	expectCoolDown(t, d, fingerprint, true)
+	expectCoolDown(t, d, other, false)
+}
+
+// TestTokenBucketRate checks that the tokens are refilled at the informed
+// rate, and that the burst is the capacity of the bucket. The detector must
+// have a burst of 2.
+func TestTokenBucketRate(t *testing.T, d anicetus.Detector, rate detector.Rate) {
+	fingerprint := anicetus.Fingerprint("rate")
+
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+
+	// a single token is refilled
+	time.Sleep(rate.DurationOf(1) + rate.DurationOf(1)/4)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	// the bucket is full again, but never above the burst
+	time.Sleep(rate.DurationOf(3))
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+}
+
+// TestSlidingWindowLimit checks that requests up to the limit are allowed, and
+// that the following ones are detected as a thundering herd. The window must
+// not slide during the test.
+func TestSlidingWindowLimit(t *testing.T, d anicetus.Detector, limit int) {
+	fingerprint := anicetus.Fingerprint("limit")
+
+	for i := range limit {
+		if expectThunderingHerd(t, d, fingerprint, false) {
+			t.Fatalf("request %d of the limit detected as thundering herd", i+1)
+		}
+	}
+	expectThunderingHerd(t, d, fingerprint, true)
+	expectThunderingHerd(t, d, fingerprint, true)
+}
+
+// TestSlidingWindowSlide checks that the requests stop counting once the
+// window slides past them, with no penalty for the detection. The detector
+// must have a limit of 2.
+func TestSlidingWindowSlide(t *testing.T, d anicetus.Detector, window time.Duration) {
+	fingerprint := anicetus.Fingerprint("slide")
+
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+
+	time.Sleep(2*window + window/4)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, false)
+	expectThunderingHerd(t, d, fingerprint, true)
+}
+
+// TestIndependentFingerprints checks that each fingerprint has its own bucket
+// or window. The detector must not refill tokens or slide the window during
+// the test.
+func TestIndependentFingerprints(t *testing.T, d anicetus.Detector, burst int) {
+	for _, fingerprint := range []anicetus.Fingerprint{"a", "b"} {
+		for range burst {
+			expectThunderingHerd(t, d, fingerprint, false)
+		}
+	}
+	expectThunderingHerd(t, d, "a", true)
+	expectThunderingHerd(t, d, "b", true)
+}
+
+// TestTokenBucketConcurrency checks that exactly the burst is allowed among
+// concurrent requests. The detector must not refill tokens during the test.
+// It also applies to sliding window detectors, using the limit as the burst
+// and a window that doesn't slide during the test.
+func TestTokenBucketConcurrency(t *testing.T, d anicetus.Detector, burst int) {
+	fingerprint := anicetus.Fingerprint("concurrency")
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range max(concurrentRequests, burst*3) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			if ok, err := d.IsThunderingHerd(t.Context(), fingerprint); err != nil {
+				t.Errorf("unexpected error detecting thundering herd: %v", err)
+			} else if !ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := int(allowed.Load()); n != burst {
+		t.Errorf("unexpected number of allowed requests: got %d, want %d", n, burst)
+	}
+}
+
+func expectCoolDown(t *testing.T, d anicetus.Detector, fingerprint anicetus.Fingerprint, want bool) {
+	t.Helper()
+
+	if ok, err := d.IsCoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error checking cooldown: %v", err)
+	} else if ok != want {
+		t.Errorf("unexpected cooldown for %q: got %v, want %v", fingerprint, ok, want)
+	}
+}
+
+// expectThunderingHerd checks the detection, returning true if it failed.
+func expectThunderingHerd(t *testing.T, d anicetus.Detector, fingerprint anicetus.Fingerprint, want bool) bool {
+	t.Helper()
+
+	ok, err := d.IsThunderingHerd(t.Context(), fingerprint)
+	if err != nil {
+		t.Errorf("unexpected error detecting thundering herd: %v", err)
+		return true
+	}
+	if ok != want {
+		t.Errorf("unexpected thundering herd for %q: got %v, want %v", fingerprint, ok, want)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+// Package detectortest provides conformance test suites for anicetus.Detector
+// implementations.
+//
+// Usage:
+//
+//	func TestMyDetector(t *testing.T) {
+//	  detectortest.RunTokenBucket(t,
+//	    func(t *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+//	      return NewMyDetector(options...)
+//	    },
+//	  )
+//	}
+package detectortest
@@ -0,0 +1,3 @@
+// Package gomemcache provides a detector solution using memcached. This is an
+// implementation using the https://github.com/bradfitz/gomemcache client.
+package gomemcache
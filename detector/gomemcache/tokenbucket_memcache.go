@@ -0,0 +1,229 @@
+package gomemcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+)
+
+var _ anicetus.Detector = &TokenBucketMemcache{}
+
+// maxCASAttempts is the number of times a compare-and-swap is retried when
+// concurrent requests modify the same token bucket.
+const maxCASAttempts = 100
+
+// errTooManyConflicts is returned when a compare-and-swap could not be applied
+// after many attempts.
+var errTooManyConflicts = errors.New("too many concurrent memcached updates")
+
+// TokenBucketMemcache is a token bucket detector strategy that stores the state
+// in memcached. The token buckets are updated with compare-and-swap, so many
+// replicas can share them.
+type TokenBucketMemcache struct {
+	client *memcache.Client
+	logger *slog.Logger
+
+	options atomic.Pointer[detector.TokenBucketOptions]
+	// optionsMutex avoids losing concurrent updates.
+	optionsMutex sync.Mutex
+}
+
+// NewTokenBucketMemcache creates a new token bucket detector strategy using
+// memcached.
+func NewTokenBucketMemcache(client *memcache.Client, options ...detector.TokenBucketOption) *TokenBucketMemcache {
+	o := detector.NewTokenBucketOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	t := &TokenBucketMemcache{
+		client: client,
+		logger: o.Logger(),
+	}
+	t.options.Store(o)
+	return t
+}
+
+// Update applies the options on top of the current ones. The token buckets and
+// cooldowns stored in memcached are kept, and the new options are used from the
+// next request on.
+func (t *TokenBucketMemcache) Update(options ...detector.TokenBucketOption) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+
+	o := *t.options.Load()
+	for _, opt := range options {
+		opt(&o)
+	}
+	t.options.Store(&o)
+}
+
+// CoolDown will cool down the fingerprint.
+func (t *TokenBucketMemcache) CoolDown(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	o := t.options.Load()
+	// the expiration is also stored in the value, as memcached expirations have
+	// a resolution of seconds
+	expiresAt := time.Now().Add(o.CoolDownInterval())
+	err := t.client.Set(&memcache.Item{
+		Key:        addKeyPrefix(fingerprint, modeCoolDown),
+		Value:      []byte(strconv.FormatInt(expiresAt.UnixNano(), 10)),
+		Expiration: expiration(o.CoolDownInterval()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set memcached key: %w", err)
+	}
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (t *TokenBucketMemcache) IsCoolDown(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	item, err := t.client.Get(addKeyPrefix(fingerprint, modeCoolDown))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get memcached key: %w", err)
+	}
+
+	expiresAt, err := strconv.ParseInt(string(item.Value), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid memcached cooldown %q: %w", item.Value, err)
+	}
+	return time.Now().Before(time.Unix(0, expiresAt)), nil
+}
+
+// IsThunderingHerd checks if the fingerprint is a thundering herd. When the
+// bucket is empty, the penalty is applied to it.
+func (t *TokenBucketMemcache) IsThunderingHerd(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	o := t.options.Load()
+	key := addKeyPrefix(fingerprint, modeThunderingHerd)
+	maxTokens := float64(o.Burst())
+
+	for range maxCASAttempts {
+		item, err := t.client.Get(key)
+		exists := err == nil
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return false, fmt.Errorf("failed to get memcached key: %w", err)
+		}
+
+		now := time.Now()
+		b := bucket{tokens: maxTokens, lastRefreshed: now}
+		if exists {
+			if b, err = decodeBucket(item.Value); err != nil {
+				return false, err
+			}
+		}
+
+		// refill the tokens based on elapsed time
+		elapsed := max(now.Sub(b.lastRefreshed), 0)
+		b.tokens = min(maxTokens, b.tokens+elapsed.Seconds()*o.Rate().PerSecond())
+
+		thunderingHerd := b.tokens < 1
+		if thunderingHerd {
+			// apply penalty for thundering herd
+			b.tokens, b.lastRefreshed = o.Penalty().Apply(b.tokens, b.lastRefreshed, now)
+		} else {
+			b.tokens--
+			b.lastRefreshed = now
+		}
+
+		if !exists {
+			err = t.client.Add(&memcache.Item{
+				Key:        key,
+				Value:      b.encode(),
+				Expiration: expiration(fullBucketPeriod(o)),
+			})
+		} else {
+			item.Value = b.encode()
+			item.Expiration = expiration(fullBucketPeriod(o))
+			err = t.client.CompareAndSwap(item)
+		}
+		if errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCASConflict) {
+			continue
+		} else if err != nil {
+			return false, fmt.Errorf("failed to store memcached key: %w", err)
+		}
+		return thunderingHerd, nil
+	}
+	return false, errTooManyConflicts
+}
+
+// fullBucketPeriod is the time needed to refill an empty bucket, including any
+// pause of the penalty. After that the stored bucket has no effect and can
+// expire.
+func fullBucketPeriod(o *detector.TokenBucketOptions) time.Duration {
+	period := o.Rate().DurationOf(o.Burst())
+	if pause := o.Penalty().Pause(); period <= math.MaxInt64-pause {
+		period += pause
+	}
+	return period
+}
+
+// bucket is the token bucket state stored in memcached for each fingerprint.
+type bucket struct {
+	tokens        float64
+	lastRefreshed time.Time
+}
+
+// encode encodes the bucket as "<tokens> <last refreshed unix nanoseconds>".
+func (b bucket) encode() []byte {
+	return []byte(strconv.FormatFloat(b.tokens, 'f', -1, 64) + " " + strconv.FormatInt(b.lastRefreshed.UnixNano(), 10))
+}
+
+// decodeBucket decodes a bucket encoded by bucket.encode.
+func decodeBucket(data []byte) (bucket, error) {
+	rawTokens, rawLastRefreshed, ok := strings.Cut(string(data), " ")
+	if !ok {
+		return bucket{}, fmt.Errorf("invalid memcached token bucket %q", data)
+	}
+	tokens, err := strconv.ParseFloat(rawTokens, 64)
+	if err != nil {
+		return bucket{}, fmt.Errorf("invalid memcached token bucket tokens %q: %w", rawTokens, err)
+	}
+	lastRefreshed, err := strconv.ParseInt(rawLastRefreshed, 10, 64)
+	if err != nil {
+		return bucket{}, fmt.Errorf("invalid memcached token bucket refresh %q: %w", rawLastRefreshed, err)
+	}
+	return bucket{tokens: tokens, lastRefreshed: time.Unix(0, lastRefreshed)}, nil
+}
+
+// relativeExpirationLimit is the maximum expiration, in seconds, that memcached
+// handles as relative to the current time.
+const relativeExpirationLimit = 60 * 60 * 24 * 30
+
+// expiration converts the duration to a memcached expiration, rounding it up to
+// seconds.
+func expiration(d time.Duration) int32 {
+	if d <= 0 {
+		return 0
+	}
+	seconds := math.Ceil(d.Seconds())
+	if seconds > relativeExpirationLimit {
+		// memcached handles big expirations as unix timestamps
+		return int32(time.Now().Add(d).Unix())
+	}
+	return int32(seconds)
+}
+
+type mode string
+
+const (
+	modeCoolDown       mode = "cooldown"
+	modeThunderingHerd mode = "th"
+)
+
+// addKeyPrefix adds the key prefix to the fingerprint to correctly set the
+// scope.
+func addKeyPrefix(fingerprint anicetus.Fingerprint, m mode) string {
+	return "anicetus:" + string(m) + ":" + fingerprint.String()
+}
@@ -0,0 +1,159 @@
+package gomemcache_test
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+	"github.com/rafaeljusto/anicetus/v2/detector/gomemcache"
+	"github.com/rafaeljusto/anicetus/v2/internal/memcachetest"
+)
+
+func TestTokenBucketMemcache_IsThunderingHerd(t *testing.T) {
+	tests := []struct {
+		burst      int64
+		interval   time.Duration
+		cycles     int
+		cycleSleep func(cycle int) time.Duration
+		want       func(cycle int) bool
+	}{{
+		burst:    1,
+		interval: time.Second,
+		cycles:   2,
+		want: func(cycle int) bool {
+			return cycle == 2
+		},
+	}, {
+		burst:    4,
+		interval: 500 * time.Millisecond,
+		cycles:   7,
+		cycleSleep: func(cycle int) time.Duration {
+			if cycle == 6 {
+				// sleep longer to allow populating 1 token and avoid thundering herd
+				return 500 * time.Millisecond
+			}
+			return 100 * time.Millisecond
+		},
+		want: func(cycle int) bool {
+			return slices.Contains([]int{5, 6}, cycle)
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("interval %s and burst %d", tt.interval, tt.burst), func(t *testing.T) {
+			detector := gomemcache.NewTokenBucketMemcache(newClient(t),
+				detector.TokenBucketWithBurst(tt.burst),
+				detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: tt.interval}),
+			)
+
+			for i := 1; i <= tt.cycles; i++ {
+				t.Run("cycle"+strconv.Itoa(i), func(t *testing.T) {
+					ok, err := detector.IsThunderingHerd(t.Context(), anicetus.Fingerprint("test"))
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					if want := tt.want(i); ok != want {
+						t.Errorf("unexpected result: got %v, want %v", ok, want)
+					}
+					if tt.cycleSleep != nil {
+						if sleep := tt.cycleSleep(i); sleep > 0 {
+							time.Sleep(sleep)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestTokenBucketMemcache_IsThunderingHerd_concurrent(t *testing.T) {
+	detector := gomemcache.NewTokenBucketMemcache(newClient(t),
+		detector.TokenBucketWithBurst(10),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+	)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ok, err := detector.IsThunderingHerd(t.Context(), anicetus.Fingerprint("test"))
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := allowed.Load(); n != 10 {
+		t.Errorf("unexpected number of allowed requests: %d", n)
+	}
+}
+
+func TestTokenBucketMemcache_CoolDown(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	detector := gomemcache.NewTokenBucketMemcache(newClient(t),
+		detector.TokenBucketWithCoolDownInterval(100*time.Millisecond),
+	)
+
+	if ok, err := detector.IsCoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be in cooldown")
+	}
+
+	if err := detector.CoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := detector.IsCoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be in cooldown")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if ok, err := detector.IsCoolDown(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint cooldown should be expired")
+	}
+}
+
+func newClient(t *testing.T) *memcache.Client {
+	t.Helper()
+
+	server, err := memcachetest.NewServer()
+	if err != nil {
+		t.Fatalf("failed to start memcached server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	client := memcache.New(server.Addr())
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func TestTokenBucketMemcache_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(t *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		return gomemcache.NewTokenBucketMemcache(newClient(t), options...)
+	})
+}
@@ -0,0 +1,329 @@
+package goredis
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	_ anicetus.Detector          = &TokenBucketRedis{}
+	_ anicetus.DetectorInspector = &TokenBucketRedis{}
+
+	tokenBucketScript = redis.NewScript(`
+-- Token Bucket rate limiter
+-- KEYS[1]: The Redis key for storing the token bucket
+-- ARGV[1]: Maximum capacity of the bucket (max_tokens)
+-- ARGV[2]: Refill rate per second (tokens_per_second)
+-- ARGV[3]: Penalty strategy (drain, none, halve or fixed)
+-- ARGV[4]: Penalty duration in seconds, used by the fixed strategy
+
+local key = KEYS[1]
+local max_tokens = tonumber(ARGV[1])
+local refill_rate = tonumber(ARGV[2])
+local penalty = ARGV[3]
+local penalty_duration = tonumber(ARGV[4])
+local requested_tokens = 1
+local current_time = redis.call("TIME")
+local now = tonumber(current_time[1]) + tonumber(current_time[2]) / 1000000
+
+-- Fetch the stored bucket data
+local bucket = redis.call("HMGET", key, "tokens", "last_refreshed")
+local tokens = tonumber(bucket[1]) or max_tokens
+local last_refreshed = tonumber(bucket[2]) or now
+
+-- Refill the tokens based on elapsed time, that is never negative while the
+-- refill is paused by a penalty
+local elapsed_time = math.max(now - last_refreshed, 0)
+local new_tokens = math.min(max_tokens, tokens + (elapsed_time * refill_rate))
+
+-- Check if we have enough tokens
+if new_tokens >= requested_tokens then
+  new_tokens = new_tokens - requested_tokens
+
+  local hmset_result = redis.call("HMSET", key, "tokens", new_tokens, "last_refreshed", now)
+  if not hmset_result then
+    redis.log(redis.LOG_NOTICE, "anicetus: failed to update token bucket for key: " .. key)
+  end
+
+  redis.call("EXPIRE", key, math.ceil(max_tokens / refill_rate))
+  return 1 -- Allowed
+
+else
+  -- apply penalty for thundering herd
+  local penalty_last_refreshed = now
+  if penalty == "none" then
+    -- keep the tokens
+  elseif penalty == "halve" then
+    new_tokens = new_tokens / 2
+  elseif penalty == "fixed" then
+    if last_refreshed > now then
+      -- already penalized
+      penalty_last_refreshed = last_refreshed
+    else
+      new_tokens = 0
+      penalty_last_refreshed = now + penalty_duration
+    end
+  else
+    new_tokens = 0
+  end
+
+  local hmset_result = redis.call("HMSET", key, "tokens", new_tokens, "last_refreshed", penalty_last_refreshed)
+  if not hmset_result then
+    redis.log(redis.LOG_NOTICE, "anicetus: failed to update token bucket for key: " .. key)
+    return 1 -- Allowed
+  end
+
+  redis.call("EXPIRE", key, math.ceil(max_tokens / refill_rate + penalty_last_refreshed - now))
+  return 0 -- Thundering herd
+end
+`)
+)
+
+// TokenBucketRedis is a token bucket detector strategy that stores the state in
+// Redis.
+type TokenBucketRedis struct {
+	client redis.UniversalClient
+	logger *slog.Logger
+
+	options atomic.Pointer[detector.TokenBucketOptions]
+	// optionsMutex avoids losing concurrent updates.
+	optionsMutex sync.Mutex
+}
+
+// NewTokenBucketRedis creates a new token bucket detector strategy.
+func NewTokenBucketRedis(client redis.UniversalClient, options ...detector.TokenBucketOption) *TokenBucketRedis {
+	o := detector.NewTokenBucketOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	t := &TokenBucketRedis{
+		client: client,
+		logger: o.Logger(),
+	}
+	t.options.Store(o)
+	return t
+}
+
+// Update applies the options on top of the current ones. The token buckets and
+// cooldowns stored in Redis are kept, and the new options are used from the
+// next request on.
+func (t *TokenBucketRedis) Update(options ...detector.TokenBucketOption) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+
+	o := *t.options.Load()
+	for _, opt := range options {
+		opt(&o)
+	}
+	t.options.Store(&o)
+}
+
+// CoolDown will cool down the fingerprint.
+func (t *TokenBucketRedis) CoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	o := t.options.Load()
+	result, err := t.client.Set(ctx, addKeyPrefix(fingerprint, modeCoolDown), 1, o.CoolDownInterval()).Result()
+	if err != nil {
+		return fmt.Errorf("failed to set redis key: %w", err)
+	}
+	if result != "OK" {
+		return fmt.Errorf("failed to set redis key")
+	}
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (t *TokenBucketRedis) IsCoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	result, err := t.client.Exists(ctx, addKeyPrefix(fingerprint, modeCoolDown)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check redis key: %w", err)
+	}
+	return result == 1, nil
+}
+
+// IsThunderingHerd checks if the fingerprint is a thundering herd.
+func (t *TokenBucketRedis) IsThunderingHerd(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	o := t.options.Load()
+	allow, err := tokenBucketScript.Run(ctx, t.client, []string{addKeyPrefix(fingerprint, modeThunderingHerd)},
+		o.Burst(),                      // max tokens
+		o.Rate().PerSecond(),           // refill rate
+		string(o.Penalty().Strategy),   // penalty strategy
+		o.Penalty().Duration.Seconds(), // penalty duration
+	).Bool()
+	if err != nil {
+		return false, fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	return !allow, nil
+}
+
+// ListDetections returns a page of detector states using the Redis SCAN
+// cursor. Token buckets are listed first, followed by the cooldowns of
+// fingerprints without a token bucket. When using a cluster client, the scan is
+// executed in a single node.
+func (t *TokenBucketRedis) ListDetections(
+	ctx context.Context,
+	cursor string,
+	limit int,
+) ([]anicetus.DetectorState, string, error) {
+	m, scanCursor := modeThunderingHerd, uint64(0)
+	if cursor != "" {
+		prefix, value, ok := strings.Cut(cursor, ":")
+		if !ok || (mode(prefix) != modeThunderingHerd && mode(prefix) != modeCoolDown) {
+			return nil, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+		var err error
+		if scanCursor, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, "", fmt.Errorf("invalid cursor %q: %w", cursor, err)
+		}
+		m = mode(prefix)
+	}
+
+	keys, scanCursor, err := t.client.Scan(ctx, scanCursor, addKeyPrefix("*", m), int64(max(limit, 0))).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan redis keys: %w", err)
+	}
+
+	switch {
+	case scanCursor != 0:
+		cursor = string(m) + ":" + strconv.FormatUint(scanCursor, 10)
+	case m == modeThunderingHerd:
+		cursor = string(modeCoolDown) + ":0"
+	default:
+		cursor = ""
+	}
+
+	now, err := t.client.Time(ctx).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get redis time: %w", err)
+	}
+
+	fingerprints := make([]anicetus.Fingerprint, len(keys))
+	pipeline := t.client.Pipeline()
+	commands := make([]inspectCommands, len(keys))
+	for i, key := range keys {
+		fingerprints[i] = removeKeyPrefix(key, m)
+		commands[i] = t.queueInspect(ctx, pipeline, fingerprints[i])
+	}
+	if len(keys) > 0 {
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return nil, "", fmt.Errorf("failed to execute redis pipeline: %w", err)
+		}
+	}
+
+	states := make([]anicetus.DetectorState, 0, len(keys))
+	for i, fingerprint := range fingerprints {
+		state, bucketFound, coolDownFound := t.parseInspect(fingerprint, commands[i], now)
+		// fingerprints with a token bucket were already listed in the first phase
+		if m == modeCoolDown && bucketFound {
+			continue
+		}
+		if bucketFound || coolDownFound {
+			states = append(states, state)
+		}
+	}
+	return states, cursor, nil
+}
+
+// InspectDetection returns the detector state of the fingerprint.
+func (t *TokenBucketRedis) InspectDetection(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+) (anicetus.DetectorState, bool, error) {
+	now, err := t.client.Time(ctx).Result()
+	if err != nil {
+		return anicetus.DetectorState{}, false, fmt.Errorf("failed to get redis time: %w", err)
+	}
+
+	pipeline := t.client.Pipeline()
+	commands := t.queueInspect(ctx, pipeline, fingerprint)
+	if _, err := pipeline.Exec(ctx); err != nil {
+		return anicetus.DetectorState{}, false, fmt.Errorf("failed to execute redis pipeline: %w", err)
+	}
+
+	state, bucketFound, coolDownFound := t.parseInspect(fingerprint, commands, now)
+	return state, bucketFound || coolDownFound, nil
+}
+
+// inspectCommands stores the pipelined commands to retrieve the state of a
+// fingerprint.
+type inspectCommands struct {
+	bucket      *redis.SliceCmd
+	coolDownTTL *redis.DurationCmd
+}
+
+// queueInspect queues in the pipeline the commands to retrieve the state of
+// the fingerprint.
+func (t *TokenBucketRedis) queueInspect(
+	ctx context.Context,
+	pipeline redis.Pipeliner,
+	fingerprint anicetus.Fingerprint,
+) inspectCommands {
+	return inspectCommands{
+		bucket:      pipeline.HMGet(ctx, addKeyPrefix(fingerprint, modeThunderingHerd), "tokens", "last_refreshed"),
+		coolDownTTL: pipeline.PTTL(ctx, addKeyPrefix(fingerprint, modeCoolDown)),
+	}
+}
+
+// parseInspect parses the replies of the commands queued by queueInspect.
+func (t *TokenBucketRedis) parseInspect(
+	fingerprint anicetus.Fingerprint,
+	commands inspectCommands,
+	now time.Time,
+) (state anicetus.DetectorState, bucketFound, coolDownFound bool) {
+	o := t.options.Load()
+	state = anicetus.DetectorState{
+		Fingerprint: fingerprint,
+		Tokens:      float64(o.Burst()),
+	}
+
+	if bucket := commands.bucket.Val(); len(bucket) == 2 && bucket[0] != nil {
+		bucketFound = true
+		tokensStr, _ := bucket[0].(string)
+		lastRefreshedStr, _ := bucket[1].(string)
+		tokens, _ := strconv.ParseFloat(tokensStr, 64)
+		lastRefreshed, _ := strconv.ParseFloat(lastRefreshedStr, 64)
+		elapsed := max(float64(now.UnixMicro())/1e6-lastRefreshed, 0)
+		state.Tokens = min(float64(o.Burst()), tokens+elapsed*o.Rate().PerSecond())
+	}
+
+	// PTTL returns negative values when the key doesn't exist or when it has no
+	// expiration
+	if coolDownTTL := commands.coolDownTTL.Val(); coolDownTTL >= 0 {
+		coolDownFound = true
+		state.CoolDownRemaining = coolDownTTL
+	}
+	return state, bucketFound, coolDownFound
+}
+
+// mode is used to set the correct redis scope for the keys.
+type mode string
+
+const (
+	// modeCoolDown is the mode used to check if the fingerprint is in cooldown.
+	modeCoolDown mode = "cooldown"
+	// modeThunderingHerd is the mode used to check if the fingerprint is a
+	// thundering herd.
+	modeThunderingHerd mode = "th"
+)
+
+// addKeyPrefix adds the key prefix to the fingerprint to correctly set the
+// scope.
+func addKeyPrefix(fingerprint anicetus.Fingerprint, m mode) string {
+	return fmt.Sprintf("anicetus:%s:%s", m, fingerprint)
+}
+
+// removeKeyPrefix removes the key prefix of the scope, returning the
+// fingerprint.
+func removeKeyPrefix(key string, m mode) anicetus.Fingerprint {
+	return anicetus.Fingerprint(strings.TrimPrefix(key, addKeyPrefix("", m)))
+}
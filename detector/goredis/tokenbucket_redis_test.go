@@ -0,0 +1,194 @@
+//go:build integration_tests
+// +build integration_tests
+
+package goredis_test
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+	"github.com/rafaeljusto/anicetus/v2/detector/goredis"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultRedisAddress = "localhost:6379"
+
+func TestTokenBucketRedis_IsThunderingHerd(t *testing.T) {
+	tests := []struct {
+		burst      int64
+		interval   time.Duration
+		cycles     int
+		cycleSleep func(cycle int) time.Duration
+		want       func(cycle int) bool
+	}{{
+		burst:    1,
+		interval: time.Second,
+		cycles:   2,
+		want: func(cycle int) bool {
+			return cycle == 2
+		},
+	}, {
+		burst:    4,
+		interval: 500 * time.Millisecond,
+		cycles:   6,
+		cycleSleep: func(cycle int) time.Duration {
+			if cycle == 6 {
+				// sleep longer to allow populating 1 token and avoid thundering herd
+				return 500 * time.Millisecond
+			}
+			return 100 * time.Millisecond
+		},
+		want: func(cycle int) bool {
+			// this may fail if the I/O with Redis is too slow, as the filling rate
+			// will give a chance for cycle 5
+			return slices.Contains([]int{5, 6}, cycle)
+		},
+	}}
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisAddress},
+	})
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			t.Errorf("failed to close redis client: %v", err)
+		}
+	}()
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("interval %s and burst %d", tt.interval, tt.burst), func(t *testing.T) {
+			if err := redisClient.FlushDB(t.Context()).Err(); err != nil {
+				t.Fatalf("failed to flush redis database: %v", err)
+			}
+
+			detector := goredis.NewTokenBucketRedis(
+				redisClient,
+				detector.TokenBucketWithBurst(tt.burst),
+				detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: tt.interval}),
+			)
+
+			for i := 1; i <= tt.cycles; i++ {
+				t.Run("cycle"+strconv.Itoa(i), func(t *testing.T) {
+					ok, err := detector.IsThunderingHerd(t.Context(), anicetus.Fingerprint("test"))
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					if want := tt.want(i); ok != want {
+						t.Errorf("unexpected result: got %v, want %v", ok, want)
+					}
+					if tt.cycleSleep != nil {
+						if sleep := tt.cycleSleep(i); sleep > 0 {
+							time.Sleep(sleep)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestTokenBucketRedis_inspect(t *testing.T) {
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisAddress},
+	})
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			t.Errorf("failed to close redis client: %v", err)
+		}
+	}()
+
+	if err := redisClient.FlushDB(t.Context()).Err(); err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	detector := goredis.NewTokenBucketRedis(
+		redisClient,
+		detector.TokenBucketWithBurst(2),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		detector.TokenBucketWithCoolDownInterval(time.Minute),
+	)
+
+	if _, err := detector.IsThunderingHerd(t.Context(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := detector.CoolDown(t.Context(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := detector.CoolDown(t.Context(), "b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	states := make(map[anicetus.Fingerprint]anicetus.DetectorState)
+	var cursor string
+	for {
+		page, next, err := detector.ListDetections(t.Context(), cursor, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, state := range page {
+			if _, ok := states[state.Fingerprint]; ok {
+				t.Errorf("fingerprint %q listed twice", state.Fingerprint)
+			}
+			states[state.Fingerprint] = state
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	if len(states) != 2 {
+		t.Fatalf("unexpected states: %+v", states)
+	}
+	if state := states["a"]; state.Tokens < 1 || state.Tokens > 1.01 || state.CoolDownRemaining <= 0 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+	if state := states["b"]; state.Tokens != 2 || state.CoolDownRemaining <= 0 || state.CoolDownRemaining > time.Minute {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	if _, ok, err := detector.InspectDetection(t.Context(), "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be tracked")
+	}
+}
+
+func TestTokenBucketRedis_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(t *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		redisAddress := defaultRedisAddress
+		if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+			redisAddress = e
+		}
+
+		redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs: []string{redisAddress},
+		})
+		t.Cleanup(func() {
+			if err := redisClient.Close(); err != nil {
+				t.Errorf("failed to close redis client: %v", err)
+			}
+		})
+
+		if err := redisClient.FlushDB(t.Context()).Err(); err != nil {
+			t.Fatalf("failed to flush redis database: %v", err)
+		}
+
+		return goredis.NewTokenBucketRedis(redisClient, options...)
+	})
+}
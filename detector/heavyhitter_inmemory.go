@@ -0,0 +1,140 @@
+package detector
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/mapexp"
+	"github.com/rafaeljusto/anicetus/v2/internal/sketch"
+)
+
+var _ anicetus.Detector = &HeavyHitterInMemory{}
+
+// HeavyHitterInMemory is a detector strategy that stores the state in memory
+// using fixed memory, whatever the number of fingerprints. The requests are
+// counted in a count-min sketch, and a fingerprint among the top K hot
+// fingerprints with more estimated requests than the limit in the last window
+// is a thundering herd. The count of the sliding window is estimated like in
+// SlidingWindowInMemory, so the previous counts decay as the window advances.
+//
+// The sketch never underestimates, so a thundering herd is always detected, but
+// a fingerprint can be overestimated within the configured error bounds. The
+// cooldowns are limited to the top K size, evicting the least recently cooled
+// down fingerprints.
+type HeavyHitterInMemory struct {
+	cooldowns *mapexp.Map[anicetus.Fingerprint, bool]
+	// mutex protects the sketches and the top K.
+	mutex    sync.Mutex
+	windowID int64
+	current  *sketch.CountMin
+	previous *sketch.CountMin
+	topK     *sketch.TopK
+	limit    int64
+	window   time.Duration
+}
+
+// NewHeavyHitterInMemory creates a new heavy-hitter detector strategy.
+func NewHeavyHitterInMemory(options ...HeavyHitterOption) *HeavyHitterInMemory {
+	o := NewHeavyHitterOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	epsilon, delta := o.ErrorBounds()
+	h := &HeavyHitterInMemory{
+		current:  sketch.NewCountMin(epsilon, delta),
+		previous: sketch.NewCountMin(epsilon, delta),
+		topK:     sketch.NewTopK(o.TopK()),
+		limit:    o.Limit(),
+		window:   o.Window(),
+	}
+	h.cooldowns = mapexp.New[anicetus.Fingerprint, bool](o.CoolDownInterval())
+	// only hot fingerprints are detected and cooled down, so the cooldowns are
+	// bounded like them
+	h.cooldowns.SetMaxEntries(o.TopK())
+	return h
+}
+
+// CoolDown will cool down the fingerprint.
+func (h *HeavyHitterInMemory) CoolDown(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	h.cooldowns.Set(fingerprint, true)
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (h *HeavyHitterInMemory) IsCoolDown(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	cooldown, ok := h.cooldowns.Peek(fingerprint)
+	return cooldown && ok, nil
+}
+
+// IsThunderingHerd counts the request and checks if the fingerprint is a
+// thundering herd.
+func (h *HeavyHitterInMemory) IsThunderingHerd(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	h.slide(now)
+
+	key := string(fingerprint)
+	current := h.current.Add(key)
+	count := float64(h.previous.Estimate(key))*(1-h.elapsed(now)) + float64(current)
+
+	hot := h.topK.Update(key, uint32(math.Ceil(count)))
+	return hot && count > float64(h.limit), nil
+}
+
+// HeavyHitter is a hot fingerprint with its estimated requests in the last
+// window.
+type HeavyHitter struct {
+	Fingerprint anicetus.Fingerprint
+	Count       uint32
+}
+
+// HeavyHitters returns the top K hot fingerprints, hottest first. The counts
+// are estimated when the fingerprints last received a request.
+func (h *HeavyHitterInMemory) HeavyHitters() []HeavyHitter {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.slide(time.Now())
+
+	items := h.topK.Items()
+	heavyHitters := make([]HeavyHitter, len(items))
+	for i, item := range items {
+		heavyHitters[i] = HeavyHitter{
+			Fingerprint: anicetus.Fingerprint(item.Key),
+			Count:       item.Count,
+		}
+	}
+	return heavyHitters
+}
+
+// slide moves to the window of the informed time, making the current sketch
+// the previous one when a new window starts. It must be called with the mutex
+// locked.
+func (h *HeavyHitterInMemory) slide(now time.Time) {
+	windowID := now.UnixNano() / int64(h.window)
+	switch {
+	case windowID == h.windowID:
+		return
+	case windowID == h.windowID+1:
+		h.previous, h.current = h.current, h.previous
+		h.current.Reset()
+	default:
+		h.previous.Reset()
+		h.current.Reset()
+	}
+	h.windowID = windowID
+
+	// at the beginning of the window, the counts are the previous window counts
+	h.topK.Recount(h.previous.Estimate)
+}
+
+// elapsed returns the fraction of the current window that already passed.
+func (h *HeavyHitterInMemory) elapsed(now time.Time) float64 {
+	return float64(now.UnixNano()-h.windowID*int64(h.window)) / float64(h.window)
+}
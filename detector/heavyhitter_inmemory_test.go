@@ -0,0 +1,180 @@
+package detector_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+)
+
+func TestHeavyHitterInMemory_IsThunderingHerd(t *testing.T) {
+	detector := detector.NewHeavyHitterInMemory(
+		detector.HeavyHitterWithLimit(100),
+		detector.HeavyHitterWithWindow(time.Hour),
+		detector.HeavyHitterWithErrorBounds(0.001, 0.01),
+		detector.HeavyHitterWithTopK(10),
+	)
+
+	// many cold fingerprints, with a hot one in the middle of them
+	var detected int
+	for i := range 100_000 {
+		fingerprint := anicetus.Fingerprint("cold/" + strconv.Itoa(i))
+		if i%500 == 0 {
+			fingerprint = "hot"
+		}
+		ok, err := detector.IsThunderingHerd(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok {
+			if fingerprint != "hot" {
+				t.Fatalf("cold fingerprint %q detected as thundering herd", fingerprint)
+			}
+			detected++
+		}
+	}
+
+	// the hot fingerprint had 200 requests, the last 100 above the limit. It is
+	// never underestimated, but it may be overestimated by up to 0.1% of all
+	// requests (100), being detected earlier
+	if detected < 100 || detected > 200 {
+		t.Errorf("unexpected thundering herd detections: %d", detected)
+	}
+
+	heavyHitters := detector.HeavyHitters()
+	if len(heavyHitters) == 0 || len(heavyHitters) > 10 {
+		t.Fatalf("unexpected heavy hitters: %+v", heavyHitters)
+	}
+	if heavyHitter := heavyHitters[0]; heavyHitter.Fingerprint != "hot" || heavyHitter.Count < 200 {
+		t.Errorf("unexpected hottest fingerprint: %+v", heavyHitter)
+	}
+}
+
+func TestHeavyHitterWithErrorBounds(t *testing.T) {
+	tests := []struct {
+		name        string
+		epsilon     float64
+		delta       float64
+		wantEpsilon float64
+		wantDelta   float64
+	}{{
+		name:        "it should set valid error bounds",
+		epsilon:     0.01,
+		delta:       0.05,
+		wantEpsilon: 0.01,
+		wantDelta:   0.05,
+	}, {
+		name:        "it should ignore a zero epsilon",
+		epsilon:     0,
+		delta:       0.05,
+		wantEpsilon: 0.0001,
+		wantDelta:   0.01,
+	}, {
+		name:        "it should ignore a negative epsilon",
+		epsilon:     -0.01,
+		delta:       0.05,
+		wantEpsilon: 0.0001,
+		wantDelta:   0.01,
+	}, {
+		name:        "it should ignore a zero delta",
+		epsilon:     0.01,
+		delta:       0,
+		wantEpsilon: 0.0001,
+		wantDelta:   0.01,
+	}, {
+		name:        "it should ignore a delta of 1 or more",
+		epsilon:     0.01,
+		delta:       1,
+		wantEpsilon: 0.0001,
+		wantDelta:   0.01,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := detector.NewHeavyHitterOptions()
+			detector.HeavyHitterWithErrorBounds(tt.epsilon, tt.delta)(o)
+
+			if epsilon, delta := o.ErrorBounds(); epsilon != tt.wantEpsilon || delta != tt.wantDelta {
+				t.Errorf("unexpected error bounds %v and %v", epsilon, delta)
+			}
+
+			// the detector is created with the resulting bounds
+			if _, err := detector.NewHeavyHitterInMemory(
+				detector.HeavyHitterWithErrorBounds(tt.epsilon, tt.delta),
+			).IsThunderingHerd(t.Context(), "test"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHeavyHitterInMemory_CoolDown_bounded(t *testing.T) {
+	detector := detector.NewHeavyHitterInMemory(
+		detector.HeavyHitterWithTopK(2),
+	)
+
+	for _, fingerprint := range []anicetus.Fingerprint{"a", "b", "c"} {
+		if err := detector.CoolDown(t.Context(), fingerprint); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// only the last top K cooldowns are kept
+	for fingerprint, want := range map[anicetus.Fingerprint]bool{"a": false, "b": true, "c": true} {
+		if cooldown, err := detector.IsCoolDown(t.Context(), fingerprint); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if cooldown != want {
+			t.Errorf("unexpected cooldown of %q: %t", fingerprint, cooldown)
+		}
+	}
+}
+
+func TestHeavyHitterInMemory_conformance(t *testing.T) {
+	t.Run("CoolDown", func(t *testing.T) {
+		detectortest.TestCoolDown(t, detector.NewHeavyHitterInMemory(
+			detector.HeavyHitterWithCoolDownInterval(200*time.Millisecond),
+		), 200*time.Millisecond)
+	})
+	t.Run("Limit", func(t *testing.T) {
+		detectortest.TestSlidingWindowLimit(t, detector.NewHeavyHitterInMemory(
+			detector.HeavyHitterWithLimit(3),
+			detector.HeavyHitterWithWindow(time.Hour),
+		), 3)
+	})
+	t.Run("Slide", func(t *testing.T) {
+		detectortest.TestSlidingWindowSlide(t, detector.NewHeavyHitterInMemory(
+			detector.HeavyHitterWithLimit(2),
+			detector.HeavyHitterWithWindow(200*time.Millisecond),
+		), 200*time.Millisecond)
+	})
+	t.Run("IndependentFingerprints", func(t *testing.T) {
+		detectortest.TestIndependentFingerprints(t, detector.NewHeavyHitterInMemory(
+			detector.HeavyHitterWithLimit(1),
+			detector.HeavyHitterWithWindow(time.Hour),
+		), 1)
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		detectortest.TestTokenBucketConcurrency(t, detector.NewHeavyHitterInMemory(
+			detector.HeavyHitterWithLimit(10),
+			detector.HeavyHitterWithWindow(time.Hour),
+		), 10)
+	})
+}
+
+func BenchmarkHeavyHitterInMemory_IsThunderingHerd(b *testing.B) {
+	detector := detector.NewHeavyHitterInMemory()
+
+	fingerprints := make([]anicetus.Fingerprint, 1_000_000)
+	for i := range fingerprints {
+		fingerprints[i] = anicetus.Fingerprint("/users/" + strconv.Itoa(i))
+	}
+
+	for i := 0; b.Loop(); i++ {
+		if _, err := detector.IsThunderingHerd(b.Context(), fingerprints[i%len(fingerprints)]); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package detector
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/mapexp"
+)
+
+var _ anicetus.OutcomeDetector = &LatencyInMemory{}
+
+// LatencyInMemory is a detector strategy that stores the state in memory and
+// combines the request rate with the latency of the backend. Like
+// SlidingWindowInMemory, a fingerprint with more requests than the limit in the
+// last window is a thundering herd, but the limit is reduced in the same
+// proportion that the observed latency exceeds the target latency. The
+// latencies are informed with ReportOutcome.
+type LatencyInMemory struct {
+	cooldowns *mapexp.Map[anicetus.Fingerprint, bool]
+	counters  *mapexp.Map[anicetus.Fingerprint, *slidingWindowCounter]
+	// countersMutex avoids concurrent requests of a new fingerprint from
+	// creating different counters.
+	countersMutex sync.Mutex
+	latencies     *mapexp.Map[string, *latencyAverage]
+	// latenciesMutex avoids concurrent outcomes of a new group from creating
+	// different averages.
+	latenciesMutex sync.Mutex
+	options        atomic.Pointer[LatencyOptions]
+	// optionsMutex avoids losing concurrent updates.
+	optionsMutex sync.Mutex
+}
+
+// NewLatencyInMemory creates a new latency-aware detector strategy.
+func NewLatencyInMemory(options ...LatencyOption) *LatencyInMemory {
+	o := NewLatencyOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	l := &LatencyInMemory{
+		cooldowns: mapexp.New[anicetus.Fingerprint, bool](o.CoolDownInterval()),
+		// after two windows without requests the counter would be empty
+		counters: mapexp.New[anicetus.Fingerprint, *slidingWindowCounter](2 * o.Window()),
+		// latencies not reported for two windows are outdated
+		latencies: mapexp.New[string, *latencyAverage](2 * o.Window()),
+	}
+	l.options.Store(o)
+	return l
+}
+
+// Update applies the options on top of the current ones. The cooldowns already
+// started keep their expiration and the observed latencies are kept. When the
+// window changes, the requests are counted again from the next window.
+func (l *LatencyInMemory) Update(options ...LatencyOption) {
+	l.optionsMutex.Lock()
+	defer l.optionsMutex.Unlock()
+
+	o := *l.options.Load()
+	for _, opt := range options {
+		opt(&o)
+	}
+	l.options.Store(&o)
+
+	l.cooldowns.SetTTL(o.CoolDownInterval())
+	l.counters.SetTTL(2 * o.Window())
+	l.latencies.SetTTL(2 * o.Window())
+}
+
+// CoolDown will cool down the fingerprint.
+func (l *LatencyInMemory) CoolDown(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	l.cooldowns.Set(fingerprint, true)
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (l *LatencyInMemory) IsCoolDown(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	cooldown, ok := l.cooldowns.Peek(fingerprint)
+	return cooldown && ok, nil
+}
+
+// IsThunderingHerd counts the request and checks if the fingerprint is a
+// thundering herd, considering the latency observed for its group.
+func (l *LatencyInMemory) IsThunderingHerd(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	l.countersMutex.Lock()
+	counter, ok := l.counters.Get(fingerprint)
+	if !ok {
+		counter = new(slidingWindowCounter)
+		l.counters.Set(fingerprint, counter)
+	}
+	l.countersMutex.Unlock()
+
+	return counter.add(time.Now(), l.options.Load().Window()) > l.Limit(fingerprint), nil
+}
+
+// ReportOutcome adds the latency of the request to the moving average of the
+// fingerprint's group. Failed requests are considered at least as slow as the
+// target latency.
+func (l *LatencyInMemory) ReportOutcome(_ context.Context, fingerprint anicetus.Fingerprint, outcome anicetus.Outcome) error {
+	o := l.options.Load()
+
+	latency := outcome.Latency
+	if outcome.Failed {
+		latency = max(latency, o.TargetLatency())
+	}
+
+	group := o.Group()(fingerprint)
+
+	l.latenciesMutex.Lock()
+	average, ok := l.latencies.Get(group)
+	if !ok {
+		average = new(latencyAverage)
+		l.latencies.Set(group, average)
+	}
+	l.latenciesMutex.Unlock()
+
+	average.add(latency, o.Smoothing())
+	return nil
+}
+
+// Limit returns the number of requests currently allowed in the window for
+// the fingerprint, reduced by the latency observed for its group. It is never
+// lower than one request.
+func (l *LatencyInMemory) Limit(fingerprint anicetus.Fingerprint) float64 {
+	o := l.options.Load()
+
+	average, ok := l.latencies.Peek(o.Group()(fingerprint))
+	if !ok {
+		return float64(o.Limit())
+	}
+
+	latency := average.value()
+	if latency <= o.TargetLatency() {
+		return float64(o.Limit())
+	}
+	return max(float64(o.Limit())*float64(o.TargetLatency())/float64(latency), 1)
+}
+
+// latencyAverage is an exponentially weighted moving average of latencies.
+type latencyAverage struct {
+	mutex   sync.Mutex
+	average time.Duration
+	started bool
+}
+
+// add includes the latency in the average, with the smoothing as its weight.
+func (a *latencyAverage) add(latency time.Duration, smoothing float64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if !a.started {
+		a.average, a.started = latency, true
+		return
+	}
+	a.average += time.Duration(smoothing * float64(latency-a.average))
+}
+
+// value returns the current average.
+func (a *latencyAverage) value() time.Duration {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.average
+}
@@ -0,0 +1,133 @@
+package detector_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+)
+
+func TestLatencyInMemory_IsThunderingHerd(t *testing.T) {
+	detector := detector.NewLatencyInMemory(
+		detector.LatencyWithLimit(10),
+		detector.LatencyWithWindow(time.Minute),
+		detector.LatencyWithTargetLatency(100*time.Millisecond),
+		detector.LatencyWithSmoothing(1),
+	)
+
+	for i := 1; i <= 10; i++ {
+		if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Fatalf("request %d should not be a thundering herd with a fast backend", i)
+		}
+	}
+
+	if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("request above the limit should be a thundering herd")
+	}
+
+	err := detector.ReportOutcome(t.Context(), "other", anicetus.Outcome{Latency: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limit := detector.Limit("other"); limit != 1 {
+		t.Errorf("unexpected limit for a slow backend: %v", limit)
+	}
+
+	if ok, err := detector.IsThunderingHerd(t.Context(), "other"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("first request should not be a thundering herd")
+	}
+	if ok, err := detector.IsThunderingHerd(t.Context(), "other"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("second request should be a thundering herd with a slow backend")
+	}
+}
+
+func TestLatencyInMemory_Update(t *testing.T) {
+	latency := detector.NewLatencyInMemory(
+		detector.LatencyWithLimit(1),
+		detector.LatencyWithWindow(time.Hour),
+		detector.LatencyWithCoolDownInterval(time.Hour),
+	)
+
+	if ok, err := latency.IsThunderingHerd(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ok {
+		t.Fatal("first request should not be a thundering herd")
+	}
+	if err := latency.CoolDown(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	latency.Update(detector.LatencyWithLimit(3))
+
+	for i := 2; i <= 3; i++ {
+		if ok, err := latency.IsThunderingHerd(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Fatalf("request %d should not be a thundering herd with the new limit", i)
+		}
+	}
+	if ok, err := latency.IsThunderingHerd(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("request above the new limit should be a thundering herd")
+	}
+
+	if ok, err := latency.IsCoolDown(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("cooldown should be kept after the update")
+	}
+}
+
+func TestLatencyInMemory_ReportOutcome(t *testing.T) {
+	detector := detector.NewLatencyInMemory(
+		detector.LatencyWithLimit(100),
+		detector.LatencyWithTargetLatency(100*time.Millisecond),
+		detector.LatencyWithSmoothing(0.5),
+		detector.LatencyWithGroup(func(fingerprint anicetus.Fingerprint) string {
+			group, _, _ := strings.Cut(string(fingerprint), "/")
+			return group
+		}),
+	)
+
+	if limit := detector.Limit("a/1"); limit != 100 {
+		t.Errorf("unexpected limit without outcomes: %v", limit)
+	}
+
+	outcomes := []anicetus.Outcome{
+		{Latency: 50 * time.Millisecond},
+		{Latency: 350 * time.Millisecond},
+		{Latency: 10 * time.Millisecond, Failed: true},
+	}
+	for _, outcome := range outcomes {
+		if err := detector.ReportOutcome(t.Context(), "a/1", outcome); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// 50ms, then (50+350)/2 = 200ms, then the failure counts as the target
+	// latency: (200+100)/2 = 150ms
+	if limit := detector.Limit("a/2"); limit < 66 || limit > 67 {
+		t.Errorf("unexpected limit for the same group: %v", limit)
+	}
+	if limit := detector.Limit("b/1"); limit != 100 {
+		t.Errorf("unexpected limit for another group: %v", limit)
+	}
+}
+
+func TestLatencyInMemory_CoolDown(t *testing.T) {
+	detectortest.TestCoolDown(t, detector.NewLatencyInMemory(
+		detector.LatencyWithCoolDownInterval(200*time.Millisecond),
+	), 200*time.Millisecond)
+}
@@ -1,8 +1,12 @@
 package detector
 
 import (
+	"context"
 	"log/slog"
+	"runtime"
 	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
 )
 
 // Options provides all the available options.
@@ -37,8 +41,11 @@ type TokenBucketOptions struct {
 	Options
 
 	coolDownInterval time.Duration
-	limitersBurst    int64
-	limitersInterval time.Duration
+	rate             Rate
+	burst            int64
+	penalty          Penalty
+	maxEntries       int
+	shards           int
 }
 
 // NewTokenBucketOptions creates a new TokenBucketOptions with default values.
@@ -47,8 +54,10 @@ func NewTokenBucketOptions() *TokenBucketOptions {
 		Options: *NewOptions(),
 
 		coolDownInterval: 5 * time.Minute,
-		limitersBurst:    1000,
-		limitersInterval: 1 * time.Minute,
+		rate:             Rate{Events: 1000, Period: time.Minute},
+		burst:            1000,
+		penalty:          Penalty{Strategy: PenaltyDrain},
+		shards:           runtime.GOMAXPROCS(0),
 	}
 }
 
@@ -57,14 +66,45 @@ func (o *TokenBucketOptions) CoolDownInterval() time.Duration {
 	return o.coolDownInterval
 }
 
+// Rate returns the rate at which tokens are added to the bucket.
+func (o *TokenBucketOptions) Rate() Rate {
+	return o.rate
+}
+
+// Burst returns the capacity of the bucket.
+func (o *TokenBucketOptions) Burst() int64 {
+	return o.burst
+}
+
+// Penalty returns the penalty applied to the bucket when a thundering herd is
+// detected.
+func (o *TokenBucketOptions) Penalty() Penalty {
+	return o.penalty
+}
+
+// MaxEntries returns the maximum number of fingerprints kept in memory. Zero
+// means no limit.
+func (o *TokenBucketOptions) MaxEntries() int {
+	return o.maxEntries
+}
+
+// Shards returns the number of partitions of sharded detectors.
+func (o *TokenBucketOptions) Shards() int {
+	return o.shards
+}
+
 // LimitersBurst returns the burst for the limiters in the TokenBucketOptions.
+//
+// Deprecated: Use Burst instead.
 func (o *TokenBucketOptions) LimitersBurst() int64 {
-	return o.limitersBurst
+	return o.burst
 }
 
-// LimitersInterval returns the interval for the limiters in the TokenBucketOptions.
+// LimitersInterval returns the interval between tokens added to the bucket.
+//
+// Deprecated: Use Rate instead.
 func (o *TokenBucketOptions) LimitersInterval() time.Duration {
-	return o.limitersInterval
+	return o.rate.DurationOf(1)
 }
 
 // TokenBucketOption is a helper function to configure the TokenBucketOptions.
@@ -87,18 +127,666 @@ func TokenBucketWithCoolDownInterval(interval time.Duration) TokenBucketOption {
 	}
 }
 
-// TokenBucketWithLimitersBurst sets the burst for the limiters in the
-// TokenBucketOptions.
-func TokenBucketWithLimitersBurst(burst int64) TokenBucketOption {
+// TokenBucketWithRate sets the rate at which tokens are added to the bucket,
+// the sustained rate of requests allowed for a fingerprint. For example,
+// Rate{Events: 120, Period: time.Minute} (or ParseRate("120/m")) allows 120
+// requests per minute.
+func TokenBucketWithRate(rate Rate) TokenBucketOption {
 	return func(o *TokenBucketOptions) {
-		o.limitersBurst = burst
+		o.rate = rate
 	}
 }
 
-// TokenBucketWithLimitersInterval sets the interval for the limiters in the
+// TokenBucketWithBurst sets the capacity of the bucket, the number of requests
+// allowed at once before the rate kicks in. An idle bucket is full again after
+// the time the rate takes to add the burst.
+func TokenBucketWithBurst(burst int64) TokenBucketOption {
+	return func(o *TokenBucketOptions) {
+		o.burst = burst
+	}
+}
+
+// TokenBucketWithPenalty sets the penalty applied to the bucket when a
+// thundering herd is detected. By default the bucket is drained.
+func TokenBucketWithPenalty(penalty Penalty) TokenBucketOption {
+	return func(o *TokenBucketOptions) {
+		o.penalty = penalty
+	}
+}
+
+// TokenBucketWithMaxEntries sets the maximum number of fingerprints kept in
+// memory, for detectors that keep the state in memory. When the limit is
+// reached, the state of the least recently used fingerprint is evicted, like
+// an idle bucket being full again or a cooldown ending earlier. By default
+// there's no limit.
+func TokenBucketWithMaxEntries(maxEntries int) TokenBucketOption {
+	return func(o *TokenBucketOptions) {
+		o.maxEntries = maxEntries
+	}
+}
+
+// TokenBucketWithShards sets the number of partitions of sharded detectors.
+// Each fingerprint always belongs to the same partition, and the maximum
+// number of fingerprints is split between them. More partitions reduce the
+// lock contention when checking different fingerprints in parallel. By default
+// it's the number of CPUs usable by the process (GOMAXPROCS).
+func TokenBucketWithShards(shards int) TokenBucketOption {
+	return func(o *TokenBucketOptions) {
+		o.shards = shards
+	}
+}
+
+// TokenBucketWithLimitersBurst sets the burst for the limiters in the
 // TokenBucketOptions.
+//
+// Deprecated: Use TokenBucketWithBurst instead.
+func TokenBucketWithLimitersBurst(burst int64) TokenBucketOption {
+	return TokenBucketWithBurst(burst)
+}
+
+// TokenBucketWithLimitersInterval sets the interval between tokens added to
+// the bucket, a rate of one token per interval.
+//
+// Deprecated: Use TokenBucketWithRate instead, as a rate of one event per
+// interval.
 func TokenBucketWithLimitersInterval(interval time.Duration) TokenBucketOption {
-	return func(o *TokenBucketOptions) {
-		o.limitersInterval = interval
+	return TokenBucketWithRate(Rate{Events: 1, Period: interval})
+}
+
+// SlidingWindowOptions represents the options that can be used to configure a
+// sliding window strategy.
+type SlidingWindowOptions struct {
+	Options
+
+	coolDownInterval time.Duration
+	limit            int64
+	window           time.Duration
+}
+
+// NewSlidingWindowOptions creates a new SlidingWindowOptions with default
+// values.
+func NewSlidingWindowOptions() *SlidingWindowOptions {
+	return &SlidingWindowOptions{
+		Options: *NewOptions(),
+
+		coolDownInterval: 5 * time.Minute,
+		limit:            1000,
+		window:           1 * time.Minute,
+	}
+}
+
+// CoolDownInterval returns the cooldown interval for the SlidingWindowOptions.
+func (o *SlidingWindowOptions) CoolDownInterval() time.Duration {
+	return o.coolDownInterval
+}
+
+// Limit returns the number of requests allowed in the window.
+func (o *SlidingWindowOptions) Limit() int64 {
+	return o.limit
+}
+
+// Window returns the duration of the sliding window.
+func (o *SlidingWindowOptions) Window() time.Duration {
+	return o.window
+}
+
+// SlidingWindowOption is a helper function to configure the
+// SlidingWindowOptions.
+type SlidingWindowOption func(*SlidingWindowOptions)
+
+// SlidingWindowWithBasicOption sets the basic options for the
+// SlidingWindowOptions.
+func SlidingWindowWithBasicOption(options ...Option) SlidingWindowOption {
+	return func(o *SlidingWindowOptions) {
+		for _, opt := range options {
+			opt(&o.Options)
+		}
+	}
+}
+
+// SlidingWindowWithCoolDownInterval sets the cooldown interval for the
+// SlidingWindowOptions.
+func SlidingWindowWithCoolDownInterval(interval time.Duration) SlidingWindowOption {
+	return func(o *SlidingWindowOptions) {
+		o.coolDownInterval = interval
+	}
+}
+
+// SlidingWindowWithLimit sets the number of requests allowed in the window.
+// More requests than the limit in the window are a thundering herd.
+func SlidingWindowWithLimit(limit int64) SlidingWindowOption {
+	return func(o *SlidingWindowOptions) {
+		o.limit = limit
+	}
+}
+
+// SlidingWindowWithWindow sets the duration of the sliding window.
+func SlidingWindowWithWindow(window time.Duration) SlidingWindowOption {
+	return func(o *SlidingWindowOptions) {
+		o.window = window
+	}
+}
+
+// SpikeOptions represents the options that can be used to configure a spike
+// strategy.
+type SpikeOptions struct {
+	Options
+
+	coolDownInterval time.Duration
+	shortWindow      time.Duration
+	baselineWindow   time.Duration
+	multiplier       float64
+	floor            int64
+	warmUp           time.Duration
+}
+
+// NewSpikeOptions creates a new SpikeOptions with default values.
+func NewSpikeOptions() *SpikeOptions {
+	return &SpikeOptions{
+		Options: *NewOptions(),
+
+		coolDownInterval: 5 * time.Minute,
+		shortWindow:      10 * time.Second,
+		baselineWindow:   10 * time.Minute,
+		multiplier:       10,
+		floor:            20,
+		warmUp:           1 * time.Minute,
+	}
+}
+
+// CoolDownInterval returns the cooldown interval for the SpikeOptions.
+func (o *SpikeOptions) CoolDownInterval() time.Duration {
+	return o.coolDownInterval
+}
+
+// ShortWindow returns the time constant of the short-term rate average.
+func (o *SpikeOptions) ShortWindow() time.Duration {
+	return o.shortWindow
+}
+
+// BaselineWindow returns the time constant of the baseline rate average.
+func (o *SpikeOptions) BaselineWindow() time.Duration {
+	return o.baselineWindow
+}
+
+// Multiplier returns how many times the short-term rate must exceed the
+// baseline to be a thundering herd.
+func (o *SpikeOptions) Multiplier() float64 {
+	return o.multiplier
+}
+
+// Floor returns the minimum number of requests in the short window to be a
+// thundering herd.
+func (o *SpikeOptions) Floor() int64 {
+	return o.floor
+}
+
+// WarmUp returns the period after a fingerprint is first seen in which its
+// baseline isn't trusted.
+func (o *SpikeOptions) WarmUp() time.Duration {
+	return o.warmUp
+}
+
+// SpikeOption is a helper function to configure the SpikeOptions.
+type SpikeOption func(*SpikeOptions)
+
+// SpikeWithBasicOption sets the basic options for the SpikeOptions.
+func SpikeWithBasicOption(options ...Option) SpikeOption {
+	return func(o *SpikeOptions) {
+		for _, opt := range options {
+			opt(&o.Options)
+		}
+	}
+}
+
+// SpikeWithCoolDownInterval sets the cooldown interval for the SpikeOptions.
+func SpikeWithCoolDownInterval(interval time.Duration) SpikeOption {
+	return func(o *SpikeOptions) {
+		o.coolDownInterval = interval
+	}
+}
+
+// SpikeWithShortWindow sets the time constant of the short-term rate average.
+// Shorter windows react faster to spikes, but are more sensitive to noise.
+func SpikeWithShortWindow(window time.Duration) SpikeOption {
+	return func(o *SpikeOptions) {
+		o.shortWindow = window
+	}
+}
+
+// SpikeWithBaselineWindow sets the time constant of the baseline rate average,
+// which should be much longer than the short window.
+func SpikeWithBaselineWindow(window time.Duration) SpikeOption {
+	return func(o *SpikeOptions) {
+		o.baselineWindow = window
+	}
+}
+
+// SpikeWithMultiplier sets how many times the short-term rate must exceed the
+// baseline to be a thundering herd.
+func SpikeWithMultiplier(multiplier float64) SpikeOption {
+	return func(o *SpikeOptions) {
+		o.multiplier = multiplier
+	}
+}
+
+// SpikeWithFloor sets the minimum number of requests in the short window to be
+// a thundering herd, so low traffic fingerprints aren't flagged by small
+// variations.
+func SpikeWithFloor(floor int64) SpikeOption {
+	return func(o *SpikeOptions) {
+		o.floor = floor
+	}
+}
+
+// SpikeWithWarmUp sets the period after a fingerprint is first seen in which
+// its baseline isn't trusted. During the warm-up the short-term rate is
+// compared with the floor times the multiplier instead.
+func SpikeWithWarmUp(warmUp time.Duration) SpikeOption {
+	return func(o *SpikeOptions) {
+		o.warmUp = warmUp
+	}
+}
+
+// ConcurrencyOptions represents the options that can be used to configure a
+// concurrency strategy.
+type ConcurrencyOptions struct {
+	Options
+
+	coolDownInterval time.Duration
+	limit            int64
+	ttl              time.Duration
+}
+
+// NewConcurrencyOptions creates a new ConcurrencyOptions with default values.
+func NewConcurrencyOptions() *ConcurrencyOptions {
+	return &ConcurrencyOptions{
+		Options: *NewOptions(),
+
+		coolDownInterval: 5 * time.Minute,
+		limit:            100,
+		ttl:              5 * time.Minute,
+	}
+}
+
+// CoolDownInterval returns the cooldown interval for the ConcurrencyOptions.
+func (o *ConcurrencyOptions) CoolDownInterval() time.Duration {
+	return o.coolDownInterval
+}
+
+// Limit returns the number of requests allowed in flight at the same time.
+func (o *ConcurrencyOptions) Limit() int64 {
+	return o.limit
+}
+
+// TTL returns the time-to-live of the in-flight count without requests
+// starting or finishing.
+func (o *ConcurrencyOptions) TTL() time.Duration {
+	return o.ttl
+}
+
+// ConcurrencyOption is a helper function to configure the ConcurrencyOptions.
+type ConcurrencyOption func(*ConcurrencyOptions)
+
+// ConcurrencyWithBasicOption sets the basic options for the
+// ConcurrencyOptions.
+func ConcurrencyWithBasicOption(options ...Option) ConcurrencyOption {
+	return func(o *ConcurrencyOptions) {
+		for _, opt := range options {
+			opt(&o.Options)
+		}
+	}
+}
+
+// ConcurrencyWithCoolDownInterval sets the cooldown interval for the
+// ConcurrencyOptions.
+func ConcurrencyWithCoolDownInterval(interval time.Duration) ConcurrencyOption {
+	return func(o *ConcurrencyOptions) {
+		o.coolDownInterval = interval
+	}
+}
+
+// ConcurrencyWithLimit sets the number of requests allowed in flight at the
+// same time. More requests in flight than the limit are a thundering herd.
+func ConcurrencyWithLimit(limit int64) ConcurrencyOption {
+	return func(o *ConcurrencyOptions) {
+		o.limit = limit
+	}
+}
+
+// ConcurrencyWithTTL sets the time-to-live of the in-flight count, for
+// detectors shared by many replicas. The count of a fingerprint is reset when
+// no request starts or finishes during this period, recovering from replicas
+// that stopped with requests in flight. It should be longer than the slowest
+// request.
+func ConcurrencyWithTTL(ttl time.Duration) ConcurrencyOption {
+	return func(o *ConcurrencyOptions) {
+		o.ttl = ttl
+	}
+}
+
+// LatencyOptions represents the options that can be used to configure a
+// latency-aware strategy.
+type LatencyOptions struct {
+	Options
+
+	coolDownInterval time.Duration
+	limit            int64
+	window           time.Duration
+	targetLatency    time.Duration
+	smoothing        float64
+	group            func(anicetus.Fingerprint) string
+}
+
+// NewLatencyOptions creates a new LatencyOptions with default values.
+func NewLatencyOptions() *LatencyOptions {
+	return &LatencyOptions{
+		Options: *NewOptions(),
+
+		coolDownInterval: 5 * time.Minute,
+		limit:            1000,
+		window:           1 * time.Minute,
+		targetLatency:    500 * time.Millisecond,
+		smoothing:        0.2,
+		group: func(fingerprint anicetus.Fingerprint) string {
+			return string(fingerprint)
+		},
+	}
+}
+
+// CoolDownInterval returns the cooldown interval for the LatencyOptions.
+func (o *LatencyOptions) CoolDownInterval() time.Duration {
+	return o.coolDownInterval
+}
+
+// Limit returns the number of requests allowed in the window while the backend
+// answers within the target latency.
+func (o *LatencyOptions) Limit() int64 {
+	return o.limit
+}
+
+// Window returns the duration of the sliding window.
+func (o *LatencyOptions) Window() time.Duration {
+	return o.window
+}
+
+// TargetLatency returns the latency expected from a healthy backend.
+func (o *LatencyOptions) TargetLatency() time.Duration {
+	return o.targetLatency
+}
+
+// Smoothing returns the weight of each new latency in the moving average.
+func (o *LatencyOptions) Smoothing() float64 {
+	return o.smoothing
+}
+
+// Group returns the function mapping a fingerprint to the group sharing the
+// observed latency.
+func (o *LatencyOptions) Group() func(anicetus.Fingerprint) string {
+	return o.group
+}
+
+// LatencyOption is a helper function to configure the LatencyOptions.
+type LatencyOption func(*LatencyOptions)
+
+// LatencyWithBasicOption sets the basic options for the LatencyOptions.
+func LatencyWithBasicOption(options ...Option) LatencyOption {
+	return func(o *LatencyOptions) {
+		for _, opt := range options {
+			opt(&o.Options)
+		}
+	}
+}
+
+// LatencyWithCoolDownInterval sets the cooldown interval for the
+// LatencyOptions.
+func LatencyWithCoolDownInterval(interval time.Duration) LatencyOption {
+	return func(o *LatencyOptions) {
+		o.coolDownInterval = interval
+	}
+}
+
+// LatencyWithLimit sets the number of requests allowed in the window while the
+// backend answers within the target latency.
+func LatencyWithLimit(limit int64) LatencyOption {
+	return func(o *LatencyOptions) {
+		o.limit = limit
+	}
+}
+
+// LatencyWithWindow sets the duration of the sliding window.
+func LatencyWithWindow(window time.Duration) LatencyOption {
+	return func(o *LatencyOptions) {
+		o.window = window
+	}
+}
+
+// LatencyWithTargetLatency sets the latency expected from a healthy backend.
+// When the observed latency is above the target, the limit is reduced in the
+// same proportion.
+func LatencyWithTargetLatency(latency time.Duration) LatencyOption {
+	return func(o *LatencyOptions) {
+		o.targetLatency = latency
+	}
+}
+
+// LatencyWithSmoothing sets the weight (between 0 and 1) of each new latency
+// in the moving average. Higher values react faster to changes.
+func LatencyWithSmoothing(smoothing float64) LatencyOption {
+	return func(o *LatencyOptions) {
+		o.smoothing = smoothing
+	}
+}
+
+// LatencyWithGroup sets the function mapping a fingerprint to the group
+// sharing the observed latency, like all fingerprints of the same backend
+// endpoint. By default, each fingerprint has its own latency.
+func LatencyWithGroup(group func(anicetus.Fingerprint) string) LatencyOption {
+	return func(o *LatencyOptions) {
+		o.group = group
+	}
+}
+
+// SignalOptions represents the options that can be used to configure a
+// detector fed by backend load signals.
+type SignalOptions struct {
+	Options
+
+	ttl time.Duration
+}
+
+// NewSignalOptions creates a new SignalOptions with default values.
+func NewSignalOptions() *SignalOptions {
+	return &SignalOptions{
+		Options: *NewOptions(),
+
+		ttl: 30 * time.Second,
+	}
+}
+
+// TTL returns for how long an active signal is considered without being
+// reported again.
+func (o *SignalOptions) TTL() time.Duration {
+	return o.ttl
+}
+
+// SignalOption is a helper function to configure the SignalOptions.
+type SignalOption func(*SignalOptions)
+
+// SignalWithBasicOption sets the basic options for the SignalOptions.
+func SignalWithBasicOption(options ...Option) SignalOption {
+	return func(o *SignalOptions) {
+		for _, opt := range options {
+			opt(&o.Options)
+		}
+	}
+}
+
+// SignalWithTTL sets for how long an active signal is considered without being
+// reported again. It avoids a signal that is never cleared by the backend (no
+// more responses for the fingerprint) from keeping it in herd mode forever.
+func SignalWithTTL(ttl time.Duration) SignalOption {
+	return func(o *SignalOptions) {
+		o.ttl = ttl
+	}
+}
+
+// HeavyHitterOptions represents the options that can be used to configure a
+// heavy-hitter strategy.
+type HeavyHitterOptions struct {
+	Options
+
+	coolDownInterval time.Duration
+	limit            int64
+	window           time.Duration
+	epsilon          float64
+	delta            float64
+	topK             int
+}
+
+// NewHeavyHitterOptions creates a new HeavyHitterOptions with default values.
+func NewHeavyHitterOptions() *HeavyHitterOptions {
+	return &HeavyHitterOptions{
+		Options: *NewOptions(),
+
+		coolDownInterval: 5 * time.Minute,
+		limit:            1000,
+		window:           1 * time.Minute,
+		epsilon:          0.0001,
+		delta:            0.01,
+		topK:             100,
+	}
+}
+
+// CoolDownInterval returns the cooldown interval for the HeavyHitterOptions.
+func (o *HeavyHitterOptions) CoolDownInterval() time.Duration {
+	return o.coolDownInterval
+}
+
+// Limit returns the number of requests allowed in the window.
+func (o *HeavyHitterOptions) Limit() int64 {
+	return o.limit
+}
+
+// Window returns the duration of the window, after which the counts decay.
+func (o *HeavyHitterOptions) Window() time.Duration {
+	return o.window
+}
+
+// ErrorBounds returns the error rate (epsilon), as a fraction of all requests
+// in the window, and the probability of exceeding it (delta).
+func (o *HeavyHitterOptions) ErrorBounds() (epsilon, delta float64) {
+	return o.epsilon, o.delta
+}
+
+// TopK returns the number of hot fingerprints tracked.
+func (o *HeavyHitterOptions) TopK() int {
+	return o.topK
+}
+
+// HeavyHitterOption is a helper function to configure the HeavyHitterOptions.
+type HeavyHitterOption func(*HeavyHitterOptions)
+
+// HeavyHitterWithBasicOption sets the basic options for the
+// HeavyHitterOptions.
+func HeavyHitterWithBasicOption(options ...Option) HeavyHitterOption {
+	return func(o *HeavyHitterOptions) {
+		for _, opt := range options {
+			opt(&o.Options)
+		}
+	}
+}
+
+// HeavyHitterWithCoolDownInterval sets the cooldown interval for the
+// HeavyHitterOptions.
+func HeavyHitterWithCoolDownInterval(interval time.Duration) HeavyHitterOption {
+	return func(o *HeavyHitterOptions) {
+		o.coolDownInterval = interval
+	}
+}
+
+// HeavyHitterWithLimit sets the number of requests allowed in the window. A
+// fingerprint with more estimated requests than the limit in the last window is
+// a thundering herd.
+func HeavyHitterWithLimit(limit int64) HeavyHitterOption {
+	return func(o *HeavyHitterOptions) {
+		o.limit = limit
+	}
+}
+
+// HeavyHitterWithWindow sets the duration of the window. The counts of the
+// previous window decay as the current window advances, like in a sliding
+// window counter.
+func HeavyHitterWithWindow(window time.Duration) HeavyHitterOption {
+	return func(o *HeavyHitterOptions) {
+		o.window = window
+	}
+}
+
+// HeavyHitterWithErrorBounds sets the error bounds of the estimates: with
+// probability 1-delta, a fingerprint is overestimated by at most epsilon times
+// the requests of all fingerprints in the window. Lower values are more
+// accurate, using more memory (proportional to (e/epsilon)*ln(1/delta)). Both
+// must be between 0 and 1 (exclusive), otherwise the option is ignored.
+func HeavyHitterWithErrorBounds(epsilon, delta float64) HeavyHitterOption {
+	return func(o *HeavyHitterOptions) {
+		if epsilon <= 0 || epsilon >= 1 || delta <= 0 || delta >= 1 {
+			return
+		}
+		o.epsilon = epsilon
+		o.delta = delta
+	}
+}
+
+// HeavyHitterWithTopK sets the number of hot fingerprints tracked. Only them
+// can be detected as a thundering herd, avoiding false positives from
+// fingerprints overestimated by the sketch.
+func HeavyHitterWithTopK(k int) HeavyHitterOption {
+	return func(o *HeavyHitterOptions) {
+		o.topK = k
+	}
+}
+
+// CompositeOptions represents the options that can be used to configure a
+// composite detector.
+type CompositeOptions struct {
+	Options
+
+	triggerHandler func(context.Context, anicetus.Fingerprint, []int)
+}
+
+// NewCompositeOptions creates a new CompositeOptions with default values.
+func NewCompositeOptions() *CompositeOptions {
+	return &CompositeOptions{
+		Options: *NewOptions(),
+
+		triggerHandler: func(context.Context, anicetus.Fingerprint, []int) {},
+	}
+}
+
+// TriggerHandler returns the function called with the children that detected
+// the thundering herd.
+func (o *CompositeOptions) TriggerHandler() func(context.Context, anicetus.Fingerprint, []int) {
+	return o.triggerHandler
+}
+
+// CompositeOption is a helper function to configure the CompositeOptions.
+type CompositeOption func(*CompositeOptions)
+
+// CompositeWithBasicOption sets the basic options for the CompositeOptions.
+func CompositeWithBasicOption(options ...Option) CompositeOption {
+	return func(o *CompositeOptions) {
+		for _, opt := range options {
+			opt(&o.Options)
+		}
+	}
+}
+
+// CompositeWithTriggerHandler sets the function called when the composite
+// detects a thundering herd, with the indexes of the children that detected
+// it.
+func CompositeWithTriggerHandler(handler func(ctx context.Context, fingerprint anicetus.Fingerprint, triggered []int)) CompositeOption {
+	return func(o *CompositeOptions) {
+		o.triggerHandler = handler
 	}
 }
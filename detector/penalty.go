@@ -0,0 +1,66 @@
+package detector
+
+import "time"
+
+// PenaltyStrategy defines what happens to a token bucket when a thundering
+// herd is detected.
+type PenaltyStrategy string
+
+// List of possible penalty strategies.
+const (
+	// PenaltyDrain empties the bucket, so the fingerprint needs to wait for new
+	// tokens to be refilled. This is the default strategy.
+	PenaltyDrain PenaltyStrategy = "drain"
+
+	// PenaltyNone keeps the tokens of the bucket, so the fingerprint is allowed
+	// again as soon as a token is refilled.
+	PenaltyNone PenaltyStrategy = "none"
+
+	// PenaltyHalve removes half of the remaining tokens of the bucket. As a
+	// thundering herd is only detected when less than a token remains, it
+	// delays the next request allowed by up to half a token, a lighter penalty
+	// than PenaltyDrain. Each detection halves the tokens again.
+	PenaltyHalve PenaltyStrategy = "halve"
+
+	// PenaltyFixed empties the bucket and stops refilling it for a fixed
+	// duration. Detections during that time don't extend it.
+	PenaltyFixed PenaltyStrategy = "fixed"
+)
+
+// Penalty is applied to a token bucket when a thundering herd is detected.
+type Penalty struct {
+	// Strategy is the penalty strategy. An empty or unknown strategy drains the
+	// bucket.
+	Strategy PenaltyStrategy
+	// Duration is the time the bucket isn't refilled, only used by the
+	// PenaltyFixed strategy.
+	Duration time.Duration
+}
+
+// Apply returns the tokens and the last refresh time of a bucket after the
+// penalty. The tokens must already be refilled until now. A last refresh time
+// in the future means that the bucket isn't refilled until then.
+func (p Penalty) Apply(tokens float64, lastRefreshed, now time.Time) (float64, time.Time) {
+	switch p.Strategy {
+	case PenaltyNone:
+		return tokens, now
+	case PenaltyHalve:
+		return tokens / 2, now
+	case PenaltyFixed:
+		if lastRefreshed.After(now) {
+			// already penalized
+			return tokens, lastRefreshed
+		}
+		return 0, now.Add(p.Duration)
+	default:
+		return 0, now
+	}
+}
+
+// Pause returns the maximum time a bucket isn't refilled after the penalty.
+func (p Penalty) Pause() time.Duration {
+	if p.Strategy == PenaltyFixed {
+		return max(p.Duration, 0)
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package detector_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2/detector"
+)
+
+func TestPenalty_Apply(t *testing.T) {
+	now := time.Now()
+	last := now.Add(-time.Second)
+	future := now.Add(time.Second)
+
+	tests := []struct {
+		name              string
+		penalty           detector.Penalty
+		tokens            float64
+		lastRefreshed     time.Time
+		wantTokens        float64
+		wantLastRefreshed time.Time
+	}{{
+		name:              "Drain",
+		penalty:           detector.Penalty{Strategy: detector.PenaltyDrain},
+		tokens:            0.5,
+		lastRefreshed:     last,
+		wantTokens:        0,
+		wantLastRefreshed: now,
+	}, {
+		name:              "DefaultDrain",
+		penalty:           detector.Penalty{},
+		tokens:            0.5,
+		lastRefreshed:     last,
+		wantTokens:        0,
+		wantLastRefreshed: now,
+	}, {
+		name:              "None",
+		penalty:           detector.Penalty{Strategy: detector.PenaltyNone},
+		tokens:            0.5,
+		lastRefreshed:     last,
+		wantTokens:        0.5,
+		wantLastRefreshed: now,
+	}, {
+		name:              "Halve",
+		penalty:           detector.Penalty{Strategy: detector.PenaltyHalve},
+		tokens:            0.5,
+		lastRefreshed:     last,
+		wantTokens:        0.25,
+		wantLastRefreshed: now,
+	}, {
+		name:              "Fixed",
+		penalty:           detector.Penalty{Strategy: detector.PenaltyFixed, Duration: time.Minute},
+		tokens:            0.5,
+		lastRefreshed:     last,
+		wantTokens:        0,
+		wantLastRefreshed: now.Add(time.Minute),
+	}, {
+		name:              "FixedAlreadyPenalized",
+		penalty:           detector.Penalty{Strategy: detector.PenaltyFixed, Duration: time.Minute},
+		tokens:            0,
+		lastRefreshed:     future,
+		wantTokens:        0,
+		wantLastRefreshed: future,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, lastRefreshed := tt.penalty.Apply(tt.tokens, tt.lastRefreshed, now)
+			if tokens != tt.wantTokens {
+				t.Errorf("expected %f tokens, got %f", tt.wantTokens, tokens)
+			}
+			if !lastRefreshed.Equal(tt.wantLastRefreshed) {
+				t.Errorf("expected last refreshed at %s, got %s", tt.wantLastRefreshed, lastRefreshed)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package detector
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is a number of events in a period, like 120 requests per minute.
+type Rate struct {
+	// Events is the number of events allowed in the period.
+	Events int64
+	// Period is the duration in which the events happen.
+	Period time.Duration
+}
+
+// ParseRate parses a rate in the format "<events>/<period>", where the period
+// is a unit ("s", "m" or "h") or a duration ("500ms", "10s", "2m"). For
+// example, "120/m" is 120 events per minute and "5/10s" is 5 events every 10
+// seconds. The number of events must be positive.
+func ParseRate(s string) (Rate, error) {
+	eventsStr, periodStr, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q: expected <events>/<period>", s)
+	}
+
+	events, err := strconv.ParseInt(strings.TrimSpace(eventsStr), 10, 64)
+	if err != nil || events <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q: invalid number of events", s)
+	}
+
+	periodStr = strings.TrimSpace(periodStr)
+	switch periodStr {
+	case "s", "m", "h":
+		periodStr = "1" + periodStr
+	}
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q: invalid period", s)
+	}
+
+	return Rate{Events: events, Period: period}, nil
+}
+
+// String returns the rate in the format accepted by ParseRate.
+func (r Rate) String() string {
+	var period string
+	switch r.Period {
+	case time.Second:
+		period = "s"
+	case time.Minute:
+		period = "m"
+	case time.Hour:
+		period = "h"
+	default:
+		period = r.Period.String()
+	}
+	return strconv.FormatInt(r.Events, 10) + "/" + period
+}
+
+// PerSecond returns the number of events per second.
+func (r Rate) PerSecond() float64 {
+	if r.Period <= 0 {
+		return 0
+	}
+	return float64(r.Events) / r.Period.Seconds()
+}
+
+// DurationOf returns the time it takes for the number of events to happen at
+// this rate.
+func (r Rate) DurationOf(events int64) time.Duration {
+	if r.Events <= 0 {
+		return math.MaxInt64
+	}
+	return time.Duration(float64(r.Period) * float64(events) / float64(r.Events))
+}
@@ -0,0 +1,64 @@
+package detector_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2/detector"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    detector.Rate
+		wantErr bool
+	}{
+		{input: "120/m", want: detector.Rate{Events: 120, Period: time.Minute}},
+		{input: "10/s", want: detector.Rate{Events: 10, Period: time.Second}},
+		{input: " 5 / h ", want: detector.Rate{Events: 5, Period: time.Hour}},
+		{input: "5/10s", want: detector.Rate{Events: 5, Period: 10 * time.Second}},
+		{input: "1/500ms", want: detector.Rate{Events: 1, Period: 500 * time.Millisecond}},
+		{input: "120", wantErr: true},
+		{input: "abc/m", wantErr: true},
+		{input: "-1/m", wantErr: true},
+		{input: "0/s", wantErr: true},
+		{input: "10/week", wantErr: true},
+		{input: "10/0s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			rate, err := detector.ParseRate(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", rate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rate != tt.want {
+				t.Errorf("unexpected rate: got %v, want %v", rate, tt.want)
+			}
+
+			// the string representation can be parsed back
+			if parsed, err := detector.ParseRate(rate.String()); err != nil || parsed != rate {
+				t.Errorf("unexpected round trip of %q: got %v (%v)", rate.String(), parsed, err)
+			}
+		})
+	}
+}
+
+func TestRate_DurationOf(t *testing.T) {
+	rate := detector.Rate{Events: 120, Period: time.Minute}
+	if perSecond := rate.PerSecond(); perSecond != 2 {
+		t.Errorf("unexpected events per second: %v", perSecond)
+	}
+	if d := rate.DurationOf(1); d != 500*time.Millisecond {
+		t.Errorf("unexpected duration of one event: %s", d)
+	}
+	if d := rate.DurationOf(120); d != time.Minute {
+		t.Errorf("unexpected duration of the burst: %s", d)
+	}
+}
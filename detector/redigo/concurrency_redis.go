@@ -0,0 +1,179 @@
+package redigo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+)
+
+var (
+	_ anicetus.InFlightDetector = &ConcurrencyRedis{}
+
+	requestStartedScript = redis.NewScript(1, `
+-- KEYS[1]: The Redis key for storing the in-flight count
+-- ARGV[1]: Time-to-live of the count in milliseconds
+
+local count = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return count
+`)
+
+	requestFinishedScript = redis.NewScript(1, `
+-- KEYS[1]: The Redis key for storing the in-flight count
+-- ARGV[1]: Time-to-live of the count in milliseconds
+
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+if count <= 1 then
+  redis.call("DEL", KEYS[1])
+  return 0
+end
+count = redis.call("DECR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return count
+`)
+)
+
+// ConcurrencyRedis is a detector strategy that stores the state in Redis and
+// tracks the requests in flight of each fingerprint among all replicas. A
+// fingerprint with more requests in flight than the limit is a thundering
+// herd.
+type ConcurrencyRedis struct {
+	pool             *redis.Pool
+	coolDownInterval time.Duration
+	limit            int64
+	ttl              time.Duration
+	logger           *slog.Logger
+}
+
+// NewConcurrencyRedis creates a new concurrency detector strategy.
+func NewConcurrencyRedis(pool *redis.Pool, options ...detector.ConcurrencyOption) *ConcurrencyRedis {
+	o := detector.NewConcurrencyOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &ConcurrencyRedis{
+		pool:             pool,
+		coolDownInterval: o.CoolDownInterval(),
+		limit:            o.Limit(),
+		ttl:              o.TTL(),
+		logger:           o.Logger(),
+	}
+}
+
+// CoolDown will cool down the fingerprint.
+func (c *ConcurrencyRedis) CoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if c.logger != nil {
+				c.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	result, err := redis.String(conn.Do("SET", addKeyPrefix(fingerprint, modeCoolDown), 1,
+		"PX", c.coolDownInterval.Milliseconds(),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to set redis key: %w", err)
+	}
+	if result != "OK" {
+		return fmt.Errorf("failed to set redis key")
+	}
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (c *ConcurrencyRedis) IsCoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if c.logger != nil {
+				c.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	result, err := redis.Int(conn.Do("EXISTS", addKeyPrefix(fingerprint, modeCoolDown)))
+	if err != nil {
+		return false, fmt.Errorf("failed to check redis key: %w", err)
+	}
+	return result == 1, nil
+}
+
+// IsThunderingHerd checks if the fingerprint has more requests in flight than
+// the limit.
+func (c *ConcurrencyRedis) IsThunderingHerd(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	inFlight, err := c.InFlight(ctx, fingerprint)
+	if err != nil {
+		return false, err
+	}
+	return inFlight > c.limit, nil
+}
+
+// RequestStarted increments the requests in flight of the fingerprint.
+func (c *ConcurrencyRedis) RequestStarted(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	return c.run(ctx, requestStartedScript, fingerprint)
+}
+
+// RequestFinished decrements the requests in flight of the fingerprint.
+func (c *ConcurrencyRedis) RequestFinished(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	return c.run(ctx, requestFinishedScript, fingerprint)
+}
+
+// InFlight returns the number of requests in flight of the fingerprint.
+func (c *ConcurrencyRedis) InFlight(ctx context.Context, fingerprint anicetus.Fingerprint) (int64, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if c.logger != nil {
+				c.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	inFlight, err := redis.Int64(conn.Do("GET", addKeyPrefix(fingerprint, modeInFlight)))
+	if err == redis.ErrNil {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get redis key: %w", err)
+	}
+	return inFlight, nil
+}
+
+// run executes the script changing the in-flight count of the fingerprint.
+func (c *ConcurrencyRedis) run(ctx context.Context, script *redis.Script, fingerprint anicetus.Fingerprint) error {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if c.logger != nil {
+				c.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	if _, err := script.DoContext(ctx, conn, addKeyPrefix(fingerprint, modeInFlight),
+		max(c.ttl.Milliseconds(), 1), // time-to-live
+	); err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+//go:build integration_tests
+// +build integration_tests
+
+package redigo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+	"github.com/rafaeljusto/anicetus/v2/detector/redigo"
+)
+
+func TestConcurrencyRedis_IsThunderingHerd(t *testing.T) {
+	detector := redigo.NewConcurrencyRedis(newRedisPool(t),
+		detector.ConcurrencyWithLimit(2),
+	)
+
+	for i := 1; i <= 3; i++ {
+		if err := detector.RequestStarted(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if want := i > 2; ok != want {
+			t.Errorf("unexpected result with %d requests in flight: got %v, want %v", i, ok, want)
+		}
+	}
+
+	if err := detector.RequestFinished(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be a thundering herd after a request finished")
+	}
+
+	// finishing more requests than started must not go below zero
+	for range 5 {
+		if err := detector.RequestFinished(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n, err := detector.InFlight(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != 0 {
+		t.Errorf("unexpected requests in flight: %d", n)
+	}
+}
+
+func TestConcurrencyRedis_ttl(t *testing.T) {
+	detector := redigo.NewConcurrencyRedis(newRedisPool(t),
+		detector.ConcurrencyWithTTL(100*time.Millisecond),
+	)
+
+	if err := detector.RequestStarted(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if n, err := detector.InFlight(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n != 0 {
+		t.Errorf("in-flight count should expire: %d", n)
+	}
+}
+
+func TestConcurrencyRedis_CoolDown(t *testing.T) {
+	detectortest.TestCoolDown(t, redigo.NewConcurrencyRedis(newRedisPool(t),
+		detector.ConcurrencyWithCoolDownInterval(200*time.Millisecond),
+	), 200*time.Millisecond)
+}
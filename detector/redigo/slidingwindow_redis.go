@@ -0,0 +1,156 @@
+package redigo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+)
+
+var (
+	_ anicetus.Detector = &SlidingWindowRedis{}
+
+	slidingWindowScript = redis.NewScript(1, `
+-- Sliding window counter
+-- KEYS[1]: The Redis key for storing the counters
+-- ARGV[1]: Maximum number of requests in the window (limit)
+-- ARGV[2]: Window size in milliseconds (window)
+
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local current_time = redis.call("TIME")
+local now = tonumber(current_time[1]) * 1000 + math.floor(tonumber(current_time[2]) / 1000)
+local current_window = math.floor(now / window)
+
+-- Fetch the stored counters of the current and previous fixed windows
+local counters = redis.call("HMGET", key, "window", "current", "previous")
+local stored_window = tonumber(counters[1])
+local current = tonumber(counters[2]) or 0
+local previous = tonumber(counters[3]) or 0
+
+if stored_window == current_window - 1 then
+  previous = current
+  current = 0
+elseif stored_window ~= current_window then
+  previous = 0
+  current = 0
+end
+current = current + 1
+
+redis.call("HSET", key, "window", current_window, "current", current, "previous", previous)
+redis.call("PEXPIRE", key, 2 * window)
+
+-- Estimate the requests in the sliding window weighting the previous fixed
+-- window by how much of it is still covered
+local elapsed = (now - current_window * window) / window
+if previous * (1 - elapsed) + current > limit then
+  return 1 -- Thundering herd
+end
+return 0 -- Allowed
+`)
+)
+
+// SlidingWindowRedis is a sliding window counter detector strategy that stores
+// the state in Redis. A fingerprint with more requests than the limit in the
+// last window is a thundering herd.
+type SlidingWindowRedis struct {
+	pool             *redis.Pool
+	coolDownInterval time.Duration
+	limit            int64
+	window           time.Duration
+	logger           *slog.Logger
+}
+
+// NewSlidingWindowRedis creates a new sliding window detector strategy.
+func NewSlidingWindowRedis(pool *redis.Pool, options ...detector.SlidingWindowOption) *SlidingWindowRedis {
+	o := detector.NewSlidingWindowOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &SlidingWindowRedis{
+		pool:             pool,
+		coolDownInterval: o.CoolDownInterval(),
+		limit:            o.Limit(),
+		window:           o.Window(),
+		logger:           o.Logger(),
+	}
+}
+
+// CoolDown will cool down the fingerprint.
+func (s *SlidingWindowRedis) CoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if s.logger != nil {
+				s.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	result, err := redis.String(conn.Do("SET", addKeyPrefix(fingerprint, modeCoolDown), 1,
+		"PX", s.coolDownInterval.Milliseconds(),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to set redis key: %w", err)
+	}
+	if result != "OK" {
+		return fmt.Errorf("failed to set redis key")
+	}
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (s *SlidingWindowRedis) IsCoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if s.logger != nil {
+				s.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	result, err := redis.Int(conn.Do("EXISTS", addKeyPrefix(fingerprint, modeCoolDown)))
+	if err != nil {
+		return false, fmt.Errorf("failed to check redis key: %w", err)
+	}
+	return result == 1, nil
+}
+
+// IsThunderingHerd counts the request and checks if the fingerprint is a
+// thundering herd.
+func (s *SlidingWindowRedis) IsThunderingHerd(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if s.logger != nil {
+				s.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	thunderingHerd, err := redis.Bool(slidingWindowScript.DoContext(ctx, conn,
+		addKeyPrefix(fingerprint, modeSlidingWindow),
+		s.limit,                         // limit
+		max(s.window.Milliseconds(), 1), // window
+	))
+	if err != nil {
+		return false, fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	return thunderingHerd, nil
+}
@@ -0,0 +1,57 @@
+//go:build integration_tests
+// +build integration_tests
+
+package redigo_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+	"github.com/rafaeljusto/anicetus/v2/detector/redigo"
+)
+
+func TestSlidingWindowRedis_conformance(t *testing.T) {
+	detectortest.RunSlidingWindow(t, func(t *testing.T, options ...detector.SlidingWindowOption) anicetus.Detector {
+		return redigo.NewSlidingWindowRedis(newRedisPool(t), options...)
+	})
+}
+
+// newRedisPool creates a pool for an empty Redis database.
+func newRedisPool(t *testing.T) *redis.Pool {
+	t.Helper()
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisPool := &redis.Pool{
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", redisAddress)
+		},
+	}
+	t.Cleanup(func() {
+		if err := redisPool.Close(); err != nil {
+			t.Errorf("failed to close redis pool: %v", err)
+		}
+	})
+
+	redisConn, err := redisPool.GetContext(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get redis connection: %v", err)
+	}
+	defer func() {
+		if err := redisConn.Close(); err != nil {
+			t.Errorf("failed to close redis connection: %v", err)
+		}
+	}()
+	if _, err := redisConn.Do("FLUSHDB"); err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+	return redisPool
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -12,17 +16,22 @@ import (
 )
 
 var (
-	_ anicetus.Detector = &TokenBucketRedis{}
+	_ anicetus.Detector          = &TokenBucketRedis{}
+	_ anicetus.DetectorInspector = &TokenBucketRedis{}
 
 	tokenBucketScript = redis.NewScript(1, `
 -- Token Bucket rate limiter
 -- KEYS[1]: The Redis key for storing the token bucket
 -- ARGV[1]: Maximum capacity of the bucket (max_tokens)
 -- ARGV[2]: Refill rate per second (tokens_per_second)
+-- ARGV[3]: Penalty strategy (drain, none, halve or fixed)
+-- ARGV[4]: Penalty duration in seconds, used by the fixed strategy
 
 local key = KEYS[1]
 local max_tokens = tonumber(ARGV[1])
 local refill_rate = tonumber(ARGV[2])
+local penalty = ARGV[3]
+local penalty_duration = tonumber(ARGV[4])
 local requested_tokens = 1
 local current_time = redis.call("TIME")
 local now = tonumber(current_time[1]) + tonumber(current_time[2]) / 1000000
@@ -32,8 +41,9 @@ local bucket = redis.call("HMGET", key, "tokens", "last_refreshed")
 local tokens = tonumber(bucket[1]) or max_tokens
 local last_refreshed = tonumber(bucket[2]) or now
 
--- Refill the tokens based on elapsed time
-local elapsed_time = now - last_refreshed
+-- Refill the tokens based on elapsed time, that is never negative while the
+-- refill is paused by a penalty
+local elapsed_time = math.max(now - last_refreshed, 0)
 local new_tokens = math.min(max_tokens, tokens + (elapsed_time * refill_rate))
 
 -- Check if we have enough tokens
@@ -50,12 +60,30 @@ if new_tokens >= requested_tokens then
 
 else
   -- apply penalty for thundering herd
-  local hmset_result = redis.call("HMSET", key, "tokens", 0, "last_refreshed", now)
+  local penalty_last_refreshed = now
+  if penalty == "none" then
+    -- keep the tokens
+  elseif penalty == "halve" then
+    new_tokens = new_tokens / 2
+  elseif penalty == "fixed" then
+    if last_refreshed > now then
+      -- already penalized
+      penalty_last_refreshed = last_refreshed
+    else
+      new_tokens = 0
+      penalty_last_refreshed = now + penalty_duration
+    end
+  else
+    new_tokens = 0
+  end
+
+  local hmset_result = redis.call("HMSET", key, "tokens", new_tokens, "last_refreshed", penalty_last_refreshed)
   if not hmset_result then
     redis.log(redis.LOG_NOTICE, "anicetus: failed to update token bucket for key: " .. key)
     return 1 -- Allowed
   end
 
+  redis.call("EXPIRE", key, math.ceil(max_tokens / refill_rate + penalty_last_refreshed - now))
   return 0 -- Thundering herd
 end
 `)
@@ -64,11 +92,12 @@ end
 // TokenBucketRedis is a token bucket detector strategy that stores the state in
 // Redis.
 type TokenBucketRedis struct {
-	pool             *redis.Pool
-	coolDownInterval time.Duration
-	limitersBurst    int64
-	limitersInterval time.Duration
-	logger           *slog.Logger
+	pool   *redis.Pool
+	logger *slog.Logger
+
+	options atomic.Pointer[detector.TokenBucketOptions]
+	// optionsMutex avoids losing concurrent updates.
+	optionsMutex sync.Mutex
 }
 
 // NewTokenBucketRedis creates a new token bucket detector strategy.
@@ -78,17 +107,31 @@ func NewTokenBucketRedis(pool *redis.Pool, options ...detector.TokenBucketOption
 		opt(o)
 	}
 
-	return &TokenBucketRedis{
-		pool:             pool,
-		coolDownInterval: o.CoolDownInterval(),
-		limitersBurst:    o.LimitersBurst(),
-		limitersInterval: o.LimitersInterval(),
-		logger:           o.Logger(),
+	t := &TokenBucketRedis{
+		pool:   pool,
+		logger: o.Logger(),
 	}
+	t.options.Store(o)
+	return t
+}
+
+// Update applies the options on top of the current ones. The token buckets and
+// cooldowns stored in Redis are kept, and the new options are used from the
+// next request on.
+func (t *TokenBucketRedis) Update(options ...detector.TokenBucketOption) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+
+	o := *t.options.Load()
+	for _, opt := range options {
+		opt(&o)
+	}
+	t.options.Store(&o)
 }
 
 // CoolDown will cool down the fingerprint.
 func (t *TokenBucketRedis) CoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	o := t.options.Load()
 	conn, err := t.pool.GetContext(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get redis connection: %w", err)
@@ -101,13 +144,13 @@ func (t *TokenBucketRedis) CoolDown(ctx context.Context, fingerprint anicetus.Fi
 		}
 	}()
 
-	result, err := redis.Int(conn.Do("SET", addKeyPrefix(fingerprint, modeCoolDown), 1,
-		"EX", t.coolDownInterval.Seconds(),
+	result, err := redis.String(conn.Do("SET", addKeyPrefix(fingerprint, modeCoolDown), 1,
+		"PX", o.CoolDownInterval().Milliseconds(),
 	))
 	if err != nil {
 		return fmt.Errorf("failed to set redis key: %w", err)
 	}
-	if result != 1 {
+	if result != "OK" {
 		return fmt.Errorf("failed to set redis key")
 	}
 	return nil
@@ -136,6 +179,7 @@ func (t *TokenBucketRedis) IsCoolDown(ctx context.Context, fingerprint anicetus.
 
 // IsThunderingHerd checks if the fingerprint is a thundering herd.
 func (t *TokenBucketRedis) IsThunderingHerd(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	o := t.options.Load()
 	conn, err := t.pool.GetContext(ctx)
 	if err != nil {
 		return false, fmt.Errorf("failed to get redis connection: %w", err)
@@ -149,8 +193,10 @@ func (t *TokenBucketRedis) IsThunderingHerd(ctx context.Context, fingerprint ani
 	}()
 
 	allow, err := redis.Bool(tokenBucketScript.DoContext(ctx, conn, addKeyPrefix(fingerprint, modeThunderingHerd),
-		t.limitersBurst,                // max tokens
-		1/t.limitersInterval.Seconds(), // refill rate
+		o.Burst(),                      // max tokens
+		o.Rate().PerSecond(),           // refill rate
+		string(o.Penalty().Strategy),   // penalty strategy
+		o.Penalty().Duration.Seconds(), // penalty duration
 	))
 	if err != nil {
 		return false, fmt.Errorf("failed to execute redis lua script: %w", err)
@@ -158,6 +204,185 @@ func (t *TokenBucketRedis) IsThunderingHerd(ctx context.Context, fingerprint ani
 	return !allow, nil
 }
 
+// ListDetections returns a page of detector states using the Redis SCAN
+// cursor. Token buckets are listed first, followed by the cooldowns of
+// fingerprints without a token bucket.
+func (t *TokenBucketRedis) ListDetections(
+	ctx context.Context,
+	cursor string,
+	limit int,
+) ([]anicetus.DetectorState, string, error) {
+	m, scanCursor := modeThunderingHerd, "0"
+	if cursor != "" {
+		prefix, value, ok := strings.Cut(cursor, ":")
+		if !ok || (mode(prefix) != modeThunderingHerd && mode(prefix) != modeCoolDown) {
+			return nil, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+		m, scanCursor = mode(prefix), value
+	}
+
+	conn, err := t.pool.GetContext(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if t.logger != nil {
+				t.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	args := redis.Args{scanCursor, "MATCH", addKeyPrefix("*", m)}
+	if limit > 0 {
+		args = args.Add("COUNT", limit)
+	}
+	values, err := redis.Values(conn.Do("SCAN", args...))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan redis keys: %w", err)
+	}
+	var keys []string
+	if _, err := redis.Scan(values, &scanCursor, &keys); err != nil {
+		return nil, "", fmt.Errorf("failed to parse redis scan reply: %w", err)
+	}
+
+	switch {
+	case scanCursor != "0":
+		cursor = string(m) + ":" + scanCursor
+	case m == modeThunderingHerd:
+		cursor = string(modeCoolDown) + ":0"
+	default:
+		cursor = ""
+	}
+
+	now, err := serverTime(conn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	fingerprints := make([]anicetus.Fingerprint, len(keys))
+	for i, key := range keys {
+		fingerprints[i] = removeKeyPrefix(key, m)
+		if err := t.sendInspect(conn, fingerprints[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, "", fmt.Errorf("failed to flush redis commands: %w", err)
+	}
+
+	states := make([]anicetus.DetectorState, 0, len(keys))
+	for _, fingerprint := range fingerprints {
+		state, bucketFound, coolDownFound, err := t.receiveInspect(conn, fingerprint, now)
+		if err != nil {
+			return nil, "", err
+		}
+		// fingerprints with a token bucket were already listed in the first phase
+		if m == modeCoolDown && bucketFound {
+			continue
+		}
+		if bucketFound || coolDownFound {
+			states = append(states, state)
+		}
+	}
+	return states, cursor, nil
+}
+
+// InspectDetection returns the detector state of the fingerprint.
+func (t *TokenBucketRedis) InspectDetection(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+) (anicetus.DetectorState, bool, error) {
+	conn, err := t.pool.GetContext(ctx)
+	if err != nil {
+		return anicetus.DetectorState{}, false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if t.logger != nil {
+				t.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	now, err := serverTime(conn)
+	if err != nil {
+		return anicetus.DetectorState{}, false, err
+	}
+	if err := t.sendInspect(conn, fingerprint); err != nil {
+		return anicetus.DetectorState{}, false, err
+	}
+	if err := conn.Flush(); err != nil {
+		return anicetus.DetectorState{}, false, fmt.Errorf("failed to flush redis commands: %w", err)
+	}
+	state, bucketFound, coolDownFound, err := t.receiveInspect(conn, fingerprint, now)
+	if err != nil {
+		return anicetus.DetectorState{}, false, err
+	}
+	return state, bucketFound || coolDownFound, nil
+}
+
+// sendInspect pipelines the commands to retrieve the state of the fingerprint.
+// The replies must be read with receiveInspect.
+func (t *TokenBucketRedis) sendInspect(conn redis.Conn, fingerprint anicetus.Fingerprint) error {
+	if err := conn.Send("HMGET", addKeyPrefix(fingerprint, modeThunderingHerd), "tokens", "last_refreshed"); err != nil {
+		return fmt.Errorf("failed to send redis command: %w", err)
+	}
+	if err := conn.Send("PTTL", addKeyPrefix(fingerprint, modeCoolDown)); err != nil {
+		return fmt.Errorf("failed to send redis command: %w", err)
+	}
+	return nil
+}
+
+// receiveInspect reads the replies of the commands sent by sendInspect.
+func (t *TokenBucketRedis) receiveInspect(
+	conn redis.Conn,
+	fingerprint anicetus.Fingerprint,
+	now time.Time,
+) (state anicetus.DetectorState, bucketFound, coolDownFound bool, err error) {
+	o := t.options.Load()
+	bucket, err := redis.Strings(conn.Receive())
+	if err != nil {
+		return state, false, false, fmt.Errorf("failed to get redis key: %w", err)
+	}
+	coolDownTTL, err := redis.Int64(conn.Receive())
+	if err != nil {
+		return state, false, false, fmt.Errorf("failed to get redis key ttl: %w", err)
+	}
+
+	state = anicetus.DetectorState{
+		Fingerprint: fingerprint,
+		Tokens:      float64(o.Burst()),
+	}
+
+	if len(bucket) == 2 && bucket[0] != "" {
+		bucketFound = true
+		tokens, _ := strconv.ParseFloat(bucket[0], 64)
+		lastRefreshed, _ := strconv.ParseFloat(bucket[1], 64)
+		elapsed := max(float64(now.UnixMicro())/1e6-lastRefreshed, 0)
+		state.Tokens = min(float64(o.Burst()), tokens+elapsed*o.Rate().PerSecond())
+	}
+
+	// PTTL returns -2 when the key doesn't exist and -1 when it has no expiration
+	if coolDownTTL >= 0 {
+		coolDownFound = true
+		state.CoolDownRemaining = time.Duration(coolDownTTL) * time.Millisecond
+	}
+	return state, bucketFound, coolDownFound, nil
+}
+
+// serverTime returns the current time of the Redis server.
+func serverTime(conn redis.Conn) (time.Time, error) {
+	values, err := redis.Int64s(conn.Do("TIME"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get redis time: %w", err)
+	}
+	if len(values) != 2 {
+		return time.Time{}, fmt.Errorf("unexpected redis time reply: %v", values)
+	}
+	return time.Unix(values[0], values[1]*int64(time.Microsecond)), nil
+}
+
 // mode is used to set the correct redis scope for the keys.
 type mode string
 
@@ -167,6 +392,11 @@ const (
 	// modeThunderingHerd is the mode used to check if the fingerprint is a
 	// thundering herd.
 	modeThunderingHerd mode = "th"
+	// modeSlidingWindow is the mode used to count the requests of the sliding
+	// window.
+	modeSlidingWindow mode = "sw"
+	// modeInFlight is the mode used to count the requests in flight.
+	modeInFlight mode = "inflight"
 )
 
 // addKeyPrefix adds the key prefix to the fingerprint to correctly set the
@@ -174,3 +404,9 @@ const (
 func addKeyPrefix(fingerprint anicetus.Fingerprint, m mode) string {
 	return fmt.Sprintf("anicetus:%s:%s", m, fingerprint)
 }
+
+// removeKeyPrefix removes the key prefix of the scope, returning the
+// fingerprint.
+func removeKeyPrefix(key string, m mode) anicetus.Fingerprint {
+	return anicetus.Fingerprint(strings.TrimPrefix(key, addKeyPrefix("", m)))
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/gomodule/redigo/redis"
 	"github.com/rafaeljusto/anicetus/v2"
 	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
 	"github.com/rafaeljusto/anicetus/v2/detector/redigo"
 )
 
@@ -81,8 +82,8 @@ func TestTokenBucketRedis_IsThunderingHerd(t *testing.T) {
 
 			detector := redigo.NewTokenBucketRedis(
 				redisPool,
-				detector.TokenBucketWithLimitersBurst(tt.burst),
-				detector.TokenBucketWithLimitersInterval(tt.interval),
+				detector.TokenBucketWithBurst(tt.burst),
+				detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: tt.interval}),
 			)
 
 			for i := 1; i <= tt.cycles; i++ {
@@ -104,3 +105,116 @@ func TestTokenBucketRedis_IsThunderingHerd(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenBucketRedis_inspect(t *testing.T) {
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisPool := &redis.Pool{
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", redisAddress)
+		},
+	}
+
+	redisConn, err := redisPool.GetContext(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get redis connection: %v", err)
+	}
+	defer func() {
+		if err := redisConn.Close(); err != nil {
+			t.Errorf("failed to close redis connection: %v", err)
+		}
+	}()
+	_, err = redisConn.Do("FLUSHDB")
+	if err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	detector := redigo.NewTokenBucketRedis(
+		redisPool,
+		detector.TokenBucketWithBurst(2),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		detector.TokenBucketWithCoolDownInterval(time.Minute),
+	)
+
+	if _, err := detector.IsThunderingHerd(t.Context(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := detector.CoolDown(t.Context(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := detector.CoolDown(t.Context(), "b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	states := make(map[anicetus.Fingerprint]anicetus.DetectorState)
+	var cursor string
+	for {
+		page, next, err := detector.ListDetections(t.Context(), cursor, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, state := range page {
+			if _, ok := states[state.Fingerprint]; ok {
+				t.Errorf("fingerprint %q listed twice", state.Fingerprint)
+			}
+			states[state.Fingerprint] = state
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	if len(states) != 2 {
+		t.Fatalf("unexpected states: %+v", states)
+	}
+	if state := states["a"]; state.Tokens < 1 || state.Tokens > 1.01 || state.CoolDownRemaining <= 0 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+	if state := states["b"]; state.Tokens != 2 || state.CoolDownRemaining <= 0 || state.CoolDownRemaining > time.Minute {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	if _, ok, err := detector.InspectDetection(t.Context(), "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be tracked")
+	}
+}
+
+func TestTokenBucketRedis_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(t *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		redisAddress := defaultRedisAddress
+		if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+			redisAddress = e
+		}
+
+		redisPool := &redis.Pool{
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", redisAddress)
+			},
+		}
+		t.Cleanup(func() {
+			if err := redisPool.Close(); err != nil {
+				t.Errorf("failed to close redis pool: %v", err)
+			}
+		})
+
+		redisConn, err := redisPool.GetContext(t.Context())
+		if err != nil {
+			t.Fatalf("failed to get redis connection: %v", err)
+		}
+		defer func() {
+			if err := redisConn.Close(); err != nil {
+				t.Errorf("failed to close redis connection: %v", err)
+			}
+		}()
+		if _, err := redisConn.Do("FLUSHDB"); err != nil {
+			t.Fatalf("failed to flush redis database: %v", err)
+		}
+
+		return redigo.NewTokenBucketRedis(redisPool, options...)
+	})
+}
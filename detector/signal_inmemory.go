@@ -0,0 +1,185 @@
+package detector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/mapexp"
+)
+
+var (
+	_ anicetus.SignalDetector   = &SignalInMemory{}
+	_ anicetus.InFlightDetector = &SignalInMemory{}
+	_ anicetus.OutcomeDetector  = &SignalInMemory{}
+
+	_ anicetus.SignalDetector    = &SignalInspectorInMemory{}
+	_ anicetus.DetectorInspector = &SignalInspectorInMemory{}
+)
+
+// SignalInMemory is a detector strategy that stores the load signals reported
+// by the backend in memory, wrapping another detector. While a signal is active
+// for the fingerprint, or for the whole backend, the fingerprint is a
+// thundering herd and never in cooldown, so the gatekeeper keeps protecting the
+// backend. Otherwise, the wrapped detector decides.
+//
+// The requests in flight and the outcomes (anicetus.InFlightDetector and
+// anicetus.OutcomeDetector) are forwarded to the wrapped detector, being
+// ignored when it doesn't support them. To inspect the detections of the
+// wrapped detector, use SignalInspectorInMemory.
+type SignalInMemory struct {
+	inner anicetus.Detector
+	// signals stores when the active signal of each fingerprint expires.
+	signals *mapexp.Map[anicetus.Fingerprint, time.Time]
+	// global is when the active signal of the whole backend expires.
+	global      time.Time
+	globalMutex sync.RWMutex
+	ttl         time.Duration
+}
+
+// NewSignalInMemory creates a new detector strategy fed by backend load
+// signals, wrapping the inner detector.
+func NewSignalInMemory(inner anicetus.Detector, options ...SignalOption) *SignalInMemory {
+	o := NewSignalOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &SignalInMemory{
+		inner:   inner,
+		signals: mapexp.New[anicetus.Fingerprint, time.Time](o.TTL()),
+		ttl:     o.TTL(),
+	}
+}
+
+// CoolDown will cool down the fingerprint in the wrapped detector.
+func (s *SignalInMemory) CoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	return s.inner.CoolDown(ctx, fingerprint)
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown. A fingerprint with an
+// active signal is never in cooldown.
+func (s *SignalInMemory) IsCoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	if s.IsSignaled(fingerprint) {
+		return false, nil
+	}
+	return s.inner.IsCoolDown(ctx, fingerprint)
+}
+
+// IsThunderingHerd checks if the fingerprint is a thundering herd. A
+// fingerprint with an active signal is always a thundering herd.
+func (s *SignalInMemory) IsThunderingHerd(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	if s.IsSignaled(fingerprint) {
+		return true, nil
+	}
+	return s.inner.IsThunderingHerd(ctx, fingerprint)
+}
+
+// PushSignal activates or clears the signal of the fingerprint, or of the
+// whole backend for global signals. An active signal expires after the TTL if
+// it isn't reported again.
+func (s *SignalInMemory) PushSignal(_ context.Context, fingerprint anicetus.Fingerprint, signal anicetus.Signal) error {
+	var expiresAt time.Time
+	if signal.Active {
+		expiresAt = time.Now().Add(s.ttl)
+	}
+
+	if signal.Global {
+		s.globalMutex.Lock()
+		s.global = expiresAt
+		s.globalMutex.Unlock()
+		return nil
+	}
+
+	if signal.Active {
+		s.signals.Set(fingerprint, expiresAt)
+	} else {
+		s.signals.Delete(fingerprint)
+	}
+	return nil
+}
+
+// IsSignaled checks if there's an active signal for the fingerprint or for the
+// whole backend.
+func (s *SignalInMemory) IsSignaled(fingerprint anicetus.Fingerprint) bool {
+	now := time.Now()
+
+	s.globalMutex.RLock()
+	global := s.global
+	s.globalMutex.RUnlock()
+	if now.Before(global) {
+		return true
+	}
+
+	expiresAt, ok := s.signals.Peek(fingerprint)
+	return ok && now.Before(expiresAt)
+}
+
+// RequestStarted notifies the wrapped detector that a request started.
+func (s *SignalInMemory) RequestStarted(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	inFlightDetector, ok := anicetus.As[anicetus.InFlightDetector](s.inner)
+	if !ok {
+		return nil
+	}
+	return inFlightDetector.RequestStarted(ctx, fingerprint)
+}
+
+// RequestFinished notifies the wrapped detector that a request finished.
+func (s *SignalInMemory) RequestFinished(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	inFlightDetector, ok := anicetus.As[anicetus.InFlightDetector](s.inner)
+	if !ok {
+		return nil
+	}
+	return inFlightDetector.RequestFinished(ctx, fingerprint)
+}
+
+// ReportOutcome informs the wrapped detector of the outcome of a request.
+func (s *SignalInMemory) ReportOutcome(ctx context.Context, fingerprint anicetus.Fingerprint, outcome anicetus.Outcome) error {
+	outcomeDetector, ok := anicetus.As[anicetus.OutcomeDetector](s.inner)
+	if !ok {
+		return nil
+	}
+	return outcomeDetector.ReportOutcome(ctx, fingerprint, outcome)
+}
+
+// SignalInspectorInMemory is a SignalInMemory wrapping a detector that can be
+// inspected (anicetus.DetectorInspector), forwarding the inspection to it.
+type SignalInspectorInMemory struct {
+	*SignalInMemory
+
+	inspector anicetus.DetectorInspector
+}
+
+// NewSignalInspectorInMemory creates a new detector strategy fed by backend load
+// signals, wrapping the inner detector that can be inspected.
+func NewSignalInspectorInMemory(
+	inner interface {
+		anicetus.Detector
+		anicetus.DetectorInspector
+	},
+	options ...SignalOption,
+) *SignalInspectorInMemory {
+	return &SignalInspectorInMemory{
+		SignalInMemory: NewSignalInMemory(inner, options...),
+		inspector:      inner,
+	}
+}
+
+// ListDetections returns a page of detector states of the wrapped detector.
+func (s *SignalInspectorInMemory) ListDetections(
+	ctx context.Context,
+	cursor string,
+	limit int,
+) ([]anicetus.DetectorState, string, error) {
+	return s.inspector.ListDetections(ctx, cursor, limit)
+}
+
+// InspectDetection returns the detector state of the fingerprint in the
+// wrapped detector.
+func (s *SignalInspectorInMemory) InspectDetection(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+) (anicetus.DetectorState, bool, error) {
+	return s.inspector.InspectDetection(ctx, fingerprint)
+}
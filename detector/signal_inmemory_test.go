@@ -0,0 +1,118 @@
+package detector_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+)
+
+func TestSignalInMemory_PushSignal(t *testing.T) {
+	inner := detector.NewTokenBucketInMemory(
+		detector.TokenBucketWithBurst(100),
+	)
+	detector := detector.NewSignalInMemory(inner,
+		detector.SignalWithTTL(200*time.Millisecond),
+	)
+
+	if err := detector.CoolDown(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertSignaled(t, detector, "test", false)
+
+	if err := detector.PushSignal(t.Context(), "test", anicetus.Signal{Active: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertSignaled(t, detector, "test", true)
+	assertSignaled(t, detector, "other", false)
+
+	if err := detector.PushSignal(t.Context(), "test", anicetus.Signal{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertSignaled(t, detector, "test", false)
+
+	if err := detector.PushSignal(t.Context(), "test", anicetus.Signal{Active: true, Global: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertSignaled(t, detector, "other", true)
+
+	// an active signal not reported again expires
+	time.Sleep(250 * time.Millisecond)
+	assertSignaled(t, detector, "other", false)
+}
+
+func TestSignalInMemory_unsupported(t *testing.T) {
+	detector := detector.NewSignalInMemory(detector.NewTokenBucketInMemory())
+
+	// the wrapped detector doesn't track requests in flight or outcomes
+	if err := detector.RequestStarted(t.Context(), "test"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := detector.ReportOutcome(t.Context(), "test", anicetus.Outcome{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// inspecting the wrapped detector requires SignalInspectorInMemory
+	if _, ok := anicetus.As[anicetus.DetectorInspector](detector); ok {
+		t.Error("detector should not support inspection")
+	}
+}
+
+func TestSignalInspectorInMemory_InspectDetection(t *testing.T) {
+	detector := detector.NewSignalInspectorInMemory(detector.NewTokenBucketInMemory())
+
+	inspector, ok := anicetus.As[anicetus.DetectorInspector](detector)
+	if !ok {
+		t.Fatal("detector should support inspection")
+	}
+
+	if err := detector.CoolDown(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state, ok, err := inspector.InspectDetection(t.Context(), "test"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok || state.CoolDownRemaining <= 0 {
+		t.Errorf("unexpected detector state: %+v", state)
+	}
+
+	if err := detector.PushSignal(t.Context(), "test", anicetus.Signal{Active: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertSignaled(t, detector.SignalInMemory, "test", true)
+}
+
+func TestSignalInMemory_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(_ *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		return detector.NewSignalInMemory(detector.NewTokenBucketInMemory(options...))
+	})
+}
+
+func TestSignalInspectorInMemory_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(_ *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		return detector.NewSignalInspectorInMemory(detector.NewTokenBucketInMemory(options...))
+	})
+}
+
+// assertSignaled checks if the detector considers the fingerprint a
+// thundering herd, ignoring its cooldown, only because of a signal.
+func assertSignaled(t *testing.T, detector *detector.SignalInMemory, fingerprint anicetus.Fingerprint, want bool) {
+	t.Helper()
+
+	if ok := detector.IsSignaled(fingerprint); ok != want {
+		t.Errorf("unexpected signal for %q: got %v, want %v", fingerprint, ok, want)
+	}
+	if ok, err := detector.IsThunderingHerd(t.Context(), fingerprint); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ok != want {
+		t.Errorf("unexpected thundering herd for %q: got %v, want %v", fingerprint, ok, want)
+	}
+	if want {
+		if ok, err := detector.IsCoolDown(t.Context(), fingerprint); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Errorf("fingerprint %q with signal should not be in cooldown", fingerprint)
+		}
+	}
+}
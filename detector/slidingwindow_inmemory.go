@@ -0,0 +1,100 @@
+package detector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/mapexp"
+)
+
+var _ anicetus.Detector = &SlidingWindowInMemory{}
+
+// SlidingWindowInMemory is a sliding window counter detector strategy that
+// stores the state in memory. A fingerprint with more requests than the limit
+// in the last window is a thundering herd.
+//
+// The requests are counted in fixed windows, and the count of the sliding
+// window is estimated weighting the previous fixed window by how much of it is
+// still covered by the sliding window.
+type SlidingWindowInMemory struct {
+	cooldowns *mapexp.Map[anicetus.Fingerprint, bool]
+	counters  *mapexp.Map[anicetus.Fingerprint, *slidingWindowCounter]
+	// countersMutex avoids concurrent requests of a new fingerprint from
+	// creating different counters.
+	countersMutex sync.Mutex
+	limit         int64
+	window        time.Duration
+}
+
+// NewSlidingWindowInMemory creates a new sliding window detector strategy.
+func NewSlidingWindowInMemory(options ...SlidingWindowOption) *SlidingWindowInMemory {
+	o := NewSlidingWindowOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &SlidingWindowInMemory{
+		cooldowns: mapexp.New[anicetus.Fingerprint, bool](o.CoolDownInterval()),
+		// after two windows without requests the counter would be empty
+		counters: mapexp.New[anicetus.Fingerprint, *slidingWindowCounter](2 * o.Window()),
+		limit:    o.Limit(),
+		window:   o.Window(),
+	}
+}
+
+// CoolDown will cool down the fingerprint.
+func (s *SlidingWindowInMemory) CoolDown(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	s.cooldowns.Set(fingerprint, true)
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (s *SlidingWindowInMemory) IsCoolDown(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	cooldown, ok := s.cooldowns.Peek(fingerprint)
+	return cooldown && ok, nil
+}
+
+// IsThunderingHerd counts the request and checks if the fingerprint is a
+// thundering herd.
+func (s *SlidingWindowInMemory) IsThunderingHerd(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	s.countersMutex.Lock()
+	counter, ok := s.counters.Get(fingerprint)
+	if !ok {
+		counter = new(slidingWindowCounter)
+		s.counters.Set(fingerprint, counter)
+	}
+	s.countersMutex.Unlock()
+
+	return counter.add(time.Now(), s.window) > float64(s.limit), nil
+}
+
+// slidingWindowCounter counts the requests of the current and previous fixed
+// windows.
+type slidingWindowCounter struct {
+	mutex    sync.Mutex
+	window   int64
+	current  int64
+	previous int64
+}
+
+// add counts a request at the informed time, returning the estimated number of
+// requests in the sliding window ending now.
+func (c *slidingWindowCounter) add(now time.Time, size time.Duration) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	window := now.UnixNano() / int64(size)
+	switch {
+	case window == c.window+1:
+		c.previous, c.current = c.current, 0
+	case window != c.window:
+		c.previous, c.current = 0, 0
+	}
+	c.window = window
+	c.current++
+
+	elapsed := float64(now.UnixNano()-window*int64(size)) / float64(size)
+	return float64(c.previous)*(1-elapsed) + float64(c.current)
+}
@@ -0,0 +1,50 @@
+package detector_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+)
+
+func TestSlidingWindowInMemory_IsThunderingHerd(t *testing.T) {
+	window := 200 * time.Millisecond
+	detector := detector.NewSlidingWindowInMemory(
+		detector.SlidingWindowWithLimit(4),
+		detector.SlidingWindowWithWindow(window),
+	)
+
+	// wait for the beginning of a fixed window to have a predictable weight for
+	// the previous window
+	time.Sleep(time.Until(time.Now().Truncate(window).Add(window)))
+
+	for i := range 4 {
+		if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Fatalf("request %d should not be a thundering herd", i+1)
+		}
+	}
+
+	// a quarter into the next fixed window, 3/4 of the previous requests are
+	// still in the sliding window
+	time.Sleep(window + window/4)
+	if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("request should not be a thundering herd")
+	}
+	if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("request should be a thundering herd")
+	}
+}
+
+func TestSlidingWindowInMemory_conformance(t *testing.T) {
+	detectortest.RunSlidingWindow(t, func(_ *testing.T, options ...detector.SlidingWindowOption) anicetus.Detector {
+		return detector.NewSlidingWindowInMemory(options...)
+	})
+}
@@ -0,0 +1,122 @@
+package detector
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/mapexp"
+)
+
+var _ anicetus.Detector = &SpikeInMemory{}
+
+// SpikeInMemory is a detector strategy that stores the state in memory and
+// compares the short-term request rate of each fingerprint with its own
+// baseline. A fingerprint whose short-term rate exceeds the baseline by the
+// multiplier is a thundering herd, as long as it has at least the floor of
+// requests in the short window.
+//
+// Both rates are exponentially weighted moving averages, with the short and
+// baseline windows as time constants.
+type SpikeInMemory struct {
+	cooldowns *mapexp.Map[anicetus.Fingerprint, bool]
+	rates     *mapexp.Map[anicetus.Fingerprint, *spikeRates]
+	// ratesMutex avoids concurrent requests of a new fingerprint from creating
+	// different rates.
+	ratesMutex     sync.Mutex
+	shortWindow    time.Duration
+	baselineWindow time.Duration
+	multiplier     float64
+	floor          int64
+	warmUp         time.Duration
+}
+
+// NewSpikeInMemory creates a new spike detector strategy.
+func NewSpikeInMemory(options ...SpikeOption) *SpikeInMemory {
+	o := NewSpikeOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &SpikeInMemory{
+		cooldowns: mapexp.New[anicetus.Fingerprint, bool](o.CoolDownInterval()),
+		// after 5 time constants without requests the baseline is below 1% of
+		// its value, so the fingerprint can start over
+		rates:          mapexp.New[anicetus.Fingerprint, *spikeRates](5 * max(o.BaselineWindow(), o.WarmUp())),
+		shortWindow:    o.ShortWindow(),
+		baselineWindow: o.BaselineWindow(),
+		multiplier:     o.Multiplier(),
+		floor:          o.Floor(),
+		warmUp:         o.WarmUp(),
+	}
+}
+
+// CoolDown will cool down the fingerprint.
+func (s *SpikeInMemory) CoolDown(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	s.cooldowns.Set(fingerprint, true)
+	return nil
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (s *SpikeInMemory) IsCoolDown(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	cooldown, ok := s.cooldowns.Peek(fingerprint)
+	return cooldown && ok, nil
+}
+
+// IsThunderingHerd counts the request and checks if the fingerprint is a
+// thundering herd.
+func (s *SpikeInMemory) IsThunderingHerd(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	now := time.Now()
+
+	s.ratesMutex.Lock()
+	rates, ok := s.rates.Get(fingerprint)
+	if !ok {
+		rates = &spikeRates{firstSeen: now, last: now}
+		s.rates.Set(fingerprint, rates)
+	}
+	s.ratesMutex.Unlock()
+
+	short, baseline, age := rates.add(now, s.shortWindow, s.baselineWindow)
+
+	// requests in the short window at the short-term rate
+	requests := short * s.shortWindow.Seconds()
+	if requests < float64(s.floor) {
+		return false, nil
+	}
+	if age < s.warmUp {
+		return requests >= float64(s.floor)*s.multiplier, nil
+	}
+	return short > baseline*s.multiplier, nil
+}
+
+// spikeRates keeps the moving averages of the request rate of a fingerprint.
+type spikeRates struct {
+	mutex     sync.Mutex
+	firstSeen time.Time
+	last      time.Time
+	// short is the short-term rate, in requests per second.
+	short float64
+	// baseline is the long-term rate, in requests per second.
+	baseline float64
+}
+
+// add counts a request at the informed time, returning the updated rates and
+// the time since the fingerprint was first seen.
+func (r *spikeRates) add(now time.Time, shortWindow, baselineWindow time.Duration) (short, baseline float64, age time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	elapsed := max(now.Sub(r.last), 0).Seconds()
+	r.last = now
+	r.short = ewmaRate(r.short, elapsed, shortWindow.Seconds())
+	r.baseline = ewmaRate(r.baseline, elapsed, baselineWindow.Seconds())
+	return r.short, r.baseline, now.Sub(r.firstSeen)
+}
+
+// ewmaRate decays the rate by the elapsed seconds and adds one request. With
+// a constant rate of requests it converges to that rate.
+func ewmaRate(rate, elapsed, window float64) float64 {
+	return rate*math.Exp(-elapsed/window) + 1/window
+}
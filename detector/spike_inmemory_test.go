@@ -0,0 +1,83 @@
+package detector_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+)
+
+func TestSpikeInMemory_IsThunderingHerd(t *testing.T) {
+	t.Run("it should compare with the floor during warm-up", func(t *testing.T) {
+		detector := detector.NewSpikeInMemory(
+			detector.SpikeWithShortWindow(time.Hour),
+			detector.SpikeWithFloor(5),
+			detector.SpikeWithMultiplier(2),
+			detector.SpikeWithWarmUp(time.Hour),
+		)
+
+		for i := range 9 {
+			if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if ok {
+				t.Fatalf("request %d should not be a thundering herd", i+1)
+			}
+		}
+		for range 5 {
+			if _, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok {
+			t.Error("request should be a thundering herd")
+		}
+	})
+
+	t.Run("it should detect a spike over the baseline", func(t *testing.T) {
+		detector := detector.NewSpikeInMemory(
+			detector.SpikeWithShortWindow(100*time.Millisecond),
+			detector.SpikeWithBaselineWindow(time.Second),
+			detector.SpikeWithFloor(10),
+			detector.SpikeWithMultiplier(3),
+			detector.SpikeWithWarmUp(0),
+		)
+
+		// steady traffic of ~50 requests per second, below the floor of 10
+		// requests in the short window, even while the baseline is still growing
+		for i := range 50 {
+			if ok, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if ok {
+				t.Fatalf("steady request %d should not be a thundering herd", i+1)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		var detected bool
+		for range 40 {
+			ok, err := detector.IsThunderingHerd(t.Context(), "test")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			detected = detected || ok
+		}
+		if !detected {
+			t.Error("spike should be a thundering herd")
+		}
+
+		if ok, err := detector.IsThunderingHerd(t.Context(), "other"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Error("other fingerprint should not be a thundering herd")
+		}
+	})
+}
+
+func TestSpikeInMemory_CoolDown(t *testing.T) {
+	detectortest.TestCoolDown(t, detector.NewSpikeInMemory(
+		detector.SpikeWithCoolDownInterval(200*time.Millisecond),
+	), 200*time.Millisecond)
+}
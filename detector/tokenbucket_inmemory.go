@@ -2,6 +2,11 @@ package detector
 
 import (
 	"context"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rafaeljusto/anicetus/v2"
@@ -9,15 +14,20 @@ import (
 	"github.com/rafaeljusto/anicetus/v2/internal/rate"
 )
 
-var _ anicetus.Detector = &TokenBucketInMemory{}
+var (
+	_ anicetus.Detector          = &TokenBucketInMemory{}
+	_ anicetus.DetectorInspector = &TokenBucketInMemory{}
+)
 
 // TokenBucketInMemory is a token bucket detector strategy that stores the state
 // in memory.
 type TokenBucketInMemory struct {
-	cooldowns        *mapexp.Map[anicetus.Fingerprint, bool]
-	limiters         *mapexp.Map[anicetus.Fingerprint, *rate.Limiter]
-	limitersBurst    int64
-	limitersInterval time.Duration
+	cooldowns *mapexp.Map[anicetus.Fingerprint, bool]
+	limiters  *mapexp.Map[anicetus.Fingerprint, *rate.Limiter]
+	options   atomic.Pointer[TokenBucketOptions]
+	// limitersMutex avoids creating limiters with outdated options while the
+	// options are updated.
+	limitersMutex sync.RWMutex
 }
 
 // NewTokenBucketInMemory creates a new token bucket detector strategy.
@@ -27,14 +37,45 @@ func NewTokenBucketInMemory(options ...TokenBucketOption) *TokenBucketInMemory {
 		opt(o)
 	}
 
-	fullBucketPeriod := o.LimitersInterval() * time.Duration(o.limitersBurst)
+	t := &TokenBucketInMemory{
+		cooldowns: mapexp.New[anicetus.Fingerprint, bool](o.CoolDownInterval()),
+		limiters:  mapexp.New[anicetus.Fingerprint, *rate.Limiter](fullBucketPeriod(o)),
+	}
+	t.cooldowns.SetMaxEntries(o.MaxEntries())
+	t.limiters.SetMaxEntries(o.MaxEntries())
+	t.options.Store(o)
+	return t
+}
+
+// Update applies the options on top of the current ones. The new rate and
+// burst are applied to the token buckets of all fingerprints, keeping their
+// tokens, and the cooldowns already started keep their expiration.
+func (t *TokenBucketInMemory) Update(options ...TokenBucketOption) {
+	t.limitersMutex.Lock()
+	defer t.limitersMutex.Unlock()
 
-	return &TokenBucketInMemory{
-		cooldowns:        mapexp.New[anicetus.Fingerprint, bool](o.CoolDownInterval()),
-		limiters:         mapexp.New[anicetus.Fingerprint, *rate.Limiter](fullBucketPeriod),
-		limitersBurst:    o.LimitersBurst(),
-		limitersInterval: o.LimitersInterval(),
+	o := *t.options.Load()
+	for _, opt := range options {
+		opt(&o)
 	}
+	t.options.Store(&o)
+
+	now := time.Now()
+	t.limiters.Range(func(_ anicetus.Fingerprint, limiter *rate.Limiter) bool {
+		limiter.SetLimitAt(now, rate.Limit(o.Rate().PerSecond()))
+		limiter.SetBurstAt(now, int(o.Burst()))
+		return true
+	})
+	t.limiters.SetTTL(fullBucketPeriod(&o))
+	t.limiters.SetMaxEntries(o.MaxEntries())
+	t.cooldowns.SetTTL(o.CoolDownInterval())
+	t.cooldowns.SetMaxEntries(o.MaxEntries())
+}
+
+// Evictions returns the number of token buckets and cooldowns evicted to
+// respect the maximum number of entries.
+func (t *TokenBucketInMemory) Evictions() uint64 {
+	return t.limiters.Evictions() + t.cooldowns.Evictions()
 }
 
 // CoolDown will cool down the fingerprint.
@@ -45,16 +86,187 @@ func (t *TokenBucketInMemory) CoolDown(_ context.Context, fingerprint anicetus.F
 
 // IsCoolDown checks if the fingerprint is in cooldown.
 func (t *TokenBucketInMemory) IsCoolDown(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
-	cooldown, ok := t.cooldowns.Get(fingerprint)
+	cooldown, ok := t.cooldowns.Peek(fingerprint)
 	return cooldown && ok, nil
 }
 
-// IsThunderingHerd checks if the fingerprint is a thundering herd.
+// IsThunderingHerd checks if the fingerprint is a thundering herd. When the
+// bucket is empty, the penalty is applied to it.
 func (t *TokenBucketInMemory) IsThunderingHerd(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
 	limiter, ok := t.limiters.Get(fingerprint)
 	if !ok {
-		limiter = rate.NewLimiter(rate.Every(t.limitersInterval), int(t.limitersBurst))
+		t.limitersMutex.RLock()
+		o := t.options.Load()
+		limiter = rate.NewLimiterWithPenalty(rate.Limit(o.Rate().PerSecond()), int(o.Burst()), t.penalize)
 		t.limiters.Set(fingerprint, limiter)
+		t.limitersMutex.RUnlock()
 	}
 	return !limiter.Allow(), nil
 }
+
+// Snapshot writes the token buckets and cooldowns of all fingerprints, so they
+// can be restored later, like after a restart.
+func (t *TokenBucketInMemory) Snapshot(w io.Writer) error {
+	var snapshot tokenBucketSnapshot
+	t.snapshot(&snapshot)
+	return writeTokenBucketSnapshot(w, &snapshot)
+}
+
+// Restore loads the token buckets and cooldowns written by Snapshot, replacing
+// the state of the same fingerprints. Expired cooldowns and buckets that would
+// be full again are dropped. The buckets use the current rate and burst.
+func (t *TokenBucketInMemory) Restore(r io.Reader) error {
+	snapshot, err := readTokenBucketSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, bucket := range snapshot.Buckets {
+		t.restoreBucket(bucket, now)
+	}
+	for _, cooldown := range snapshot.CoolDowns {
+		t.restoreCoolDown(cooldown, now)
+	}
+	return nil
+}
+
+func (t *TokenBucketInMemory) snapshot(snapshot *tokenBucketSnapshot) {
+	t.limiters.Range(func(fingerprint anicetus.Fingerprint, limiter *rate.Limiter) bool {
+		expiresAt, ok := t.limiters.Expiration(fingerprint)
+		if !ok {
+			return true
+		}
+		tokens, lastRefreshed := limiter.State()
+		snapshot.Buckets = append(snapshot.Buckets, tokenBucketSnapshotBucket{
+			Fingerprint:   fingerprint,
+			Tokens:        tokens,
+			LastRefreshed: lastRefreshed,
+			ExpiresAt:     expiresAt,
+		})
+		return true
+	})
+	t.cooldowns.Range(func(fingerprint anicetus.Fingerprint, cooldown bool) bool {
+		expiresAt, ok := t.cooldowns.Expiration(fingerprint)
+		if !ok || !cooldown {
+			return true
+		}
+		snapshot.CoolDowns = append(snapshot.CoolDowns, tokenBucketSnapshotCoolDown{
+			Fingerprint: fingerprint,
+			ExpiresAt:   expiresAt,
+		})
+		return true
+	})
+}
+
+func (t *TokenBucketInMemory) restoreBucket(bucket tokenBucketSnapshotBucket, now time.Time) {
+	if snapshotExpired(bucket.ExpiresAt, now) {
+		return
+	}
+
+	t.limitersMutex.RLock()
+	defer t.limitersMutex.RUnlock()
+
+	o := t.options.Load()
+	limiter := rate.NewLimiterWithPenalty(rate.Limit(o.Rate().PerSecond()), int(o.Burst()), t.penalize)
+	limiter.SetState(min(bucket.Tokens, float64(o.Burst())), bucket.LastRefreshed)
+	t.limiters.Set(bucket.Fingerprint, limiter)
+}
+
+func (t *TokenBucketInMemory) restoreCoolDown(cooldown tokenBucketSnapshotCoolDown, now time.Time) {
+	if snapshotExpired(cooldown.ExpiresAt, now) {
+		return
+	}
+
+	if cooldown.ExpiresAt.IsZero() {
+		t.cooldowns.Set(cooldown.Fingerprint, true)
+	} else {
+		t.cooldowns.SetWithTTL(cooldown.Fingerprint, true, cooldown.ExpiresAt.Sub(now))
+	}
+}
+
+// penalize applies the current penalty to a limiter that denied a request.
+func (t *TokenBucketInMemory) penalize(tokens float64, last, now time.Time) (float64, time.Time) {
+	return t.options.Load().Penalty().Apply(tokens, last, now)
+}
+
+// ListDetections returns a page of detector states sorted by fingerprint. The
+// cursor is the last fingerprint of the previous page.
+func (t *TokenBucketInMemory) ListDetections(
+	_ context.Context,
+	cursor string,
+	limit int,
+) ([]anicetus.DetectorState, string, error) {
+	found := make(map[anicetus.Fingerprint]struct{})
+	t.limiters.Range(func(fingerprint anicetus.Fingerprint, _ *rate.Limiter) bool {
+		found[fingerprint] = struct{}{}
+		return true
+	})
+	t.cooldowns.Range(func(fingerprint anicetus.Fingerprint, _ bool) bool {
+		found[fingerprint] = struct{}{}
+		return true
+	})
+
+	var fingerprints []anicetus.Fingerprint
+	for fingerprint := range found {
+		if string(fingerprint) > cursor {
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	slices.Sort(fingerprints)
+
+	var next string
+	if limit > 0 && len(fingerprints) > limit {
+		fingerprints = fingerprints[:limit]
+		next = string(fingerprints[limit-1])
+	}
+
+	states := make([]anicetus.DetectorState, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		if state, ok := t.inspect(fingerprint); ok {
+			states = append(states, state)
+		}
+	}
+	return states, next, nil
+}
+
+// InspectDetection returns the detector state of the fingerprint.
+func (t *TokenBucketInMemory) InspectDetection(
+	_ context.Context,
+	fingerprint anicetus.Fingerprint,
+) (anicetus.DetectorState, bool, error) {
+	state, ok := t.inspect(fingerprint)
+	return state, ok, nil
+}
+
+func (t *TokenBucketInMemory) inspect(fingerprint anicetus.Fingerprint) (anicetus.DetectorState, bool) {
+	state := anicetus.DetectorState{
+		Fingerprint: fingerprint,
+		Tokens:      float64(t.options.Load().Burst()),
+	}
+
+	limiter, limiterFound := t.limiters.Peek(fingerprint)
+	if limiterFound {
+		state.Tokens = limiter.Tokens()
+	}
+
+	_, coolDownFound := t.cooldowns.Peek(fingerprint)
+	if coolDownFound {
+		if expiration, ok := t.cooldowns.Expiration(fingerprint); ok {
+			state.CoolDownRemaining = max(time.Until(expiration), 0)
+		}
+	}
+
+	return state, limiterFound || coolDownFound
+}
+
+// fullBucketPeriod is the time needed to refill an empty bucket, including any
+// pause of the penalty. After that an idle limiter has no effect and can be
+// discarded.
+func fullBucketPeriod(o *TokenBucketOptions) time.Duration {
+	period := o.Rate().DurationOf(o.Burst())
+	if pause := o.Penalty().Pause(); period <= math.MaxInt64-pause {
+		period += pause
+	}
+	return period
+}
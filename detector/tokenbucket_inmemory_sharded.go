@@ -0,0 +1,176 @@
+package detector
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/shard"
+)
+
+var (
+	_ anicetus.Detector          = &TokenBucketInMemorySharded{}
+	_ anicetus.DetectorInspector = &TokenBucketInMemorySharded{}
+)
+
+// TokenBucketInMemorySharded is a token bucket detector strategy that stores
+// the state in memory, partitioned by fingerprint (TokenBucketWithShards).
+// Each partition is a TokenBucketInMemory with its own locks, so checking
+// different fingerprints in parallel doesn't compete for the same lock.
+type TokenBucketInMemorySharded struct {
+	shards       []*TokenBucketInMemory
+	seed         maphash.Seed
+	options      atomic.Pointer[TokenBucketOptions]
+	optionsMutex sync.Mutex
+}
+
+// NewTokenBucketInMemorySharded creates a new sharded token bucket detector
+// strategy.
+func NewTokenBucketInMemorySharded(options ...TokenBucketOption) *TokenBucketInMemorySharded {
+	o := NewTokenBucketOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	t := &TokenBucketInMemorySharded{
+		shards: make([]*TokenBucketInMemory, max(o.Shards(), 1)),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range t.shards {
+		t.shards[i] = NewTokenBucketInMemory(append(slices.Clip(options),
+			TokenBucketWithMaxEntries(shard.MaxEntries(o.MaxEntries(), len(t.shards))),
+		)...)
+	}
+	t.options.Store(o)
+	return t
+}
+
+// Update applies the options on top of the current ones in all partitions. The
+// number of partitions can't be changed.
+func (t *TokenBucketInMemorySharded) Update(options ...TokenBucketOption) {
+	t.optionsMutex.Lock()
+	defer t.optionsMutex.Unlock()
+
+	o := *t.options.Load()
+	for _, opt := range options {
+		opt(&o)
+	}
+	t.options.Store(&o)
+
+	maxEntries := shard.MaxEntries(o.MaxEntries(), len(t.shards))
+	for _, shard := range t.shards {
+		shard.Update(append(slices.Clip(options),
+			TokenBucketWithMaxEntries(maxEntries),
+		)...)
+	}
+}
+
+// Evictions returns the number of token buckets and cooldowns evicted to
+// respect the maximum number of entries.
+func (t *TokenBucketInMemorySharded) Evictions() uint64 {
+	var evictions uint64
+	for _, shard := range t.shards {
+		evictions += shard.Evictions()
+	}
+	return evictions
+}
+
+// CoolDown will cool down the fingerprint.
+func (t *TokenBucketInMemorySharded) CoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	return t.shard(fingerprint).CoolDown(ctx, fingerprint)
+}
+
+// IsCoolDown checks if the fingerprint is in cooldown.
+func (t *TokenBucketInMemorySharded) IsCoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	return t.shard(fingerprint).IsCoolDown(ctx, fingerprint)
+}
+
+// IsThunderingHerd checks if the fingerprint is a thundering herd. When the
+// bucket is empty, the penalty is applied to it.
+func (t *TokenBucketInMemorySharded) IsThunderingHerd(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+) (bool, error) {
+	return t.shard(fingerprint).IsThunderingHerd(ctx, fingerprint)
+}
+
+// ListDetections returns a page of detector states sorted by fingerprint. The
+// cursor is the last fingerprint of the previous page.
+func (t *TokenBucketInMemorySharded) ListDetections(
+	ctx context.Context,
+	cursor string,
+	limit int,
+) ([]anicetus.DetectorState, string, error) {
+	var states []anicetus.DetectorState
+	var more bool
+	for i, shard := range t.shards {
+		shardStates, next, err := shard.ListDetections(ctx, cursor, limit)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list detections in shard %d: %w", i, err)
+		}
+		states = append(states, shardStates...)
+		more = more || next != ""
+	}
+	slices.SortFunc(states, func(a, b anicetus.DetectorState) int {
+		return cmp.Compare(a.Fingerprint, b.Fingerprint)
+	})
+
+	var next string
+	if limit > 0 && len(states) > limit {
+		states = states[:limit]
+		more = true
+	}
+	if more && len(states) > 0 {
+		next = string(states[len(states)-1].Fingerprint)
+	}
+	return states, next, nil
+}
+
+// InspectDetection returns the detector state of the fingerprint.
+func (t *TokenBucketInMemorySharded) InspectDetection(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+) (anicetus.DetectorState, bool, error) {
+	return t.shard(fingerprint).InspectDetection(ctx, fingerprint)
+}
+
+// Snapshot writes the token buckets and cooldowns of all fingerprints, so they
+// can be restored later, like after a restart. The snapshot is compatible with
+// TokenBucketInMemory, whatever the number of partitions.
+func (t *TokenBucketInMemorySharded) Snapshot(w io.Writer) error {
+	var snapshot tokenBucketSnapshot
+	for _, shard := range t.shards {
+		shard.snapshot(&snapshot)
+	}
+	return writeTokenBucketSnapshot(w, &snapshot)
+}
+
+// Restore loads the token buckets and cooldowns written by Snapshot, replacing
+// the state of the same fingerprints. Expired cooldowns and buckets that would
+// be full again are dropped. The buckets use the current rate and burst.
+func (t *TokenBucketInMemorySharded) Restore(r io.Reader) error {
+	snapshot, err := readTokenBucketSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, bucket := range snapshot.Buckets {
+		t.shard(bucket.Fingerprint).restoreBucket(bucket, now)
+	}
+	for _, cooldown := range snapshot.CoolDowns {
+		t.shard(cooldown.Fingerprint).restoreCoolDown(cooldown, now)
+	}
+	return nil
+}
+
+func (t *TokenBucketInMemorySharded) shard(fingerprint anicetus.Fingerprint) *TokenBucketInMemory {
+	return t.shards[maphash.String(t.seed, string(fingerprint))%uint64(len(t.shards))]
+}
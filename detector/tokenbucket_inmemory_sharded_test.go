@@ -0,0 +1,119 @@
+package detector_test
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+)
+
+func TestTokenBucketInMemorySharded_inspect(t *testing.T) {
+	detector := detector.NewTokenBucketInMemorySharded(
+		detector.TokenBucketWithBurst(2),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		detector.TokenBucketWithShards(4),
+	)
+
+	want := []anicetus.Fingerprint{"a", "b", "c", "d", "e", "f", "g"}
+	for _, fingerprint := range want {
+		if _, err := detector.IsThunderingHerd(t.Context(), fingerprint); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var listed []anicetus.Fingerprint
+	var cursor string
+	for range len(want) {
+		states, next, err := detector.ListDetections(t.Context(), cursor, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(states) > 3 {
+			t.Fatalf("unexpected page size %d", len(states))
+		}
+		for _, state := range states {
+			listed = append(listed, state.Fingerprint)
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	if len(listed) != len(want) {
+		t.Fatalf("unexpected fingerprints %v", listed)
+	}
+	for i := range want {
+		if listed[i] != want[i] {
+			t.Fatalf("unexpected fingerprints %v", listed)
+		}
+	}
+
+	if state, ok, err := detector.InspectDetection(t.Context(), "a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be tracked")
+	} else if state.Tokens < 1 || state.Tokens > 1.01 {
+		t.Errorf("unexpected tokens: %f", state.Tokens)
+	}
+}
+
+func TestTokenBucketInMemorySharded_maxEntries(t *testing.T) {
+	detector := detector.NewTokenBucketInMemorySharded(
+		detector.TokenBucketWithMaxEntries(10),
+		detector.TokenBucketWithShards(2),
+	)
+
+	for i := range 100 {
+		if _, err := detector.IsThunderingHerd(t.Context(), anicetus.Fingerprint(strconv.Itoa(i))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	states, _, err := detector.ListDetections(t.Context(), "", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(states) > 10 {
+		t.Errorf("unexpected number of fingerprints %d", len(states))
+	}
+	if evictions := detector.Evictions(); evictions != uint64(100-len(states)) {
+		t.Errorf("unexpected evictions: %d", evictions)
+	}
+}
+
+func TestTokenBucketInMemorySharded_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(_ *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		return detector.NewTokenBucketInMemorySharded(options...)
+	})
+}
+
+func BenchmarkTokenBucketInMemory_IsThunderingHerdParallel(b *testing.B) {
+	benchmarkIsThunderingHerdParallel(b, detector.NewTokenBucketInMemory())
+}
+
+func BenchmarkTokenBucketInMemorySharded_IsThunderingHerdParallel(b *testing.B) {
+	benchmarkIsThunderingHerdParallel(b, detector.NewTokenBucketInMemorySharded())
+}
+
+// benchmarkIsThunderingHerdParallel checks different fingerprints in parallel.
+// Run it with -cpu 1,2,4,8 to compare how the throughput scales.
+func benchmarkIsThunderingHerdParallel(b *testing.B, detector anicetus.Detector) {
+	fingerprints := make([]anicetus.Fingerprint, 100_000)
+	for i := range fingerprints {
+		fingerprints[i] = anicetus.Fingerprint("/users/" + strconv.Itoa(i))
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := rand.IntN(len(fingerprints)); pb.Next(); i++ {
+			if _, err := detector.IsThunderingHerd(b.Context(), fingerprints[i%len(fingerprints)]); err != nil {
+				b.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+	})
+}
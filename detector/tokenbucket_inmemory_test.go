@@ -1,14 +1,17 @@
 package detector_test
 
 import (
+	"bytes"
 	"fmt"
 	"slices"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/rafaeljusto/anicetus/v2"
 	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
 )
 
 func TestTokenBucketInMemory_IsThunderingHerd(t *testing.T) {
@@ -44,8 +47,8 @@ func TestTokenBucketInMemory_IsThunderingHerd(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(fmt.Sprintf("interval %s and burst %d", tt.interval, tt.burst), func(t *testing.T) {
 			detector := detector.NewTokenBucketInMemory(
-				detector.TokenBucketWithLimitersBurst(tt.burst),
-				detector.TokenBucketWithLimitersInterval(tt.interval),
+				detector.TokenBucketWithBurst(tt.burst),
+				detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: tt.interval}),
 			)
 
 			for i := 1; i <= tt.cycles; i++ {
@@ -67,3 +70,151 @@ func TestTokenBucketInMemory_IsThunderingHerd(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenBucketInMemory_inspect(t *testing.T) {
+	detector := detector.NewTokenBucketInMemory(
+		detector.TokenBucketWithBurst(2),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		detector.TokenBucketWithCoolDownInterval(time.Minute),
+	)
+
+	if _, err := detector.IsThunderingHerd(t.Context(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := detector.CoolDown(t.Context(), "b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	states, cursor, err := detector.ListDetections(t.Context(), "", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(states) != 1 || states[0].Fingerprint != "a" {
+		t.Fatalf("unexpected first page: %+v", states)
+	}
+	if tokens := states[0].Tokens; tokens < 1 || tokens > 1.01 {
+		t.Errorf("unexpected tokens: %f", tokens)
+	}
+	if states[0].CoolDownRemaining != 0 {
+		t.Errorf("unexpected cooldown: %s", states[0].CoolDownRemaining)
+	}
+
+	states, cursor, err = detector.ListDetections(t.Context(), cursor, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(states) != 1 || states[0].Fingerprint != "b" {
+		t.Fatalf("unexpected second page: %+v", states)
+	}
+	if states[0].Tokens != 2 {
+		t.Errorf("unexpected tokens: %f", states[0].Tokens)
+	}
+	if remaining := states[0].CoolDownRemaining; remaining <= 0 || remaining > time.Minute {
+		t.Errorf("unexpected cooldown: %s", remaining)
+	}
+	if cursor != "" {
+		t.Errorf("unexpected cursor %q", cursor)
+	}
+
+	if _, ok, err := detector.InspectDetection(t.Context(), "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be tracked")
+	}
+}
+
+func TestTokenBucketInMemory_maxEntries(t *testing.T) {
+	detector := detector.NewTokenBucketInMemory(
+		detector.TokenBucketWithBurst(1),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		detector.TokenBucketWithMaxEntries(2),
+	)
+
+	for _, fingerprint := range []anicetus.Fingerprint{"a", "b", "a", "c"} {
+		if _, err := detector.IsThunderingHerd(t.Context(), fingerprint); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// "b" is the least recently used bucket, so it was evicted for "c"
+	if _, ok, err := detector.InspectDetection(t.Context(), "b"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should be evicted")
+	}
+	if ok, err := detector.IsThunderingHerd(t.Context(), "a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should keep its empty bucket")
+	}
+	if evictions := detector.Evictions(); evictions != 1 {
+		t.Errorf("unexpected evictions: %d", evictions)
+	}
+}
+
+func TestTokenBucketInMemory_snapshot(t *testing.T) {
+	options := []detector.TokenBucketOption{
+		detector.TokenBucketWithBurst(2),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+		detector.TokenBucketWithCoolDownInterval(time.Minute),
+	}
+	detector1 := detector.NewTokenBucketInMemory(options...)
+
+	if _, err := detector1.IsThunderingHerd(t.Context(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := detector1.CoolDown(t.Context(), "b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	detector1.Update(detector.TokenBucketWithCoolDownInterval(10 * time.Millisecond))
+	if err := detector1.CoolDown(t.Context(), "c"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := detector1.Snapshot(&snapshot); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// snapshots are compatible with the sharded detector
+	detector2 := detector.NewTokenBucketInMemorySharded(options...)
+	if err := detector2.Restore(&snapshot); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if state, ok, err := detector2.InspectDetection(t.Context(), "a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("bucket should be restored")
+	} else if state.Tokens < 1 || state.Tokens > 1.01 {
+		t.Errorf("unexpected tokens: %f", state.Tokens)
+	}
+
+	if cooldown, err := detector2.IsCoolDown(t.Context(), "b"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !cooldown {
+		t.Error("cooldown should be restored")
+	}
+	if state, _, err := detector2.InspectDetection(t.Context(), "b"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if state.CoolDownRemaining < 59*time.Second || state.CoolDownRemaining > time.Minute {
+		t.Errorf("unexpected cooldown: %s", state.CoolDownRemaining)
+	}
+
+	if cooldown, err := detector2.IsCoolDown(t.Context(), "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if cooldown {
+		t.Error("expired cooldown should be dropped")
+	}
+
+	if err := detector2.Restore(strings.NewReader(`{"version":2}`)); err == nil {
+		t.Error("expected an error for an unsupported version")
+	}
+}
+
+func TestTokenBucketInMemory_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(_ *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		return detector.NewTokenBucketInMemory(options...)
+	})
+}
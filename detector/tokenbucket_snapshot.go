@@ -0,0 +1,64 @@
+package detector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+// tokenBucketSnapshotVersion is the version of the in-memory token bucket
+// snapshot format. It must be increased on incompatible changes.
+const tokenBucketSnapshotVersion = 1
+
+// tokenBucketSnapshot is the state of the in-memory token bucket detectors,
+// encoded as JSON.
+type tokenBucketSnapshot struct {
+	Version   int                           `json:"version"`
+	Buckets   []tokenBucketSnapshotBucket   `json:"buckets"`
+	CoolDowns []tokenBucketSnapshotCoolDown `json:"cooldowns"`
+}
+
+type tokenBucketSnapshotBucket struct {
+	Fingerprint anicetus.Fingerprint `json:"fingerprint"`
+	Tokens      float64              `json:"tokens"`
+	// LastRefreshed is when the tokens were last updated. A time in the future
+	// is a penalty pause.
+	LastRefreshed time.Time `json:"lastRefreshed"`
+	// ExpiresAt is when the idle bucket is full again and can be discarded. The
+	// zero time means that it never expires.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type tokenBucketSnapshotCoolDown struct {
+	Fingerprint anicetus.Fingerprint `json:"fingerprint"`
+	// ExpiresAt is when the cooldown ends. The zero time means that it never
+	// ends.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func writeTokenBucketSnapshot(w io.Writer, snapshot *tokenBucketSnapshot) error {
+	snapshot.Version = tokenBucketSnapshotVersion
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return nil
+}
+
+func readTokenBucketSnapshot(r io.Reader) (*tokenBucketSnapshot, error) {
+	var snapshot tokenBucketSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snapshot.Version != tokenBucketSnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
+
+// snapshotExpired checks if an expiration of the snapshot already passed.
+func snapshotExpired(expiresAt, now time.Time) bool {
+	return !expiresAt.IsZero() && !expiresAt.After(now)
+}
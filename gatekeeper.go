@@ -3,6 +3,7 @@ package anicetus
 import (
 	"context"
 	"fmt"
+	"time"
 )
 
 // Gatekeeper stores the logic to control the thundering herd problem.
@@ -18,38 +19,70 @@ func NewGatekeeper(storage GatekeeperStorage) *Gatekeeper {
 	}
 }
 
-// analyze checks if the fingerprint is valid to be processed.
-func (g Gatekeeper) analyze(ctx context.Context, fingerprint Fingerprint) (Status, error) {
+// analyze checks if the fingerprint is valid to be processed. When the request
+// is chosen to be processed, the fencing token of the gate is also returned.
+func (g Gatekeeper) analyze(ctx context.Context, fingerprint Fingerprint) (Status, FencingToken, error) {
 	if exists, err := g.storage.Exists(ctx, fingerprint); err != nil {
-		return StatusFailed, fmt.Errorf("failed to check if fingerprint exists: %w", err)
+		return StatusFailed, 0, fmt.Errorf("failed to check if fingerprint exists: %w", err)
 
 	} else if exists {
 		if processed, err := g.storage.Processed(ctx, fingerprint); err != nil {
-			return StatusFailed, fmt.Errorf("failed to get fingerprint processed flag: %w", err)
+			return StatusFailed, 0, fmt.Errorf("failed to get fingerprint processed flag: %w", err)
 
 		} else if processed {
-			return StatusOpenGates, nil
+			return StatusOpenGates, 0, nil
 		}
 
-		return StatusWait, nil
+		return g.wait(ctx, fingerprint)
+	}
+
+	if fencedStorage, ok := As[FencedGatekeeperStorage](g.storage); ok {
+		token, acquired, err := fencedStorage.Acquire(ctx, fingerprint)
+		if err != nil {
+			return StatusFailed, 0, fmt.Errorf("failed to acquire fingerprint: %w", err)
+		} else if !acquired {
+			// another request was elected as leader in the meantime
+			return g.wait(ctx, fingerprint)
+		}
+		return StatusProcess, token, nil
 	}
 
 	if err := g.storage.Store(ctx, fingerprint, false); err != nil {
-		return StatusFailed, fmt.Errorf("failed to store fingerprint: %w", err)
+		return StatusFailed, 0, fmt.Errorf("failed to store fingerprint: %w", err)
 	}
+	return StatusProcess, 0, nil
+}
 
-	return StatusProcess, nil
+// wait tells the request to wait for the leader, counting it as a waiter of the
+// gate when the storage supports it.
+func (g Gatekeeper) wait(ctx context.Context, fingerprint Fingerprint) (Status, FencingToken, error) {
+	if waiterCounter, ok := As[GatekeeperStorageWaiterCounter](g.storage); ok {
+		if err := waiterCounter.CountWaiter(ctx, fingerprint); err != nil {
+			return StatusFailed, 0, fmt.Errorf("failed to count fingerprint waiter: %w", err)
+		}
+	}
+	return StatusWait, 0, nil
 }
 
 // Store stores the fingerprint in the storage. This should be called after the
-// processing is done of the StatusProcess.
-func (g Gatekeeper) Store(ctx context.Context, fingerprint Fingerprint, processed bool) error {
+// processing is done of the StatusProcess. When the storage supports fencing
+// and a non-zero token is informed, the operation is rejected with a
+// StaleTokenError if the token doesn't match the current gate.
+func (g Gatekeeper) Store(ctx context.Context, fingerprint Fingerprint, processed bool, token FencingToken) error {
+	if fencedStorage, ok := As[FencedGatekeeperStorage](g.storage); ok && token != 0 {
+		return fencedStorage.StoreWithToken(ctx, fingerprint, processed, token)
+	}
 	return g.storage.Store(ctx, fingerprint, processed)
 }
 
 // Remove removes the fingerprint from the storage. This should be called in
-// case there is some error while processing the request.
-func (g Gatekeeper) Remove(ctx context.Context, fingerprint Fingerprint) error {
+// case there is some error while processing the request. When the storage
+// supports fencing and a non-zero token is informed, the operation is rejected
+// with a StaleTokenError if the token doesn't match the current gate.
+func (g Gatekeeper) Remove(ctx context.Context, fingerprint Fingerprint, token FencingToken) error {
+	if fencedStorage, ok := As[FencedGatekeeperStorage](g.storage); ok && token != 0 {
+		return fencedStorage.RemoveWithToken(ctx, fingerprint, token)
+	}
 	return g.storage.Remove(ctx, fingerprint)
 }
 
@@ -65,3 +98,159 @@ type GatekeeperStorage interface {
 	// error if the fingerprint doesn't exist.
 	Remove(ctx context.Context, fingerprint Fingerprint) error
 }
+
+// FencingToken identifies the leader of a gate. Tokens are monotonically
+// increasing, so a newer leader always holds a greater token than the previous
+// ones. The zero value means that no token was issued.
+type FencingToken uint64
+
+// FencedGatekeeperStorage is an optional interface that a GatekeeperStorage can
+// implement to protect the gates against stale leaders. A slow leader may try
+// to finish its request after its gate was removed and a new leader was
+// elected; the fencing token allows the storage to detect it.
+type FencedGatekeeperStorage interface {
+	GatekeeperStorage
+
+	// Acquire atomically stores the fingerprint as not processed if it doesn't
+	// exist yet, returning a new fencing token. If the fingerprint already
+	// exists it MUST return false.
+	Acquire(ctx context.Context, fingerprint Fingerprint) (FencingToken, bool, error)
+	// StoreWithToken stores the fingerprint in the storage only if the token
+	// matches the current gate. Otherwise, it MUST return a StaleTokenError.
+	StoreWithToken(ctx context.Context, fingerprint Fingerprint, processed bool, token FencingToken) error
+	// RemoveWithToken removes the fingerprint from the storage only if the token
+	// matches the current gate. It MUST not return an error if the fingerprint
+	// doesn't exist, and MUST return a StaleTokenError if the gate belongs to
+	// another token.
+	RemoveWithToken(ctx context.Context, fingerprint Fingerprint, token FencingToken) error
+}
+
+// StaleTokenError is returned when an operation is made with a fencing token
+// that doesn't own the gate anymore.
+type StaleTokenError struct {
+	Fingerprint Fingerprint
+	Token       FencingToken
+}
+
+// Error returns the error message.
+func (e *StaleTokenError) Error() string {
+	return fmt.Sprintf("stale fencing token %d for fingerprint %q", e.Token, e.Fingerprint)
+}
+
+// GateState is the state of a gate for a fingerprint.
+type GateState struct {
+	// Fingerprint identifies the gate.
+	Fingerprint Fingerprint
+	// Processed is true when the leader finished processing the request and the
+	// gate is open.
+	Processed bool
+	// Token is the fencing token of the leader. It is zero when the gate was
+	// stored without fencing.
+	Token FencingToken
+	// LeaderAge is the time elapsed since the leader was elected.
+	LeaderAge time.Duration
+	// Waiters is the number of wait responses given while the gate was closed,
+	// counted by storages implementing GatekeeperStorageWaiterCounter. A client
+	// retrying the request is counted again on every retry.
+	Waiters int64
+}
+
+// GatekeeperStorageInspector is an optional interface that a GatekeeperStorage
+// can implement to allow listing and inspecting the gates, useful for
+// troubleshooting.
+type GatekeeperStorageInspector interface {
+	// ListGates returns a page of gates starting at the cursor. An empty cursor
+	// starts from the beginning, and an empty returned cursor means that there
+	// are no more gates. The limit is a hint of the page size, some storages may
+	// return fewer or more items.
+	ListGates(ctx context.Context, cursor string, limit int) ([]GateState, string, error)
+	// InspectGate returns the gate state of the fingerprint. It returns false if
+	// the gate doesn't exist.
+	InspectGate(ctx context.Context, fingerprint Fingerprint) (GateState, bool, error)
+}
+
+// GatekeeperStorageWaiterCounter is an optional interface that a
+// GatekeeperStorage can implement to count the requests told to wait for the
+// leader, reported in the GateState. The gatekeeper calls it every time a
+// request finds the gate closed, so checking the gate state (like Processed)
+// remains free of side effects.
+type GatekeeperStorageWaiterCounter interface {
+	// CountWaiter increments the number of waiters of the gate, if it exists and
+	// wasn't processed yet. It MUST not return an error if the gate doesn't
+	// exist.
+	CountWaiter(ctx context.Context, fingerprint Fingerprint) error
+}
+
+// GateEventType is the type of a gate state transition.
+type GateEventType int
+
+// List of possible gate state transitions.
+const (
+	GateEventNone GateEventType = iota
+
+	// GateEventElected is published when a leader is elected and the gate is
+	// closed.
+	GateEventElected
+
+	// GateEventDone is published when the leader finishes processing and the
+	// gate is opened.
+	GateEventDone
+
+	// GateEventCleanup is published when the gate is removed, allowing a new
+	// leader to be elected.
+	GateEventCleanup
+)
+
+// String returns the string representation of the gate event type.
+func (t GateEventType) String() string {
+	switch t {
+	case GateEventNone:
+		return "none"
+	case GateEventElected:
+		return "elected"
+	case GateEventDone:
+		return "done"
+	case GateEventCleanup:
+		return "cleanup"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseGateEventType parses the string representation of the gate event type.
+func ParseGateEventType(s string) (GateEventType, error) {
+	switch s {
+	case "elected":
+		return GateEventElected, nil
+	case "done":
+		return GateEventDone, nil
+	case "cleanup":
+		return GateEventCleanup, nil
+	default:
+		return GateEventNone, fmt.Errorf("unknown gate event type %q", s)
+	}
+}
+
+// GateEvent is a transition of the gate state for a fingerprint.
+type GateEvent struct {
+	// Fingerprint identifies the gate.
+	Fingerprint Fingerprint
+	// Type is the transition that happened.
+	Type GateEventType
+	// Token is the fencing token of the leader. It is zero when the gate was
+	// changed without fencing.
+	Token FencingToken
+}
+
+// GatekeeperStorageSubscriber is an optional interface that a GatekeeperStorage
+// can implement to notify the gate state transitions, so a waiting request can
+// be woken up as soon as the gate opens, whichever replica ran the leader.
+type GatekeeperStorageSubscriber interface {
+	// Subscribe returns a channel receiving the gate events of the fingerprint.
+	// The subscription is active when Subscribe returns, so the gate state
+	// should be checked afterwards to not miss transitions that happened
+	// before. The channel is closed when the context is done or when the
+	// subscription is lost, in which case the gate state should be checked
+	// again.
+	Subscribe(ctx context.Context, fingerprint Fingerprint) (<-chan GateEvent, error)
+}
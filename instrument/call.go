@@ -0,0 +1,106 @@
+package instrument
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+// caller runs the decorated calls according to the options.
+type caller struct {
+	options *Options
+}
+
+// newCaller creates a new caller with the options applied over the defaults.
+func newCaller(optFuncs []Option) caller {
+	options := NewOptions()
+	for _, opt := range optFuncs {
+		opt(options)
+	}
+	return caller{options: options}
+}
+
+// do calls fn, applying the timeout and retries, and records the result. When
+// retry is false a failed call is never retried. When timeout is false the
+// context informed to fn isn't limited by the configured timeout.
+func do[T any](
+	ctx context.Context,
+	c caller,
+	method string,
+	fingerprint anicetus.Fingerprint,
+	retry, timeout bool,
+	fn func(context.Context) (T, error),
+) (T, error) {
+	start := time.Now()
+
+	var result T
+	var err error
+	var attempts int
+	backoff := c.options.backoff
+	for {
+		attempts++
+		result, err = attempt(ctx, c, timeout, fn)
+		if err == nil || !retry || attempts > c.options.retries || !c.options.retryable(err) {
+			break
+		}
+
+		if c.options.logger != nil {
+			c.options.logger.Debug("retrying call",
+				slog.String("method", method),
+				slog.String("fingerprint", fingerprint.String()),
+				slog.Int("attempt", attempts),
+				slog.Duration("backoff", backoff),
+				slog.String("error", err.Error()),
+			)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+	}
+
+	call := Call{
+		Method:      method,
+		Fingerprint: fingerprint,
+		Latency:     time.Since(start),
+		Attempts:    attempts,
+		Err:         err,
+	}
+	if c.options.recorder != nil {
+		c.options.recorder.Record(call)
+	}
+	if c.options.logger != nil {
+		attrs := []slog.Attr{
+			slog.String("method", call.Method),
+			slog.String("fingerprint", call.Fingerprint.String()),
+			slog.Duration("latency", call.Latency),
+			slog.Int("attempts", call.Attempts),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+			c.options.logger.LogAttrs(ctx, slog.LevelWarn, "call failed", attrs...)
+		} else {
+			c.options.logger.LogAttrs(ctx, slog.LevelDebug, "call finished", attrs...)
+		}
+	}
+	return result, err
+}
+
+// attempt calls fn once, limited by the configured timeout if requested.
+func attempt[T any](ctx context.Context, c caller, timeout bool, fn func(context.Context) (T, error)) (T, error) {
+	if timeout && c.options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
@@ -0,0 +1,173 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+var (
+	_ anicetus.InFlightDetector  = &Detector{}
+	_ anicetus.OutcomeDetector   = &Detector{}
+	_ anicetus.SignalDetector    = &Detector{}
+	_ anicetus.DetectorInspector = &Detector{}
+)
+
+// Detector decorates a detector, recording the latency and errors of each
+// call, logging the call events, and retrying or limiting the duration of the
+// calls.
+//
+// Calls changing the detector state (CoolDown, IsThunderingHerd,
+// RequestStarted, RequestFinished and ReportOutcome) are never retried, as a
+// lost response would count the request twice.
+//
+// The optional anicetus.InFlightDetector, anicetus.OutcomeDetector,
+// anicetus.SignalDetector and anicetus.DetectorInspector interfaces are always
+// implemented, but anicetus.As only reports the ones supported by the inner
+// detector. Calling an unsupported one returns errors.ErrUnsupported.
+type Detector struct {
+	inner  anicetus.Detector
+	caller caller
+}
+
+// NewDetector creates a new decorator for the inner detector.
+func NewDetector(inner anicetus.Detector, optFuncs ...Option) *Detector {
+	return &Detector{
+		inner:  inner,
+		caller: newCaller(optFuncs),
+	}
+}
+
+// Unwrap returns the inner detector.
+func (d *Detector) Unwrap() anicetus.Detector {
+	return d.inner
+}
+
+// CoolDown starts the cooldown period of the fingerprint.
+func (d *Detector) CoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	_, err := do(ctx, d.caller, "Detector.CoolDown", fingerprint, false, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, d.inner.CoolDown(ctx, fingerprint)
+		},
+	)
+	return err
+}
+
+// IsCoolDown checks if the fingerprint is in the cooldown period.
+func (d *Detector) IsCoolDown(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	return do(ctx, d.caller, "Detector.IsCoolDown", fingerprint, true, true,
+		func(ctx context.Context) (bool, error) {
+			return d.inner.IsCoolDown(ctx, fingerprint)
+		},
+	)
+}
+
+// IsThunderingHerd checks if the fingerprint is a thundering herd.
+func (d *Detector) IsThunderingHerd(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	return do(ctx, d.caller, "Detector.IsThunderingHerd", fingerprint, false, true,
+		func(ctx context.Context) (bool, error) {
+			return d.inner.IsThunderingHerd(ctx, fingerprint)
+		},
+	)
+}
+
+// RequestStarted notifies the inner detector that the request started.
+func (d *Detector) RequestStarted(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	inFlightDetector, ok := anicetus.As[anicetus.InFlightDetector](d.inner)
+	if !ok {
+		return fmt.Errorf("failed to start tracking request: %w", errors.ErrUnsupported)
+	}
+	_, err := do(ctx, d.caller, "Detector.RequestStarted", fingerprint, false, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, inFlightDetector.RequestStarted(ctx, fingerprint)
+		},
+	)
+	return err
+}
+
+// RequestFinished notifies the inner detector that the request finished.
+func (d *Detector) RequestFinished(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	inFlightDetector, ok := anicetus.As[anicetus.InFlightDetector](d.inner)
+	if !ok {
+		return fmt.Errorf("failed to finish tracking request: %w", errors.ErrUnsupported)
+	}
+	_, err := do(ctx, d.caller, "Detector.RequestFinished", fingerprint, false, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, inFlightDetector.RequestFinished(ctx, fingerprint)
+		},
+	)
+	return err
+}
+
+// ReportOutcome informs the inner detector of the outcome of a request.
+func (d *Detector) ReportOutcome(ctx context.Context, fingerprint anicetus.Fingerprint, outcome anicetus.Outcome) error {
+	outcomeDetector, ok := anicetus.As[anicetus.OutcomeDetector](d.inner)
+	if !ok {
+		return fmt.Errorf("failed to report outcome: %w", errors.ErrUnsupported)
+	}
+	_, err := do(ctx, d.caller, "Detector.ReportOutcome", fingerprint, false, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, outcomeDetector.ReportOutcome(ctx, fingerprint, outcome)
+		},
+	)
+	return err
+}
+
+// PushSignal informs the inner detector of a load signal reported by the
+// backend.
+func (d *Detector) PushSignal(ctx context.Context, fingerprint anicetus.Fingerprint, signal anicetus.Signal) error {
+	signalDetector, ok := anicetus.As[anicetus.SignalDetector](d.inner)
+	if !ok {
+		return fmt.Errorf("failed to push signal: %w", errors.ErrUnsupported)
+	}
+	_, err := do(ctx, d.caller, "Detector.PushSignal", fingerprint, true, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, signalDetector.PushSignal(ctx, fingerprint, signal)
+		},
+	)
+	return err
+}
+
+// detectionsPage is a page of detector states with the next cursor.
+type detectionsPage struct {
+	states []anicetus.DetectorState
+	next   string
+}
+
+// ListDetections returns a page of detector states starting at the cursor.
+func (d *Detector) ListDetections(ctx context.Context, cursor string, limit int) ([]anicetus.DetectorState, string, error) {
+	inspector, ok := anicetus.As[anicetus.DetectorInspector](d.inner)
+	if !ok {
+		return nil, "", fmt.Errorf("failed to list detections: %w", errors.ErrUnsupported)
+	}
+	page, err := do(ctx, d.caller, "Detector.ListDetections", "", true, true,
+		func(ctx context.Context) (detectionsPage, error) {
+			states, next, err := inspector.ListDetections(ctx, cursor, limit)
+			return detectionsPage{states: states, next: next}, err
+		},
+	)
+	return page.states, page.next, err
+}
+
+// detection is a detector state with its existence flag.
+type detection struct {
+	state anicetus.DetectorState
+	found bool
+}
+
+// InspectDetection returns the detector state of the fingerprint.
+func (d *Detector) InspectDetection(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.DetectorState, bool, error) {
+	inspector, ok := anicetus.As[anicetus.DetectorInspector](d.inner)
+	if !ok {
+		return anicetus.DetectorState{}, false, fmt.Errorf("failed to inspect detection: %w", errors.ErrUnsupported)
+	}
+	result, err := do(ctx, d.caller, "Detector.InspectDetection", fingerprint, true, true,
+		func(ctx context.Context) (detection, error) {
+			state, found, err := inspector.InspectDetection(ctx, fingerprint)
+			return detection{state: state, found: found}, err
+		},
+	)
+	return result.state, result.found, err
+}
@@ -0,0 +1,129 @@
+package instrument_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/detector"
+	"github.com/rafaeljusto/anicetus/v2/detector/detectortest"
+	"github.com/rafaeljusto/anicetus/v2/instrument"
+)
+
+func TestDetector_metrics(t *testing.T) {
+	metrics := instrument.NewMetrics()
+	detector := instrument.NewDetector(detector.NewTokenBucketInMemory(
+		detector.TokenBucketWithBurst(1),
+		detector.TokenBucketWithRate(detector.Rate{Events: 1, Period: time.Hour}),
+	), instrument.WithRecorder(metrics))
+
+	for range 3 {
+		if _, err := detector.IsThunderingHerd(t.Context(), "test"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := detector.CoolDown(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state, ok, err := detector.InspectDetection(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok || state.CoolDownRemaining <= 0 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	stats := metrics.Stats()
+	if s := stats["Detector.IsThunderingHerd"]; s.Calls != 3 || s.Errors != 0 || s.Latency.Count != 3 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	if s := stats["Detector.CoolDown"]; s.Calls != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	if s := stats["Detector.InspectDetection"]; s.Calls != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestDetector_notRetryable(t *testing.T) {
+	inner := &failingDetector{}
+	metrics := instrument.NewMetrics()
+	detector := instrument.NewDetector(inner,
+		instrument.WithRecorder(metrics),
+		instrument.WithRetry(3, time.Millisecond),
+	)
+
+	if _, err := detector.IsThunderingHerd(t.Context(), "test"); !errors.Is(err, errFlaky) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := detector.CoolDown(t.Context(), "test"); !errors.Is(err, errFlaky) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := detector.ReportOutcome(t.Context(), "test", anicetus.Outcome{}); !errors.Is(err, errFlaky) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := detector.IsCoolDown(t.Context(), "test"); !errors.Is(err, errFlaky) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the state changes are called once, and only the cooldown check is retried
+	if inner.calls != 3+4 {
+		t.Errorf("unexpected calls to the inner detector %d", inner.calls)
+	}
+	if s := metrics.Stats()["Detector.IsThunderingHerd"]; s.Calls != 1 || s.Errors != 1 || s.Retries != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestDetector_unsupported(t *testing.T) {
+	detector := instrument.NewDetector(basicDetector{detector.NewTokenBucketInMemory()})
+
+	if _, _, err := detector.ListDetections(t.Context(), "", 10); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err := detector.InspectDetection(t.Context(), "test"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := detector.PushSignal(t.Context(), "test", anicetus.Signal{Active: true}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDetector_conformance(t *testing.T) {
+	detectortest.RunTokenBucket(t, func(_ *testing.T, options ...detector.TokenBucketOption) anicetus.Detector {
+		return instrument.NewDetector(detector.NewTokenBucketInMemory(options...),
+			instrument.WithRecorder(instrument.NewMetrics()),
+			instrument.WithRetry(1, time.Millisecond),
+		)
+	})
+}
+
+// basicDetector hides the optional interfaces of the inner detector.
+type basicDetector struct {
+	anicetus.Detector
+}
+
+// failingDetector fails all calls, counting them.
+type failingDetector struct {
+	calls int
+}
+
+func (d *failingDetector) CoolDown(context.Context, anicetus.Fingerprint) error {
+	d.calls++
+	return errFlaky
+}
+
+func (d *failingDetector) IsCoolDown(context.Context, anicetus.Fingerprint) (bool, error) {
+	d.calls++
+	return false, errFlaky
+}
+
+func (d *failingDetector) IsThunderingHerd(context.Context, anicetus.Fingerprint) (bool, error) {
+	d.calls++
+	return false, errFlaky
+}
+
+func (d *failingDetector) ReportOutcome(context.Context, anicetus.Fingerprint, anicetus.Outcome) error {
+	d.calls++
+	return errFlaky
+}
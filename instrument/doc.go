@@ -0,0 +1,4 @@
+// Package instrument provides decorators adding latency and error metrics,
+// structured logging, retries and timeouts to any detector or gatekeeper
+// storage.
+package instrument
@@ -0,0 +1,141 @@
+package instrument
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram buckets
+// used by default.
+var DefaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Call is the result of a decorated method call.
+type Call struct {
+	// Method identifies the called method, prefixed by the decorated
+	// interface (e.g. "Detector.IsThunderingHerd" or "GatekeeperStorage.Exists").
+	Method string
+	// Fingerprint is the fingerprint informed in the call, if any.
+	Fingerprint anicetus.Fingerprint
+	// Latency is the total duration of the call, including retries.
+	Latency time.Duration
+	// Attempts is the number of times the inner method was called.
+	Attempts int
+	// Err is the error returned by the last attempt.
+	Err error
+}
+
+// Recorder receives the result of every decorated call. It can be implemented
+// to export the metrics to a monitoring system.
+type Recorder interface {
+	Record(Call)
+}
+
+// Histogram is a latency histogram with fixed buckets.
+type Histogram struct {
+	// Buckets are the upper bounds (inclusive) of the buckets.
+	Buckets []time.Duration
+	// Counts has the number of observations of each bucket. It has one more item
+	// than Buckets, counting the observations above the last bucket.
+	Counts []uint64
+	// Count is the total number of observations.
+	Count uint64
+	// Sum is the sum of all observations.
+	Sum time.Duration
+}
+
+// observe adds the latency to the histogram.
+func (h *Histogram) observe(latency time.Duration) {
+	i, _ := slices.BinarySearch(h.Buckets, latency)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += latency
+}
+
+// MethodStats are the metrics of a decorated method.
+type MethodStats struct {
+	// Calls is the number of calls.
+	Calls uint64
+	// Errors is the number of calls that failed, after all retries.
+	Errors uint64
+	// Retries is the number of attempts made after the first one.
+	Retries uint64
+	// Latency is the histogram of the calls duration, including retries.
+	Latency Histogram
+}
+
+// Metrics is a Recorder keeping latency histograms and error counts per
+// method in memory.
+type Metrics struct {
+	buckets []time.Duration
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+}
+
+// NewMetrics creates a new Metrics with the informed histogram bucket upper
+// bounds. If no bucket is informed DefaultLatencyBuckets is used.
+func NewMetrics(buckets ...time.Duration) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	return &Metrics{
+		buckets: buckets,
+		methods: make(map[string]*MethodStats),
+	}
+}
+
+// Record adds the call result to the metrics of the method.
+func (m *Metrics) Record(call Call) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.methods[call.Method]
+	if !ok {
+		stats = &MethodStats{
+			Latency: Histogram{
+				Buckets: m.buckets,
+				Counts:  make([]uint64, len(m.buckets)+1),
+			},
+		}
+		m.methods[call.Method] = stats
+	}
+
+	stats.Calls++
+	if call.Err != nil {
+		stats.Errors++
+	}
+	if call.Attempts > 1 {
+		stats.Retries += uint64(call.Attempts - 1)
+	}
+	stats.Latency.observe(call.Latency)
+}
+
+// Stats returns a copy of the metrics of each called method.
+func (m *Metrics) Stats() map[string]MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[string]MethodStats, len(m.methods))
+	for method, methodStats := range m.methods {
+		s := *methodStats
+		s.Latency.Counts = slices.Clone(methodStats.Latency.Counts)
+		stats[method] = s
+	}
+	return stats
+}
@@ -0,0 +1,42 @@
+package instrument_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2/instrument"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := instrument.NewMetrics(10*time.Millisecond, time.Millisecond)
+
+	metrics.Record(instrument.Call{Method: "m", Latency: time.Millisecond, Attempts: 1})
+	metrics.Record(instrument.Call{Method: "m", Latency: 5 * time.Millisecond, Attempts: 3, Err: errors.New("failed")})
+	metrics.Record(instrument.Call{Method: "m", Latency: time.Second, Attempts: 1})
+
+	stats := metrics.Stats()
+	s, ok := stats["m"]
+	if !ok {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if s.Calls != 3 || s.Errors != 1 || s.Retries != 2 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	if want := []time.Duration{time.Millisecond, 10 * time.Millisecond}; !slices.Equal(s.Latency.Buckets, want) {
+		t.Errorf("unexpected buckets: got %v, want %v", s.Latency.Buckets, want)
+	}
+	if want := []uint64{1, 1, 1}; !slices.Equal(s.Latency.Counts, want) {
+		t.Errorf("unexpected counts: got %v, want %v", s.Latency.Counts, want)
+	}
+	if want := time.Second + 6*time.Millisecond; s.Latency.Count != 3 || s.Latency.Sum != want {
+		t.Errorf("unexpected histogram: %+v", s.Latency)
+	}
+
+	// the returned stats must not change with new records
+	metrics.Record(instrument.Call{Method: "m", Latency: time.Millisecond, Attempts: 1})
+	if s.Latency.Counts[0] != 1 {
+		t.Error("stats should be a copy")
+	}
+}
@@ -0,0 +1,114 @@
+package instrument
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+// Options provides all the available options.
+type Options struct {
+	// logger to be used for the call events.
+	logger *slog.Logger
+	// recorder receives the result of every call.
+	recorder Recorder
+	// timeout is the maximum duration of each attempt.
+	timeout time.Duration
+	// retries is the number of times a failed call is retried.
+	retries int
+	// backoff is the wait before the first retry, doubled on each retry.
+	backoff time.Duration
+	// retryable decides if a failed call can be retried.
+	retryable func(error) bool
+}
+
+// NewOptions creates a new Options with default values.
+func NewOptions() *Options {
+	return &Options{
+		retryable: DefaultRetryable,
+	}
+}
+
+// Logger returns the logger to be used for the call events.
+func (o *Options) Logger() *slog.Logger {
+	return o.logger
+}
+
+// Recorder returns the recorder receiving the result of every call.
+func (o *Options) Recorder() Recorder {
+	return o.recorder
+}
+
+// Timeout returns the maximum duration of each attempt. Zero means no timeout.
+func (o *Options) Timeout() time.Duration {
+	return o.timeout
+}
+
+// Retries returns the number of times a failed call is retried.
+func (o *Options) Retries() int {
+	return o.retries
+}
+
+// Backoff returns the wait before the first retry.
+func (o *Options) Backoff() time.Duration {
+	return o.backoff
+}
+
+// Retryable returns the function deciding if a failed call can be retried.
+func (o *Options) Retryable() func(error) bool {
+	return o.retryable
+}
+
+// Option is a helper function to configure the decorators.
+type Option func(*Options)
+
+// WithLogger sets the logger to be used for the call events. Successful calls
+// are logged with debug level, and failed calls with warning level.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) {
+		o.logger = logger
+	}
+}
+
+// WithRecorder sets the recorder receiving the result of every call, like
+// Metrics.
+func WithRecorder(recorder Recorder) Option {
+	return func(o *Options) {
+		o.recorder = recorder
+	}
+}
+
+// WithTimeout sets the maximum duration of each attempt. By default, the
+// attempts are only limited by the caller's context.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetry retries a failed call up to the number of retries, waiting the
+// backoff before the first retry and doubling it on each following one. By
+// default, failed calls are not retried.
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(o *Options) {
+		o.retries = retries
+		o.backoff = backoff
+	}
+}
+
+// WithRetryable sets the function deciding if a failed call can be retried. By
+// default DefaultRetryable is used.
+func WithRetryable(retryable func(error) bool) Option {
+	return func(o *Options) {
+		o.retryable = retryable
+	}
+}
+
+// DefaultRetryable considers all errors transient, except for stale fencing
+// tokens and unsupported operations, as retrying them would fail again.
+func DefaultRetryable(err error) bool {
+	var staleTokenErr *anicetus.StaleTokenError
+	return !errors.As(err, &staleTokenErr) && !errors.Is(err, errors.ErrUnsupported)
+}
@@ -0,0 +1,208 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+var (
+	_ anicetus.FencedGatekeeperStorage        = &GatekeeperStorage{}
+	_ anicetus.GatekeeperStorageInspector     = &GatekeeperStorage{}
+	_ anicetus.GatekeeperStorageSubscriber    = &GatekeeperStorage{}
+	_ anicetus.GatekeeperStorageWaiterCounter = &GatekeeperStorage{}
+)
+
+// GatekeeperStorage decorates a gatekeeper storage, recording the latency and
+// errors of each call, logging the call events, and retrying or limiting the
+// duration of the calls.
+//
+// The optional anicetus.FencedGatekeeperStorage,
+// anicetus.GatekeeperStorageInspector, anicetus.GatekeeperStorageSubscriber and
+// anicetus.GatekeeperStorageWaiterCounter interfaces are always implemented, but
+// anicetus.As only reports the ones supported by the inner storage. Calling an
+// unsupported one returns errors.ErrUnsupported.
+//
+// Acquire is never retried, as a lost response would leave the gate closed
+// without a leader to open it. CountWaiter is never retried either, as a lost
+// response would count the waiter twice.
+type GatekeeperStorage struct {
+	inner  anicetus.GatekeeperStorage
+	caller caller
+}
+
+// NewGatekeeperStorage creates a new decorator for the inner storage.
+func NewGatekeeperStorage(inner anicetus.GatekeeperStorage, optFuncs ...Option) *GatekeeperStorage {
+	return &GatekeeperStorage{
+		inner:  inner,
+		caller: newCaller(optFuncs),
+	}
+}
+
+// Unwrap returns the inner storage.
+func (s *GatekeeperStorage) Unwrap() anicetus.GatekeeperStorage {
+	return s.inner
+}
+
+// Exists checks if the fingerprint exists in the storage.
+func (s *GatekeeperStorage) Exists(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	return do(ctx, s.caller, "GatekeeperStorage.Exists", fingerprint, true, true,
+		func(ctx context.Context) (bool, error) {
+			return s.inner.Exists(ctx, fingerprint)
+		},
+	)
+}
+
+// Processed checks if the fingerprint was processed.
+func (s *GatekeeperStorage) Processed(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	return do(ctx, s.caller, "GatekeeperStorage.Processed", fingerprint, true, true,
+		func(ctx context.Context) (bool, error) {
+			return s.inner.Processed(ctx, fingerprint)
+		},
+	)
+}
+
+// CountWaiter increments the number of waiters of a gate not processed yet.
+func (s *GatekeeperStorage) CountWaiter(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	waiterCounter, ok := anicetus.As[anicetus.GatekeeperStorageWaiterCounter](s.inner)
+	if !ok {
+		return fmt.Errorf("failed to count waiter: %w", errors.ErrUnsupported)
+	}
+	_, err := do(ctx, s.caller, "GatekeeperStorage.CountWaiter", fingerprint, false, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, waiterCounter.CountWaiter(ctx, fingerprint)
+		},
+	)
+	return err
+}
+
+// Store stores the fingerprint in the storage.
+func (s *GatekeeperStorage) Store(ctx context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
+	_, err := do(ctx, s.caller, "GatekeeperStorage.Store", fingerprint, true, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, s.inner.Store(ctx, fingerprint, processed)
+		},
+	)
+	return err
+}
+
+// Remove removes the fingerprint from the storage.
+func (s *GatekeeperStorage) Remove(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	_, err := do(ctx, s.caller, "GatekeeperStorage.Remove", fingerprint, true, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, s.inner.Remove(ctx, fingerprint)
+		},
+	)
+	return err
+}
+
+// acquisition is the result of acquiring a gate.
+type acquisition struct {
+	token    anicetus.FencingToken
+	acquired bool
+}
+
+// Acquire stores the fingerprint as not processed if it doesn't exist yet,
+// returning a new fencing token.
+func (s *GatekeeperStorage) Acquire(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.FencingToken, bool, error) {
+	fencedStorage, ok := anicetus.As[anicetus.FencedGatekeeperStorage](s.inner)
+	if !ok {
+		return 0, false, fmt.Errorf("failed to acquire fingerprint: %w", errors.ErrUnsupported)
+	}
+	result, err := do(ctx, s.caller, "GatekeeperStorage.Acquire", fingerprint, false, true,
+		func(ctx context.Context) (acquisition, error) {
+			token, acquired, err := fencedStorage.Acquire(ctx, fingerprint)
+			return acquisition{token: token, acquired: acquired}, err
+		},
+	)
+	return result.token, result.acquired, err
+}
+
+// StoreWithToken stores the fingerprint in the storage only if the token
+// matches the current gate.
+func (s *GatekeeperStorage) StoreWithToken(ctx context.Context, fingerprint anicetus.Fingerprint, processed bool, token anicetus.FencingToken) error {
+	fencedStorage, ok := anicetus.As[anicetus.FencedGatekeeperStorage](s.inner)
+	if !ok {
+		return fmt.Errorf("failed to store fingerprint with token: %w", errors.ErrUnsupported)
+	}
+	_, err := do(ctx, s.caller, "GatekeeperStorage.StoreWithToken", fingerprint, true, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, fencedStorage.StoreWithToken(ctx, fingerprint, processed, token)
+		},
+	)
+	return err
+}
+
+// RemoveWithToken removes the fingerprint from the storage only if the token
+// matches the current gate.
+func (s *GatekeeperStorage) RemoveWithToken(ctx context.Context, fingerprint anicetus.Fingerprint, token anicetus.FencingToken) error {
+	fencedStorage, ok := anicetus.As[anicetus.FencedGatekeeperStorage](s.inner)
+	if !ok {
+		return fmt.Errorf("failed to remove fingerprint with token: %w", errors.ErrUnsupported)
+	}
+	_, err := do(ctx, s.caller, "GatekeeperStorage.RemoveWithToken", fingerprint, true, true,
+		func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, fencedStorage.RemoveWithToken(ctx, fingerprint, token)
+		},
+	)
+	return err
+}
+
+// gatesPage is a page of gates with the next cursor.
+type gatesPage struct {
+	gates []anicetus.GateState
+	next  string
+}
+
+// ListGates returns a page of gates starting at the cursor.
+func (s *GatekeeperStorage) ListGates(ctx context.Context, cursor string, limit int) ([]anicetus.GateState, string, error) {
+	inspector, ok := anicetus.As[anicetus.GatekeeperStorageInspector](s.inner)
+	if !ok {
+		return nil, "", fmt.Errorf("failed to list gates: %w", errors.ErrUnsupported)
+	}
+	page, err := do(ctx, s.caller, "GatekeeperStorage.ListGates", "", true, true,
+		func(ctx context.Context) (gatesPage, error) {
+			gates, next, err := inspector.ListGates(ctx, cursor, limit)
+			return gatesPage{gates: gates, next: next}, err
+		},
+	)
+	return page.gates, page.next, err
+}
+
+// gate is a gate state with its existence flag.
+type gate struct {
+	state anicetus.GateState
+	found bool
+}
+
+// InspectGate returns the gate state of the fingerprint.
+func (s *GatekeeperStorage) InspectGate(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.GateState, bool, error) {
+	inspector, ok := anicetus.As[anicetus.GatekeeperStorageInspector](s.inner)
+	if !ok {
+		return anicetus.GateState{}, false, fmt.Errorf("failed to inspect gate: %w", errors.ErrUnsupported)
+	}
+	result, err := do(ctx, s.caller, "GatekeeperStorage.InspectGate", fingerprint, true, true,
+		func(ctx context.Context) (gate, error) {
+			state, found, err := inspector.InspectGate(ctx, fingerprint)
+			return gate{state: state, found: found}, err
+		},
+	)
+	return result.state, result.found, err
+}
+
+// Subscribe returns a channel receiving the gate events of the fingerprint.
+// The timeout isn't applied, as the context controls the subscription
+// lifetime.
+func (s *GatekeeperStorage) Subscribe(ctx context.Context, fingerprint anicetus.Fingerprint) (<-chan anicetus.GateEvent, error) {
+	subscriber, ok := anicetus.As[anicetus.GatekeeperStorageSubscriber](s.inner)
+	if !ok {
+		return nil, fmt.Errorf("failed to subscribe: %w", errors.ErrUnsupported)
+	}
+	return do(ctx, s.caller, "GatekeeperStorage.Subscribe", fingerprint, true, false,
+		func(ctx context.Context) (<-chan anicetus.GateEvent, error) {
+			return subscriber.Subscribe(ctx, fingerprint)
+		},
+	)
+}
@@ -0,0 +1,236 @@
+package instrument_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/instrument"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
+)
+
+func TestGatekeeperStorage_retry(t *testing.T) {
+	inner := &flakyStorage{
+		GatekeeperStorage: storage.NewInMemory(),
+		failures:          2,
+	}
+	metrics := instrument.NewMetrics()
+	storage := instrument.NewGatekeeperStorage(inner,
+		instrument.WithRecorder(metrics),
+		instrument.WithRetry(2, time.Millisecond),
+	)
+
+	if err := storage.Store(t.Context(), "test", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, err := storage.Processed(t.Context(), "test"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	inner.failures = 3
+	if err := storage.Remove(t.Context(), "test"); !errors.Is(err, errFlaky) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	stats := metrics.Stats()
+	if s := stats["GatekeeperStorage.Store"]; s.Calls != 1 || s.Errors != 0 || s.Retries != 2 {
+		t.Errorf("unexpected store stats: %+v", s)
+	}
+	if s := stats["GatekeeperStorage.Processed"]; s.Calls != 1 || s.Errors != 0 || s.Retries != 0 {
+		t.Errorf("unexpected processed stats: %+v", s)
+	}
+	if s := stats["GatekeeperStorage.Remove"]; s.Calls != 1 || s.Errors != 1 || s.Retries != 2 {
+		t.Errorf("unexpected remove stats: %+v", s)
+	}
+}
+
+func TestGatekeeperStorage_notRetryable(t *testing.T) {
+	inner := storage.NewInMemory()
+	metrics := instrument.NewMetrics()
+	storage := instrument.NewGatekeeperStorage(inner,
+		instrument.WithRecorder(metrics),
+		instrument.WithRetry(3, time.Millisecond),
+	)
+
+	token, ok, err := storage.Acquire(t.Context(), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+	if err := storage.RemoveWithToken(t.Context(), "test", token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := storage.Acquire(t.Context(), "test"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), "test", true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if s := metrics.Stats()["GatekeeperStorage.StoreWithToken"]; s.Calls != 1 || s.Errors != 1 || s.Retries != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestGatekeeperStorage_countWaiterNotRetryable(t *testing.T) {
+	inMemory := storage.NewInMemory()
+	inner := &flakyStorage{GatekeeperStorage: inMemory}
+	metrics := instrument.NewMetrics()
+	storage := instrument.NewGatekeeperStorage(inner,
+		instrument.WithRecorder(metrics),
+		instrument.WithRetry(3, time.Millisecond),
+	)
+
+	if err := storage.Store(t.Context(), "test", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inner.failures = 1
+	if err := storage.CountWaiter(t.Context(), "test"); !errors.Is(err, errFlaky) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := storage.CountWaiter(t.Context(), "test"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if s := metrics.Stats()["GatekeeperStorage.CountWaiter"]; s.Calls != 2 || s.Errors != 1 || s.Retries != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	if gate, ok, err := inMemory.InspectGate(t.Context(), "test"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok || gate.Waiters != 1 {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+}
+
+func TestGatekeeperStorage_timeout(t *testing.T) {
+	storage := instrument.NewGatekeeperStorage(slowStorage{GatekeeperStorage: storage.NewInMemory()},
+		instrument.WithTimeout(10*time.Millisecond),
+	)
+
+	if _, err := storage.Exists(t.Context(), "test"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGatekeeperStorage_logger(t *testing.T) {
+	var output bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	storage := instrument.NewGatekeeperStorage(&flakyStorage{
+		GatekeeperStorage: storage.NewInMemory(),
+		failures:          1,
+	}, instrument.WithLogger(logger))
+
+	if err := storage.Store(t.Context(), "test", false); !errors.Is(err, errFlaky) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := storage.Exists(t.Context(), "test"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected log output: %s", output.String())
+	}
+	if !strings.Contains(lines[0], "level=WARN") || !strings.Contains(lines[0], "method=GatekeeperStorage.Store") ||
+		!strings.Contains(lines[0], "fingerprint=test") || !strings.Contains(lines[0], "error=") {
+		t.Errorf("unexpected log line: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], "level=DEBUG") || !strings.Contains(lines[1], "method=GatekeeperStorage.Exists") {
+		t.Errorf("unexpected log line: %s", lines[1])
+	}
+}
+
+func TestGatekeeperStorage_unsupported(t *testing.T) {
+	storage := instrument.NewGatekeeperStorage(basicStorage{storage.NewInMemory()})
+
+	if _, _, err := storage.Acquire(t.Context(), "test"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, err := storage.InspectGate(t.Context(), "test"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := storage.Subscribe(t.Context(), "test"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := storage.CountWaiter(t.Context(), "test"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGatekeeperStorage_conformance(t *testing.T) {
+	storagetest.Run(t, func(_ *testing.T) anicetus.GatekeeperStorage {
+		return instrument.NewGatekeeperStorage(storage.NewInMemory(),
+			instrument.WithRecorder(instrument.NewMetrics()),
+			instrument.WithRetry(1, time.Millisecond),
+			instrument.WithTimeout(time.Second),
+		)
+	})
+}
+
+func TestGatekeeperStorage_conformanceUnsupported(t *testing.T) {
+	storagetest.Run(t, func(_ *testing.T) anicetus.GatekeeperStorage {
+		return instrument.NewGatekeeperStorage(basicStorage{storage.NewInMemory()})
+	})
+}
+
+var errFlaky = errors.New("flaky")
+
+// flakyStorage fails the configured number of calls before delegating to the
+// inner storage.
+type flakyStorage struct {
+	anicetus.GatekeeperStorage
+
+	failures int
+}
+
+func (s *flakyStorage) Store(ctx context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
+	if s.failures > 0 {
+		s.failures--
+		return errFlaky
+	}
+	return s.GatekeeperStorage.Store(ctx, fingerprint, processed)
+}
+
+func (s *flakyStorage) Remove(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	if s.failures > 0 {
+		s.failures--
+		return errFlaky
+	}
+	return s.GatekeeperStorage.Remove(ctx, fingerprint)
+}
+
+func (s *flakyStorage) CountWaiter(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	if s.failures > 0 {
+		s.failures--
+		return errFlaky
+	}
+	return s.GatekeeperStorage.(anicetus.GatekeeperStorageWaiterCounter).CountWaiter(ctx, fingerprint)
+}
+
+// slowStorage blocks the existence checks until the context is done.
+type slowStorage struct {
+	anicetus.GatekeeperStorage
+}
+
+func (s slowStorage) Exists(ctx context.Context, _ anicetus.Fingerprint) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+// basicStorage hides the optional interfaces of the inner storage.
+type basicStorage struct {
+	anicetus.GatekeeperStorage
+}
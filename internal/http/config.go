@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rafaeljusto/anicetus/v2/detector"
 	"github.com/rafaeljusto/anicetus/v2/fingerprint"
 )
 
@@ -23,22 +24,63 @@ type Config struct {
 		Cookies []string
 	}
 	Detector struct {
-		RequestsPerMinute int64
-		CoolDown          time.Duration
+		Type          DetectorType
+		Rate          detector.Rate
+		Burst         int64
+		CoolDown      time.Duration
+		TargetLatency time.Duration
+	}
+	Signal struct {
+		Header    string
+		Threshold float64
+		Scope     SignalScope
+		TTL       time.Duration
+	}
+	Storage struct {
+		Type        StorageType
+		Path        string
+		TTL         time.Duration
+		MaxEntries  int
+		Shards      int
+		SnapshotDir string
 	}
 	Backend struct {
 		Timeout time.Duration
 		Address *url.URL
 	}
+	Peers struct {
+		Addresses []string
+		DNS       string
+		Self      string
+		Secret    string
+		Refresh   time.Duration
+	}
 }
 
-// ParseFromEnvs parses the configuration from environment variables.
+// ParseFromEnvs parses the configuration from environment variables. When
+// ANICETUS_CONFIG_FILE is set, the variables defined in the file take
+// precedence over the environment, so the configuration can be changed without
+// restarting the process.
 func ParseFromEnvs() (*Config, error) {
 	var config Config
 	var errs error
 	var err error
 
-	if portStr := os.Getenv("ANICETUS_PORT"); portStr != "" {
+	getenv := os.Getenv
+	if configFile := os.Getenv("ANICETUS_CONFIG_FILE"); configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ANICETUS_CONFIG_FILE: %w", err)
+		}
+		getenv = func(key string) string {
+			if value, ok := values[key]; ok {
+				return value
+			}
+			return os.Getenv(key)
+		}
+	}
+
+	if portStr := getenv("ANICETUS_PORT"); portStr != "" {
 		config.Port, err = strconv.ParseInt(portStr, 10, 64)
 		if err != nil {
 			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_PORT: %w", err))
@@ -46,7 +88,7 @@ func ParseFromEnvs() (*Config, error) {
 	}
 
 	loggerLevel := slog.LevelInfo
-	if loggerLevelStr := os.Getenv("ANICETUS_LOG_LEVEL"); loggerLevelStr != "" {
+	if loggerLevelStr := getenv("ANICETUS_LOG_LEVEL"); loggerLevelStr != "" {
 		if err = loggerLevel.UnmarshalText([]byte(loggerLevelStr)); err != nil {
 			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_LOG_LEVEL: %w", err))
 		}
@@ -60,7 +102,7 @@ func ParseFromEnvs() (*Config, error) {
 		fingerprint.HTTPRequestFieldPath,
 		fingerprint.HTTPRequestFieldQuery,
 	}
-	if fingerprintFieldsStr := os.Getenv("ANICETUS_FINGERPRINT_FIELDS"); fingerprintFieldsStr != "" {
+	if fingerprintFieldsStr := getenv("ANICETUS_FINGERPRINT_FIELDS"); fingerprintFieldsStr != "" {
 		fingerprintFields = fingerprintFields[:0]
 		for _, fieldStr := range strings.Split(fingerprintFieldsStr, ",") {
 			field, err := fingerprint.ParseHTTPRequestField(fieldStr)
@@ -72,7 +114,7 @@ func ParseFromEnvs() (*Config, error) {
 	}
 	config.Fingerprint.Fields = fingerprintFields
 
-	if fingerprintHeadersStr := os.Getenv("ANICETUS_FINGERPRINT_HEADERS"); fingerprintHeadersStr != "" {
+	if fingerprintHeadersStr := getenv("ANICETUS_FINGERPRINT_HEADERS"); fingerprintHeadersStr != "" {
 		config.Fingerprint.Headers = strings.Split(fingerprintHeadersStr, ",")
 
 		var i int
@@ -86,7 +128,7 @@ func ParseFromEnvs() (*Config, error) {
 		config.Fingerprint.Headers = config.Fingerprint.Headers[:i]
 	}
 
-	if fingerprintCookiesStr := os.Getenv("ANICETUS_FINGERPRINT_COOKIES"); fingerprintCookiesStr != "" {
+	if fingerprintCookiesStr := getenv("ANICETUS_FINGERPRINT_COOKIES"); fingerprintCookiesStr != "" {
 		config.Fingerprint.Cookies = strings.Split(fingerprintCookiesStr, ",")
 
 		var i int
@@ -100,24 +142,156 @@ func ParseFromEnvs() (*Config, error) {
 		config.Fingerprint.Cookies = config.Fingerprint.Cookies[:i]
 	}
 
-	config.Detector.RequestsPerMinute = 1000
-	if requestsPerMinuteStr := os.Getenv("ANICETUS_DETECTOR_REQUESTS_PER_MINUTE"); requestsPerMinuteStr != "" {
-		config.Detector.RequestsPerMinute, err = strconv.ParseInt(requestsPerMinuteStr, 10, 64)
+	config.Detector.Rate = detector.Rate{Events: 1000, Period: time.Minute}
+	if rateStr := getenv("ANICETUS_DETECTOR_RATE"); rateStr != "" {
+		config.Detector.Rate, err = detector.ParseRate(rateStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_DETECTOR_RATE: %w", err))
+		}
+	} else if requestsPerMinuteStr := getenv("ANICETUS_DETECTOR_REQUESTS_PER_MINUTE"); requestsPerMinuteStr != "" {
+		// deprecated in favor of ANICETUS_DETECTOR_RATE
+		config.Detector.Rate.Events, err = strconv.ParseInt(requestsPerMinuteStr, 10, 64)
 		if err != nil {
 			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_DETECTOR_REQUESTS_PER_MINUTE: %w", err))
+		} else if config.Detector.Rate.Events <= 0 {
+			errs = errors.Join(errs, fmt.Errorf("ANICETUS_DETECTOR_REQUESTS_PER_MINUTE must be positive"))
+		}
+	}
+
+	// by default, the requests of a whole period can arrive at once
+	config.Detector.Burst = config.Detector.Rate.Events
+	if burstStr := getenv("ANICETUS_DETECTOR_BURST"); burstStr != "" {
+		config.Detector.Burst, err = strconv.ParseInt(burstStr, 10, 64)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_DETECTOR_BURST: %w", err))
+		}
+	}
+
+	config.Detector.Type = DetectorTypeTokenBucket
+	if detectorTypeStr := getenv("ANICETUS_DETECTOR"); detectorTypeStr != "" {
+		config.Detector.Type, err = ParseDetectorType(detectorTypeStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_DETECTOR: %w", err))
+		}
+	}
+
+	config.Detector.TargetLatency = 500 * time.Millisecond
+	if targetLatencyStr := getenv("ANICETUS_DETECTOR_TARGET_LATENCY"); targetLatencyStr != "" {
+		config.Detector.TargetLatency, err = time.ParseDuration(targetLatencyStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_DETECTOR_TARGET_LATENCY: %w", err))
 		}
 	}
 
 	config.Detector.CoolDown = 10 * time.Minute
-	if coolDownStr := os.Getenv("ANICETUS_DETECTOR_COOLDOWN"); coolDownStr != "" {
+	if coolDownStr := getenv("ANICETUS_DETECTOR_COOLDOWN"); coolDownStr != "" {
 		config.Detector.CoolDown, err = time.ParseDuration(coolDownStr)
 		if err != nil {
 			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_DETECTOR_COOLDOWN: %w", err))
 		}
 	}
 
+	config.Signal.Header = strings.TrimSpace(getenv("ANICETUS_SIGNAL_HEADER"))
+
+	config.Signal.Threshold = 1
+	if thresholdStr := getenv("ANICETUS_SIGNAL_THRESHOLD"); thresholdStr != "" {
+		config.Signal.Threshold, err = strconv.ParseFloat(thresholdStr, 64)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_SIGNAL_THRESHOLD: %w", err))
+		}
+	}
+
+	config.Signal.Scope = SignalScopeFingerprint
+	if scopeStr := getenv("ANICETUS_SIGNAL_SCOPE"); scopeStr != "" {
+		config.Signal.Scope, err = ParseSignalScope(scopeStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_SIGNAL_SCOPE: %w", err))
+		}
+	}
+
+	config.Signal.TTL = 30 * time.Second
+	if ttlStr := getenv("ANICETUS_SIGNAL_TTL"); ttlStr != "" {
+		config.Signal.TTL, err = time.ParseDuration(ttlStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_SIGNAL_TTL: %w", err))
+		}
+	}
+
+	config.Storage.Type = StorageTypeMemory
+	if storageTypeStr := getenv("ANICETUS_STORAGE"); storageTypeStr != "" {
+		config.Storage.Type, err = ParseStorageType(storageTypeStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_STORAGE: %w", err))
+		}
+	}
+
+	config.Storage.Path = "anicetus.db"
+	if storagePathStr := getenv("ANICETUS_STORAGE_PATH"); storagePathStr != "" {
+		config.Storage.Path = storagePathStr
+	}
+
+	// gates of leaders that crashed before finishing are released after the TTL
+	config.Storage.TTL = 10 * time.Minute
+	if ttlStr := getenv("ANICETUS_STORAGE_TTL"); ttlStr != "" {
+		config.Storage.TTL, err = time.ParseDuration(ttlStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_STORAGE_TTL: %w", err))
+		} else if config.Storage.TTL < 0 {
+			errs = errors.Join(errs, fmt.Errorf("ANICETUS_STORAGE_TTL can't be negative"))
+		}
+	}
+
+	if maxEntriesStr := getenv("ANICETUS_STORAGE_MAX_ENTRIES"); maxEntriesStr != "" {
+		config.Storage.MaxEntries, err = strconv.Atoi(maxEntriesStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_STORAGE_MAX_ENTRIES: %w", err))
+		}
+	}
+
+	config.Storage.Shards = 1
+	if shardsStr := getenv("ANICETUS_STORAGE_SHARDS"); shardsStr != "" {
+		config.Storage.Shards, err = strconv.Atoi(shardsStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_STORAGE_SHARDS: %w", err))
+		}
+	}
+
+	config.Storage.SnapshotDir = getenv("ANICETUS_STORAGE_SNAPSHOT_DIR")
+
+	if peersStr := getenv("ANICETUS_PEERS"); peersStr != "" {
+		for _, peer := range strings.Split(peersStr, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				config.Peers.Addresses = append(config.Peers.Addresses, peer)
+			}
+		}
+	}
+	config.Peers.DNS = strings.TrimSpace(getenv("ANICETUS_PEERS_DNS"))
+	config.Peers.Self = strings.TrimSpace(getenv("ANICETUS_PEERS_SELF"))
+	config.Peers.Secret = getenv("ANICETUS_PEERS_SECRET")
+	if len(config.Peers.Addresses) > 0 || config.Peers.DNS != "" {
+		if len(config.Peers.Addresses) > 0 && config.Peers.DNS != "" {
+			errs = errors.Join(errs, fmt.Errorf("ANICETUS_PEERS and ANICETUS_PEERS_DNS can't be used together"))
+		}
+		if config.Peers.Self == "" {
+			errs = errors.Join(errs, fmt.Errorf("ANICETUS_PEERS_SELF is required with peers"))
+		}
+		if config.Peers.Secret == "" {
+			errs = errors.Join(errs, fmt.Errorf("ANICETUS_PEERS_SECRET is required with peers"))
+		}
+	}
+
+	config.Peers.Refresh = 30 * time.Second
+	if refreshStr := getenv("ANICETUS_PEERS_REFRESH"); refreshStr != "" {
+		config.Peers.Refresh, err = time.ParseDuration(refreshStr)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_PEERS_REFRESH: %w", err))
+		} else if config.Peers.Refresh <= 0 {
+			errs = errors.Join(errs, fmt.Errorf("ANICETUS_PEERS_REFRESH must be positive"))
+		}
+	}
+
 	timeout := time.Minute
-	if timeoutStr := os.Getenv("ANICETUS_BACKEND_TIMEOUT"); timeoutStr != "" {
+	if timeoutStr := getenv("ANICETUS_BACKEND_TIMEOUT"); timeoutStr != "" {
 		timeout, err = time.ParseDuration(timeoutStr)
 		if err != nil {
 			errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_BACKEND_TIMEOUT: %w", err))
@@ -125,7 +299,7 @@ func ParseFromEnvs() (*Config, error) {
 	}
 	config.Backend.Timeout = timeout
 
-	if addressStr := os.Getenv("ANICETUS_BACKEND_ADDRESS"); addressStr == "" {
+	if addressStr := getenv("ANICETUS_BACKEND_ADDRESS"); addressStr == "" {
 		errs = errors.Join(errs, fmt.Errorf("ANICETUS_BACKEND_ADDRESS is required"))
 	} else if config.Backend.Address, err = url.Parse(addressStr); err != nil {
 		errs = errors.Join(errs, fmt.Errorf("failed to parse ANICETUS_BACKEND_ADDRESS: %w", err))
@@ -136,3 +310,100 @@ func ParseFromEnvs() (*Config, error) {
 	}
 	return &config, nil
 }
+
+// readConfigFile reads the environment variables defined in a file, one
+// KEY=VALUE per line. Empty lines and lines starting with # are ignored, and
+// values can be quoted.
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid line %d: expected KEY=VALUE", i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, nil
+}
+
+// StorageType defines where the detector and gatekeeper state is kept.
+type StorageType string
+
+// List of supported storage types.
+const (
+	// StorageTypeMemory keeps the state in memory, losing it on restarts.
+	StorageTypeMemory StorageType = "memory"
+	// StorageTypeBBolt keeps the state in a local file using an embedded bbolt
+	// database.
+	StorageTypeBBolt StorageType = "bbolt"
+)
+
+// ParseStorageType parses a string into a StorageType.
+func ParseStorageType(s string) (StorageType, error) {
+	switch storageType := StorageType(strings.ToLower(strings.TrimSpace(s))); storageType {
+	case StorageTypeMemory, StorageTypeBBolt:
+		return storageType, nil
+	default:
+		return "", fmt.Errorf("unknown storage type: %s", s)
+	}
+}
+
+// DetectorType defines the strategy used to detect a thundering herd.
+type DetectorType string
+
+// List of supported detector types.
+const (
+	// DetectorTypeTokenBucket detects a thundering herd when the rate and burst
+	// are exceeded.
+	DetectorTypeTokenBucket DetectorType = "token-bucket"
+	// DetectorTypeLatency detects a thundering herd when the requests in the rate
+	// period exceed the rate, reducing the limit when the backend is slower than
+	// the target latency. Its state is always kept in memory.
+	DetectorTypeLatency DetectorType = "latency"
+)
+
+// ParseDetectorType parses a string into a DetectorType.
+func ParseDetectorType(s string) (DetectorType, error) {
+	switch detectorType := DetectorType(strings.ToLower(strings.TrimSpace(s))); detectorType {
+	case DetectorTypeTokenBucket, DetectorTypeLatency:
+		return detectorType, nil
+	default:
+		return "", fmt.Errorf("unknown detector type: %s", s)
+	}
+}
+
+// SignalScope defines what is affected by a load signal reported by the
+// backend.
+type SignalScope string
+
+// List of supported signal scopes.
+const (
+	// SignalScopeFingerprint applies the signal only to the fingerprint of the
+	// request that received it.
+	SignalScopeFingerprint SignalScope = "fingerprint"
+	// SignalScopeBackend applies the signal to all fingerprints.
+	SignalScopeBackend SignalScope = "backend"
+)
+
+// ParseSignalScope parses a string into a SignalScope.
+func ParseSignalScope(s string) (SignalScope, error) {
+	switch signalScope := SignalScope(strings.ToLower(strings.TrimSpace(s))); signalScope {
+	case SignalScopeFingerprint, SignalScopeBackend:
+		return signalScope, nil
+	default:
+		return "", fmt.Errorf("unknown signal scope: %s", s)
+	}
+}
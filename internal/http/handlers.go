@@ -1,8 +1,14 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/rafaeljusto/anicetus/v2"
 	"github.com/rafaeljusto/anicetus/v2/fingerprint"
@@ -20,6 +26,10 @@ func anicetusHandler(config *Config, resources *Resources) http.HandlerFunc {
 			slog.String("path", r.URL.Path),
 		)
 
+		// the peer headers are removed from all requests, so clients can't skip
+		// the owner of the fingerprint and they never reach the backend
+		forwarded := fromPeer(r, config.Peers.Secret)
+
 		if r.Method != http.MethodGet {
 			if err := forwardRequest(w, r, config, resources); err != nil {
 				httpLogger.Error("failed to forward request",
@@ -35,7 +45,28 @@ func anicetusHandler(config *Config, resources *Resources) http.HandlerFunc {
 			fingerprint.WithHTTPRequestCookies(config.Fingerprint.Cookies...),
 		)
 
-		gatekeeperStatus, err := resources.Anicetus.Evaluate(r.Context(), fingerprint)
+		// all requests of a fingerprint are evaluated by the same replica, so
+		// it sees the whole herd and elects a single leader
+		if resources.Peers != nil && !forwarded {
+			if peer, self := resources.Peers.Owner(string(fingerprint.Fingerprint())); !self {
+				err := forwardToPeer(w, r, config, resources, peer)
+				if err == nil {
+					return
+				} else if !errors.Is(err, errPeerUnavailable) {
+					httpLogger.Error("failed to forward request to peer",
+						slog.String("peer", peer),
+						slog.String("error", err.Error()),
+					)
+					return
+				}
+				httpLogger.Warn("peer unavailable, evaluating request locally",
+					slog.String("peer", peer),
+					slog.String("error", err.Error()),
+				)
+			}
+		}
+
+		gatekeeperStatus, fencingToken, err := resources.Anicetus.Evaluate(r.Context(), fingerprint)
 		if err != nil {
 			httpLogger.Error("failed to analyze fingerprint",
 				slog.String("error", err.Error()),
@@ -43,6 +74,49 @@ func anicetusHandler(config *Config, resources *Resources) http.HandlerFunc {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		defer func() {
+			// the request is finished even if the client went away
+			if err := resources.Anicetus.RequestFinished(context.WithoutCancel(r.Context()), fingerprint); err != nil {
+				httpLogger.Error("failed to finish request",
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+
+		reportOutcome := forwardRequestWithOutcomeHandler(func(outcome anicetus.Outcome) {
+			err := resources.Anicetus.ReportOutcome(context.WithoutCancel(r.Context()), fingerprint, outcome)
+			if err != nil {
+				httpLogger.Error("failed to report outcome",
+					slog.String("error", err.Error()),
+				)
+			}
+		})
+
+		pushSignal := forwardRequestWithSignalHandler(func(header http.Header) {
+			if config.Signal.Header == "" {
+				return
+			}
+			active, err := parseSignal(header.Get(config.Signal.Header), config.Signal.Threshold)
+			// the signal is only meaningful between the backend and anicetus
+			header.Del(config.Signal.Header)
+			if err != nil {
+				httpLogger.Warn("failed to parse backend signal",
+					slog.String("header", config.Signal.Header),
+					slog.String("error", err.Error()),
+				)
+				return
+			}
+
+			signal := anicetus.Signal{
+				Active: active,
+				Global: config.Signal.Scope == SignalScopeBackend,
+			}
+			if err := resources.Anicetus.PushSignal(context.WithoutCancel(r.Context()), fingerprint, signal); err != nil {
+				httpLogger.Error("failed to push signal",
+					slog.String("error", err.Error()),
+				)
+			}
+		})
 
 		switch gatekeeperStatus {
 		case anicetus.StatusFailed:
@@ -54,8 +128,20 @@ func anicetusHandler(config *Config, resources *Resources) http.HandlerFunc {
 
 			err := forwardRequest(w, r, config, resources,
 				forwardRequestWithAnicetus(gatekeeperStatus, fingerprint.Fingerprint()),
+				reportOutcome,
+				pushSignal,
 				forwardRequestWithResponseHandler(func(*http.Response) error {
-					return resources.Anicetus.RequestDone(r.Context(), fingerprint)
+					err := resources.Anicetus.RequestDone(r.Context(), fingerprint, fencingToken)
+					if staleTokenErr := (*anicetus.StaleTokenError)(nil); errors.As(err, &staleTokenErr) {
+						// a newer leader owns the gate now, so the response can still be
+						// delivered without touching the gate
+						httpLogger.Warn("stale leader finished processing",
+							slog.String("fingerprint", string(fingerprint.Fingerprint())),
+							slog.String("error", err.Error()),
+						)
+						return nil
+					}
+					return err
 				}),
 			)
 			if err != nil {
@@ -64,7 +150,7 @@ func anicetusHandler(config *Config, resources *Resources) http.HandlerFunc {
 				)
 				w.WriteHeader(http.StatusInternalServerError)
 
-				if err := resources.Anicetus.Cleanup(r.Context(), fingerprint); err != nil {
+				if err := resources.Anicetus.Cleanup(r.Context(), fingerprint, fencingToken); err != nil {
 					httpLogger.Error("failed to remove fingerprint",
 						slog.String("error", err.Error()),
 					)
@@ -78,6 +164,8 @@ func anicetusHandler(config *Config, resources *Resources) http.HandlerFunc {
 		case anicetus.StatusOpenGates:
 			err := forwardRequest(w, r, config, resources,
 				forwardRequestWithAnicetus(gatekeeperStatus, fingerprint.Fingerprint()),
+				reportOutcome,
+				pushSignal,
 			)
 			if err != nil {
 				httpLogger.Error("failed to forward request",
@@ -89,6 +177,27 @@ func anicetusHandler(config *Config, resources *Resources) http.HandlerFunc {
 	}
 }
 
+// parseSignal checks if the backend load signal is active. The header value can
+// be a load factor, active when reaching the threshold, or a boolean flag. A
+// missing header means that the signal isn't active.
+func parseSignal(value string, threshold float64) (bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false, nil
+	}
+	if load, err := strconv.ParseFloat(value, 64); err == nil {
+		if load < 0 || math.IsNaN(load) || math.IsInf(load, 0) {
+			return false, fmt.Errorf("invalid signal %q: the load factor must be a non-negative number", value)
+		}
+		return load >= threshold, nil
+	}
+	active, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid signal %q: expected a load factor or a boolean", value)
+	}
+	return active, nil
+}
+
 func loggerWrapper(logger *slog.Logger, handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("request received",
@@ -0,0 +1,249 @@
+package http_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	anicetushttp "github.com/rafaeljusto/anicetus/v2/internal/http"
+)
+
+func TestRegisterHandlers_signal(t *testing.T) {
+	backend := newScriptedBackend(t)
+
+	tests := []struct {
+		name        string
+		scope       anicetushttp.SignalScope
+		signal      *string
+		wantSignals []anicetus.Signal
+	}{{
+		name:        "it should push an active signal reaching the threshold",
+		signal:      ptr("0.8"),
+		wantSignals: []anicetus.Signal{{Active: true}},
+	}, {
+		name:        "it should push an inactive signal below the threshold",
+		signal:      ptr(" 0.5 "),
+		wantSignals: []anicetus.Signal{{}},
+	}, {
+		name:        "it should push an active boolean signal",
+		signal:      ptr("true"),
+		wantSignals: []anicetus.Signal{{Active: true}},
+	}, {
+		name:        "it should push an inactive boolean signal",
+		signal:      ptr("false"),
+		wantSignals: []anicetus.Signal{{}},
+	}, {
+		name:        "it should push a global signal with the backend scope",
+		scope:       anicetushttp.SignalScopeBackend,
+		signal:      ptr("1"),
+		wantSignals: []anicetus.Signal{{Active: true, Global: true}},
+	}, {
+		name:        "it should clear the signal when the header is missing",
+		wantSignals: []anicetus.Signal{{}},
+	}, {
+		name:        "it should clear the signal when the header is empty",
+		signal:      ptr(""),
+		wantSignals: []anicetus.Signal{{}},
+	}, {
+		name:   "it should ignore a malformed signal",
+		signal: ptr("overloaded"),
+	}, {
+		name:   "it should ignore a negative load factor",
+		signal: ptr("-1"),
+	}, {
+		name:   "it should ignore an out of range load factor",
+		signal: ptr("1e400"),
+	}, {
+		name:   "it should ignore a load factor that is not a number",
+		signal: ptr("NaN"),
+	}, {
+		name:   "it should ignore an infinite load factor",
+		signal: ptr("+Inf"),
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newConfig(backend.URL)
+			config.Signal.Header = "Backend-Load"
+			config.Signal.Threshold = 0.8
+			config.Signal.Scope = tt.scope
+
+			detector := new(recordingDetector)
+			proxy := httptest.NewServer(newHandler(config, newResourcesWithDetector(detector, nil)))
+			t.Cleanup(proxy.Close)
+
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, proxy.URL+"/test", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.signal != nil {
+				req.Header.Set("Test-Signal", *tt.signal)
+			}
+
+			response := doRequest(t, req)
+			if response.StatusCode != http.StatusOK {
+				t.Errorf("unexpected status code %d", response.StatusCode)
+			}
+			if _, ok := response.Header["Backend-Load"]; ok {
+				t.Error("signal header should not be sent to the client")
+			}
+			if signals := detector.Signals(); !slices.Equal(signals, tt.wantSignals) {
+				t.Errorf("unexpected signals: got %v, want %v", signals, tt.wantSignals)
+			}
+		})
+	}
+}
+
+func TestRegisterHandlers_outcome(t *testing.T) {
+	backend := newScriptedBackend(t)
+
+	// a port nobody listens to
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unavailable := "http://" + listener.Addr().String()
+	if err := listener.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		backendURL   string
+		status       int
+		expectStatus int
+		expectFailed bool
+	}{{
+		name:         "it should report a successful request",
+		backendURL:   backend.URL,
+		status:       http.StatusOK,
+		expectStatus: http.StatusOK,
+	}, {
+		name:         "it should report a client error as successful",
+		backendURL:   backend.URL,
+		status:       http.StatusNotFound,
+		expectStatus: http.StatusNotFound,
+	}, {
+		name:         "it should report a server error as failed",
+		backendURL:   backend.URL,
+		status:       http.StatusServiceUnavailable,
+		expectStatus: http.StatusServiceUnavailable,
+		expectFailed: true,
+	}, {
+		name:         "it should report a transport error as failed",
+		backendURL:   unavailable,
+		expectStatus: http.StatusInternalServerError,
+		expectFailed: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := new(recordingDetector)
+			proxy := httptest.NewServer(newHandler(newConfig(tt.backendURL), newResourcesWithDetector(detector, nil)))
+			t.Cleanup(proxy.Close)
+
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, proxy.URL+"/test", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.status != 0 {
+				req.Header.Set("Test-Status", strconv.Itoa(tt.status))
+			}
+
+			response := doRequest(t, req)
+			if response.StatusCode != tt.expectStatus {
+				t.Errorf("unexpected status code %d", response.StatusCode)
+			}
+
+			outcomes := detector.Outcomes()
+			if len(outcomes) != 1 {
+				t.Fatalf("unexpected outcomes: %v", outcomes)
+			}
+			if outcomes[0].Failed != tt.expectFailed {
+				t.Errorf("unexpected failed outcome: got %v, want %v", outcomes[0].Failed, tt.expectFailed)
+			}
+			if outcomes[0].Latency <= 0 {
+				t.Errorf("unexpected latency %s", outcomes[0].Latency)
+			}
+		})
+	}
+}
+
+// newScriptedBackend creates a backend answering with the status code
+// (Test-Status) and load signal (Test-Signal) informed in the request headers.
+func newScriptedBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if signal, ok := r.Header["Test-Signal"]; ok {
+			w.Header()["Backend-Load"] = signal
+		}
+		status := http.StatusOK
+		if statusStr := r.Header.Get("Test-Status"); statusStr != "" {
+			status, _ = strconv.Atoi(statusStr)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// recordingDetector never detects a thundering herd, recording the signals and
+// outcomes reported by the proxy.
+type recordingDetector struct {
+	mutex    sync.Mutex
+	signals  []anicetus.Signal
+	outcomes []anicetus.Outcome
+}
+
+func (d *recordingDetector) CoolDown(context.Context, anicetus.Fingerprint) error {
+	return nil
+}
+
+func (d *recordingDetector) IsCoolDown(context.Context, anicetus.Fingerprint) (bool, error) {
+	return false, nil
+}
+
+func (d *recordingDetector) IsThunderingHerd(context.Context, anicetus.Fingerprint) (bool, error) {
+	return false, nil
+}
+
+func (d *recordingDetector) PushSignal(_ context.Context, _ anicetus.Fingerprint, signal anicetus.Signal) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.signals = append(d.signals, signal)
+	return nil
+}
+
+func (d *recordingDetector) ReportOutcome(_ context.Context, _ anicetus.Fingerprint, outcome anicetus.Outcome) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.outcomes = append(d.outcomes, outcome)
+	return nil
+}
+
+func (d *recordingDetector) Signals() []anicetus.Signal {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return slices.Clone(d.signals)
+}
+
+func (d *recordingDetector) Outcomes() []anicetus.Outcome {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return slices.Clone(d.outcomes)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
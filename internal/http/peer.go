@@ -0,0 +1,105 @@
+package http
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	// peerHeader identifies the requests forwarded by another replica, which are
+	// always evaluated locally to avoid loops while the replicas don't agree on
+	// the peers.
+	peerHeader = "Anicetus-Peer"
+	// peerSecretHeader carries the secret shared by the replicas, so only
+	// requests forwarded by them are trusted.
+	peerSecretHeader = "Anicetus-Peer-Secret"
+	// peerProtoHeader is the protocol of the original request, as it can be part
+	// of the fingerprint.
+	peerProtoHeader = "Anicetus-Peer-Proto"
+)
+
+// errPeerUnavailable is returned when the request couldn't be forwarded to the
+// peer and nothing was written to the caller, so the request can still be
+// evaluated locally.
+var errPeerUnavailable = errors.New("peer unavailable")
+
+// forwardToPeer forwards the request to the replica owning its fingerprint and
+// writes the response back to the caller. The body is buffered, so the request
+// is intact to be evaluated locally when the peer is unavailable.
+func forwardToPeer(w http.ResponseWriter, r *http.Request, config *Config, resources *Resources, peer string) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	target := *r.URL
+	target.Scheme = "http"
+	target.Host = peer
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: failed to create peer request: %w", errPeerUnavailable, err)
+	}
+
+	req.Header = r.Header.Clone()
+	req.Header.Add("X-Forwarded-For", r.RemoteAddr)
+	req.Header.Set(peerHeader, resources.Peers.Self())
+	req.Header.Set(peerSecretHeader, config.Peers.Secret)
+	req.Header.Set(peerProtoHeader, r.Proto)
+	req.Host = r.Host
+
+	response, err := resources.PeerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: failed to execute peer request: %w", errPeerUnavailable, err)
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			resources.Logger.Error("failed to close peer response body",
+				slog.String("error", err.Error()),
+			)
+		}
+	}()
+
+	for key, values := range response.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	w.WriteHeader(response.StatusCode)
+	if _, err := io.Copy(w, response.Body); err != nil {
+		return fmt.Errorf("failed to copy peer response body: %w", err)
+	}
+
+	return nil
+}
+
+// fromPeer checks if the request was forwarded by another replica, which must
+// present the shared secret. The peer headers are always removed, and the
+// protocol of the original request is restored, so the fingerprint is the same
+// computed by the peer.
+func fromPeer(r *http.Request, secret string) bool {
+	peer := r.Header.Get(peerHeader)
+	peerSecret := r.Header.Get(peerSecretHeader)
+	proto := r.Header.Get(peerProtoHeader)
+	r.Header.Del(peerHeader)
+	r.Header.Del(peerSecretHeader)
+	r.Header.Del(peerProtoHeader)
+
+	if peer == "" || secret == "" || subtle.ConstantTimeCompare([]byte(peerSecret), []byte(secret)) != 1 {
+		return false
+	}
+	if proto != "" {
+		if major, minor, ok := http.ParseHTTPVersion(proto); ok {
+			r.Proto, r.ProtoMajor, r.ProtoMinor = proto, major, minor
+		}
+	}
+	return true
+}
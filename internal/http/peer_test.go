@@ -0,0 +1,449 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/fingerprint"
+	anicetushttp "github.com/rafaeljusto/anicetus/v2/internal/http"
+	"github.com/rafaeljusto/anicetus/v2/internal/peers"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+)
+
+const peersSecret = "secret"
+
+func TestRegisterHandlers_fromPeer(t *testing.T) {
+	backend := newBackend(t, nil)
+
+	// the owner of the fingerprint must not receive the request again
+	var loops atomic.Int64
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		loops.Add(1)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	t.Cleanup(owner.Close)
+
+	self := "127.0.0.1:1"
+	config := newConfig(backend.URL)
+	p := peers.NewStatic(self, []string{owner.Listener.Addr().String()})
+	proxy := httptest.NewServer(newHandler(config, newResources(p)))
+	t.Cleanup(proxy.Close)
+
+	path := ownedPath(t, config, p, owner.Listener.Addr().String())
+
+	tests := []struct {
+		name         string
+		secret       string
+		expectStatus int
+		expectLoops  int64
+	}{
+		{
+			name:         "it should evaluate requests forwarded by a peer locally",
+			secret:       peersSecret,
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "it should forward requests with an invalid secret to the owner",
+			secret:       "invalid",
+			expectStatus: http.StatusTeapot,
+			expectLoops:  1,
+		},
+		{
+			name:         "it should forward requests without a secret to the owner",
+			expectStatus: http.StatusTeapot,
+			expectLoops:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loops.Store(0)
+			backend.peerHeaders.Store(0)
+
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, proxy.URL+path, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req.Header.Set("Anicetus-Peer", owner.Listener.Addr().String())
+			if tt.secret != "" {
+				req.Header.Set("Anicetus-Peer-Secret", tt.secret)
+			}
+
+			response := doRequest(t, req)
+			if response.StatusCode != tt.expectStatus {
+				t.Errorf("unexpected status code %d", response.StatusCode)
+			}
+			if n := loops.Load(); n != tt.expectLoops {
+				t.Errorf("unexpected requests to the owner: %d", n)
+			}
+			if n := backend.peerHeaders.Load(); n > 0 {
+				t.Errorf("peer headers reached the backend %d times", n)
+			}
+		})
+	}
+}
+
+func TestRegisterHandlers_peerUnavailable(t *testing.T) {
+	backend := newBackend(t, nil)
+
+	// a port nobody listens to
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unavailable := listener.Addr().String()
+	if err := listener.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := newConfig(backend.URL)
+	p := peers.NewStatic("127.0.0.1:1", []string{unavailable})
+	proxy := httptest.NewServer(newHandler(config, newResources(p)))
+	t.Cleanup(proxy.Close)
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, proxy.URL+ownedPath(t, config, p, unavailable), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	response := doRequest(t, req)
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	if n := backend.leaders.Load(); n != 1 {
+		t.Errorf("request should be evaluated locally, got %d leaders", n)
+	}
+}
+
+func TestRegisterHandlers_peerBodyConsumed(t *testing.T) {
+	backend := newBackend(t, nil)
+
+	// the peer fails after reading only part of the request
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Read(make([]byte, 4096))
+			_ = conn.Close()
+		}
+	}()
+
+	config := newConfig(backend.URL)
+	p := peers.NewStatic("127.0.0.1:1", []string{listener.Addr().String()})
+	proxy := httptest.NewServer(newHandler(config, newResources(p)))
+	t.Cleanup(proxy.Close)
+
+	body := bytes.Repeat([]byte("a"), 1<<20)
+	path := ownedPath(t, config, p, listener.Addr().String())
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, proxy.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	response := doRequest(t, req)
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	if size := response.Header.Get("Backend-Body-Size"); size != strconv.Itoa(len(body)) {
+		t.Errorf("request evaluated locally should keep the whole body, got %s bytes", size)
+	}
+}
+
+func TestRegisterHandlers_peers(t *testing.T) {
+	release := make(chan struct{})
+	backend := newBackend(t, release)
+	config := newConfig(backend.URL)
+
+	// the addresses are known before the replicas start
+	const replicas = 3
+	listeners := make([]net.Listener, replicas)
+	addresses := make([]string, replicas)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		listeners[i] = listener
+		addresses[i] = listener.Addr().String()
+	}
+
+	proxies := make([]*httptest.Server, replicas)
+	for i, listener := range listeners {
+		proxy := httptest.NewUnstartedServer(newHandler(config, newResources(peers.NewStatic(addresses[i], addresses))))
+		if err := proxy.Listener.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		proxy.Listener = listener
+		proxy.Start()
+		t.Cleanup(proxy.Close)
+		proxies[i] = proxy
+	}
+
+	for i := range replicas {
+		path := "/herd/" + strconv.Itoa(i)
+		backend.leaders.Store(0)
+
+		// the herd hits all replicas while the leader is processing
+		const requests = 30
+		var waiting atomic.Int64
+		var wg sync.WaitGroup
+		for j := range requests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, proxies[j%replicas].URL+path, nil)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				if response := doRequest(t, req); response.StatusCode == http.StatusServiceUnavailable {
+					waiting.Add(1)
+				}
+			}()
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for waiting.Load() < requests-1 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		select {
+		case release <- struct{}{}:
+		case <-time.After(5 * time.Second):
+			t.Errorf("fingerprint %q has no leader", path)
+		}
+		wg.Wait()
+
+		if n := backend.leaders.Load(); n != 1 {
+			t.Errorf("fingerprint %q should have a single leader in the cluster, got %d", path, n)
+		}
+		if n := waiting.Load(); n != requests-1 {
+			t.Errorf("fingerprint %q should have %d waiting requests, got %d", path, requests-1, n)
+		}
+	}
+}
+
+// backend counts the leaders of the thundering herds and the requests that
+// carry peer headers, which should never reach it. The body size and the
+// forwarded addresses of the requests are echoed in the response headers.
+type backend struct {
+	*httptest.Server
+
+	leaders     atomic.Int64
+	peerHeaders atomic.Int64
+}
+
+// newBackend creates a backend where each leader blocks until release receives
+// a value. A nil release answers the leaders right away.
+func newBackend(t *testing.T, release <-chan struct{}) *backend {
+	t.Helper()
+
+	b := new(backend)
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Anicetus-Peer") != "" || r.Header.Get("Anicetus-Peer-Secret") != "" {
+			b.peerHeaders.Add(1)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Backend-Body-Size", strconv.Itoa(len(body)))
+		w.Header().Set("Backend-Forwarded-For", strings.Join(r.Header.Values("X-Forwarded-For"), ","))
+		if r.Header.Get("Anicetus-Status") == anicetus.StatusProcess.String() {
+			b.leaders.Add(1)
+			if release != nil {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func newConfig(backendURL string) *anicetushttp.Config {
+	config := new(anicetushttp.Config)
+	config.Fingerprint.Fields = []fingerprint.HTTPRequestField{
+		fingerprint.HTTPRequestFieldMethod,
+		fingerprint.HTTPRequestFieldPath,
+	}
+	config.Backend.Address, _ = url.Parse(backendURL)
+	config.Backend.Timeout = 10 * time.Second
+	config.Peers.Secret = peersSecret
+	return config
+}
+
+func newResources(p *peers.Peers) *anicetushttp.Resources {
+	return newResourcesWithDetector(herdDetector{}, p)
+}
+
+func newResourcesWithDetector(detector anicetus.Detector, p *peers.Peers) *anicetushttp.Resources {
+	return &anicetushttp.Resources{
+		Logger:        slog.New(slog.DiscardHandler),
+		Anicetus:      anicetus.NewAnicetus[fingerprint.HTTPRequest](detector, storage.NewInMemory()),
+		BackendClient: &http.Client{Timeout: 10 * time.Second},
+		Peers:         p,
+		PeerClient: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func newHandler(config *anicetushttp.Config, resources *anicetushttp.Resources) http.Handler {
+	router := http.NewServeMux()
+	anicetushttp.RegisterHandlers(router, config, resources)
+	return router
+}
+
+// ownedPath returns a path whose fingerprint is owned by the peer.
+func ownedPath(t *testing.T, config *anicetushttp.Config, p *peers.Peers, peer string) string {
+	t.Helper()
+
+	for i := range 1000 {
+		path := "/users/" + strconv.Itoa(i)
+		fingerprint := fingerprint.NewHTTPRequest(httptest.NewRequest(http.MethodGet, path, nil),
+			fingerprint.WithHTTPRequestFields(config.Fingerprint.Fields...),
+		)
+		if owner, _ := p.Owner(string(fingerprint.Fingerprint())); owner == peer {
+			return path
+		}
+	}
+	t.Fatalf("no path owned by %q", peer)
+	return ""
+}
+
+func doRequest(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return &http.Response{}
+	}
+	if err := response.Body.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	return response
+}
+
+// herdDetector considers every request part of a thundering herd, so the gates
+// are never opened.
+type herdDetector struct{}
+
+func (herdDetector) CoolDown(context.Context, anicetus.Fingerprint) error {
+	return nil
+}
+
+func (herdDetector) IsCoolDown(context.Context, anicetus.Fingerprint) (bool, error) {
+	return false, nil
+}
+
+func (herdDetector) IsThunderingHerd(context.Context, anicetus.Fingerprint) (bool, error) {
+	return true, nil
+}
+
+func TestRegisterHandlers_replicas(t *testing.T) {
+	backend := newBackend(t, nil)
+
+	// two replicas configured like in production, listening on localhost
+	listeners := make([]net.Listener, 2)
+	addresses := make([]string, len(listeners))
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		listeners[i] = listener
+		addresses[i] = listener.Addr().String()
+	}
+
+	var config *anicetushttp.Config
+	for i, listener := range listeners {
+		t.Setenv("ANICETUS_LOG_LEVEL", "error")
+		t.Setenv("ANICETUS_FINGERPRINT_FIELDS", "method,path")
+		t.Setenv("ANICETUS_BACKEND_ADDRESS", backend.URL)
+		t.Setenv("ANICETUS_PEERS", strings.Join(addresses, ","))
+		t.Setenv("ANICETUS_PEERS_SELF", addresses[i])
+		t.Setenv("ANICETUS_PEERS_SECRET", peersSecret)
+
+		var err error
+		config, err = anicetushttp.ParseFromEnvs()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resources, err := anicetushttp.NewResources(config)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		server := &http.Server{Handler: newHandler(config, resources)}
+		go func() {
+			_ = server.Serve(listener)
+		}()
+		t.Cleanup(func() {
+			if err := server.Close(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if err := resources.Close(); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	// both replicas agree on the owners
+	p := peers.NewStatic(addresses[0], addresses)
+	paths := []string{ownedPath(t, config, p, addresses[0]), ownedPath(t, config, p, addresses[1])}
+
+	for i, address := range addresses {
+		for j, path := range paths {
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://"+address+path, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			response := doRequest(t, req)
+			if response.StatusCode != http.StatusOK {
+				t.Errorf("unexpected status code %d", response.StatusCode)
+			}
+
+			// a forwarded request also carries the address of the client
+			forwardedFor := strings.Split(response.Header.Get("Backend-Forwarded-For"), ",")
+			want := 1
+			if i != j {
+				want = 2
+			}
+			if len(forwardedFor) != want {
+				t.Errorf("request to replica %d of path owned by replica %d forwarded by %v", i, j, forwardedFor)
+			}
+			for _, address := range forwardedFor {
+				if host, _, err := net.SplitHostPort(address); err != nil || host != "127.0.0.1" {
+					t.Errorf("unexpected forwarded address %q", address)
+				}
+			}
+		}
+	}
+}
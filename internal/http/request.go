@@ -5,12 +5,15 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/rafaeljusto/anicetus/v2"
 )
 
 type forwardRequestOptions struct {
 	responseHandler func(*http.Response) error
+	outcomeHandler  func(anicetus.Outcome)
+	signalHandler   func(http.Header)
 	anicetus        struct {
 		status      anicetus.Status
 		fingerprint anicetus.Fingerprint
@@ -25,6 +28,23 @@ func forwardRequestWithResponseHandler(handler func(*http.Response) error) forwa
 	}
 }
 
+// forwardRequestWithOutcomeHandler informs the time the backend took to answer
+// the request, and if it failed (transport errors and 5xx status codes).
+func forwardRequestWithOutcomeHandler(handler func(anicetus.Outcome)) forwardRequestOption {
+	return func(opts *forwardRequestOptions) {
+		opts.outcomeHandler = handler
+	}
+}
+
+// forwardRequestWithSignalHandler gives access to the backend response headers
+// before they are copied to the client, so load signals can be read (and
+// removed).
+func forwardRequestWithSignalHandler(handler func(http.Header)) forwardRequestOption {
+	return func(opts *forwardRequestOptions) {
+		opts.signalHandler = handler
+	}
+}
+
 func forwardRequestWithAnicetus(
 	status anicetus.Status,
 	fingerprint anicetus.Fingerprint,
@@ -48,6 +68,8 @@ func forwardRequest(
 		responseHandler: func(*http.Response) error {
 			return nil
 		},
+		outcomeHandler: func(anicetus.Outcome) {},
+		signalHandler:  func(http.Header) {},
 	}
 	for _, optFunc := range optFuncs {
 		optFunc(opts)
@@ -69,7 +91,12 @@ func forwardRequest(
 	}
 	req.Host = r.Host
 
+	start := time.Now()
 	response, err := resources.BackendClient.Do(req)
+	opts.outcomeHandler(anicetus.Outcome{
+		Latency: time.Since(start),
+		Failed:  err != nil || response.StatusCode >= http.StatusInternalServerError,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to execute forwarded request: %w", err)
 	}
@@ -81,6 +108,8 @@ func forwardRequest(
 		}
 	}()
 
+	opts.signalHandler(response.Header)
+
 	if err := opts.responseHandler(response); err != nil {
 		return fmt.Errorf("failed to handle forwarded response: %w", err)
 	}
@@ -1,15 +1,23 @@
 package http
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/rafaeljusto/anicetus/v2"
 	"github.com/rafaeljusto/anicetus/v2/detector"
+	detectorbbolt "github.com/rafaeljusto/anicetus/v2/detector/bbolt"
 	"github.com/rafaeljusto/anicetus/v2/fingerprint"
+	"github.com/rafaeljusto/anicetus/v2/internal/peers"
 	"github.com/rafaeljusto/anicetus/v2/storage"
+	storagebbolt "github.com/rafaeljusto/anicetus/v2/storage/bbolt"
+	bolt "go.etcd.io/bbolt"
 )
 
 // Resources stores the resources for the web server.
@@ -17,27 +25,270 @@ type Resources struct {
 	Logger        *slog.Logger
 	Anicetus      *anicetus.Anicetus[fingerprint.HTTPRequest]
 	BackendClient *http.Client
+	// Peers assigns each fingerprint to a replica, being nil when the replicas
+	// don't share the state.
+	Peers *peers.Peers
+	// PeerClient forwards requests to the replica owning their fingerprints.
+	PeerClient *http.Client
+
+	// reloadDetector applies the detector options of a new configuration.
+	reloadDetector func(*Config)
+	// closers are executed in reverse order when the resources are closed.
+	closers []func() error
+	// snapshotDir is where the in-memory state is saved on shutdown.
+	snapshotDir string
+	// snapshots are the in-memory states saved on shutdown and restored on
+	// start.
+	snapshots []snapshot
+}
+
+// snapshot is an in-memory state stored in a file of the snapshot directory.
+type snapshot struct {
+	file        string
+	snapshotter interface {
+		Snapshot(io.Writer) error
+		Restore(io.Reader) error
+	}
 }
 
 // NewResources creates a new set of resources for the web server.
-func NewResources(config *Config) *Resources {
+func NewResources(config *Config) (*Resources, error) {
 	resources := &Resources{
 		Logger: slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 			Level: config.LoggerLevel,
 		})),
-		Anicetus: anicetus.NewAnicetus[fingerprint.HTTPRequest](
-			detector.NewTokenBucketInMemory(
-				detector.TokenBucketWithLimitersBurst(config.Detector.RequestsPerMinute),
-				detector.TokenBucketWithLimitersInterval(time.Minute),
-				detector.TokenBucketWithCoolDownInterval(config.Detector.CoolDown),
-			),
-			storage.NewInMemory(),
-		),
 	}
 
+	detectorOptions := append(tokenBucketOptions(config),
+		detector.TokenBucketWithBasicOption(detector.WithLogger(resources.Logger)),
+	)
+	storageOptions := []storage.Option{
+		storage.WithLogger(resources.Logger),
+	}
+
+	var thunderingHerdDetector anicetus.Detector
+	var gatekeeperStorage anicetus.GatekeeperStorage
+	if config.Detector.Type == DetectorTypeLatency {
+		latency := detector.NewLatencyInMemory(append(latencyOptions(config),
+			detector.LatencyWithBasicOption(detector.WithLogger(resources.Logger)),
+		)...)
+		resources.reloadDetector = func(config *Config) {
+			latency.Update(latencyOptions(config)...)
+		}
+		thunderingHerdDetector = latency
+	}
+
+	switch config.Storage.Type {
+	case StorageTypeBBolt:
+		db, err := bolt.Open(config.Storage.Path, 0o600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open bbolt database: %w", err)
+		}
+		resources.closers = append(resources.closers, db.Close)
+
+		if thunderingHerdDetector == nil {
+			tokenBucket, err := detectorbbolt.NewTokenBucketBolt(db, detectorOptions...)
+			if err != nil {
+				return nil, errors.Join(err, resources.Close())
+			}
+			resources.closers = append(resources.closers, func() error {
+				tokenBucket.Stop()
+				return nil
+			})
+			resources.reloadDetector = func(config *Config) {
+				tokenBucket.Update(tokenBucketOptions(config)...)
+			}
+			thunderingHerdDetector = tokenBucket
+		}
+
+		boltStorage, err := storagebbolt.NewBolt(db, append(storageOptions,
+			storage.WithTTL(config.Storage.TTL),
+		)...)
+		if err != nil {
+			return nil, errors.Join(err, resources.Close())
+		}
+		resources.closers = append(resources.closers, func() error {
+			boltStorage.Stop()
+			return nil
+		})
+		gatekeeperStorage = boltStorage
+
+	default:
+		if thunderingHerdDetector == nil {
+			tokenBucket := detector.NewTokenBucketInMemorySharded(append(detectorOptions,
+				detector.TokenBucketWithMaxEntries(config.Storage.MaxEntries),
+				detector.TokenBucketWithShards(config.Storage.Shards),
+			)...)
+			resources.reloadDetector = func(config *Config) {
+				tokenBucket.Update(tokenBucketOptions(config)...)
+			}
+			resources.snapshots = append(resources.snapshots, snapshot{
+				file:        "detector.json",
+				snapshotter: tokenBucket,
+			})
+			thunderingHerdDetector = tokenBucket
+		}
+		inMemoryStorage := storage.NewInMemorySharded(append(storageOptions,
+			storage.WithTTL(config.Storage.TTL),
+			storage.WithMaxEntries(config.Storage.MaxEntries),
+			storage.WithShards(config.Storage.Shards),
+		)...)
+		resources.snapshots = append(resources.snapshots, snapshot{
+			file:        "gates.json",
+			snapshotter: inMemoryStorage,
+		})
+		gatekeeperStorage = inMemoryStorage
+	}
+
+	if config.Signal.Header != "" {
+		thunderingHerdDetector = detector.NewSignalInMemory(thunderingHerdDetector,
+			detector.SignalWithBasicOption(detector.WithLogger(resources.Logger)),
+			detector.SignalWithTTL(config.Signal.TTL),
+		)
+	}
+
+	if config.Storage.SnapshotDir != "" {
+		resources.snapshotDir = config.Storage.SnapshotDir
+		resources.restoreSnapshots()
+	}
+
+	resources.Anicetus = anicetus.NewAnicetus[fingerprint.HTTPRequest](thunderingHerdDetector, gatekeeperStorage)
+
 	resources.BackendClient = &http.Client{
 		Timeout: config.Backend.Timeout,
 	}
 
-	return resources
+	switch {
+	case len(config.Peers.Addresses) > 0:
+		resources.Peers = peers.NewStatic(config.Peers.Self, config.Peers.Addresses)
+	case config.Peers.DNS != "":
+		var err error
+		resources.Peers, err = peers.NewDNS(config.Peers.Self, config.Peers.DNS, config.Peers.Refresh, resources.Logger)
+		if err != nil {
+			return nil, errors.Join(err, resources.Close())
+		}
+		resources.closers = append(resources.closers, func() error {
+			resources.Peers.Stop()
+			return nil
+		})
+	}
+	if resources.Peers != nil {
+		resources.PeerClient = &http.Client{
+			Timeout: config.Backend.Timeout,
+			// the responses of the peer are delivered as they are
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	return resources, nil
+}
+
+// Reload applies the detector rate, burst, cooldown and target latency of the
+// configuration to the running detector, keeping its state. Other settings
+// require a restart.
+func (r *Resources) Reload(config *Config) {
+	if r.reloadDetector != nil {
+		r.reloadDetector(config)
+	}
+}
+
+// SaveSnapshots writes the in-memory state to the snapshot directory, so it can
+// be restored on the next start. Each file is replaced atomically.
+func (r *Resources) SaveSnapshots() error {
+	if r.snapshotDir == "" {
+		return nil
+	}
+
+	var errs error
+	for _, snapshot := range r.snapshots {
+		if err := saveSnapshot(r.snapshotDir, snapshot); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to save snapshot %q: %w", snapshot.file, err))
+		}
+	}
+	return errs
+}
+
+// restoreSnapshots loads the in-memory state saved by a previous process.
+// Missing or invalid snapshots are ignored, starting with an empty state.
+func (r *Resources) restoreSnapshots() {
+	for _, snapshot := range r.snapshots {
+		file, err := os.Open(filepath.Join(r.snapshotDir, snapshot.file))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			r.Logger.Warn("failed to open snapshot",
+				slog.String("file", snapshot.file),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+
+		err = snapshot.snapshotter.Restore(file)
+		_ = file.Close()
+		if err != nil {
+			r.Logger.Warn("failed to restore snapshot",
+				slog.String("file", snapshot.file),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		r.Logger.Info("snapshot restored",
+			slog.String("file", snapshot.file),
+		)
+	}
+}
+
+func saveSnapshot(dir string, snapshot snapshot) error {
+	file, err := os.CreateTemp(dir, snapshot.file+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	if err := snapshot.snapshotter.Snapshot(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := os.Rename(file.Name(), filepath.Join(dir, snapshot.file)); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// Close releases the resources, like open database files.
+func (r *Resources) Close() error {
+	var errs error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		errs = errors.Join(errs, r.closers[i]())
+	}
+	r.closers = nil
+	return errs
+}
+
+// tokenBucketOptions returns the token bucket detector options that can be
+// changed by a configuration reload.
+func tokenBucketOptions(config *Config) []detector.TokenBucketOption {
+	return []detector.TokenBucketOption{
+		detector.TokenBucketWithRate(config.Detector.Rate),
+		detector.TokenBucketWithBurst(config.Detector.Burst),
+		detector.TokenBucketWithCoolDownInterval(config.Detector.CoolDown),
+	}
+}
+
+// latencyOptions returns the latency detector options that can be changed by a
+// configuration reload.
+func latencyOptions(config *Config) []detector.LatencyOption {
+	return []detector.LatencyOption{
+		detector.LatencyWithLimit(config.Detector.Rate.Events),
+		detector.LatencyWithWindow(config.Detector.Rate.Period),
+		detector.LatencyWithTargetLatency(config.Detector.TargetLatency),
+		detector.LatencyWithCoolDownInterval(config.Detector.CoolDown),
+	}
 }
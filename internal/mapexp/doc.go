@@ -1,3 +1,4 @@
 // Package mapexp implements a generic map with items that expire after a
-// certain duration.
+// certain duration. Each item can have its own duration, and the map can be
+// bounded, evicting the least recently used items.
 package mapexp
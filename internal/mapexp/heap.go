@@ -0,0 +1,67 @@
+package mapexp
+
+import (
+	"container/list"
+	"time"
+)
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+	// ttl is the duration of the entry when it is renewed. It is only used when
+	// the entry was set with its own TTL, otherwise the TTL of the map is used.
+	ttl       time.Duration
+	customTTL bool
+	// expiration is when the entry expires. The zero time means that the entry
+	// never expires.
+	expiration time.Time
+	// index is the position of the entry in the expiration heap, or -1 when the
+	// entry never expires.
+	index int
+	usage *list.Element
+}
+
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expiration.IsZero() && !e.expiration.After(now)
+}
+
+func (e *entry[K, V]) eviction(reason EvictionReason) eviction[K, V] {
+	return eviction[K, V]{
+		item:   item[K, V]{key: e.key, value: e.value},
+		reason: reason,
+	}
+}
+
+// expirationHeap is a min-heap of entries ordered by expiration, so the next
+// entry to expire is always at the top. It implements heap.Interface.
+type expirationHeap[K comparable, V any] []*entry[K, V]
+
+func (h expirationHeap[K, V]) Len() int {
+	return len(h)
+}
+
+func (h expirationHeap[K, V]) Less(i, j int) bool {
+	return h[i].expiration.Before(h[j].expiration)
+}
+
+func (h expirationHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expirationHeap[K, V]) Push(x any) {
+	e := x.(*entry[K, V])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expirationHeap[K, V]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
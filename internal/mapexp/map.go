@@ -1,79 +1,379 @@
 package mapexp
 
 import (
+	"container/heap"
+	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Map is a generic map with items that expire after a certain duration.
+// purgeResolution is the minimum interval between purges, so entries expiring
+// close to each other are purged together. Expired entries are never returned,
+// even before they are purged.
+const purgeResolution = 10 * time.Millisecond
+
+// EvictionReason is the reason an item was removed from the map.
+type EvictionReason int
+
+// List of possible eviction reasons.
+const (
+	// EvictionExpired means that the item expired.
+	EvictionExpired EvictionReason = iota
+	// EvictionCapacity means that the item was the least recently used when the
+	// map exceeded the maximum number of entries.
+	EvictionCapacity
+)
+
+// String returns a human readable representation of the reason.
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionExpired:
+		return "expired"
+	case EvictionCapacity:
+		return "capacity"
+	}
+	return "unknown"
+}
+
+type item[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+type eviction[K comparable, V any] struct {
+	item[K, V]
+	reason EvictionReason
+}
+
+// Map is a generic map with items that expire after a certain duration. The
+// expirations are kept in a min-heap, so setting, renewing and expiring an
+// item is O(log n), and a background goroutine purges the expired items when
+// the next one expires.
 type Map[K comparable, V any] struct {
-	items           map[K]V
-	itemsMutex      sync.RWMutex
-	expirationQueue *expirationQueue[K]
+	items       map[K]*entry[K, V]
+	expirations expirationHeap[K, V]
+	// usage keeps the items from the least to the most recently used, so the
+	// oldest items can be evicted when there are too many of them.
+	usage      *list.List
+	ttl        time.Duration
+	maxEntries int
+	onEvict    func(key K, value V, reason EvictionReason)
+	mutex      sync.Mutex
+	evictions  atomic.Uint64
 
-	stop chan struct{}
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-// New creates a new Map.
+// New creates a new Map where the items expire after the TTL. A non-positive
+// TTL means that the items never expire.
 func New[K comparable, V any](ttl time.Duration) *Map[K, V] {
 	m := &Map[K, V]{
-		items:           make(map[K]V),
-		expirationQueue: newExpirationQueue[K](ttl),
+		items: make(map[K]*entry[K, V]),
+		usage: list.New(),
+		ttl:   ttl,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
 	}
-	m.start()
+	go m.purge()
 	return m
 }
 
-// Set sets the value for the key in the map.
+// SetTTL changes the duration of the items set or renewed from now on. Items
+// already in the map keep their expiration, and items set with their own TTL
+// keep it.
+func (m *Map[K, V]) SetTTL(ttl time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.ttl = ttl
+}
+
+// SetMaxEntries limits the number of items in the map. When the limit is
+// exceeded, the least recently used items are evicted. Zero means no limit.
+func (m *Map[K, V]) SetMaxEntries(maxEntries int) {
+	m.mutex.Lock()
+	m.maxEntries = maxEntries
+	evicted := m.evict()
+	m.mutex.Unlock()
+
+	m.notify(evicted)
+}
+
+// OnEvict registers a function called when an item expires or is evicted to
+// respect the maximum number of entries. It isn't called for deleted or
+// replaced items. The function is called without holding any lock, so it can
+// use the map.
+func (m *Map[K, V]) OnEvict(f func(key K, value V, reason EvictionReason)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.onEvict = f
+}
+
+// Evictions returns the number of items evicted to respect the maximum number
+// of entries.
+func (m *Map[K, V]) Evictions() uint64 {
+	return m.evictions.Load()
+}
+
+// Set sets the value for the key in the map, expiring after the TTL of the
+// map.
 func (m *Map[K, V]) Set(key K, value V) {
-	m.itemsMutex.Lock()
-	m.items[key] = value
-	m.itemsMutex.Unlock()
+	m.set(key, value, 0, false)
+}
 
-	m.expirationQueue.add(key)
+// SetWithTTL sets the value for the key in the map, expiring after the given
+// TTL instead of the TTL of the map. The TTL is kept when the item is renewed.
+// A non-positive TTL means that the item never expires.
+func (m *Map[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	m.set(key, value, ttl, true)
 }
 
-// Get gets the value for the key in the map.
+func (m *Map[K, V]) set(key K, value V, ttl time.Duration, customTTL bool) {
+	now := time.Now()
+
+	m.mutex.Lock()
+	e, ok := m.items[key]
+	if ok {
+		e.value = value
+		e.ttl = ttl
+		e.customTTL = customTTL
+		m.usage.MoveToBack(e.usage)
+	} else {
+		e = &entry[K, V]{
+			key:       key,
+			value:     value,
+			ttl:       ttl,
+			customTTL: customTTL,
+			index:     -1,
+		}
+		e.usage = m.usage.PushBack(e)
+		m.items[key] = e
+	}
+	m.renew(e, now)
+	evicted := m.evict()
+	wake := e.index == 0
+	m.mutex.Unlock()
+
+	if wake {
+		// the item expires before the next purge
+		select {
+		case m.wake <- struct{}{}:
+		default:
+		}
+	}
+	m.notify(evicted)
+}
+
+// Get gets the value for the key in the map, renewing its expiration.
 func (m *Map[K, V]) Get(key K) (V, bool) {
-	m.itemsMutex.RLock()
-	value, ok := m.items[key]
-	m.itemsMutex.RUnlock()
+	now := time.Now()
+
+	m.mutex.Lock()
+	e, ok := m.items[key]
+	if !ok {
+		m.mutex.Unlock()
+		var empty V
+		return empty, false
+	}
+	if e.expired(now) {
+		m.remove(e)
+		m.mutex.Unlock()
+		m.notify([]eviction[K, V]{e.eviction(EvictionExpired)})
+		var empty V
+		return empty, false
+	}
+	m.renew(e, now)
+	m.usage.MoveToBack(e.usage)
+	value := e.value
+	m.mutex.Unlock()
 
-	m.expirationQueue.renew(key)
-	return value, ok
+	return value, true
+}
+
+// Peek gets the value for the key in the map without renewing its expiration.
+func (m *Map[K, V]) Peek(key K) (V, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.items[key]
+	if !ok || e.expired(time.Now()) {
+		var empty V
+		return empty, false
+	}
+	return e.value, true
+}
+
+// Expiration returns when the key will expire. The zero time is returned for
+// keys that never expire.
+func (m *Map[K, V]) Expiration(key K) (time.Time, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.items[key]
+	if !ok || e.expired(time.Now()) {
+		return time.Time{}, false
+	}
+	return e.expiration, true
+}
+
+// Range calls f sequentially for each key and value present in the map. If f
+// returns false, range stops the iteration. The expiration of the keys is not
+// renewed. Range iterates over a copy of the map, so f can use the map.
+func (m *Map[K, V]) Range(f func(key K, value V) bool) {
+	now := time.Now()
+
+	m.mutex.Lock()
+	items := make([]item[K, V], 0, len(m.items))
+	for key, e := range m.items {
+		if !e.expired(now) {
+			items = append(items, item[K, V]{key: key, value: e.value})
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, item := range items {
+		if !f(item.key, item.value) {
+			return
+		}
+	}
+}
+
+// Len returns the number of items in the map, purging the expired ones.
+func (m *Map[K, V]) Len() int {
+	m.mutex.Lock()
+	expired := m.expire(time.Now())
+	n := len(m.items)
+	m.mutex.Unlock()
+
+	m.notify(expired)
+	return n
 }
 
 // Delete deletes the key from the map.
 func (m *Map[K, V]) Delete(key K) {
-	m.itemsMutex.Lock()
-	delete(m.items, key)
-	m.itemsMutex.Unlock()
-}
-
-func (m *Map[K, V]) start() {
-	go func() {
-		timer := time.NewTicker(m.expirationQueue.ttl)
-		defer timer.Stop()
-
-		for {
-			select {
-			case <-m.stop:
-				return
-			case <-timer.C:
-			}
-
-			expiredKeys := m.expirationQueue.purge()
-
-			m.itemsMutex.Lock()
-			for _, key := range expiredKeys {
-				delete(m.items, key)
-			}
-			m.itemsMutex.Unlock()
-		}
-	}()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if e, ok := m.items[key]; ok {
+		m.remove(e)
+	}
 }
 
-// Stop stops the map from expiring the keys.
+// Stop stops the map from purging the expired keys. Expired keys are still
+// never returned. It is safe to call Stop more than once.
 func (m *Map[K, V]) Stop() {
-	close(m.stop)
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+func (m *Map[K, V]) purge() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.wake:
+		case <-timer.C:
+		}
+
+		now := time.Now()
+
+		m.mutex.Lock()
+		expired := m.expire(now)
+		var next time.Duration
+		if len(m.expirations) > 0 {
+			next = max(m.expirations[0].expiration.Sub(now), purgeResolution)
+		}
+		m.mutex.Unlock()
+
+		m.notify(expired)
+
+		if next > 0 {
+			timer.Reset(next)
+		} else {
+			timer.Stop()
+		}
+	}
+}
+
+// renew updates the expiration of the entry. The caller must hold the lock.
+func (m *Map[K, V]) renew(e *entry[K, V], now time.Time) {
+	ttl := m.ttl
+	if e.customTTL {
+		ttl = e.ttl
+	}
+
+	if ttl <= 0 {
+		e.expiration = time.Time{}
+		if e.index >= 0 {
+			heap.Remove(&m.expirations, e.index)
+		}
+		return
+	}
+
+	e.expiration = now.Add(ttl)
+	if e.index >= 0 {
+		heap.Fix(&m.expirations, e.index)
+	} else {
+		heap.Push(&m.expirations, e)
+	}
+}
+
+// remove removes the entry from the map. The caller must hold the lock.
+func (m *Map[K, V]) remove(e *entry[K, V]) {
+	delete(m.items, e.key)
+	m.usage.Remove(e.usage)
+	if e.index >= 0 {
+		heap.Remove(&m.expirations, e.index)
+	}
+}
+
+// expire removes the expired entries, returning them. The caller must hold the
+// lock.
+func (m *Map[K, V]) expire(now time.Time) []eviction[K, V] {
+	var expired []eviction[K, V]
+	for len(m.expirations) > 0 && m.expirations[0].expired(now) {
+		e := m.expirations[0]
+		m.remove(e)
+		expired = append(expired, e.eviction(EvictionExpired))
+	}
+	return expired
+}
+
+// evict removes the least recently used entries while there are too many of
+// them, returning them. The caller must hold the lock.
+func (m *Map[K, V]) evict() []eviction[K, V] {
+	var evicted []eviction[K, V]
+	for m.maxEntries > 0 && len(m.items) > m.maxEntries {
+		e := m.usage.Front().Value.(*entry[K, V])
+		m.remove(e)
+		m.evictions.Add(1)
+		evicted = append(evicted, e.eviction(EvictionCapacity))
+	}
+	return evicted
+}
+
+// notify calls the eviction callback for the removed entries.
+func (m *Map[K, V]) notify(evictions []eviction[K, V]) {
+	if len(evictions) == 0 {
+		return
+	}
+
+	m.mutex.Lock()
+	onEvict := m.onEvict
+	m.mutex.Unlock()
+
+	if onEvict == nil {
+		return
+	}
+	for _, e := range evictions {
+		onEvict(e.key, e.value, e.reason)
+	}
 }
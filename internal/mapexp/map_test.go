@@ -0,0 +1,172 @@
+package mapexp_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2/internal/mapexp"
+)
+
+func TestMap_expiration(t *testing.T) {
+	m := mapexp.New[string, int](100 * time.Millisecond)
+	defer m.Stop()
+
+	m.Set("renewed", 1)
+	m.Set("expired", 2)
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := m.Get("renewed"); !ok {
+		t.Fatal("renewed key should exist")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := m.Peek("expired"); ok {
+		t.Error("expired key should not exist")
+	}
+	if value, ok := m.Peek("renewed"); !ok || value != 1 {
+		t.Errorf("unexpected renewed key %d (%t)", value, ok)
+	}
+	if n := m.Len(); n != 1 {
+		t.Errorf("unexpected length %d", n)
+	}
+}
+
+func TestMap_SetWithTTL(t *testing.T) {
+	m := mapexp.New[string, int](time.Hour)
+	defer m.Stop()
+
+	m.SetWithTTL("short", 1, 50*time.Millisecond)
+	m.SetWithTTL("forever", 2, 0)
+	m.Set("default", 3)
+
+	if expiration, ok := m.Expiration("forever"); !ok || !expiration.IsZero() {
+		t.Errorf("unexpected expiration %s (%t)", expiration, ok)
+	}
+	if expiration, ok := m.Expiration("default"); !ok || time.Until(expiration) < 59*time.Minute {
+		t.Errorf("unexpected expiration %s (%t)", expiration, ok)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := m.Get("short"); ok {
+		t.Error("short key should have expired")
+	}
+	if _, ok := m.Get("forever"); !ok {
+		t.Error("forever key should exist")
+	}
+}
+
+func TestMap_OnEvict(t *testing.T) {
+	m := mapexp.New[string, int](50 * time.Millisecond)
+	defer m.Stop()
+
+	var mutex sync.Mutex
+	evicted := make(map[string]mapexp.EvictionReason)
+	m.OnEvict(func(key string, _ int, reason mapexp.EvictionReason) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		evicted[key] = reason
+	})
+	m.SetMaxEntries(2)
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Get("a")
+	m.Set("c", 3)
+	m.Delete("c")
+
+	mutex.Lock()
+	if reason, ok := evicted["b"]; !ok || reason != mapexp.EvictionCapacity {
+		t.Errorf("unexpected eviction of b: %s (%t)", reason, ok)
+	}
+	mutex.Unlock()
+	if evictions := m.Evictions(); evictions != 1 {
+		t.Errorf("unexpected number of evictions %d", evictions)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if reason, ok := evicted["a"]; !ok || reason != mapexp.EvictionExpired {
+		t.Errorf("unexpected eviction of a: %s (%t)", reason, ok)
+	}
+	if _, ok := evicted["c"]; ok {
+		t.Error("deleted key should not be evicted")
+	}
+}
+
+func TestMap_Stop(t *testing.T) {
+	m := mapexp.New[string, int](10 * time.Millisecond)
+	m.Set("key", 1)
+	m.Stop()
+	m.Stop()
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := m.Get("key"); ok {
+		t.Error("expired key should not exist after stopping")
+	}
+}
+
+const benchmarkKeys = 1_000_000
+
+func newBenchmarkMap(b *testing.B) *mapexp.Map[int, int] {
+	b.Helper()
+
+	m := mapexp.New[int, int](time.Hour)
+	b.Cleanup(m.Stop)
+	for i := range benchmarkKeys {
+		m.Set(i, i)
+	}
+	return m
+}
+
+func BenchmarkMap_Set(b *testing.B) {
+	m := newBenchmarkMap(b)
+
+	for i := 0; b.Loop(); i++ {
+		m.Set(i%benchmarkKeys, i)
+	}
+}
+
+func BenchmarkMap_SetEvicting(b *testing.B) {
+	m := newBenchmarkMap(b)
+	m.SetMaxEntries(benchmarkKeys)
+
+	for i := benchmarkKeys; b.Loop(); i++ {
+		m.Set(i, i)
+	}
+}
+
+func BenchmarkMap_Get(b *testing.B) {
+	m := newBenchmarkMap(b)
+
+	for i := 0; b.Loop(); i++ {
+		m.Get(i % benchmarkKeys)
+	}
+}
+
+func BenchmarkMap_Peek(b *testing.B) {
+	m := newBenchmarkMap(b)
+
+	for i := 0; b.Loop(); i++ {
+		m.Peek(i % benchmarkKeys)
+	}
+}
+
+func BenchmarkMap_expire(b *testing.B) {
+	for b.Loop() {
+		b.StopTimer()
+		m := mapexp.New[int, int](time.Hour)
+		m.Stop()
+		for i := range benchmarkKeys {
+			m.SetWithTTL(i, i, time.Nanosecond)
+		}
+		b.StartTimer()
+
+		if n := m.Len(); n != 0 {
+			b.Fatalf("unexpected length %d", n)
+		}
+	}
+}
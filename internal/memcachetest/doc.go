@@ -0,0 +1,4 @@
+// Package memcachetest implements an in-process memcached server, supporting
+// the subset of the text protocol used by the memcached detector and storage,
+// so they can be tested without a memcached instance.
+package memcachetest
@@ -0,0 +1,316 @@
+package memcachetest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// relativeExpirationLimit is the maximum expiration, in seconds, that is
+// handled as relative to the current time. Bigger values are unix timestamps.
+const relativeExpirationLimit = 60 * 60 * 24 * 30
+
+type item struct {
+	value     []byte
+	flags     uint32
+	cas       uint64
+	expiresAt time.Time
+}
+
+func (i item) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && !now.Before(i.expiresAt)
+}
+
+// Server is an in-process memcached server.
+type Server struct {
+	listener net.Listener
+
+	mutex sync.Mutex
+	items map[string]item
+	cas   uint64
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a new memcached server listening on a random local port.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	s := &Server{
+		listener: listener,
+		items:    make(map[string]item),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err := s.execute(rw, fields); err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			fmt.Fprintf(rw, "CLIENT_ERROR %s\r\n", err)
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) execute(rw *bufio.ReadWriter, fields []string) error {
+	switch command, args := fields[0], fields[1:]; command {
+	case "get", "gets":
+		s.get(rw, args)
+	case "set", "add", "replace", "cas":
+		return s.store(rw, command, args)
+	case "delete":
+		if len(args) < 1 {
+			return errors.New("bad command line format")
+		}
+		s.delete(rw, args[0])
+	case "incr", "decr":
+		if len(args) < 2 {
+			return errors.New("bad command line format")
+		}
+		return s.incrDecr(rw, command, args[0], args[1])
+	case "touch":
+		if len(args) < 2 {
+			return errors.New("bad command line format")
+		}
+		return s.touch(rw, args[0], args[1])
+	case "flush_all":
+		s.mutex.Lock()
+		clear(s.items)
+		s.mutex.Unlock()
+		fmt.Fprint(rw, "OK\r\n")
+	case "version":
+		fmt.Fprint(rw, "VERSION memcachetest\r\n")
+	default:
+		fmt.Fprint(rw, "ERROR\r\n")
+	}
+	return nil
+}
+
+func (s *Server) get(rw *bufio.ReadWriter, keys []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		i, ok := s.load(key, now)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(rw, "VALUE %s %d %d %d\r\n", key, i.flags, len(i.value), i.cas)
+		_, _ = rw.Write(i.value)
+		fmt.Fprint(rw, "\r\n")
+	}
+	fmt.Fprint(rw, "END\r\n")
+}
+
+func (s *Server) store(rw *bufio.ReadWriter, command string, args []string) error {
+	if len(args) < 4 || (command == "cas" && len(args) < 5) {
+		return errors.New("bad command line format")
+	}
+
+	key := args[0]
+	flags, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return errors.New("bad command line format")
+	}
+	expiration, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errors.New("bad command line format")
+	}
+	size, err := strconv.Atoi(args[3])
+	if err != nil || size < 0 {
+		return errors.New("bad data chunk")
+	}
+	var cas uint64
+	if command == "cas" {
+		if cas, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			return errors.New("bad command line format")
+		}
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(rw, data); err != nil {
+		return io.EOF
+	}
+	if string(data[size:]) != "\r\n" {
+		return errors.New("bad data chunk")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	current, exists := s.load(key, now)
+	switch {
+	case command == "add" && exists,
+		command == "replace" && !exists:
+		fmt.Fprint(rw, "NOT_STORED\r\n")
+		return nil
+	case command == "cas" && !exists:
+		fmt.Fprint(rw, "NOT_FOUND\r\n")
+		return nil
+	case command == "cas" && current.cas != cas:
+		fmt.Fprint(rw, "EXISTS\r\n")
+		return nil
+	}
+
+	s.cas++
+	s.items[key] = item{
+		value:     data[:size],
+		flags:     uint32(flags),
+		cas:       s.cas,
+		expiresAt: expiresAt(now, expiration),
+	}
+	fmt.Fprint(rw, "STORED\r\n")
+	return nil
+}
+
+func (s *Server) delete(rw *bufio.ReadWriter, key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.load(key, time.Now()); !ok {
+		fmt.Fprint(rw, "NOT_FOUND\r\n")
+		return
+	}
+	delete(s.items, key)
+	fmt.Fprint(rw, "DELETED\r\n")
+}
+
+func (s *Server) incrDecr(rw *bufio.ReadWriter, command, key, rawDelta string) error {
+	delta, err := strconv.ParseUint(rawDelta, 10, 64)
+	if err != nil {
+		return errors.New("invalid numeric delta argument")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i, ok := s.load(key, time.Now())
+	if !ok {
+		fmt.Fprint(rw, "NOT_FOUND\r\n")
+		return nil
+	}
+	value, err := strconv.ParseUint(string(i.value), 10, 64)
+	if err != nil {
+		return errors.New("cannot increment or decrement non-numeric value")
+	}
+
+	if command == "incr" {
+		value += delta
+	} else {
+		value -= min(value, delta)
+	}
+
+	s.cas++
+	i.value = []byte(strconv.FormatUint(value, 10))
+	i.cas = s.cas
+	s.items[key] = i
+	fmt.Fprintf(rw, "%d\r\n", value)
+	return nil
+}
+
+func (s *Server) touch(rw *bufio.ReadWriter, key, rawExpiration string) error {
+	expiration, err := strconv.ParseInt(rawExpiration, 10, 64)
+	if err != nil {
+		return errors.New("invalid exptime argument")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	i, ok := s.load(key, now)
+	if !ok {
+		fmt.Fprint(rw, "NOT_FOUND\r\n")
+		return nil
+	}
+	i.expiresAt = expiresAt(now, expiration)
+	s.items[key] = i
+	fmt.Fprint(rw, "TOUCHED\r\n")
+	return nil
+}
+
+// load returns the item of the key, removing it if it's expired. The mutex must
+// be held by the caller.
+func (s *Server) load(key string, now time.Time) (item, bool) {
+	i, ok := s.items[key]
+	if !ok {
+		return item{}, false
+	}
+	if i.expired(now) {
+		delete(s.items, key)
+		return item{}, false
+	}
+	return i, true
+}
+
+// expiresAt converts the memcached expiration to a time. Zero means that the
+// item never expires, and negative values expire the item immediately.
+func expiresAt(now time.Time, expiration int64) time.Time {
+	switch {
+	case expiration == 0:
+		return time.Time{}
+	case expiration < 0:
+		return now
+	case expiration <= relativeExpirationLimit:
+		return now.Add(time.Duration(expiration) * time.Second)
+	default:
+		return time.Unix(expiration, 0)
+	}
+}
@@ -0,0 +1,4 @@
+// Package peers discovers the replicas of the proxy and assigns each
+// fingerprint to one of them using consistent hashing, so all requests of a
+// thundering herd can be evaluated by the same replica.
+package peers
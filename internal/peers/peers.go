@@ -0,0 +1,122 @@
+package peers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Peers keeps the ring of the proxy replicas. The replicas are a static list
+// or resolved periodically from a DNS name, and the replica itself is always
+// part of the ring.
+type Peers struct {
+	self   string
+	ring   atomic.Pointer[Ring]
+	logger *slog.Logger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewStatic creates the ring with a static list of peers, identified by their
+// "host:port" addresses. The self address must be the one used by the other
+// peers to reach this replica.
+func NewStatic(self string, addresses []string) *Peers {
+	p := &Peers{
+		self: self,
+		stop: make(chan struct{}),
+	}
+	p.update(addresses)
+	return p
+}
+
+// NewDNS creates the ring with the peers resolved from the name, in the
+// "host:port" format, using all addresses of the host with the same port. The
+// name is resolved again at every interval, so peers can join and leave. It
+// fails when the first resolution fails.
+func NewDNS(self, name string, interval time.Duration, logger *slog.Logger) (*Peers, error) {
+	host, port, err := net.SplitHostPort(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peers name: %w", err)
+	}
+
+	p := &Peers{
+		self:   self,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+
+	resolve := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+
+		hosts, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve peers: %w", err)
+		}
+		addresses := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			addresses = append(addresses, net.JoinHostPort(host, port))
+		}
+		p.update(addresses)
+		return nil
+	}
+	if err := resolve(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+			// the current peers are kept while the name can't be resolved
+			if err := resolve(); err != nil && p.logger != nil {
+				p.logger.Warn("failed to refresh peers",
+					slog.String("error", err.Error()),
+				)
+			}
+		}
+	}()
+
+	return p, nil
+}
+
+// Self returns the address of this replica.
+func (p *Peers) Self() string {
+	return p.self
+}
+
+// Owner returns the address of the peer owning the key, and if it is this
+// replica.
+func (p *Peers) Owner(key string) (string, bool) {
+	owner, ok := p.ring.Load().Get(key)
+	if !ok {
+		return p.self, true
+	}
+	return owner, owner == p.self
+}
+
+// Stop stops refreshing the peers. It is safe to call Stop more than once.
+func (p *Peers) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+func (p *Peers) update(addresses []string) {
+	if !slices.Contains(addresses, p.self) {
+		addresses = append(slices.Clip(addresses), p.self)
+	}
+	p.ring.Store(NewRing(addresses))
+}
@@ -0,0 +1,98 @@
+package peers_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2/internal/peers"
+)
+
+func TestPeers_Owner(t *testing.T) {
+	addresses := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	p := peers.NewStatic("10.0.0.1:80", addresses)
+	ring := peers.NewRing(addresses)
+
+	var owned int
+	for i := range 1000 {
+		key := "/users/" + strconv.Itoa(i)
+		want, _ := ring.Get(key)
+		peer, self := p.Owner(key)
+		if peer != want {
+			t.Errorf("key %q owned by %q, expected %q", key, peer, want)
+		}
+		if self != (peer == "10.0.0.1:80") {
+			t.Errorf("key %q owned by %q reported as self: %v", key, peer, self)
+		}
+		if self {
+			owned++
+		}
+	}
+	if owned == 0 || owned == 1000 {
+		t.Errorf("keys should be split between the peers, self owns %d", owned)
+	}
+}
+
+func TestPeers_Owner_selfMissing(t *testing.T) {
+	// the replica is always part of the ring, even when the list doesn't have it
+	p := peers.NewStatic("10.0.0.3:80", []string{"10.0.0.1:80", "10.0.0.2:80"})
+	ring := peers.NewRing([]string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"})
+
+	if self := p.Self(); self != "10.0.0.3:80" {
+		t.Errorf("unexpected self address %q", self)
+	}
+
+	var owned int
+	for i := range 1000 {
+		key := "/users/" + strconv.Itoa(i)
+		want, _ := ring.Get(key)
+		peer, self := p.Owner(key)
+		if peer != want {
+			t.Errorf("key %q owned by %q, expected %q", key, peer, want)
+		}
+		if self {
+			owned++
+		}
+	}
+	if owned == 0 {
+		t.Error("self should own part of the keys")
+	}
+}
+
+func TestPeers_Owner_noPeers(t *testing.T) {
+	p := peers.NewStatic("10.0.0.1:80", nil)
+	defer p.Stop()
+
+	for i := range 100 {
+		if peer, self := p.Owner("/users/" + strconv.Itoa(i)); !self || peer != "10.0.0.1:80" {
+			t.Errorf("self should own all keys, got %q", peer)
+		}
+	}
+}
+
+func TestNewDNS(t *testing.T) {
+	p, err := peers.NewDNS("127.0.0.1:8080", "localhost:8080", time.Hour, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// stopping more than once is safe
+	p.Stop()
+	p.Stop()
+
+	for i := range 100 {
+		peer, self := p.Owner("/users/" + strconv.Itoa(i))
+		if host, port, err := net.SplitHostPort(peer); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() || port != "8080" {
+			t.Errorf("unexpected peer %q", peer)
+		}
+		if self != (peer == "127.0.0.1:8080") {
+			t.Errorf("peer %q reported as self: %v", peer, self)
+		}
+	}
+
+	if _, err := peers.NewDNS("127.0.0.1:8080", "localhost", time.Hour, nil); err == nil {
+		t.Error("name without port should fail")
+	}
+}
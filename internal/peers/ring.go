@@ -0,0 +1,70 @@
+package peers
+
+import (
+	"hash/fnv"
+	"slices"
+	"strconv"
+)
+
+// virtualNodes is the number of points of each peer in the ring, spreading the
+// keys evenly between the peers.
+const virtualNodes = 100
+
+// Ring is a consistent hash ring. When a peer joins or leaves, only the keys
+// of its points in the ring move to other peers. Rings with the same peers
+// assign the same keys to the same peers, whatever the process, so the hash is
+// deterministic.
+type Ring struct {
+	hashes []uint64
+	peers  map[uint64]string
+}
+
+// NewRing creates a ring with the peers. Duplicated peers are ignored.
+func NewRing(peers []string) *Ring {
+	r := &Ring{
+		peers: make(map[uint64]string, len(peers)*virtualNodes),
+	}
+	for _, peer := range peers {
+		for i := range virtualNodes {
+			hash := hashKey(peer + "#" + strconv.Itoa(i))
+			if _, ok := r.peers[hash]; ok {
+				continue
+			}
+			r.peers[hash] = peer
+			r.hashes = append(r.hashes, hash)
+		}
+	}
+	slices.Sort(r.hashes)
+	return r
+}
+
+// Get returns the peer owning the key, which is the first point in the ring
+// after the hash of the key. It returns false when the ring is empty.
+func (r *Ring) Get(key string) (string, bool) {
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+
+	hash := hashKey(key)
+	i, _ := slices.BinarySearch(r.hashes, hash)
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.peers[r.hashes[i]], true
+}
+
+func hashKey(key string) uint64 {
+	hash := fnv.New64a()
+	// writing to a hash never fails
+	_, _ = hash.Write([]byte(key))
+
+	// FNV-1a spreads similar keys poorly, so the bits are mixed with the
+	// finalizer of MurmurHash3
+	h := hash.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,92 @@
+package peers_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/rafaeljusto/anicetus/v2/internal/peers"
+)
+
+func TestRing_Get(t *testing.T) {
+	ring := peers.NewRing([]string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"})
+	reordered := peers.NewRing([]string{"10.0.0.3:80", "10.0.0.1:80", "10.0.0.2:80", "10.0.0.1:80"})
+
+	for i := range 1000 {
+		key := "/users/" + strconv.Itoa(i)
+		peer, ok := ring.Get(key)
+		if !ok {
+			t.Fatalf("key %q should have an owner", key)
+		}
+		if again, _ := ring.Get(key); again != peer {
+			t.Errorf("key %q moved from %q to %q in the same ring", key, peer, again)
+		}
+		// the order of the peers and duplicates don't change the owners
+		if other, _ := reordered.Get(key); other != peer {
+			t.Errorf("key %q owned by %q and %q in rings with the same peers", key, peer, other)
+		}
+	}
+}
+
+func TestRing_Get_empty(t *testing.T) {
+	if peer, ok := peers.NewRing(nil).Get("/users/1"); ok {
+		t.Errorf("empty ring should not have owners, got %q", peer)
+	}
+}
+
+func TestRing_Get_distribution(t *testing.T) {
+	addresses := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80"}
+	ring := peers.NewRing(addresses)
+
+	const keys = 100_000
+	counts := make(map[string]int)
+	for i := range keys {
+		peer, _ := ring.Get("/users/" + strconv.Itoa(i))
+		counts[peer]++
+	}
+
+	share := keys / len(addresses)
+	for _, address := range addresses {
+		if count := counts[address]; count < share/2 || count > share*3/2 {
+			t.Errorf("peer %q owns %d keys, expected around %d", address, count, share)
+		}
+	}
+}
+
+func TestRing_Get_minimalMovement(t *testing.T) {
+	addresses := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}
+	before := peers.NewRing(addresses)
+	joined := peers.NewRing(append(addresses, "10.0.0.4:80"))
+	left := peers.NewRing(addresses[:2])
+
+	const keys = 100_000
+	var movedOnJoin, movedOnLeave int
+	for i := range keys {
+		key := "/users/" + strconv.Itoa(i)
+		owner, _ := before.Get(key)
+
+		// only the keys taken by the new peer move
+		if peer, _ := joined.Get(key); peer != owner {
+			movedOnJoin++
+			if peer != "10.0.0.4:80" {
+				t.Fatalf("key %q moved from %q to %q when a peer joined", key, owner, peer)
+			}
+		}
+
+		// only the keys of the peer that left move
+		if peer, _ := left.Get(key); peer != owner {
+			movedOnLeave++
+			if owner != "10.0.0.3:80" {
+				t.Fatalf("key %q moved from %q to %q when another peer left", key, owner, peer)
+			}
+		}
+	}
+
+	// about a quarter of the keys moves to the fourth peer, and a third of them
+	// is reassigned when one of the three peers leaves
+	if movedOnJoin < keys/8 || movedOnJoin > keys*3/8 {
+		t.Errorf("unexpected keys moved when a peer joined: %d", movedOnJoin)
+	}
+	if movedOnLeave < keys/6 || movedOnLeave > keys/2 {
+		t.Errorf("unexpected keys moved when a peer left: %d", movedOnLeave)
+	}
+}
@@ -64,6 +64,9 @@ type Limiter struct {
 	last time.Time
 	// lastEvent is the latest time of a rate-limited event (past or future)
 	lastEvent time.Time
+	// penalty returns the tokens and the last update time after a denied event,
+	// draining the bucket when nil
+	penalty PenaltyFunc
 }
 
 // Limit returns the maximum overall event rate.
@@ -96,6 +99,24 @@ func (lim *Limiter) Tokens() float64 {
 	return lim.TokensAt(time.Now())
 }
 
+// State returns the tokens of the limiter and the last time they were updated,
+// without refilling them until now. A last update in the future is a penalty
+// pause.
+func (lim *Limiter) State() (tokens float64, last time.Time) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	return lim.tokens, lim.last
+}
+
+// SetState sets the tokens of the limiter and the last time they were updated,
+// like returned by State.
+func (lim *Limiter) SetState(tokens float64, last time.Time) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	lim.tokens = tokens
+	lim.last = last
+}
+
 // NewLimiter returns a new Limiter that allows events up to rate r and permits
 // bursts of at most b tokens.
 func NewLimiter(r Limit, b int) *Limiter {
@@ -106,6 +127,19 @@ func NewLimiter(r Limit, b int) *Limiter {
 	}
 }
 
+// PenaltyFunc returns the tokens and the last update time of a limiter after an
+// event is denied, receiving the tokens available at the time of the event. A
+// last update time in the future stops refilling the tokens until then.
+type PenaltyFunc func(tokens float64, last, t time.Time) (float64, time.Time)
+
+// NewLimiterWithPenalty returns a new Limiter like NewLimiter, applying the
+// penalty when an event is denied instead of draining the bucket.
+func NewLimiterWithPenalty(r Limit, b int, penalty PenaltyFunc) *Limiter {
+	lim := NewLimiter(r, b)
+	lim.penalty = penalty
+	return lim
+}
+
 // Allow reports whether an event may happen now.
 func (lim *Limiter) Allow() bool {
 	return lim.AllowN(time.Now(), 1)
@@ -315,7 +349,10 @@ func (lim *Limiter) SetLimitAt(t time.Time, newLimit Limit) {
 
 	t, tokens := lim.advance(t)
 
-	lim.last = t
+	// a last update in the future is a penalty pause that must be kept
+	if lim.last.Before(t) {
+		lim.last = t
+	}
 	lim.tokens = tokens
 	lim.limit = newLimit
 }
@@ -332,7 +369,10 @@ func (lim *Limiter) SetBurstAt(t time.Time, newBurst int) {
 
 	t, tokens := lim.advance(t)
 
-	lim.last = t
+	// a last update in the future is a penalty pause that must be kept
+	if lim.last.Before(t) {
+		lim.last = t
+	}
 	lim.tokens = tokens
 	lim.burst = newBurst
 }
@@ -388,8 +428,12 @@ func (lim *Limiter) reserveN(t time.Time, n int, maxFutureReserve time.Duration)
 		r.tokens = 0
 		r.timeToAct = t.Add(waitDuration)
 
-		lim.last = t
-		lim.tokens = 0
+		if lim.penalty != nil {
+			lim.tokens, lim.last = lim.penalty(tokens+float64(n), lim.last, t)
+		} else {
+			lim.last = t
+			lim.tokens = 0
+		}
 		lim.lastEvent = r.timeToAct
 	}
 
@@ -0,0 +1,3 @@
+// Package shard provides helpers shared by the in-memory implementations
+// partitioned by fingerprint.
+package shard
@@ -0,0 +1,10 @@
+package shard
+
+// MaxEntries splits the maximum number of entries between the shards, rounding
+// up so the limit is never zero (no limit) by accident.
+func MaxEntries(maxEntries, shards int) int {
+	if maxEntries <= 0 {
+		return 0
+	}
+	return (maxEntries + shards - 1) / shards
+}
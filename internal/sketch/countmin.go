@@ -0,0 +1,73 @@
+package sketch
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+)
+
+// CountMin is a count-min sketch. It estimates how many times each key was
+// added, never underestimating it. With probability 1-delta, the estimate
+// exceeds the real count by at most epsilon times the total count.
+//
+// It isn't safe for concurrent use.
+type CountMin struct {
+	seed     maphash.Seed
+	width    uint64
+	depth    uint64
+	counters []uint32
+}
+
+// NewCountMin creates a count-min sketch for the error bounds, which must be
+// between 0 and 1 (exclusive), otherwise it panics. The memory used is
+// proportional to (e/epsilon)*ln(1/delta).
+func NewCountMin(epsilon, delta float64) *CountMin {
+	if !(epsilon > 0 && epsilon < 1) || !(delta > 0 && delta < 1) {
+		panic(fmt.Sprintf("sketch: invalid error bounds epsilon=%v delta=%v", epsilon, delta))
+	}
+
+	width := uint64(math.Ceil(math.E / epsilon))
+	depth := max(uint64(math.Ceil(math.Log(1/delta))), 1)
+	return &CountMin{
+		seed:     maphash.MakeSeed(),
+		width:    width,
+		depth:    depth,
+		counters: make([]uint32, width*depth),
+	}
+}
+
+// Add increments the count of the key, returning its new estimate.
+func (c *CountMin) Add(key string) uint32 {
+	estimate := uint32(math.MaxUint32)
+	c.each(key, func(i uint64) {
+		if c.counters[i] < math.MaxUint32 {
+			c.counters[i]++
+		}
+		estimate = min(estimate, c.counters[i])
+	})
+	return estimate
+}
+
+// Estimate returns the estimated count of the key.
+func (c *CountMin) Estimate(key string) uint32 {
+	estimate := uint32(math.MaxUint32)
+	c.each(key, func(i uint64) {
+		estimate = min(estimate, c.counters[i])
+	})
+	return estimate
+}
+
+// Reset clears all counts.
+func (c *CountMin) Reset() {
+	clear(c.counters)
+}
+
+// each calls f with the counter index of the key in each row. The row hashes
+// are derived from a single 64-bit hash (Kirsch-Mitzenmacher).
+func (c *CountMin) each(key string, f func(uint64)) {
+	hash := maphash.String(c.seed, key)
+	h1, h2 := hash&math.MaxUint32, hash>>32|1
+	for row := range c.depth {
+		f(row*c.width + (h1+row*h2)%c.width)
+	}
+}
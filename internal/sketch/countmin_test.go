@@ -0,0 +1,117 @@
+package sketch_test
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/rafaeljusto/anicetus/v2/internal/sketch"
+)
+
+func TestCountMin_Estimate(t *testing.T) {
+	tests := []struct {
+		name    string
+		epsilon float64
+		delta   float64
+	}{{
+		name:    "accurate",
+		epsilon: 0.001,
+		delta:   0.001,
+	}, {
+		name:    "default bounds",
+		epsilon: 0.01,
+		delta:   0.01,
+	}, {
+		name:    "single row",
+		epsilon: 0.1,
+		delta:   0.5,
+	}, {
+		name:    "narrowest",
+		epsilon: 0.999,
+		delta:   0.999,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			countMin := sketch.NewCountMin(tt.epsilon, tt.delta)
+
+			// a few hot keys among many cold ones
+			counts := make(map[string]uint32)
+			var total uint32
+			for i := range 20_000 {
+				key := "cold-" + strconv.Itoa(i)
+				if i%10 == 0 {
+					key = "hot-" + strconv.Itoa(i%100)
+				}
+				counts[key]++
+				total++
+				if estimate := countMin.Add(key); estimate < counts[key] {
+					t.Fatalf("key %q underestimated when added: %d < %d", key, estimate, counts[key])
+				}
+			}
+
+			bound := uint32(math.Ceil(tt.epsilon * float64(total)))
+			var exceeded int
+			for key, count := range counts {
+				estimate := countMin.Estimate(key)
+				if estimate < count {
+					t.Errorf("key %q underestimated: %d < %d", key, estimate, count)
+				}
+				if estimate > total {
+					t.Errorf("key %q estimated above the total: %d > %d", key, estimate, total)
+				}
+				if estimate-count > bound {
+					exceeded++
+				}
+			}
+			// each key exceeds the error with probability delta at most
+			if limit := int(2 * tt.delta * float64(len(counts))); exceeded > limit {
+				t.Errorf("%d keys exceeded the error of %d, expected at most %d", exceeded, bound, limit)
+			}
+		})
+	}
+}
+
+func TestCountMin_Reset(t *testing.T) {
+	countMin := sketch.NewCountMin(0.01, 0.01)
+	for range 10 {
+		countMin.Add("test")
+	}
+	if estimate := countMin.Estimate("test"); estimate != 10 {
+		t.Errorf("unexpected estimate: %d", estimate)
+	}
+
+	countMin.Reset()
+	if estimate := countMin.Estimate("test"); estimate != 0 {
+		t.Errorf("unexpected estimate after reset: %d", estimate)
+	}
+	if estimate := countMin.Estimate("unknown"); estimate != 0 {
+		t.Errorf("unexpected estimate of unknown key: %d", estimate)
+	}
+}
+
+func TestNewCountMin_invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		epsilon float64
+		delta   float64
+	}{
+		{name: "zero epsilon", epsilon: 0, delta: 0.01},
+		{name: "negative epsilon", epsilon: -0.1, delta: 0.01},
+		{name: "epsilon of one", epsilon: 1, delta: 0.01},
+		{name: "zero delta", epsilon: 0.01, delta: 0},
+		{name: "delta above one", epsilon: 0.01, delta: 10},
+		{name: "not a number", epsilon: math.NaN(), delta: 0.01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("sketch should not be created")
+				}
+			}()
+			sketch.NewCountMin(tt.epsilon, tt.delta)
+		})
+	}
+}
@@ -0,0 +1,3 @@
+// Package sketch implements probabilistic data structures to count and rank
+// high-cardinality keys in fixed memory.
+package sketch
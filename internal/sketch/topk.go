@@ -0,0 +1,118 @@
+package sketch
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// Item is a key ranked by TopK.
+type Item struct {
+	Key   string
+	Count uint32
+}
+
+// TopK keeps the k keys with the highest counts, as informed by the caller
+// (usually the estimates of a CountMin). When full, a new key only enters by
+// replacing the key with the lowest count, if its count is higher.
+//
+// It isn't safe for concurrent use.
+type TopK struct {
+	k     int
+	items topKHeap
+}
+
+// NewTopK creates a TopK keeping up to k keys.
+func NewTopK(k int) *TopK {
+	return &TopK{
+		k:     k,
+		items: topKHeap{index: make(map[string]int, k)},
+	}
+}
+
+// Update informs the current count of the key. It returns true when the key is
+// among the top k keys.
+func (t *TopK) Update(key string, count uint32) bool {
+	if i, ok := t.items.index[key]; ok {
+		t.items.items[i].Count = count
+		heap.Fix(&t.items, i)
+		return true
+	}
+
+	if len(t.items.items) < t.k {
+		heap.Push(&t.items, Item{Key: key, Count: count})
+		return true
+	}
+
+	if len(t.items.items) == 0 || count <= t.items.items[0].Count {
+		return false
+	}
+	delete(t.items.index, t.items.items[0].Key)
+	t.items.items[0] = Item{Key: key, Count: count}
+	t.items.index[key] = 0
+	heap.Fix(&t.items, 0)
+	return true
+}
+
+// Contains checks if the key is among the top k keys.
+func (t *TopK) Contains(key string) bool {
+	_, ok := t.items.index[key]
+	return ok
+}
+
+// Recount replaces the count of every key, removing the keys whose count is
+// zero.
+func (t *TopK) Recount(count func(key string) uint32) {
+	items := t.items.items[:0]
+	clear(t.items.index)
+	for _, item := range t.items.items {
+		if item.Count = count(item.Key); item.Count > 0 {
+			t.items.index[item.Key] = len(items)
+			items = append(items, item)
+		}
+	}
+	t.items.items = items
+	heap.Init(&t.items)
+}
+
+// Items returns the keys sorted by count, highest first.
+func (t *TopK) Items() []Item {
+	items := slices.Clone(t.items.items)
+	slices.SortFunc(items, func(a, b Item) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	return items
+}
+
+// topKHeap is a min-heap of items by count, tracking the position of each key.
+type topKHeap struct {
+	items []Item
+	index map[string]int
+}
+
+func (h topKHeap) Len() int {
+	return len(h.items)
+}
+
+func (h topKHeap) Less(i, j int) bool {
+	return h.items[i].Count < h.items[j].Count
+}
+
+func (h topKHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Key] = i
+	h.index[h.items[j].Key] = j
+}
+
+func (h *topKHeap) Push(x any) {
+	item := x.(Item)
+	h.index[item.Key] = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *topKHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, item.Key)
+	return item
+}
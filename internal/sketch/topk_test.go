@@ -0,0 +1,121 @@
+package sketch_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/rafaeljusto/anicetus/v2/internal/sketch"
+)
+
+func TestTopK_Update(t *testing.T) {
+	type update struct {
+		key   string
+		count uint32
+		want  bool
+	}
+
+	tests := []struct {
+		name      string
+		k         int
+		updates   []update
+		wantItems []sketch.Item
+	}{{
+		name: "it should keep keys while not full",
+		k:    3,
+		updates: []update{
+			{key: "a", count: 1, want: true},
+			{key: "b", count: 3, want: true},
+			{key: "c", count: 2, want: true},
+		},
+		wantItems: []sketch.Item{{Key: "b", Count: 3}, {Key: "c", Count: 2}, {Key: "a", Count: 1}},
+	}, {
+		name: "it should replace the lowest count by a higher one",
+		k:    2,
+		updates: []update{
+			{key: "a", count: 1, want: true},
+			{key: "b", count: 3, want: true},
+			{key: "c", count: 2, want: true},
+		},
+		wantItems: []sketch.Item{{Key: "b", Count: 3}, {Key: "c", Count: 2}},
+	}, {
+		name: "it should not replace the lowest count by an equal or lower one",
+		k:    2,
+		updates: []update{
+			{key: "a", count: 2, want: true},
+			{key: "b", count: 3, want: true},
+			{key: "c", count: 2, want: false},
+			{key: "d", count: 1, want: false},
+		},
+		wantItems: []sketch.Item{{Key: "b", Count: 3}, {Key: "a", Count: 2}},
+	}, {
+		name: "it should reorder keys already tracked",
+		k:    2,
+		updates: []update{
+			{key: "a", count: 1, want: true},
+			{key: "b", count: 2, want: true},
+			{key: "a", count: 5, want: true},
+			{key: "c", count: 3, want: true},
+		},
+		wantItems: []sketch.Item{{Key: "a", Count: 5}, {Key: "c", Count: 3}},
+	}, {
+		name: "it should not keep keys without room",
+		k:    0,
+		updates: []update{
+			{key: "a", count: 10, want: false},
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topK := sketch.NewTopK(tt.k)
+			for _, u := range tt.updates {
+				if ok := topK.Update(u.key, u.count); ok != u.want {
+					t.Errorf("unexpected update of %q with %d: got %v, want %v", u.key, u.count, ok, u.want)
+				}
+			}
+
+			if items := topK.Items(); !slices.Equal(items, tt.wantItems) {
+				t.Errorf("unexpected items: got %v, want %v", items, tt.wantItems)
+			}
+			for _, item := range tt.wantItems {
+				if !topK.Contains(item.Key) {
+					t.Errorf("key %q should be tracked", item.Key)
+				}
+			}
+			if len(topK.Items()) > max(tt.k, 0) {
+				t.Errorf("more than %d keys tracked", tt.k)
+			}
+		})
+	}
+}
+
+func TestTopK_Recount(t *testing.T) {
+	topK := sketch.NewTopK(3)
+	topK.Update("a", 1)
+	topK.Update("b", 2)
+	topK.Update("c", 3)
+
+	topK.Recount(func(key string) uint32 {
+		return map[string]uint32{"a": 4, "c": 1}[key]
+	})
+
+	want := []sketch.Item{{Key: "a", Count: 4}, {Key: "c", Count: 1}}
+	if items := topK.Items(); !slices.Equal(items, want) {
+		t.Errorf("unexpected items: got %v, want %v", items, want)
+	}
+	if topK.Contains("b") {
+		t.Error("key without count should be removed")
+	}
+
+	// the heap is consistent after the recount
+	if !topK.Update("d", 2) {
+		t.Error("key should fill the room left")
+	}
+	if !topK.Update("e", 3) {
+		t.Error("key should replace the lowest count")
+	}
+	want = []sketch.Item{{Key: "a", Count: 4}, {Key: "e", Count: 3}, {Key: "d", Count: 2}}
+	if items := topK.Items(); !slices.Equal(items, want) {
+		t.Errorf("unexpected items: got %v, want %v", items, want)
+	}
+}
@@ -0,0 +1,315 @@
+package bbolt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ anicetus.FencedGatekeeperStorage = &Bolt{}
+
+// bucketName is the bbolt bucket where the gates are stored.
+var bucketName = []byte("anicetus_gates")
+
+// Bolt is a file-backed storage for the fingerprints. Every write is executed
+// in a bbolt transaction, which is synced to disk before returning, so the
+// state survives crashes and restarts. The database must not be opened with
+// NoSync for these guarantees to hold.
+type Bolt struct {
+	db     *bolt.DB
+	ttl    time.Duration
+	logger *slog.Logger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewBolt creates a new file-backed storage using the bbolt database. When a
+// TTL is configured (storage.WithTTL), expired gates are purged periodically
+// until Stop is called. The database is not closed by the storage.
+func NewBolt(db *bolt.DB, options ...storage.Option) (*Bolt, error) {
+	o := storage.NewOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bbolt bucket: %w", err)
+	}
+
+	b := &Bolt{
+		db:     db,
+		ttl:    o.TTL(),
+		logger: o.Logger(),
+		stop:   make(chan struct{}),
+	}
+	if b.ttl > 0 {
+		b.start()
+	}
+	return b, nil
+}
+
+// Exists checks if the fingerprint exists in the storage.
+func (b *Bolt) Exists(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	var exists bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		_, exists = b.load(tx, fingerprint, time.Now())
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read bbolt key: %w", err)
+	}
+	return exists, nil
+}
+
+// Processed checks if the fingerprint was processed.
+func (b *Bolt) Processed(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	var processed bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		g, _ := b.load(tx, fingerprint, time.Now())
+		processed = g.processed
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read bbolt key: %w", err)
+	}
+	return processed, nil
+}
+
+// Store stores the fingerprint in the storage. Concurrent writes are combined
+// in a single transaction, saving disk syncs.
+func (b *Bolt) Store(_ context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		now := time.Now()
+		g, _ := b.load(tx, fingerprint, now)
+		g.processed = processed
+		return b.save(tx, fingerprint, g, now)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write bbolt key: %w", err)
+	}
+	return nil
+}
+
+// Remove removes the fingerprint from the storage. As it is called for every
+// request that isn't a thundering herd, the fingerprint is checked in a
+// read-only transaction first, so no disk sync happens when there is nothing
+// to remove.
+func (b *Bolt) Remove(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	var exists bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(bucketName).Get([]byte(fingerprint)) != nil
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read bbolt key: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+
+	err = b.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete([]byte(fingerprint))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete bbolt key: %w", err)
+	}
+	return nil
+}
+
+// Acquire stores the fingerprint as not processed if it doesn't exist yet,
+// returning a new fencing token. The tokens are generated from the bucket
+// sequence, so they keep increasing across restarts.
+func (b *Bolt) Acquire(_ context.Context, fingerprint anicetus.Fingerprint) (anicetus.FencingToken, bool, error) {
+	var token anicetus.FencingToken
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		if _, exists := b.load(tx, fingerprint, now); exists {
+			return nil
+		}
+		sequence, err := tx.Bucket(bucketName).NextSequence()
+		if err != nil {
+			return err
+		}
+		token = anicetus.FencingToken(sequence)
+		return b.save(tx, fingerprint, gate{token: token}, now)
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to write bbolt key: %w", err)
+	}
+	return token, token != 0, nil
+}
+
+// StoreWithToken stores the fingerprint in the storage if the token still owns
+// the gate.
+func (b *Bolt) StoreWithToken(
+	_ context.Context,
+	fingerprint anicetus.Fingerprint,
+	processed bool,
+	token anicetus.FencingToken,
+) error {
+	var stale bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		g, exists := b.load(tx, fingerprint, now)
+		if stale = !exists || g.token != token; stale {
+			return nil
+		}
+		g.processed = processed
+		return b.save(tx, fingerprint, g, now)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write bbolt key: %w", err)
+	}
+	if stale {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	return nil
+}
+
+// RemoveWithToken removes the fingerprint from the storage if the token still
+// owns the gate.
+func (b *Bolt) RemoveWithToken(_ context.Context, fingerprint anicetus.Fingerprint, token anicetus.FencingToken) error {
+	var stale bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		g, exists := b.load(tx, fingerprint, time.Now())
+		if !exists {
+			return nil
+		}
+		if stale = g.token != token; stale {
+			return nil
+		}
+		return tx.Bucket(bucketName).Delete([]byte(fingerprint))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete bbolt key: %w", err)
+	}
+	if stale {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	return nil
+}
+
+// Stop stops purging the expired gates.
+func (b *Bolt) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+// load reads the gate of the fingerprint, ignoring it if already expired.
+func (b *Bolt) load(tx *bolt.Tx, fingerprint anicetus.Fingerprint, now time.Time) (gate, bool) {
+	data := tx.Bucket(bucketName).Get([]byte(fingerprint))
+	if data == nil {
+		return gate{}, false
+	}
+	g, err := decodeGate(data)
+	if err != nil {
+		if b.logger != nil {
+			b.logger.Error("failed to decode gate",
+				slog.String("fingerprint", fingerprint.String()),
+				slog.String("error", err.Error()),
+			)
+		}
+		return gate{}, false
+	}
+	if g.expired(now) {
+		return gate{}, false
+	}
+	return g, true
+}
+
+// save writes the gate of the fingerprint, renewing its expiration.
+func (b *Bolt) save(tx *bolt.Tx, fingerprint anicetus.Fingerprint, g gate, now time.Time) error {
+	if b.ttl > 0 {
+		g.expiresAt = now.Add(b.ttl)
+	}
+	return tx.Bucket(bucketName).Put([]byte(fingerprint), g.encode())
+}
+
+func (b *Bolt) start() {
+	go func() {
+		ticker := time.NewTicker(b.ttl)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C:
+			}
+
+			if err := b.purge(time.Now()); err != nil && b.logger != nil {
+				b.logger.Error("failed to purge expired gates", slog.String("error", err.Error()))
+			}
+		}
+	}()
+}
+
+// purge removes all the expired gates from the database.
+func (b *Bolt) purge(now time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(bucketName).Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			if g, err := decodeGate(value); err == nil && !g.expired(now) {
+				continue
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// gate is the state of a gate persisted in the database.
+type gate struct {
+	processed bool
+	token     anicetus.FencingToken
+	expiresAt time.Time
+}
+
+// gateSize is the size of the encoded gate: processed flag, fencing token and
+// expiration (unix nanoseconds, zero when it never expires).
+const gateSize = 1 + 8 + 8
+
+func (g gate) expired(now time.Time) bool {
+	return !g.expiresAt.IsZero() && !now.Before(g.expiresAt)
+}
+
+func (g gate) encode() []byte {
+	data := make([]byte, gateSize)
+	if g.processed {
+		data[0] = 1
+	}
+	binary.BigEndian.PutUint64(data[1:9], uint64(g.token))
+	if !g.expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(data[9:17], uint64(g.expiresAt.UnixNano()))
+	}
+	return data
+}
+
+func decodeGate(data []byte) (gate, error) {
+	if len(data) != gateSize {
+		return gate{}, fmt.Errorf("unexpected gate size %d", len(data))
+	}
+	g := gate{
+		processed: data[0] == 1,
+		token:     anicetus.FencingToken(binary.BigEndian.Uint64(data[1:9])),
+	}
+	if expiresAt := binary.BigEndian.Uint64(data[9:17]); expiresAt != 0 {
+		g.expiresAt = time.Unix(0, int64(expiresAt))
+	}
+	return g, nil
+}
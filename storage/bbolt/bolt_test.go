@@ -0,0 +1,232 @@
+package bbolt_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+	"github.com/rafaeljusto/anicetus/v2/storage/bbolt"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBolt_lifecycle(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := newBolt(t, openDB(t, filepath.Join(t.TempDir(), "anicetus.db")))
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("unexpected fingerprint exists")
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("unexpected fingerprint processed")
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should exists")
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be processed")
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not exists")
+	}
+}
+
+func TestBolt_fencing(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := newBolt(t, openDB(t, filepath.Join(t.TempDir(), "anicetus.db")))
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be acquired twice")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	newToken, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	} else if newToken <= token {
+		t.Errorf("fencing token should increase: got %d, previous %d", newToken, token)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBolt_ttl(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := newBolt(t, openDB(t, filepath.Join(t.TempDir(), "anicetus.db")),
+		storage.WithTTL(100*time.Millisecond),
+	)
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should be expired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be acquired after expiration")
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBolt_persistence(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+	path := filepath.Join(t.TempDir(), "anicetus.db")
+
+	db := openDB(t, path)
+	storage := newBolt(t, db)
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+	storage.Stop()
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+
+	storage = newBolt(t, openDB(t, path))
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should survive a restart")
+	}
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	newToken, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	} else if newToken <= token {
+		t.Errorf("fencing token should increase across restarts: got %d, previous %d", newToken, token)
+	}
+}
+
+func openDB(t *testing.T, path string) *bolt.DB {
+	t.Helper()
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func newBolt(t *testing.T, db *bolt.DB, options ...storage.Option) *bbolt.Bolt {
+	t.Helper()
+
+	storage, err := bbolt.NewBolt(db, options...)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	t.Cleanup(storage.Stop)
+	return storage
+}
+
+func TestBolt_conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) anicetus.GatekeeperStorage {
+		return newBolt(t, openDB(t, filepath.Join(t.TempDir(), "anicetus.db")))
+	})
+}
@@ -0,0 +1,5 @@
+// Package bbolt provides a storage solution persisted in a local file. This is
+// an implementation using the https://github.com/etcd-io/bbolt embedded
+// key/value database, useful for single node deployments that need to keep the
+// state across restarts without running an external database.
+package bbolt
@@ -0,0 +1,3 @@
+// Package gomemcache provides a storage solution using memcached. This is an
+// implementation using the https://github.com/bradfitz/gomemcache client.
+package gomemcache
@@ -0,0 +1,293 @@
+package gomemcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+)
+
+var _ anicetus.FencedGatekeeperStorage = &Memcache{}
+
+// maxCASAttempts is the number of times a compare-and-swap is retried when
+// concurrent writers modify the same gate.
+const maxCASAttempts = 100
+
+// errTooManyConflicts is returned when a compare-and-swap could not be applied
+// after many attempts.
+var errTooManyConflicts = errors.New("too many concurrent memcached updates")
+
+// Memcache is a memcached storage for the fingerprints. The leader election
+// relies on the atomic memcached add command, and updates are applied with
+// compare-and-swap.
+//
+// Memcached may evict items under memory pressure, so gates and the fencing
+// token counter are not durable. An evicted gate allows a new leader to be
+// elected, like an expired one.
+type Memcache struct {
+	client *memcache.Client
+	ttl    time.Duration
+	logger *slog.Logger
+}
+
+// NewMemcache creates a new memcached storage. When a TTL is configured
+// (storage.WithTTL), it's rounded up to seconds, the memcached resolution.
+func NewMemcache(client *memcache.Client, options ...storage.Option) *Memcache {
+	o := storage.NewOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &Memcache{
+		client: client,
+		ttl:    o.TTL(),
+		logger: o.Logger(),
+	}
+}
+
+// Exists checks if the fingerprint exists in the storage.
+func (m *Memcache) Exists(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	_, exists, err := m.load(fingerprint)
+	return exists, err
+}
+
+// Processed checks if the fingerprint was processed.
+func (m *Memcache) Processed(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	g, _, err := m.load(fingerprint)
+	return g.processed, err
+}
+
+// Store stores the fingerprint in the storage.
+func (m *Memcache) Store(_ context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
+	return m.update(fingerprint, func(g gate, _ bool) (gate, error) {
+		g.processed = processed
+		return g, nil
+	})
+}
+
+// Remove removes the fingerprint from the storage.
+func (m *Memcache) Remove(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	err := m.client.Delete(addKeyPrefix(fingerprint))
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return fmt.Errorf("failed to delete memcached key: %w", err)
+	}
+	return nil
+}
+
+// Acquire stores the fingerprint as not processed if it doesn't exist yet,
+// returning a new fencing token.
+func (m *Memcache) Acquire(_ context.Context, fingerprint anicetus.Fingerprint) (anicetus.FencingToken, bool, error) {
+	token, err := m.nextToken()
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = m.client.Add(&memcache.Item{
+		Key:        addKeyPrefix(fingerprint),
+		Value:      gate{token: anicetus.FencingToken(token)}.encode(),
+		Expiration: m.expiration(),
+	})
+	if errors.Is(err, memcache.ErrNotStored) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("failed to add memcached key: %w", err)
+	}
+	return anicetus.FencingToken(token), true, nil
+}
+
+// StoreWithToken stores the fingerprint in the storage if the token still owns
+// the gate.
+func (m *Memcache) StoreWithToken(
+	_ context.Context,
+	fingerprint anicetus.Fingerprint,
+	processed bool,
+	token anicetus.FencingToken,
+) error {
+	return m.update(fingerprint, func(g gate, exists bool) (gate, error) {
+		if !exists || g.token != token {
+			return gate{}, &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+		}
+		g.processed = processed
+		return g, nil
+	})
+}
+
+// RemoveWithToken removes the fingerprint from the storage if the token still
+// owns the gate.
+func (m *Memcache) RemoveWithToken(_ context.Context, fingerprint anicetus.Fingerprint, token anicetus.FencingToken) error {
+	key := addKeyPrefix(fingerprint)
+	for range maxCASAttempts {
+		item, err := m.client.Get(key)
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get memcached key: %w", err)
+		}
+
+		g, err := decodeGate(item.Value)
+		if err != nil {
+			return err
+		}
+		if g.token != token {
+			return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+		}
+
+		// memcached has no conditional delete, so the item is swapped with one
+		// that expires immediately
+		item.Expiration = -1
+		err = m.client.CompareAndSwap(item)
+		if errors.Is(err, memcache.ErrNotStored) {
+			return nil
+		} else if errors.Is(err, memcache.ErrCASConflict) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to swap memcached key: %w", err)
+		}
+		return nil
+	}
+	return errTooManyConflicts
+}
+
+// load retrieves the gate of the fingerprint.
+func (m *Memcache) load(fingerprint anicetus.Fingerprint) (gate, bool, error) {
+	item, err := m.client.Get(addKeyPrefix(fingerprint))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return gate{}, false, nil
+	} else if err != nil {
+		return gate{}, false, fmt.Errorf("failed to get memcached key: %w", err)
+	}
+
+	g, err := decodeGate(item.Value)
+	if err != nil {
+		return gate{}, false, err
+	}
+	return g, true, nil
+}
+
+// update applies the change to the gate of the fingerprint, retrying if it was
+// concurrently modified. A missing gate is created.
+func (m *Memcache) update(fingerprint anicetus.Fingerprint, change func(g gate, exists bool) (gate, error)) error {
+	key := addKeyPrefix(fingerprint)
+	for range maxCASAttempts {
+		item, err := m.client.Get(key)
+		exists := err == nil
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return fmt.Errorf("failed to get memcached key: %w", err)
+		}
+
+		var g gate
+		if exists {
+			if g, err = decodeGate(item.Value); err != nil {
+				return err
+			}
+		}
+		if g, err = change(g, exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			err = m.client.Add(&memcache.Item{
+				Key:        key,
+				Value:      g.encode(),
+				Expiration: m.expiration(),
+			})
+		} else {
+			item.Value = g.encode()
+			item.Expiration = m.expiration()
+			err = m.client.CompareAndSwap(item)
+		}
+		if errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCASConflict) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to store memcached key: %w", err)
+		}
+		return nil
+	}
+	return errTooManyConflicts
+}
+
+// nextToken increments the fencing token counter, creating it when missing.
+func (m *Memcache) nextToken() (uint64, error) {
+	for range maxCASAttempts {
+		token, err := m.client.Increment(fencingKey, 1)
+		if err == nil {
+			return token, nil
+		} else if !errors.Is(err, memcache.ErrCacheMiss) {
+			return 0, fmt.Errorf("failed to increment memcached fencing token: %w", err)
+		}
+
+		err = m.client.Add(&memcache.Item{Key: fencingKey, Value: []byte("0")})
+		if err != nil && !errors.Is(err, memcache.ErrNotStored) {
+			return 0, fmt.Errorf("failed to add memcached fencing token: %w", err)
+		}
+	}
+	return 0, errTooManyConflicts
+}
+
+// expiration returns the memcached expiration of the gates in seconds.
+func (m *Memcache) expiration() int32 {
+	if m.ttl <= 0 {
+		return 0
+	}
+	seconds := math.Ceil(m.ttl.Seconds())
+	if seconds > relativeExpirationLimit {
+		// memcached handles big expirations as unix timestamps
+		return int32(time.Now().Add(m.ttl).Unix())
+	}
+	return int32(seconds)
+}
+
+// gate is the state stored in memcached for each fingerprint.
+type gate struct {
+	processed bool
+	token     anicetus.FencingToken
+}
+
+// encode encodes the gate as "<processed> <token>".
+func (g gate) encode() []byte {
+	return []byte(strconv.Itoa(boolToInt(g.processed)) + " " + strconv.FormatUint(uint64(g.token), 10))
+}
+
+// decodeGate decodes a gate encoded by gate.encode.
+func decodeGate(data []byte) (gate, error) {
+	processed, token, ok := strings.Cut(string(data), " ")
+	if !ok {
+		return gate{}, fmt.Errorf("invalid memcached gate %q", data)
+	}
+	t, err := strconv.ParseUint(token, 10, 64)
+	if err != nil {
+		return gate{}, fmt.Errorf("invalid memcached gate token %q: %w", token, err)
+	}
+	return gate{processed: processed == "1", token: anicetus.FencingToken(t)}, nil
+}
+
+// relativeExpirationLimit is the maximum expiration, in seconds, that memcached
+// handles as relative to the current time.
+const relativeExpirationLimit = 60 * 60 * 24 * 30
+
+// fencingKey is the memcached key of the fencing token counter.
+const fencingKey = "anicetus:fencing"
+
+// addKeyPrefix adds the key prefix to the fingerprint to correctly set the
+// scope.
+func addKeyPrefix(fingerprint anicetus.Fingerprint) string {
+	return keyPrefix + fingerprint.String()
+}
+
+// keyPrefix is the scope of the gate keys.
+const keyPrefix = "anicetus:gate:"
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,220 @@
+package gomemcache_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/memcachetest"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+	"github.com/rafaeljusto/anicetus/v2/storage/gomemcache"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
+)
+
+func TestMemcache_lifecycle(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := gomemcache.NewMemcache(newClient(t))
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("unexpected fingerprint exists")
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("unexpected fingerprint processed")
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should exists")
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be processed")
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not exists")
+	}
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMemcache_fencing(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := gomemcache.NewMemcache(newClient(t))
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be acquired twice")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	newToken, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	} else if newToken <= token {
+		t.Errorf("fencing token should increase: got %d, previous %d", newToken, token)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMemcache_ttl(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := gomemcache.NewMemcache(newClient(t), storage.WithTTL(time.Second))
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should be expired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be acquired after expiration")
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMemcache_singleLeader(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	client := newClient(t)
+	replicas := []*gomemcache.Memcache{
+		gomemcache.NewMemcache(client),
+		gomemcache.NewMemcache(client),
+		gomemcache.NewMemcache(client),
+	}
+
+	var leaders atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func(storage *gomemcache.Memcache) {
+			defer wg.Done()
+
+			_, ok, err := storage.Acquire(t.Context(), fingerprint)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if ok {
+				leaders.Add(1)
+			}
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+
+	if n := leaders.Load(); n != 1 {
+		t.Errorf("unexpected number of leaders: %d", n)
+	}
+}
+
+func newClient(t *testing.T) *memcache.Client {
+	t.Helper()
+
+	server, err := memcachetest.NewServer()
+	if err != nil {
+		t.Fatalf("failed to start memcached server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	client := memcache.New(server.Addr())
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func TestMemcache_conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) anicetus.GatekeeperStorage {
+		return gomemcache.NewMemcache(newClient(t))
+	})
+}
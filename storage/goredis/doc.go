@@ -0,0 +1,4 @@
+// Package goredis provides a storage solution using Redis. This is an
+// implementation using the https://github.com/redis/go-redis client. Different
+// Redis clients may be available to allow easy integration with codebases.
+package goredis
@@ -0,0 +1,190 @@
+package goredis
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// subscriberBufferSize is the number of gate events buffered for each
+// subscriber. Events are dropped when a slow subscriber has a full buffer.
+const subscriberBufferSize = 8
+
+// gateEvents dispatches the gate events received from Redis to the local
+// subscribers. A single Redis subscription is shared by all of them, and is
+// kept only while there are subscribers.
+type gateEvents struct {
+	client redis.UniversalClient
+	logger *slog.Logger
+
+	mutex        sync.Mutex
+	subscribers  map[anicetus.Fingerprint]map[chan anicetus.GateEvent]struct{}
+	subscription *subscription
+	// ready is closed when the current subscription is active.
+	ready chan struct{}
+}
+
+func newGateEvents(client redis.UniversalClient, logger *slog.Logger) *gateEvents {
+	return &gateEvents{
+		client:      client,
+		logger:      logger,
+		subscribers: make(map[anicetus.Fingerprint]map[chan anicetus.GateEvent]struct{}),
+	}
+}
+
+// subscribe registers a subscriber for the fingerprint, waiting for the Redis
+// subscription to be active.
+func (g *gateEvents) subscribe(ctx context.Context, fingerprint anicetus.Fingerprint) (<-chan anicetus.GateEvent, error) {
+	events := make(chan anicetus.GateEvent, subscriberBufferSize)
+
+	g.mutex.Lock()
+	if g.subscription == nil {
+		g.start()
+	}
+	if g.subscribers[fingerprint] == nil {
+		g.subscribers[fingerprint] = make(map[chan anicetus.GateEvent]struct{})
+	}
+	g.subscribers[fingerprint][events] = struct{}{}
+	s, ready := g.subscription, g.ready
+	g.mutex.Unlock()
+
+	select {
+	case <-ready:
+	case <-s.Done():
+		// the subscription failed and the subscribers were already released
+		return nil, fmt.Errorf("failed to subscribe to redis gate events: %w", s.Err())
+	case <-ctx.Done():
+		g.unsubscribe(fingerprint, events)
+		return nil, ctx.Err()
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			g.unsubscribe(fingerprint, events)
+		case <-s.Done():
+		}
+	}()
+	return events, nil
+}
+
+// unsubscribe removes the subscriber, ending the Redis subscription when there
+// are no more subscribers.
+func (g *gateEvents) unsubscribe(fingerprint anicetus.Fingerprint, events chan anicetus.GateEvent) {
+	g.mutex.Lock()
+	if _, ok := g.subscribers[fingerprint][events]; !ok {
+		// already released
+		g.mutex.Unlock()
+		return
+	}
+	delete(g.subscribers[fingerprint], events)
+	if len(g.subscribers[fingerprint]) == 0 {
+		delete(g.subscribers, fingerprint)
+	}
+	close(events)
+
+	var s *subscription
+	if len(g.subscribers) == 0 {
+		s, g.subscription = g.subscription, nil
+	}
+	g.mutex.Unlock()
+
+	if s != nil {
+		// stop outside the lock, as the receiver may be dispatching an event
+		s.Stop()
+	}
+}
+
+// start subscribes to the Redis events channel. The mutex must be held by the
+// caller.
+func (g *gateEvents) start() {
+	ready := make(chan struct{})
+	var readyOnce sync.Once
+
+	s := subscribe(g.client, EventsChannel,
+		func() {
+			readyOnce.Do(func() {
+				close(ready)
+			})
+		},
+		g.dispatch,
+	)
+	g.subscription, g.ready = s, ready
+
+	go func() {
+		<-s.Done()
+		if err := s.Err(); err != nil {
+			g.release(s)
+			if g.logger != nil {
+				g.logger.Warn("redis gate events subscription lost", slog.String("error", err.Error()))
+			}
+		}
+	}()
+}
+
+// release closes all the subscribers of the failed subscription, so they can
+// check the gate state again.
+func (g *gateEvents) release(s *subscription) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.subscription != s {
+		return
+	}
+	for fingerprint, subscribers := range g.subscribers {
+		for events := range subscribers {
+			close(events)
+		}
+		delete(g.subscribers, fingerprint)
+	}
+	g.subscription = nil
+}
+
+// dispatch sends the gate event to the subscribers of the fingerprint.
+func (g *gateEvents) dispatch(data []byte) {
+	event, err := parseGateEvent(data)
+	if err != nil {
+		if g.logger != nil {
+			g.logger.Warn("invalid redis gate event", slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for events := range g.subscribers[event.Fingerprint] {
+		select {
+		case events <- event:
+		default:
+			// slow subscriber, the gate state can still be checked directly
+		}
+	}
+}
+
+// parseGateEvent parses a message published on the events channel.
+func parseGateEvent(data []byte) (anicetus.GateEvent, error) {
+	parts := strings.SplitN(string(data), " ", 3)
+	if len(parts) != 3 {
+		return anicetus.GateEvent{}, fmt.Errorf("invalid gate event %q", data)
+	}
+	eventType, err := anicetus.ParseGateEventType(parts[0])
+	if err != nil {
+		return anicetus.GateEvent{}, err
+	}
+	token, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return anicetus.GateEvent{}, fmt.Errorf("invalid gate event token %q: %w", parts[1], err)
+	}
+	return anicetus.GateEvent{
+		Fingerprint: anicetus.Fingerprint(parts[2]),
+		Type:        eventType,
+		Token:       anicetus.FencingToken(token),
+	}, nil
+}
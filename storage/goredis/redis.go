@@ -0,0 +1,414 @@
+package goredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	_ anicetus.FencedGatekeeperStorage        = &Redis{}
+	_ anicetus.GatekeeperStorageInspector     = &Redis{}
+	_ anicetus.GatekeeperStorageSubscriber    = &Redis{}
+	_ anicetus.GatekeeperStorageWaiterCounter = &Redis{}
+
+	countWaiterScript = redis.NewScript(`
+-- Count waiters of the gate, if it exists and wasn't processed yet
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Number of waiters
+
+if redis.call("HGET", KEYS[1], "processed") == "0" then
+  redis.call("HINCRBY", KEYS[1], "waiters", ARGV[1])
+end
+return 1
+`)
+
+	storeScript = redis.NewScript(`
+-- Store the gate state, publishing the transition
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Processed flag
+-- ARGV[2]: Events channel
+-- ARGV[3]: Fingerprint of the gate
+
+local current_time = redis.call("TIME")
+local now = tonumber(current_time[1]) * 1000 + math.floor(tonumber(current_time[2]) / 1000)
+
+redis.call("HSETNX", KEYS[1], "since", now)
+redis.call("HSET", KEYS[1], "processed", ARGV[1])
+
+local token = redis.call("HGET", KEYS[1], "token") or "0"
+local event = ARGV[1] == "1" and "done" or "elected"
+redis.call("PUBLISH", ARGV[2], event .. " " .. token .. " " .. ARGV[3])
+return 1
+`)
+
+	removeScript = redis.NewScript(`
+-- Remove the gate, publishing the transition
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Events channel
+-- ARGV[2]: Fingerprint of the gate
+
+local token = redis.call("HGET", KEYS[1], "token")
+if redis.call("DEL", KEYS[1]) == 1 then
+  redis.call("PUBLISH", ARGV[1], "cleanup " .. (token or "0") .. " " .. ARGV[2])
+end
+return 1
+`)
+
+	acquireScript = redis.NewScript(`
+-- Acquire the gate with a new fencing token, publishing the transition
+-- KEYS[1]: The Redis key for storing the gate
+-- KEYS[2]: The Redis key for generating the fencing tokens of the gate
+-- ARGV[1]: Events channel
+-- ARGV[2]: Fingerprint of the gate
+-- ARGV[3]: Expiration of the fencing tokens key in milliseconds
+
+if redis.call("EXISTS", KEYS[1]) == 1 then
+  return 0 -- Gate already exists
+end
+
+local current_time = redis.call("TIME")
+local now = tonumber(current_time[1]) * 1000 + math.floor(tonumber(current_time[2]) / 1000)
+
+-- The token is never lower than the current time in microseconds, so it keeps
+-- increasing after the fencing tokens key expires
+local token = math.max(
+  tonumber(redis.call("GET", KEYS[2]) or "0") + 1,
+  tonumber(current_time[1]) * 1000000 + tonumber(current_time[2])
+)
+token = string.format("%.0f", token)
+redis.call("SET", KEYS[2], token, "PX", ARGV[3])
+
+redis.call("HSET", KEYS[1], "processed", 0, "token", token, "since", now)
+redis.call("PUBLISH", ARGV[1], "elected " .. token .. " " .. ARGV[2])
+return token -- Acquired
+`)
+
+	storeWithTokenScript = redis.NewScript(`
+-- Store the gate state if the fencing token still owns it, publishing the
+-- transition
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Processed flag
+-- ARGV[2]: Fencing token of the leader
+-- ARGV[3]: Events channel
+-- ARGV[4]: Fingerprint of the gate
+
+local token = redis.call("HGET", KEYS[1], "token")
+if not token or token ~= ARGV[2] then
+  return 0 -- Stale token
+end
+
+redis.call("HSET", KEYS[1], "processed", ARGV[1])
+
+local event = ARGV[1] == "1" and "done" or "elected"
+redis.call("PUBLISH", ARGV[3], event .. " " .. token .. " " .. ARGV[4])
+return 1 -- Stored
+`)
+
+	removeWithTokenScript = redis.NewScript(`
+-- Remove the gate if the fencing token still owns it, publishing the transition
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Fencing token of the leader
+-- ARGV[2]: Events channel
+-- ARGV[3]: Fingerprint of the gate
+
+local token = redis.call("HGET", KEYS[1], "token")
+if not token then
+  return 1 -- Nothing to remove
+end
+if token ~= ARGV[1] then
+  return 0 -- Stale token
+end
+
+redis.call("DEL", KEYS[1])
+redis.call("PUBLISH", ARGV[2], "cleanup " .. token .. " " .. ARGV[3])
+return 1 -- Removed
+`)
+)
+
+// Redis is a redis storage for the fingerprints. Every gate state transition
+// is published on the EventsChannel.
+type Redis struct {
+	client redis.UniversalClient
+	logger *slog.Logger
+	events *gateEvents
+}
+
+// NewRedis creates a new redis storage.
+func NewRedis(client redis.UniversalClient, options ...storage.Option) *Redis {
+	o := storage.NewOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return &Redis{
+		client: client,
+		logger: o.Logger(),
+		events: newGateEvents(client, o.Logger()),
+	}
+}
+
+// Exists checks if the fingerprint exists in the storage.
+func (r *Redis) Exists(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	result, err := r.client.Exists(ctx, addKeyPrefix(fingerprint)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check redis key: %w", err)
+	}
+	return result == 1, nil
+}
+
+// Processed checks if the fingerprint was processed.
+func (r *Redis) Processed(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	result, err := r.client.HGet(ctx, addKeyPrefix(fingerprint), "processed").Bool()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get redis key: %w", err)
+	}
+	return result, nil
+}
+
+// CountWaiter increments the number of waiters of a gate not processed yet.
+func (r *Redis) CountWaiter(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	err := countWaiterScript.Run(ctx, r.client, []string{addKeyPrefix(fingerprint)}, 1).Err()
+	if err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	return nil
+}
+
+// Store stores the fingerprint in the storage.
+func (r *Redis) Store(ctx context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
+	err := storeScript.Run(ctx, r.client, []string{addKeyPrefix(fingerprint)},
+		boolToInt(processed),
+		EventsChannel,
+		fingerprint.String(),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	return nil
+}
+
+// Remove removes the fingerprint from the storage.
+func (r *Redis) Remove(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	err := removeScript.Run(ctx, r.client, []string{addKeyPrefix(fingerprint)},
+		EventsChannel,
+		fingerprint.String(),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	return nil
+}
+
+// Acquire stores the fingerprint as not processed if it doesn't exist yet,
+// returning a new fencing token.
+func (r *Redis) Acquire(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.FencingToken, bool, error) {
+	token, err := acquireScript.Run(ctx, r.client, []string{addKeyPrefix(fingerprint), fencingKey(fingerprint)},
+		EventsChannel,
+		fingerprint.String(),
+		fencingKeyTTL.Milliseconds(),
+	).Uint64()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	if token == 0 {
+		return 0, false, nil
+	}
+	return anicetus.FencingToken(token), true, nil
+}
+
+// StoreWithToken stores the fingerprint in the storage if the token still owns
+// the gate.
+func (r *Redis) StoreWithToken(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+	processed bool,
+	token anicetus.FencingToken,
+) error {
+	stored, err := storeWithTokenScript.Run(ctx, r.client, []string{addKeyPrefix(fingerprint)},
+		boolToInt(processed),
+		strconv.FormatUint(uint64(token), 10),
+		EventsChannel,
+		fingerprint.String(),
+	).Bool()
+	if err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	if !stored {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	return nil
+}
+
+// RemoveWithToken removes the fingerprint from the storage if the token still
+// owns the gate.
+func (r *Redis) RemoveWithToken(ctx context.Context, fingerprint anicetus.Fingerprint, token anicetus.FencingToken) error {
+	removed, err := removeWithTokenScript.Run(ctx, r.client, []string{addKeyPrefix(fingerprint)},
+		strconv.FormatUint(uint64(token), 10),
+		EventsChannel,
+		fingerprint.String(),
+	).Bool()
+	if err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	if !removed {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	return nil
+}
+
+// ListGates returns a page of gates using the Redis SCAN cursor. When using a
+// cluster client, the scan is executed in a single node.
+func (r *Redis) ListGates(ctx context.Context, cursor string, limit int) ([]anicetus.GateState, string, error) {
+	var scanCursor uint64
+	if cursor != "" {
+		var err error
+		if scanCursor, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", fmt.Errorf("invalid cursor %q: %w", cursor, err)
+		}
+	}
+
+	keys, scanCursor, err := r.client.Scan(ctx, scanCursor, addKeyPrefix("*"), int64(max(limit, 0))).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan redis keys: %w", err)
+	}
+	if scanCursor != 0 {
+		cursor = strconv.FormatUint(scanCursor, 10)
+	} else {
+		cursor = ""
+	}
+
+	now, err := r.client.Time(ctx).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get redis time: %w", err)
+	}
+
+	pipeline := r.client.Pipeline()
+	commands := make([]*redis.SliceCmd, len(keys))
+	for i, key := range keys {
+		commands[i] = pipeline.HMGet(ctx, key, gateFields...)
+	}
+	if len(keys) > 0 {
+		if _, err := pipeline.Exec(ctx); err != nil {
+			return nil, "", fmt.Errorf("failed to get redis keys: %w", err)
+		}
+	}
+
+	gates := make([]anicetus.GateState, 0, len(keys))
+	for i, key := range keys {
+		if gate, ok := parseGateState(removeKeyPrefix(key), commands[i].Val(), now); ok {
+			gates = append(gates, gate)
+		}
+	}
+	return gates, cursor, nil
+}
+
+// InspectGate returns the gate state of the fingerprint.
+func (r *Redis) InspectGate(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.GateState, bool, error) {
+	now, err := r.client.Time(ctx).Result()
+	if err != nil {
+		return anicetus.GateState{}, false, fmt.Errorf("failed to get redis time: %w", err)
+	}
+
+	fields, err := r.client.HMGet(ctx, addKeyPrefix(fingerprint), gateFields...).Result()
+	if err != nil {
+		return anicetus.GateState{}, false, fmt.Errorf("failed to get redis key: %w", err)
+	}
+	gate, ok := parseGateState(fingerprint, fields, now)
+	return gate, ok, nil
+}
+
+// Subscribe returns a channel receiving the gate events of the fingerprint,
+// published by any replica sharing the Redis database. A single Redis
+// subscription is shared by all the subscribers of the storage, and events are
+// dropped for subscribers that don't keep up.
+func (r *Redis) Subscribe(ctx context.Context, fingerprint anicetus.Fingerprint) (<-chan anicetus.GateEvent, error) {
+	return r.events.subscribe(ctx, fingerprint)
+}
+
+// gateFields are the fields of the gate hash in the same order expected by
+// parseGateState.
+var gateFields = []string{"processed", "token", "since", "waiters"}
+
+// parseGateState parses the gate hash fields. It returns false if the gate
+// doesn't exist.
+func parseGateState(fingerprint anicetus.Fingerprint, fields []any, now time.Time) (anicetus.GateState, bool) {
+	if len(fields) != len(gateFields) || fields[0] == nil {
+		return anicetus.GateState{}, false
+	}
+
+	field := func(i int) string {
+		value, _ := fields[i].(string)
+		return value
+	}
+
+	gate := anicetus.GateState{
+		Fingerprint: fingerprint,
+		Processed:   field(0) == "1",
+	}
+	if token, err := strconv.ParseUint(field(1), 10, 64); err == nil {
+		gate.Token = anicetus.FencingToken(token)
+	}
+	if since, err := strconv.ParseInt(field(2), 10, 64); err == nil {
+		gate.LeaderAge = max(now.Sub(time.UnixMilli(since)), 0)
+	}
+	if waiters, err := strconv.ParseInt(field(3), 10, 64); err == nil {
+		gate.Waiters = waiters
+	}
+	return gate, true
+}
+
+// EventsChannel is the Redis channel where the gate state transitions are
+// published. Each message has the format "<event type> <token> <fingerprint>".
+const EventsChannel = "anicetus:gate:events"
+
+// addKeyPrefix adds the key prefix to the fingerprint to correctly set the
+// scope. The fingerprint is a hash tag, so the gate and its fencing key are in
+// the same Redis Cluster slot.
+func addKeyPrefix(fingerprint anicetus.Fingerprint) string {
+	return keyPrefix + "{" + fingerprint.String() + "}"
+}
+
+// removeKeyPrefix removes the key prefix, returning the fingerprint.
+func removeKeyPrefix(key string) anicetus.Fingerprint {
+	key = strings.TrimPrefix(key, keyPrefix)
+	key = strings.TrimPrefix(key, "{")
+	key = strings.TrimSuffix(key, "}")
+	return anicetus.Fingerprint(key)
+}
+
+// fencingKey is the Redis key used to generate the fencing tokens of the
+// fingerprint. Tokens only need to increase for the same gate, and keeping a
+// counter per fingerprint allows acquiring the gate atomically in a Redis
+// Cluster.
+func fencingKey(fingerprint anicetus.Fingerprint) string {
+	return fencingKeyPrefix + "{" + fingerprint.String() + "}"
+}
+
+// fencingKeyTTL is the expiration of the fencing tokens key of a fingerprint,
+// refreshed on every acquired gate. Tokens are seeded with the current time, so
+// they keep increasing after it expires.
+const fencingKeyTTL = 24 * time.Hour
+
+// keyPrefix is the scope of the gate keys.
+const keyPrefix = "anicetus:gate:"
+
+// fencingKeyPrefix is the scope of the fencing token keys.
+const fencingKeyPrefix = "anicetus:fencing:"
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
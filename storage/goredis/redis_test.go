@@ -0,0 +1,379 @@
+//go:build integration_tests
+// +build integration_tests
+
+package goredis_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage/goredis"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultRedisAddress = "localhost:6379"
+
+func TestRedis_lifecycle(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisAddress},
+	})
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			t.Errorf("failed to close redis client: %v", err)
+		}
+	}()
+
+	if err := redisClient.FlushDB(t.Context()).Err(); err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	storage := goredis.NewRedis(redisClient)
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("unexpected fingerprint exists")
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("unexpected fingerprint processed")
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should exists")
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be processed")
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not exists")
+	}
+}
+
+func TestRedis_fencing(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisAddress},
+	})
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			t.Errorf("failed to close redis client: %v", err)
+		}
+	}()
+
+	if err := redisClient.FlushDB(t.Context()).Err(); err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	storage := goredis.NewRedis(redisClient)
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be acquired twice")
+	}
+
+	// the gate and its fencing key must be in the same Redis Cluster slot
+	if keys, err := redisClient.Keys(t.Context(), "anicetus:*").Result(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if len(keys) != 2 || hashTag(keys[0]) == "" || hashTag(keys[0]) != hashTag(keys[1]) {
+		t.Errorf("keys should share the same hash tag: %v", keys)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	newToken, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	} else if newToken <= token {
+		t.Errorf("fencing token should increase: got %d, previous %d", newToken, token)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be processed by a stale token")
+	}
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not exists")
+	}
+}
+
+func TestRedis_inspect(t *testing.T) {
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisAddress},
+	})
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			t.Errorf("failed to close redis client: %v", err)
+		}
+	}()
+
+	if err := redisClient.FlushDB(t.Context()).Err(); err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	storage := goredis.NewRedis(redisClient)
+
+	tokens := make(map[anicetus.Fingerprint]anicetus.FencingToken)
+	for _, fingerprint := range []anicetus.Fingerprint{"a", "b", "c"} {
+		token, ok, err := storage.Acquire(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok {
+			t.Fatal("fingerprint should be acquired")
+		}
+		tokens[fingerprint] = token
+	}
+
+	// checking the gate doesn't count as a waiter
+	for range 2 {
+		if _, err := storage.Processed(t.Context(), "a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := storage.CountWaiter(t.Context(), "a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := storage.StoreWithToken(t.Context(), "b", true, tokens["b"]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	gates := make(map[anicetus.Fingerprint]anicetus.GateState)
+	var cursor string
+	for {
+		page, next, err := storage.ListGates(t.Context(), cursor, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, gate := range page {
+			gates[gate.Fingerprint] = gate
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	if len(gates) != 3 {
+		t.Fatalf("unexpected gates: %+v", gates)
+	}
+	if gate := gates["a"]; gate.Processed || gate.Waiters != 2 || gate.Token != tokens["a"] {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+	if gate := gates["b"]; !gate.Processed || gate.Waiters != 0 || gate.Token != tokens["b"] {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+
+	if gate, ok, err := storage.InspectGate(t.Context(), "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("gate should exist")
+	} else if gate.LeaderAge < 0 || gate.LeaderAge > time.Minute || gate.Token != tokens["c"] {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+
+	if _, ok, err := storage.InspectGate(t.Context(), "d"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("gate should not exist")
+	}
+}
+
+func TestRedis_cluster(t *testing.T) {
+	clusterAddresses := os.Getenv("REDIS_CLUSTER_ADDRESSES")
+	if clusterAddresses == "" {
+		t.Skip("REDIS_CLUSTER_ADDRESSES not set")
+	}
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:         strings.Split(clusterAddresses, ","),
+		IsClusterMode: true,
+	})
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			t.Errorf("failed to close redis client: %v", err)
+		}
+	}()
+
+	clusterClient, ok := redisClient.(*redis.ClusterClient)
+	if !ok {
+		t.Fatalf("unexpected redis client %T", redisClient)
+	}
+	err := clusterClient.ForEachMaster(t.Context(), func(ctx context.Context, client *redis.Client) error {
+		return client.FlushDB(ctx).Err()
+	})
+	if err != nil {
+		t.Fatalf("failed to flush redis databases: %v", err)
+	}
+
+	storage := goredis.NewRedis(redisClient)
+
+	// the fingerprints are spread over different slots
+	for _, fingerprint := range []anicetus.Fingerprint{"a", "b", "c", "d", "e"} {
+		token, ok, err := storage.Acquire(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok {
+			t.Fatal("fingerprint should be acquired")
+		}
+
+		if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if ok {
+			t.Error("fingerprint should not be acquired twice")
+		}
+
+		if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if newToken, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if !ok {
+			t.Error("fingerprint should be acquired")
+		} else if newToken <= token {
+			t.Errorf("fencing token should increase: got %d, previous %d", newToken, token)
+		}
+	}
+}
+
+func TestRedis_conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) anicetus.GatekeeperStorage {
+		return goredis.NewRedis(newRedisClient(t))
+	})
+}
+
+// hashTag returns the part of the key used by Redis Cluster to compute its
+// slot, or an empty string if the whole key is used.
+func hashTag(key string) string {
+	_, tag, ok := strings.Cut(key, "{")
+	if !ok {
+		return ""
+	}
+	tag, _, ok = strings.Cut(tag, "}")
+	if !ok {
+		return ""
+	}
+	return tag
+}
+
+// newRedisClient creates a client for an empty Redis database.
+func newRedisClient(t *testing.T) redis.UniversalClient {
+	t.Helper()
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisAddress},
+	})
+	t.Cleanup(func() {
+		if err := redisClient.Close(); err != nil {
+			t.Errorf("failed to close redis client: %v", err)
+		}
+	})
+
+	if err := redisClient.FlushDB(t.Context()).Err(); err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+	return redisClient
+}
@@ -0,0 +1,122 @@
+package goredis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// subscriptionHealthCheckInterval is the interval to ping the subscription
+// connection, detecting broken connections.
+const subscriptionHealthCheckInterval = time.Second
+
+// subscription receives the messages of a Redis channel in background, until
+// the connection fails or it's stopped.
+type subscription struct {
+	pubsub      *redis.PubSub
+	onSubscribe func()
+	onMessage   func(data []byte)
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// subscribe starts receiving the messages of the channel. The onSubscribe
+// callback is called once the subscription is active, and onMessage for each
+// received message, both from the receiving goroutine.
+func subscribe(
+	client redis.UniversalClient,
+	channel string,
+	onSubscribe func(),
+	onMessage func(data []byte),
+) *subscription {
+	s := &subscription{
+		pubsub:      client.Subscribe(context.Background(), channel),
+		onSubscribe: onSubscribe,
+		onMessage:   onMessage,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		s.err = s.receive()
+	}()
+	return s
+}
+
+// Done returns a channel that is closed when the subscription ends.
+func (s *subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the subscription ended. It's nil if it was stopped,
+// and must only be called after Done is closed.
+func (s *subscription) Err() error {
+	return s.err
+}
+
+// Stop ends the subscription, waiting for the receiving goroutine.
+func (s *subscription) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		_ = s.pubsub.Close()
+	})
+	<-s.done
+}
+
+func (s *subscription) receive() error {
+	defer func() {
+		_ = s.pubsub.Close()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepAlive(done)
+
+	for {
+		// the keep alive pings guarantee a reply before the timeout on healthy
+		// connections
+		msg, err := s.pubsub.ReceiveTimeout(context.Background(), 3*subscriptionHealthCheckInterval)
+		if err != nil {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+			}
+			return fmt.Errorf("failed to receive redis message: %w", err)
+		}
+
+		switch v := msg.(type) {
+		case *redis.Message:
+			s.onMessage([]byte(v.Payload))
+		case *redis.Subscription:
+			if v.Kind == "subscribe" {
+				s.onSubscribe()
+			}
+		}
+	}
+}
+
+// keepAlive pings the subscription connection periodically.
+func (s *subscription) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(subscriptionHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.pubsub.Ping(context.Background()); err != nil {
+			// the receiver will time out waiting for the reply
+			return
+		}
+	}
+}
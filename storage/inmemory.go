@@ -1,48 +1,386 @@
 package storage
 
 import (
+	"container/list"
 	"context"
+	"io"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rafaeljusto/anicetus/v2"
 )
 
-var _ anicetus.GatekeeperStorage = &InMemory{}
+var (
+	_ anicetus.FencedGatekeeperStorage        = &InMemory{}
+	_ anicetus.GatekeeperStorageInspector     = &InMemory{}
+	_ anicetus.GatekeeperStorageWaiterCounter = &InMemory{}
+)
 
-// InMemory is an in-memory storage for the fingerprints.
+// InMemory is an in-memory storage for the fingerprints. The number of gates
+// can be limited (WithMaxEntries), evicting the least recently used gates that
+// were already processed. Gates of active leaders are never evicted, but they
+// expire like any other gate when a TTL is configured (WithTTL), so a leader
+// that never finished doesn't block its fingerprint forever.
 type InMemory struct {
-	// data is the data stored in the storage.
-	data sync.Map
+	// mutex protects the gates and their usage order.
+	mutex sync.Mutex
+	// gates indexes the elements of the usage order by fingerprint.
+	gates map[anicetus.Fingerprint]*list.Element
+	// usage keeps the gates from the most to the least recently used.
+	usage *list.List
+	// maxEntries is the maximum number of gates kept. Zero means no limit.
+	maxEntries int
+	// ttl is the time-to-live of the gates, renewed on every write. Zero means
+	// that the gates never expire.
+	ttl time.Duration
+	// evictions is the number of gates evicted to respect maxEntries.
+	evictions atomic.Uint64
+	// tokens is the last fencing token issued by the storage, shared by the
+	// partitions of a sharded storage.
+	tokens *atomic.Uint64
+}
+
+// inMemoryEntry is the state of a gate stored in memory.
+type inMemoryEntry struct {
+	fingerprint anicetus.Fingerprint
+	processed   bool
+	token       anicetus.FencingToken
+	since       time.Time
+	expiresAt   time.Time
+	waiters     int64
 }
 
 // NewInMemory creates a new in-memory storage.
-func NewInMemory() *InMemory {
-	return &InMemory{}
+func NewInMemory(options ...Option) *InMemory {
+	o := NewOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	return newInMemory(o.MaxEntries(), o.TTL(), new(atomic.Uint64))
+}
+
+func newInMemory(maxEntries int, ttl time.Duration, tokens *atomic.Uint64) *InMemory {
+	return &InMemory{
+		gates:      make(map[anicetus.Fingerprint]*list.Element),
+		usage:      list.New(),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		tokens:     tokens,
+	}
+}
+
+// Evictions returns the number of gates evicted to respect the maximum number
+// of entries.
+func (s *InMemory) Evictions() uint64 {
+	return s.evictions.Load()
 }
 
 // Exists checks if the fingerprint exists in the storage.
 func (s *InMemory) Exists(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
-	_, ok := s.data.Load(fingerprint)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.load(fingerprint)
 	return ok, nil
 }
 
 // Processed checks if the fingerprint was processed.
 func (s *InMemory) Processed(_ context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
-	data, ok := s.data.Load(fingerprint)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.load(fingerprint)
 	if !ok {
 		return false, nil
 	}
-	return data.(bool), nil
+	return entry.processed, nil
+}
+
+// CountWaiter increments the number of waiters of a gate not processed yet.
+func (s *InMemory) CountWaiter(_ context.Context, fingerprint anicetus.Fingerprint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, ok := s.load(fingerprint); ok && !entry.processed {
+		entry.waiters++
+	}
+	return nil
 }
 
 // Store stores the fingerprint in the storage.
 func (s *InMemory) Store(_ context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
-	s.data.Store(fingerprint, processed)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, ok := s.load(fingerprint); ok {
+		entry.processed = processed
+		s.renew(entry)
+		return nil
+	}
+	s.add(fingerprint, processed, 0)
 	return nil
 }
 
 // Remove removes the fingerprint from the storage.
 func (s *InMemory) Remove(_ context.Context, fingerprint anicetus.Fingerprint) error {
-	s.data.Delete(fingerprint)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, ok := s.gates[fingerprint]; ok {
+		s.remove(element)
+	}
+	return nil
+}
+
+// Acquire stores the fingerprint as not processed if it doesn't exist yet,
+// returning a new fencing token.
+func (s *InMemory) Acquire(_ context.Context, fingerprint anicetus.Fingerprint) (anicetus.FencingToken, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.load(fingerprint); ok {
+		return 0, false, nil
+	}
+	// the token is only issued to the winner, so requests that lose the race
+	// don't contend on the counter shared by the partitions
+	token := anicetus.FencingToken(s.tokens.Add(1))
+	s.add(fingerprint, false, token)
+	return token, true, nil
+}
+
+// StoreWithToken stores the fingerprint in the storage if the token still owns
+// the gate.
+func (s *InMemory) StoreWithToken(
+	_ context.Context,
+	fingerprint anicetus.Fingerprint,
+	processed bool,
+	token anicetus.FencingToken,
+) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.load(fingerprint)
+	if !ok || entry.token != token {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	entry.processed = processed
+	s.renew(entry)
+	return nil
+}
+
+// RemoveWithToken removes the fingerprint from the storage if the token still
+// owns the gate.
+func (s *InMemory) RemoveWithToken(
+	_ context.Context,
+	fingerprint anicetus.Fingerprint,
+	token anicetus.FencingToken,
+) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.load(fingerprint)
+	if !ok {
+		return nil
+	}
+	if entry.token != token {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	s.remove(s.gates[fingerprint])
+	return nil
+}
+
+// Snapshot writes the gates of all fingerprints, so they can be restored
+// later, like after a restart.
+func (s *InMemory) Snapshot(w io.Writer) error {
+	snapshot := inMemorySnapshot{
+		LastToken: anicetus.FencingToken(s.tokens.Load()),
+	}
+	s.snapshot(&snapshot)
+	return writeInMemorySnapshot(w, &snapshot)
+}
+
+// Restore loads the gates written by Snapshot. Gates not processed yet are
+// dropped, as their leaders didn't survive the restart, and gates already in
+// the storage are kept. New fencing tokens are always greater than the
+// restored ones.
+func (s *InMemory) Restore(r io.Reader) error {
+	snapshot, err := readInMemorySnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	restoreTokens(s.tokens, snapshot.LastToken)
+	for _, gate := range snapshot.Gates {
+		s.restore(gate)
+	}
 	return nil
 }
+
+func (s *InMemory) snapshot(snapshot *inMemorySnapshot) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// from the least to the most recently used, so the usage order is restored
+	now := time.Now()
+	for element := s.usage.Back(); element != nil; element = element.Prev() {
+		entry := element.Value.(*inMemoryEntry)
+		if s.expired(entry, now) {
+			continue
+		}
+		snapshot.Gates = append(snapshot.Gates, inMemorySnapshotGate{
+			Fingerprint: entry.fingerprint,
+			Processed:   entry.processed,
+			Token:       entry.token,
+			Since:       entry.since,
+		})
+	}
+}
+
+func (s *InMemory) restore(gate inMemorySnapshotGate) {
+	if !gate.Processed {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.gates[gate.Fingerprint]; ok {
+		return
+	}
+	s.add(gate.Fingerprint, gate.Processed, gate.Token)
+	if element, ok := s.gates[gate.Fingerprint]; ok {
+		element.Value.(*inMemoryEntry).since = gate.Since
+	}
+}
+
+// load returns the gate of the fingerprint, marking it as the most recently
+// used. Expired gates are removed. The mutex must be locked.
+func (s *InMemory) load(fingerprint anicetus.Fingerprint) (*inMemoryEntry, bool) {
+	element, ok := s.gates[fingerprint]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*inMemoryEntry)
+	if s.expired(entry, time.Now()) {
+		s.remove(element)
+		return nil, false
+	}
+	s.usage.MoveToFront(element)
+	return entry, true
+}
+
+// add creates a gate elected now, evicting the least recently used processed
+// gates when there are too many of them. The mutex must be locked.
+func (s *InMemory) add(fingerprint anicetus.Fingerprint, processed bool, token anicetus.FencingToken) {
+	entry := &inMemoryEntry{
+		fingerprint: fingerprint,
+		processed:   processed,
+		token:       token,
+		since:       time.Now(),
+	}
+	s.renew(entry)
+	s.gates[fingerprint] = s.usage.PushFront(entry)
+
+	newest := s.usage.Front()
+
+	// expired gates not loaded again are dropped from the least recently used
+	// ones, so they don't pile up
+	for element := s.usage.Back(); element != newest && s.expired(element.Value.(*inMemoryEntry), entry.since); {
+		previous := element.Prev()
+		s.remove(element)
+		element = previous
+	}
+
+	for element := s.usage.Back(); element != newest && s.maxEntries > 0 && len(s.gates) > s.maxEntries; {
+		previous := element.Prev()
+		// gates not processed yet belong to active leaders
+		if element.Value.(*inMemoryEntry).processed {
+			s.remove(element)
+			s.evictions.Add(1)
+		}
+		element = previous
+	}
+}
+
+// renew extends the expiration of the gate. The mutex must be locked.
+func (s *InMemory) renew(entry *inMemoryEntry) {
+	if s.ttl > 0 {
+		entry.expiresAt = time.Now().Add(s.ttl)
+	}
+}
+
+// expired checks if the gate expired. The mutex must be locked.
+func (s *InMemory) expired(entry *inMemoryEntry, now time.Time) bool {
+	return s.ttl > 0 && !now.Before(entry.expiresAt)
+}
+
+// remove deletes the gate. The mutex must be locked.
+func (s *InMemory) remove(element *list.Element) {
+	s.usage.Remove(element)
+	delete(s.gates, element.Value.(*inMemoryEntry).fingerprint)
+}
+
+// ListGates returns a page of gates sorted by fingerprint. The cursor is the
+// last fingerprint of the previous page.
+func (s *InMemory) ListGates(
+	_ context.Context,
+	cursor string,
+	limit int,
+) ([]anicetus.GateState, string, error) {
+	var fingerprints []anicetus.Fingerprint
+	s.mutex.Lock()
+	for fingerprint := range s.gates {
+		if string(fingerprint) > cursor {
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	s.mutex.Unlock()
+	slices.Sort(fingerprints)
+
+	var next string
+	if limit > 0 && len(fingerprints) > limit {
+		fingerprints = fingerprints[:limit]
+		next = string(fingerprints[limit-1])
+	}
+
+	gates := make([]anicetus.GateState, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		if gate, ok := s.inspect(fingerprint); ok {
+			gates = append(gates, gate)
+		}
+	}
+	return gates, next, nil
+}
+
+// InspectGate returns the gate state of the fingerprint.
+func (s *InMemory) InspectGate(
+	_ context.Context,
+	fingerprint anicetus.Fingerprint,
+) (anicetus.GateState, bool, error) {
+	gate, ok := s.inspect(fingerprint)
+	return gate, ok, nil
+}
+
+func (s *InMemory) inspect(fingerprint anicetus.Fingerprint) (anicetus.GateState, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.gates[fingerprint]
+	if !ok {
+		return anicetus.GateState{}, false
+	}
+	entry := element.Value.(*inMemoryEntry)
+	if s.expired(entry, time.Now()) {
+		return anicetus.GateState{}, false
+	}
+	return anicetus.GateState{
+		Fingerprint: fingerprint,
+		Processed:   entry.processed,
+		Token:       entry.token,
+		LeaderAge:   time.Since(entry.since),
+		Waiters:     entry.waiters,
+	}, true
+}
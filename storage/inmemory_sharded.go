@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"slices"
+	"sync/atomic"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/internal/shard"
+)
+
+var (
+	_ anicetus.FencedGatekeeperStorage        = &InMemorySharded{}
+	_ anicetus.GatekeeperStorageInspector     = &InMemorySharded{}
+	_ anicetus.GatekeeperStorageWaiterCounter = &InMemorySharded{}
+)
+
+// InMemorySharded is an in-memory storage for the fingerprints, partitioned by
+// fingerprint (WithShards). Each partition is an InMemory storage with its own
+// lock, so electing leaders of different fingerprints in parallel doesn't
+// compete for the same lock. The fencing tokens are unique across partitions.
+type InMemorySharded struct {
+	shards []*InMemory
+	seed   maphash.Seed
+	tokens *atomic.Uint64
+}
+
+// NewInMemorySharded creates a new sharded in-memory storage.
+func NewInMemorySharded(options ...Option) *InMemorySharded {
+	o := NewOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	s := &InMemorySharded{
+		shards: make([]*InMemory, max(o.Shards(), 1)),
+		seed:   maphash.MakeSeed(),
+	}
+
+	maxEntries := shard.MaxEntries(o.MaxEntries(), len(s.shards))
+	s.tokens = new(atomic.Uint64)
+	for i := range s.shards {
+		s.shards[i] = newInMemory(maxEntries, o.TTL(), s.tokens)
+	}
+	return s
+}
+
+// Evictions returns the number of gates evicted to respect the maximum number
+// of entries.
+func (s *InMemorySharded) Evictions() uint64 {
+	var evictions uint64
+	for _, shard := range s.shards {
+		evictions += shard.Evictions()
+	}
+	return evictions
+}
+
+// Exists checks if the fingerprint exists in the storage.
+func (s *InMemorySharded) Exists(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	return s.shard(fingerprint).Exists(ctx, fingerprint)
+}
+
+// Processed checks if the fingerprint was processed.
+func (s *InMemorySharded) Processed(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	return s.shard(fingerprint).Processed(ctx, fingerprint)
+}
+
+// CountWaiter increments the number of waiters of a gate not processed yet.
+func (s *InMemorySharded) CountWaiter(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	return s.shard(fingerprint).CountWaiter(ctx, fingerprint)
+}
+
+// Store stores the fingerprint in the storage.
+func (s *InMemorySharded) Store(ctx context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
+	return s.shard(fingerprint).Store(ctx, fingerprint, processed)
+}
+
+// Remove removes the fingerprint from the storage.
+func (s *InMemorySharded) Remove(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	return s.shard(fingerprint).Remove(ctx, fingerprint)
+}
+
+// Acquire stores the fingerprint as not processed if it doesn't exist yet,
+// returning a new fencing token.
+func (s *InMemorySharded) Acquire(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+) (anicetus.FencingToken, bool, error) {
+	return s.shard(fingerprint).Acquire(ctx, fingerprint)
+}
+
+// StoreWithToken stores the fingerprint in the storage if the token still owns
+// the gate.
+func (s *InMemorySharded) StoreWithToken(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+	processed bool,
+	token anicetus.FencingToken,
+) error {
+	return s.shard(fingerprint).StoreWithToken(ctx, fingerprint, processed, token)
+}
+
+// RemoveWithToken removes the fingerprint from the storage if the token still
+// owns the gate.
+func (s *InMemorySharded) RemoveWithToken(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+	token anicetus.FencingToken,
+) error {
+	return s.shard(fingerprint).RemoveWithToken(ctx, fingerprint, token)
+}
+
+// ListGates returns a page of gates sorted by fingerprint. The cursor is the
+// last fingerprint of the previous page.
+func (s *InMemorySharded) ListGates(
+	ctx context.Context,
+	cursor string,
+	limit int,
+) ([]anicetus.GateState, string, error) {
+	var gates []anicetus.GateState
+	var more bool
+	for i, shard := range s.shards {
+		shardGates, next, err := shard.ListGates(ctx, cursor, limit)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list gates in shard %d: %w", i, err)
+		}
+		gates = append(gates, shardGates...)
+		more = more || next != ""
+	}
+	slices.SortFunc(gates, func(a, b anicetus.GateState) int {
+		return cmp.Compare(a.Fingerprint, b.Fingerprint)
+	})
+
+	var next string
+	if limit > 0 && len(gates) > limit {
+		gates = gates[:limit]
+		more = true
+	}
+	if more && len(gates) > 0 {
+		next = string(gates[len(gates)-1].Fingerprint)
+	}
+	return gates, next, nil
+}
+
+// InspectGate returns the gate state of the fingerprint.
+func (s *InMemorySharded) InspectGate(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+) (anicetus.GateState, bool, error) {
+	return s.shard(fingerprint).InspectGate(ctx, fingerprint)
+}
+
+// Snapshot writes the gates of all fingerprints, so they can be restored
+// later, like after a restart. The snapshot is compatible with InMemory,
+// whatever the number of partitions.
+func (s *InMemorySharded) Snapshot(w io.Writer) error {
+	snapshot := inMemorySnapshot{
+		LastToken: anicetus.FencingToken(s.tokens.Load()),
+	}
+	for _, shard := range s.shards {
+		shard.snapshot(&snapshot)
+	}
+	return writeInMemorySnapshot(w, &snapshot)
+}
+
+// Restore loads the gates written by Snapshot. Gates not processed yet are
+// dropped, as their leaders didn't survive the restart, and gates already in
+// the storage are kept. New fencing tokens are always greater than the
+// restored ones.
+func (s *InMemorySharded) Restore(r io.Reader) error {
+	snapshot, err := readInMemorySnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	restoreTokens(s.tokens, snapshot.LastToken)
+	for _, gate := range snapshot.Gates {
+		s.shard(gate.Fingerprint).restore(gate)
+	}
+	return nil
+}
+
+func (s *InMemorySharded) shard(fingerprint anicetus.Fingerprint) *InMemory {
+	return s.shards[maphash.String(s.seed, string(fingerprint))%uint64(len(s.shards))]
+}
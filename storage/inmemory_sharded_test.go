@@ -0,0 +1,129 @@
+package storage_test
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
+)
+
+func TestInMemorySharded_fencing(t *testing.T) {
+	storage := storage.NewInMemorySharded(storage.WithShards(4))
+
+	tokens := make(map[anicetus.FencingToken]anicetus.Fingerprint)
+	for i := range 100 {
+		fingerprint := anicetus.Fingerprint(strconv.Itoa(i))
+		token, ok, err := storage.Acquire(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok {
+			t.Fatal("fingerprint should be acquired")
+		}
+		if previous, ok := tokens[token]; ok {
+			t.Fatalf("token %d issued for %q and %q", token, previous, fingerprint)
+		}
+		tokens[token] = fingerprint
+
+		// a request losing the election doesn't consume a token
+		if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if ok {
+			t.Fatal("fingerprint should not be acquired twice")
+		}
+	}
+	if len(tokens) != 100 || tokens[100] == "" {
+		t.Errorf("tokens should be issued sequentially: %v", tokens)
+	}
+}
+
+func TestInMemorySharded_maxEntries(t *testing.T) {
+	storage := storage.NewInMemorySharded(storage.WithMaxEntries(10), storage.WithShards(2))
+
+	for i := range 100 {
+		if err := storage.Store(t.Context(), anicetus.Fingerprint(strconv.Itoa(i)), true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	gates, _, err := storage.ListGates(t.Context(), "", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gates) > 10 {
+		t.Errorf("unexpected number of gates %d", len(gates))
+	}
+	if evictions := storage.Evictions(); evictions != uint64(100-len(gates)) {
+		t.Errorf("unexpected evictions: %d", evictions)
+	}
+}
+
+func TestInMemorySharded_ttl(t *testing.T) {
+	storage := storage.NewInMemorySharded(storage.WithTTL(100*time.Millisecond), storage.WithShards(4))
+
+	for i := range 10 {
+		if _, ok, err := storage.Acquire(t.Context(), anicetus.Fingerprint(strconv.Itoa(i))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok {
+			t.Fatal("fingerprint should be acquired")
+		}
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	for i := range 10 {
+		if _, ok, err := storage.Acquire(t.Context(), anicetus.Fingerprint(strconv.Itoa(i))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok {
+			t.Error("fingerprint should be acquired after expiration")
+		}
+	}
+}
+
+func TestInMemorySharded_conformance(t *testing.T) {
+	storagetest.Run(t, func(*testing.T) anicetus.GatekeeperStorage {
+		return storage.NewInMemorySharded(storage.WithShards(4))
+	})
+}
+
+func BenchmarkInMemory_parallel(b *testing.B) {
+	benchmarkParallel(b, storage.NewInMemory())
+}
+
+func BenchmarkInMemorySharded_parallel(b *testing.B) {
+	benchmarkParallel(b, storage.NewInMemorySharded())
+}
+
+// benchmarkParallel elects and releases leaders of different fingerprints in
+// parallel. Run it with -cpu 1,2,4,8 to compare how the throughput scales.
+func benchmarkParallel(b *testing.B, storage anicetus.FencedGatekeeperStorage) {
+	fingerprints := make([]anicetus.Fingerprint, 100_000)
+	for i := range fingerprints {
+		fingerprints[i] = anicetus.Fingerprint("/users/" + strconv.Itoa(i))
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := rand.IntN(len(fingerprints)); pb.Next(); i++ {
+			fingerprint := fingerprints[i%len(fingerprints)]
+			token, ok, err := storage.Acquire(b.Context(), fingerprint)
+			if err != nil {
+				b.Errorf("unexpected error: %v", err)
+				return
+			}
+			if _, err := storage.Processed(b.Context(), fingerprint); err != nil {
+				b.Errorf("unexpected error: %v", err)
+				return
+			}
+			if ok {
+				if err := storage.RemoveWithToken(b.Context(), fingerprint, token); err != nil {
+					b.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+// inMemorySnapshotVersion is the version of the in-memory storage snapshot
+// format. It must be increased on incompatible changes.
+const inMemorySnapshotVersion = 1
+
+// inMemorySnapshot is the state of the in-memory storages, encoded as JSON.
+type inMemorySnapshot struct {
+	Version int `json:"version"`
+	// LastToken is the last fencing token issued, so restored gates are never
+	// owned by new leaders.
+	LastToken anicetus.FencingToken  `json:"lastToken"`
+	Gates     []inMemorySnapshotGate `json:"gates"`
+}
+
+type inMemorySnapshotGate struct {
+	Fingerprint anicetus.Fingerprint  `json:"fingerprint"`
+	Processed   bool                  `json:"processed"`
+	Token       anicetus.FencingToken `json:"token"`
+	Since       time.Time             `json:"since"`
+}
+
+func writeInMemorySnapshot(w io.Writer, snapshot *inMemorySnapshot) error {
+	snapshot.Version = inMemorySnapshotVersion
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	return nil
+}
+
+func readInMemorySnapshot(r io.Reader) (*inMemorySnapshot, error) {
+	var snapshot inMemorySnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	if snapshot.Version != inMemorySnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
+
+// restoreTokens makes sure that new fencing tokens are greater than the ones
+// of the snapshot.
+func restoreTokens(tokens *atomic.Uint64, lastToken anicetus.FencingToken) {
+	for {
+		current := tokens.Load()
+		if current >= uint64(lastToken) || tokens.CompareAndSwap(current, uint64(lastToken)) {
+			return
+		}
+	}
+}
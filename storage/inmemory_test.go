@@ -1,10 +1,15 @@
 package storage_test
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rafaeljusto/anicetus/v2"
 	"github.com/rafaeljusto/anicetus/v2/storage"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
 )
 
 func TestInMemory_lifecycle(t *testing.T) {
@@ -59,3 +64,284 @@ func TestInMemory_lifecycle(t *testing.T) {
 		t.Error("fingerprint should not exists")
 	}
 }
+
+func TestInMemory_fencing(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := storage.NewInMemory()
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be acquired twice")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	newToken, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	} else if newToken <= token {
+		t.Errorf("fencing token should increase: got %d, previous %d", newToken, token)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be processed by a stale token")
+	}
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not exists")
+	}
+}
+
+func TestInMemory_inspect(t *testing.T) {
+	storage := storage.NewInMemory()
+
+	tokens := make(map[anicetus.Fingerprint]anicetus.FencingToken)
+	for _, fingerprint := range []anicetus.Fingerprint{"c", "a", "b"} {
+		token, ok, err := storage.Acquire(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok {
+			t.Fatal("fingerprint should be acquired")
+		}
+		tokens[fingerprint] = token
+	}
+
+	// checking the gate doesn't count as a waiter
+	for range 2 {
+		if _, err := storage.Processed(t.Context(), "a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := storage.CountWaiter(t.Context(), "a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := storage.StoreWithToken(t.Context(), "b", true, tokens["b"]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	gates, cursor, err := storage.ListGates(t.Context(), "", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gates) != 2 || gates[0].Fingerprint != "a" || gates[1].Fingerprint != "b" {
+		t.Fatalf("unexpected first page: %+v", gates)
+	}
+	if cursor == "" {
+		t.Fatal("expected a cursor for the next page")
+	}
+	if gates[0].Processed || gates[0].Waiters != 2 || gates[0].Token != tokens["a"] {
+		t.Errorf("unexpected gate state: %+v", gates[0])
+	}
+	if !gates[1].Processed || gates[1].Waiters != 0 || gates[1].Token != tokens["b"] {
+		t.Errorf("unexpected gate state: %+v", gates[1])
+	}
+
+	gates, cursor, err = storage.ListGates(t.Context(), cursor, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gates) != 1 || gates[0].Fingerprint != "c" {
+		t.Fatalf("unexpected second page: %+v", gates)
+	}
+	if cursor != "" {
+		t.Errorf("unexpected cursor %q", cursor)
+	}
+
+	if gate, ok, err := storage.InspectGate(t.Context(), "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("gate should exist")
+	} else if gate.LeaderAge < 0 || gate.Token != tokens["c"] {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+
+	if _, ok, err := storage.InspectGate(t.Context(), "d"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("gate should not exist")
+	}
+}
+
+func TestInMemory_maxEntries(t *testing.T) {
+	storage := storage.NewInMemory(storage.WithMaxEntries(2))
+
+	tokenA, _, err := storage.Acquire(t.Context(), "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tokenB, _, err := storage.Acquire(t.Context(), "b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage.StoreWithToken(t.Context(), "b", true, tokenB); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// "a" is the least recently used gate, but its leader is still active
+	if _, _, err := storage.Acquire(t.Context(), "c"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for fingerprint, want := range map[anicetus.Fingerprint]bool{"a": true, "b": false, "c": true} {
+		if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if ok != want {
+			t.Errorf("unexpected existence of %q: got %v, want %v", fingerprint, ok, want)
+		}
+	}
+
+	// with only active leaders the limit is exceeded
+	if _, _, err := storage.Acquire(t.Context(), "d"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, err := storage.Exists(t.Context(), "a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("gate of an active leader should not be evicted")
+	}
+	if evictions := storage.Evictions(); evictions != 1 {
+		t.Errorf("unexpected evictions: %d", evictions)
+	}
+
+	if err := storage.StoreWithToken(t.Context(), "a", true, tokenA); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestInMemory_ttl(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := storage.NewInMemory(storage.WithTTL(100 * time.Millisecond))
+
+	// the leader never finishes
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should be expired")
+	}
+	if _, ok, err := storage.InspectGate(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("expired gate should not be inspected")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be acquired after expiration")
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestInMemory_snapshot(t *testing.T) {
+	storage1 := storage.NewInMemory()
+
+	if _, _, err := storage1.Acquire(t.Context(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tokenB, _, err := storage1.Acquire(t.Context(), "b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := storage1.StoreWithToken(t.Context(), "b", true, tokenB); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var snapshot bytes.Buffer
+	if err := storage1.Snapshot(&snapshot); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// snapshots are compatible with the sharded storage
+	storage2 := storage.NewInMemorySharded(storage.WithShards(4))
+	if err := storage2.Restore(&snapshot); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the leader of "a" didn't survive, so a new one can be elected
+	for fingerprint, want := range map[anicetus.Fingerprint]bool{"a": false, "b": true} {
+		if ok, err := storage2.Exists(t.Context(), fingerprint); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if ok != want {
+			t.Errorf("unexpected existence of %q: got %v, want %v", fingerprint, ok, want)
+		}
+	}
+	if gate, ok, err := storage2.InspectGate(t.Context(), "b"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok || !gate.Processed || gate.Token != tokenB {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+
+	if token, ok, err := storage2.Acquire(t.Context(), "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok || token <= tokenB {
+		t.Errorf("unexpected token %d (%t)", token, ok)
+	}
+
+	if err := storage2.Restore(strings.NewReader(`{"version":2}`)); err == nil {
+		t.Error("expected an error for an unsupported version")
+	}
+}
+
+func TestInMemory_conformance(t *testing.T) {
+	storagetest.Run(t, func(*testing.T) anicetus.GatekeeperStorage {
+		return storage.NewInMemory()
+	})
+}
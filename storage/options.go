@@ -1,16 +1,28 @@
 package storage
 
-import "log/slog"
+import (
+	"log/slog"
+	"runtime"
+	"time"
+)
 
 // Options provides all the available options.
 type Options struct {
 	// logger to be used internally.
 	logger *slog.Logger
+	// ttl is the time-to-live of the gates.
+	ttl time.Duration
+	// maxEntries is the maximum number of gates kept.
+	maxEntries int
+	// shards is the number of partitions of sharded storages.
+	shards int
 }
 
 // NewOptions creates a new Options with default values.
 func NewOptions() *Options {
-	return &Options{}
+	return &Options{
+		shards: runtime.GOMAXPROCS(0),
+	}
 }
 
 // Logger returns the logger to be used internally.
@@ -18,6 +30,22 @@ func (o *Options) Logger() *slog.Logger {
 	return o.logger
 }
 
+// TTL returns the time-to-live of the gates. Zero means that the gates never
+// expire.
+func (o *Options) TTL() time.Duration {
+	return o.ttl
+}
+
+// MaxEntries returns the maximum number of gates kept. Zero means no limit.
+func (o *Options) MaxEntries() int {
+	return o.maxEntries
+}
+
+// Shards returns the number of partitions of sharded storages.
+func (o *Options) Shards() int {
+	return o.shards
+}
+
 // Option is a helper function to configure the storage.
 type Option func(*Options)
 
@@ -27,3 +55,78 @@ func WithLogger(logger *slog.Logger) Option {
 		o.logger = logger
 	}
 }
+
+// WithTTL sets the time-to-live of the gates, for storages that support it.
+// Expired gates are handled as if they were removed, allowing a new leader to
+// be elected if the previous one never finished. By default, the gates never
+// expire.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.ttl = ttl
+	}
+}
+
+// WithMaxEntries sets the maximum number of gates kept, for storages that
+// support it. When the limit is reached, the least recently used gates that
+// were already processed are evicted. Gates of active leaders are never
+// evicted, so the limit can be exceeded while all gates are active. By default
+// there's no limit.
+func WithMaxEntries(maxEntries int) Option {
+	return func(o *Options) {
+		o.maxEntries = maxEntries
+	}
+}
+
+// WithShards sets the number of partitions of sharded storages. Each
+// fingerprint always belongs to the same partition, and the maximum number of
+// gates is split between them. More partitions reduce the lock contention when
+// electing leaders of different fingerprints in parallel. By default it's the
+// number of CPUs usable by the process (GOMAXPROCS).
+func WithShards(shards int) Option {
+	return func(o *Options) {
+		o.shards = shards
+	}
+}
+
+// NearCacheOptions represents the options that can be used to configure a
+// near-cache storage.
+type NearCacheOptions struct {
+	Options
+
+	localTTL time.Duration
+}
+
+// NewNearCacheOptions creates a new NearCacheOptions with default values.
+func NewNearCacheOptions() *NearCacheOptions {
+	return &NearCacheOptions{
+		Options: *NewOptions(),
+
+		localTTL: 5 * time.Second,
+	}
+}
+
+// LocalTTL returns the maximum time a gate state is kept in the local cache.
+func (o *NearCacheOptions) LocalTTL() time.Duration {
+	return o.localTTL
+}
+
+// NearCacheOption is a helper function to configure the NearCacheOptions.
+type NearCacheOption func(*NearCacheOptions)
+
+// NearCacheWithBasicOption sets the basic options for the NearCacheOptions.
+func NearCacheWithBasicOption(options ...Option) NearCacheOption {
+	return func(o *NearCacheOptions) {
+		for _, opt := range options {
+			opt(&o.Options)
+		}
+	}
+}
+
+// NearCacheWithLocalTTL sets the maximum time a gate state is kept in the local
+// cache. It's a safety net for changes that are not invalidated, like gates
+// evicted by the remote storage.
+func NearCacheWithLocalTTL(ttl time.Duration) NearCacheOption {
+	return func(o *NearCacheOptions) {
+		o.localTTL = ttl
+	}
+}
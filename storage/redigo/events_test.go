@@ -0,0 +1,119 @@
+//go:build integration_tests
+// +build integration_tests
+
+package redigo_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage/redigo"
+)
+
+func TestRedis_Subscribe(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	newPool := func() *redis.Pool {
+		return &redis.Pool{
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", redisAddress)
+			},
+		}
+	}
+
+	redisConn, err := newPool().GetContext(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get redis connection: %v", err)
+	}
+	defer func() {
+		if err := redisConn.Close(); err != nil {
+			t.Errorf("failed to close redis connection: %v", err)
+		}
+	}()
+	_, err = redisConn.Do("FLUSHDB")
+	if err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	// each storage simulates a different replica
+	replicaA := redigo.NewRedis(newPool())
+	replicaB := redigo.NewRedis(newPool())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	events, err := replicaB.Subscribe(ctx, fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// events of other gates and removals of missing gates are not received
+	if err := replicaA.Store(t.Context(), "other", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := replicaA.Remove(t.Context(), fingerprint); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	token, ok, err := replicaA.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+	expectGateEvent(t, events, anicetus.GateEvent{
+		Fingerprint: fingerprint,
+		Type:        anicetus.GateEventElected,
+		Token:       token,
+	})
+
+	if err := replicaA.StoreWithToken(t.Context(), fingerprint, true, token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectGateEvent(t, events, anicetus.GateEvent{
+		Fingerprint: fingerprint,
+		Type:        anicetus.GateEventDone,
+		Token:       token,
+	})
+
+	if err := replicaA.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectGateEvent(t, events, anicetus.GateEvent{
+		Fingerprint: fingerprint,
+		Type:        anicetus.GateEventCleanup,
+		Token:       token,
+	})
+
+	cancel()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("events channel should be closed")
+	}
+}
+
+func expectGateEvent(t *testing.T, events <-chan anicetus.GateEvent, want anicetus.GateEvent) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		if event != want {
+			t.Errorf("unexpected event: got %+v, want %+v", event, want)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("event not received: %+v", want)
+	}
+}
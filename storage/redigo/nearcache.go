@@ -0,0 +1,356 @@
+package redigo
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+)
+
+var (
+	_ anicetus.FencedGatekeeperStorage        = &NearCache{}
+	_ anicetus.GatekeeperStorageInspector     = &NearCache{}
+	_ anicetus.GatekeeperStorageSubscriber    = &NearCache{}
+	_ anicetus.GatekeeperStorageWaiterCounter = &NearCache{}
+)
+
+const (
+	// nearCacheReconnectInterval is the time to wait before subscribing again
+	// to the invalidations after a failure.
+	nearCacheReconnectInterval = time.Second
+	// nearCachePurgeInterval is the interval to purge the expired gate states.
+	nearCachePurgeInterval = time.Second
+)
+
+// NearCache is a two-level storage for the fingerprints. The gate states read
+// from Redis are kept in a local cache, so the waiters of a thundering herd in
+// the same process are answered without hitting the network. The replicas
+// subscribe to the gate state transitions published by the Redis storage on the
+// EventsChannel, dropping the local copy of the changed gates.
+//
+// The local cache is only used while the subscription is healthy. Waiters are
+// counted locally and added to the gate state in Redis periodically, so the
+// count may be behind for a short time.
+type NearCache struct {
+	redis    *Redis
+	localTTL time.Duration
+	logger   *slog.Logger
+
+	entries      map[anicetus.Fingerprint]nearCacheEntry
+	entriesMutex sync.Mutex
+	// waiters are counted locally until they are added to Redis.
+	waiters      map[anicetus.Fingerprint]int64
+	waitersMutex sync.Mutex
+	// generation changes on every invalidation, so values read from Redis
+	// concurrently with an invalidation are not cached.
+	generation atomic.Uint64
+	subscribed atomic.Bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+type nearCacheEntry struct {
+	exists    bool
+	processed bool
+	expiresAt time.Time
+}
+
+// NewNearCache creates a new near-cache storage in front of the Redis storage.
+// It subscribes to the invalidations in background until Stop is called.
+func NewNearCache(pool *redis.Pool, options ...storage.NearCacheOption) *NearCache {
+	o := storage.NewNearCacheOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	n := &NearCache{
+		redis:    NewRedis(pool, storage.WithLogger(o.Logger())),
+		localTTL: o.LocalTTL(),
+		logger:   o.Logger(),
+		entries:  make(map[anicetus.Fingerprint]nearCacheEntry),
+		waiters:  make(map[anicetus.Fingerprint]int64),
+		stop:     make(chan struct{}),
+	}
+	n.wg.Add(1)
+	go n.subscribe()
+	return n
+}
+
+// Exists checks if the fingerprint exists in the storage.
+func (n *NearCache) Exists(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	if entry, ok := n.load(fingerprint); ok {
+		return entry.exists, nil
+	}
+
+	generation := n.generation.Load()
+	exists, err := n.redis.Exists(ctx, fingerprint)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		n.cache(fingerprint, nearCacheEntry{}, generation)
+	}
+	return exists, nil
+}
+
+// Processed checks if the fingerprint was processed.
+func (n *NearCache) Processed(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	if entry, ok := n.load(fingerprint); ok {
+		return entry.processed, nil
+	}
+
+	generation := n.generation.Load()
+	processed, exists, err := n.redis.processed(ctx, fingerprint)
+	if err != nil {
+		return false, err
+	}
+	n.cache(fingerprint, nearCacheEntry{exists: exists, processed: processed}, generation)
+	return processed, nil
+}
+
+// CountWaiter increments the number of waiters of a gate not processed yet.
+// The waiters are added to Redis in background, without hitting the network,
+// until Stop is called.
+func (n *NearCache) CountWaiter(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	select {
+	case <-n.stop:
+		return n.redis.CountWaiter(ctx, fingerprint)
+	default:
+	}
+
+	n.waitersMutex.Lock()
+	defer n.waitersMutex.Unlock()
+
+	n.waiters[fingerprint]++
+	return nil
+}
+
+// Store stores the fingerprint in the storage.
+func (n *NearCache) Store(ctx context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
+	if err := n.redis.Store(ctx, fingerprint, processed); err != nil {
+		return err
+	}
+	n.invalidate(fingerprint)
+	return nil
+}
+
+// Remove removes the fingerprint from the storage.
+func (n *NearCache) Remove(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	if err := n.redis.Remove(ctx, fingerprint); err != nil {
+		return err
+	}
+	n.invalidate(fingerprint)
+	return nil
+}
+
+// Acquire stores the fingerprint as not processed if it doesn't exist yet,
+// returning a new fencing token.
+func (n *NearCache) Acquire(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.FencingToken, bool, error) {
+	token, acquired, err := n.redis.Acquire(ctx, fingerprint)
+	if err != nil || !acquired {
+		return token, acquired, err
+	}
+	n.invalidate(fingerprint)
+	return token, true, nil
+}
+
+// StoreWithToken stores the fingerprint in the storage if the token still owns
+// the gate.
+func (n *NearCache) StoreWithToken(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+	processed bool,
+	token anicetus.FencingToken,
+) error {
+	if err := n.redis.StoreWithToken(ctx, fingerprint, processed, token); err != nil {
+		return err
+	}
+	n.invalidate(fingerprint)
+	return nil
+}
+
+// RemoveWithToken removes the fingerprint from the storage if the token still
+// owns the gate.
+func (n *NearCache) RemoveWithToken(ctx context.Context, fingerprint anicetus.Fingerprint, token anicetus.FencingToken) error {
+	if err := n.redis.RemoveWithToken(ctx, fingerprint, token); err != nil {
+		return err
+	}
+	n.invalidate(fingerprint)
+	return nil
+}
+
+// ListGates returns a page of gates from Redis.
+func (n *NearCache) ListGates(ctx context.Context, cursor string, limit int) ([]anicetus.GateState, string, error) {
+	return n.redis.ListGates(ctx, cursor, limit)
+}
+
+// InspectGate returns the gate state of the fingerprint from Redis.
+func (n *NearCache) InspectGate(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.GateState, bool, error) {
+	return n.redis.InspectGate(ctx, fingerprint)
+}
+
+// Subscribe returns a channel receiving the gate events of the fingerprint.
+func (n *NearCache) Subscribe(ctx context.Context, fingerprint anicetus.Fingerprint) (<-chan anicetus.GateEvent, error) {
+	return n.redis.Subscribe(ctx, fingerprint)
+}
+
+// Subscribed reports if the invalidations are being received. The local cache
+// is only used while subscribed.
+func (n *NearCache) Subscribed() bool {
+	return n.subscribed.Load()
+}
+
+// Stop stops receiving the invalidations and disables the local cache, adding
+// the waiters counted locally to Redis.
+func (n *NearCache) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stop)
+	})
+	n.wg.Wait()
+}
+
+// load retrieves the gate state from the local cache.
+func (n *NearCache) load(fingerprint anicetus.Fingerprint) (nearCacheEntry, bool) {
+	if !n.subscribed.Load() {
+		return nearCacheEntry{}, false
+	}
+
+	n.entriesMutex.Lock()
+	defer n.entriesMutex.Unlock()
+
+	entry, ok := n.entries[fingerprint]
+	if !ok {
+		return nearCacheEntry{}, false
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		delete(n.entries, fingerprint)
+		return nearCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// cache stores the gate state in the local cache if no invalidation happened
+// since the generation was loaded.
+func (n *NearCache) cache(fingerprint anicetus.Fingerprint, entry nearCacheEntry, generation uint64) {
+	n.entriesMutex.Lock()
+	defer n.entriesMutex.Unlock()
+
+	if n.localTTL <= 0 || !n.subscribed.Load() || n.generation.Load() != generation {
+		return
+	}
+	entry.expiresAt = time.Now().Add(n.localTTL)
+	n.entries[fingerprint] = entry
+}
+
+// invalidate drops the gate state from the local cache.
+func (n *NearCache) invalidate(fingerprint anicetus.Fingerprint) {
+	n.entriesMutex.Lock()
+	defer n.entriesMutex.Unlock()
+
+	n.generation.Add(1)
+	delete(n.entries, fingerprint)
+}
+
+// invalidateAll drops all the gate states from the local cache.
+func (n *NearCache) invalidateAll() {
+	n.entriesMutex.Lock()
+	defer n.entriesMutex.Unlock()
+
+	n.generation.Add(1)
+	clear(n.entries)
+}
+
+// purge drops the expired gate states from the local cache.
+func (n *NearCache) purge() {
+	n.entriesMutex.Lock()
+	defer n.entriesMutex.Unlock()
+
+	now := time.Now()
+	for fingerprint, entry := range n.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(n.entries, fingerprint)
+		}
+	}
+}
+
+// flushWaiters adds the waiters counted locally to Redis. Failures are only
+// logged, as the waiters are used for troubleshooting.
+func (n *NearCache) flushWaiters() {
+	n.waitersMutex.Lock()
+	waiters := n.waiters
+	n.waiters = make(map[anicetus.Fingerprint]int64)
+	n.waitersMutex.Unlock()
+
+	for fingerprint, count := range waiters {
+		if err := n.redis.countWaiters(context.Background(), fingerprint, count); err != nil && n.logger != nil {
+			n.logger.Warn("failed to count redis gate waiters",
+				slog.String("fingerprint", fingerprint.String()),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+}
+
+// subscribe receives the invalidations, subscribing again after failures.
+func (n *NearCache) subscribe() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(nearCachePurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		s := subscribe(n.redis.pool, EventsChannel,
+			func() {
+				// changes before the subscription were not received
+				n.invalidateAll()
+				n.subscribed.Store(true)
+			},
+			func(data []byte) {
+				if event, err := parseGateEvent(data); err == nil {
+					n.invalidate(event.Fingerprint)
+				} else if n.logger != nil {
+					n.logger.Warn("invalid redis gate event", slog.String("error", err.Error()))
+				}
+			},
+		)
+
+	receiving:
+		for {
+			select {
+			case <-n.stop:
+				s.Stop()
+				n.subscribed.Store(false)
+				n.invalidateAll()
+				n.flushWaiters()
+				return
+			case <-s.Done():
+				break receiving
+			case <-ticker.C:
+				n.purge()
+				n.flushWaiters()
+			}
+		}
+
+		// invalidations may have been lost
+		n.subscribed.Store(false)
+		n.invalidateAll()
+
+		if n.logger != nil {
+			n.logger.Warn("redis invalidations subscription lost", slog.String("error", s.Err().Error()))
+		}
+
+		select {
+		case <-n.stop:
+			n.flushWaiters()
+			return
+		case <-time.After(nearCacheReconnectInterval):
+		}
+	}
+}
@@ -0,0 +1,136 @@
+//go:build integration_tests
+// +build integration_tests
+
+package redigo_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+	"github.com/rafaeljusto/anicetus/v2/storage/redigo"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
+)
+
+func TestNearCache_invalidation(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisPool := &redis.Pool{
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", redisAddress)
+		},
+	}
+
+	redisConn, err := redisPool.GetContext(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get redis connection: %v", err)
+	}
+	defer func() {
+		if err := redisConn.Close(); err != nil {
+			t.Errorf("failed to close redis connection: %v", err)
+		}
+	}()
+	_, err = redisConn.Do("FLUSHDB")
+	if err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	replicaA := redigo.NewNearCache(redisPool)
+	defer replicaA.Stop()
+
+	replicaB := redigo.NewNearCache(redisPool, storage.NearCacheWithLocalTTL(500*time.Millisecond))
+	defer replicaB.Stop()
+
+	waitFor(t, func() bool {
+		return replicaA.Subscribed() && replicaB.Subscribed()
+	})
+
+	token, ok, err := replicaA.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	waitFor(t, func() bool {
+		exists, err := replicaB.Exists(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		processed, err := replicaB.Processed(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return exists && !processed
+	})
+
+	if err := replicaA.StoreWithToken(t.Context(), fingerprint, true, token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		processed, err := replicaB.Processed(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return processed
+	})
+
+	// wait for in-flight invalidations before caching the gate
+	time.Sleep(100 * time.Millisecond)
+	if _, err := replicaB.Processed(t.Context(), fingerprint); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// changes without invalidation are only noticed after the local TTL
+	if _, err := redisConn.Do("DEL", "anicetus:gate:{"+fingerprint.String()+"}"); err != nil {
+		t.Fatalf("failed to delete redis key: %v", err)
+	}
+
+	if ok, err := replicaB.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be answered by the local cache")
+	}
+
+	waitFor(t, func() bool {
+		exists, err := replicaB.Exists(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return !exists
+	})
+}
+
+// waitFor waits until the condition is met, failing the test after a few
+// seconds.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNearCache_conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) anicetus.GatekeeperStorage {
+		storage := redigo.NewNearCache(newRedisPool(t))
+		t.Cleanup(storage.Stop)
+
+		waitFor(t, storage.Subscribed)
+		return storage
+	})
+}
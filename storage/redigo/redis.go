@@ -4,18 +4,141 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/rafaeljusto/anicetus/v2"
 	"github.com/rafaeljusto/anicetus/v2/storage"
 )
 
-var _ anicetus.GatekeeperStorage = &Redis{}
+var (
+	_ anicetus.FencedGatekeeperStorage        = &Redis{}
+	_ anicetus.GatekeeperStorageInspector     = &Redis{}
+	_ anicetus.GatekeeperStorageSubscriber    = &Redis{}
+	_ anicetus.GatekeeperStorageWaiterCounter = &Redis{}
 
-// Redis is a redis storage for the fingerprints.
+	countWaiterScript = redis.NewScript(1, `
+-- Count waiters of the gate, if it exists and wasn't processed yet
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Number of waiters
+
+if redis.call("HGET", KEYS[1], "processed") == "0" then
+  redis.call("HINCRBY", KEYS[1], "waiters", ARGV[1])
+end
+return 1
+`)
+
+	storeScript = redis.NewScript(1, `
+-- Store the gate state, publishing the transition
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Processed flag
+-- ARGV[2]: Events channel
+-- ARGV[3]: Fingerprint of the gate
+
+local current_time = redis.call("TIME")
+local now = tonumber(current_time[1]) * 1000 + math.floor(tonumber(current_time[2]) / 1000)
+
+redis.call("HSETNX", KEYS[1], "since", now)
+redis.call("HSET", KEYS[1], "processed", ARGV[1])
+
+local token = redis.call("HGET", KEYS[1], "token") or "0"
+local event = ARGV[1] == "1" and "done" or "elected"
+redis.call("PUBLISH", ARGV[2], event .. " " .. token .. " " .. ARGV[3])
+return 1
+`)
+
+	removeScript = redis.NewScript(1, `
+-- Remove the gate, publishing the transition
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Events channel
+-- ARGV[2]: Fingerprint of the gate
+
+local token = redis.call("HGET", KEYS[1], "token")
+if redis.call("DEL", KEYS[1]) == 1 then
+  redis.call("PUBLISH", ARGV[1], "cleanup " .. (token or "0") .. " " .. ARGV[2])
+end
+return 1
+`)
+
+	acquireScript = redis.NewScript(2, `
+-- Acquire the gate with a new fencing token, publishing the transition
+-- KEYS[1]: The Redis key for storing the gate
+-- KEYS[2]: The Redis key for generating the fencing tokens of the gate
+-- ARGV[1]: Events channel
+-- ARGV[2]: Fingerprint of the gate
+-- ARGV[3]: Expiration of the fencing tokens key in milliseconds
+
+if redis.call("EXISTS", KEYS[1]) == 1 then
+  return 0 -- Gate already exists
+end
+
+local current_time = redis.call("TIME")
+local now = tonumber(current_time[1]) * 1000 + math.floor(tonumber(current_time[2]) / 1000)
+
+-- The token is never lower than the current time in microseconds, so it keeps
+-- increasing after the fencing tokens key expires
+local token = math.max(
+  tonumber(redis.call("GET", KEYS[2]) or "0") + 1,
+  tonumber(current_time[1]) * 1000000 + tonumber(current_time[2])
+)
+token = string.format("%.0f", token)
+redis.call("SET", KEYS[2], token, "PX", ARGV[3])
+
+redis.call("HSET", KEYS[1], "processed", 0, "token", token, "since", now)
+redis.call("PUBLISH", ARGV[1], "elected " .. token .. " " .. ARGV[2])
+return token -- Acquired
+`)
+
+	storeWithTokenScript = redis.NewScript(1, `
+-- Store the gate state if the fencing token still owns it, publishing the
+-- transition
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Processed flag
+-- ARGV[2]: Fencing token of the leader
+-- ARGV[3]: Events channel
+-- ARGV[4]: Fingerprint of the gate
+
+local token = redis.call("HGET", KEYS[1], "token")
+if not token or token ~= ARGV[2] then
+  return 0 -- Stale token
+end
+
+redis.call("HSET", KEYS[1], "processed", ARGV[1])
+
+local event = ARGV[1] == "1" and "done" or "elected"
+redis.call("PUBLISH", ARGV[3], event .. " " .. token .. " " .. ARGV[4])
+return 1 -- Stored
+`)
+
+	removeWithTokenScript = redis.NewScript(1, `
+-- Remove the gate if the fencing token still owns it, publishing the transition
+-- KEYS[1]: The Redis key for storing the gate
+-- ARGV[1]: Fencing token of the leader
+-- ARGV[2]: Events channel
+-- ARGV[3]: Fingerprint of the gate
+
+local token = redis.call("HGET", KEYS[1], "token")
+if not token then
+  return 1 -- Nothing to remove
+end
+if token ~= ARGV[1] then
+  return 0 -- Stale token
+end
+
+redis.call("DEL", KEYS[1])
+redis.call("PUBLISH", ARGV[2], "cleanup " .. token .. " " .. ARGV[3])
+return 1 -- Removed
+`)
+)
+
+// Redis is a redis storage for the fingerprints. Every gate state transition
+// is published on the EventsChannel.
 type Redis struct {
 	pool   *redis.Pool
 	logger *slog.Logger
+	events *gateEvents
 }
 
 // NewRedis creates a new redis storage.
@@ -28,6 +151,7 @@ func NewRedis(pool *redis.Pool, options ...storage.Option) *Redis {
 	return &Redis{
 		pool:   pool,
 		logger: o.Logger(),
+		events: newGateEvents(pool, o.Logger()),
 	}
 }
 
@@ -45,7 +169,7 @@ func (r *Redis) Exists(ctx context.Context, fingerprint anicetus.Fingerprint) (b
 		}
 	}()
 
-	result, err := redis.Int(conn.Do("EXISTS", fingerprint))
+	result, err := redis.Int(conn.Do("EXISTS", addKeyPrefix(fingerprint)))
 	if err != nil {
 		return false, fmt.Errorf("failed to check redis key: %w", err)
 	}
@@ -54,9 +178,16 @@ func (r *Redis) Exists(ctx context.Context, fingerprint anicetus.Fingerprint) (b
 
 // Processed checks if the fingerprint was processed.
 func (r *Redis) Processed(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	processed, _, err := r.processed(ctx, fingerprint)
+	return processed, err
+}
+
+// processed checks if the fingerprint was processed, also informing if the
+// gate exists.
+func (r *Redis) processed(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, bool, error) {
 	conn, err := r.pool.GetContext(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to get redis connection: %w", err)
+		return false, false, fmt.Errorf("failed to get redis connection: %w", err)
 	}
 	defer func() {
 		if err := conn.Close(); err != nil {
@@ -66,13 +197,38 @@ func (r *Redis) Processed(ctx context.Context, fingerprint anicetus.Fingerprint)
 		}
 	}()
 
-	result, err := redis.Bool(conn.Do("GET", fingerprint))
+	result, err := redis.Bool(conn.Do("HGET", addKeyPrefix(fingerprint), "processed"))
 	if err == redis.ErrNil {
-		return false, nil
+		return false, false, nil
 	} else if err != nil {
-		return false, fmt.Errorf("failed to get redis key: %w", err)
+		return false, false, fmt.Errorf("failed to get redis key: %w", err)
+	}
+	return result, true, nil
+}
+
+// CountWaiter increments the number of waiters of a gate not processed yet.
+func (r *Redis) CountWaiter(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	return r.countWaiters(ctx, fingerprint, 1)
+}
+
+// countWaiters adds the number of waiters to a gate not processed yet.
+func (r *Redis) countWaiters(ctx context.Context, fingerprint anicetus.Fingerprint, waiters int64) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
 	}
-	return result, nil
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if r.logger != nil {
+				r.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	if _, err := redis.Int(countWaiterScript.DoContext(ctx, conn, addKeyPrefix(fingerprint), waiters)); err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	return nil
 }
 
 // Store stores the fingerprint in the storage.
@@ -89,12 +245,13 @@ func (r *Redis) Store(ctx context.Context, fingerprint anicetus.Fingerprint, pro
 		}
 	}()
 
-	result, err := redis.String(conn.Do("SET", fingerprint, boolToInt(processed)))
+	_, err = redis.Int(storeScript.DoContext(ctx, conn, addKeyPrefix(fingerprint),
+		boolToInt(processed),
+		EventsChannel,
+		fingerprint.String(),
+	))
 	if err != nil {
-		return fmt.Errorf("failed to set redis key: %w", err)
-	}
-	if result != "OK" {
-		return fmt.Errorf("failed to set redis key")
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
 	}
 	return nil
 }
@@ -113,13 +270,282 @@ func (r *Redis) Remove(ctx context.Context, fingerprint anicetus.Fingerprint) er
 		}
 	}()
 
-	_, err = redis.Int(conn.Do("DEL", fingerprint))
+	_, err = redis.Int(removeScript.DoContext(ctx, conn, addKeyPrefix(fingerprint),
+		EventsChannel,
+		fingerprint.String(),
+	))
 	if err != nil {
-		return fmt.Errorf("failed to delete redis key: %w", err)
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
 	}
 	return nil
 }
 
+// Acquire stores the fingerprint as not processed if it doesn't exist yet,
+// returning a new fencing token.
+func (r *Redis) Acquire(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.FencingToken, bool, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if r.logger != nil {
+				r.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	token, err := redis.Uint64(acquireScript.DoContext(ctx, conn, addKeyPrefix(fingerprint), fencingKey(fingerprint),
+		EventsChannel,
+		fingerprint.String(),
+		fencingKeyTTL.Milliseconds(),
+	))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	if token == 0 {
+		return 0, false, nil
+	}
+	return anicetus.FencingToken(token), true, nil
+}
+
+// StoreWithToken stores the fingerprint in the storage if the token still owns
+// the gate.
+func (r *Redis) StoreWithToken(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+	processed bool,
+	token anicetus.FencingToken,
+) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if r.logger != nil {
+				r.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	stored, err := redis.Bool(storeWithTokenScript.DoContext(ctx, conn, addKeyPrefix(fingerprint),
+		boolToInt(processed),
+		strconv.FormatUint(uint64(token), 10),
+		EventsChannel,
+		fingerprint.String(),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	if !stored {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	return nil
+}
+
+// RemoveWithToken removes the fingerprint from the storage if the token still
+// owns the gate.
+func (r *Redis) RemoveWithToken(ctx context.Context, fingerprint anicetus.Fingerprint, token anicetus.FencingToken) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if r.logger != nil {
+				r.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	removed, err := redis.Bool(removeWithTokenScript.DoContext(ctx, conn, addKeyPrefix(fingerprint),
+		strconv.FormatUint(uint64(token), 10),
+		EventsChannel,
+		fingerprint.String(),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to execute redis lua script: %w", err)
+	}
+	if !removed {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	return nil
+}
+
+// ListGates returns a page of gates using the Redis SCAN cursor.
+func (r *Redis) ListGates(ctx context.Context, cursor string, limit int) ([]anicetus.GateState, string, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if r.logger != nil {
+				r.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	if cursor == "" {
+		cursor = "0"
+	}
+	args := redis.Args{cursor, "MATCH", addKeyPrefix("*")}
+	if limit > 0 {
+		args = args.Add("COUNT", limit)
+	}
+
+	values, err := redis.Values(conn.Do("SCAN", args...))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan redis keys: %w", err)
+	}
+	var keys []string
+	if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+		return nil, "", fmt.Errorf("failed to parse redis scan reply: %w", err)
+	}
+	if cursor == "0" {
+		cursor = ""
+	}
+
+	now, err := serverTime(conn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, key := range keys {
+		if err := conn.Send("HMGET", redis.Args{key}.Add(gateFields...)...); err != nil {
+			return nil, "", fmt.Errorf("failed to send redis command: %w", err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, "", fmt.Errorf("failed to flush redis commands: %w", err)
+	}
+
+	gates := make([]anicetus.GateState, 0, len(keys))
+	for _, key := range keys {
+		fields, err := redis.Strings(conn.Receive())
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get redis key: %w", err)
+		}
+		if gate, ok := parseGateState(removeKeyPrefix(key), fields, now); ok {
+			gates = append(gates, gate)
+		}
+	}
+	return gates, cursor, nil
+}
+
+// InspectGate returns the gate state of the fingerprint.
+func (r *Redis) InspectGate(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.GateState, bool, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return anicetus.GateState{}, false, fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			if r.logger != nil {
+				r.logger.Error("failed to close redis connection", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	now, err := serverTime(conn)
+	if err != nil {
+		return anicetus.GateState{}, false, err
+	}
+
+	fields, err := redis.Strings(conn.Do("HMGET", redis.Args{addKeyPrefix(fingerprint)}.Add(gateFields...)...))
+	if err != nil {
+		return anicetus.GateState{}, false, fmt.Errorf("failed to get redis key: %w", err)
+	}
+	gate, ok := parseGateState(fingerprint, fields, now)
+	return gate, ok, nil
+}
+
+// Subscribe returns a channel receiving the gate events of the fingerprint,
+// published by any replica sharing the Redis database. A single Redis
+// subscription is shared by all the subscribers of the storage, and events are
+// dropped for subscribers that don't keep up.
+func (r *Redis) Subscribe(ctx context.Context, fingerprint anicetus.Fingerprint) (<-chan anicetus.GateEvent, error) {
+	return r.events.subscribe(ctx, fingerprint)
+}
+
+// gateFields are the fields of the gate hash in the same order expected by
+// parseGateState.
+var gateFields = []any{"processed", "token", "since", "waiters"}
+
+// parseGateState parses the gate hash fields. It returns false if the gate
+// doesn't exist.
+func parseGateState(fingerprint anicetus.Fingerprint, fields []string, now time.Time) (anicetus.GateState, bool) {
+	if len(fields) != len(gateFields) || fields[0] == "" {
+		return anicetus.GateState{}, false
+	}
+
+	gate := anicetus.GateState{
+		Fingerprint: fingerprint,
+		Processed:   fields[0] == "1",
+	}
+	if token, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+		gate.Token = anicetus.FencingToken(token)
+	}
+	if since, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+		gate.LeaderAge = max(now.Sub(time.UnixMilli(since)), 0)
+	}
+	if waiters, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+		gate.Waiters = waiters
+	}
+	return gate, true
+}
+
+// serverTime returns the current time of the Redis server.
+func serverTime(conn redis.Conn) (time.Time, error) {
+	values, err := redis.Int64s(conn.Do("TIME"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get redis time: %w", err)
+	}
+	if len(values) != 2 {
+		return time.Time{}, fmt.Errorf("unexpected redis time reply: %v", values)
+	}
+	return time.Unix(values[0], values[1]*int64(time.Microsecond)), nil
+}
+
+// EventsChannel is the Redis channel where the gate state transitions are
+// published. Each message has the format "<event type> <token> <fingerprint>".
+const EventsChannel = "anicetus:gate:events"
+
+// addKeyPrefix adds the key prefix to the fingerprint to correctly set the
+// scope. The fingerprint is a hash tag, so the gate and its fencing key are in
+// the same Redis Cluster slot.
+func addKeyPrefix(fingerprint anicetus.Fingerprint) string {
+	return keyPrefix + "{" + fingerprint.String() + "}"
+}
+
+// removeKeyPrefix removes the key prefix, returning the fingerprint.
+func removeKeyPrefix(key string) anicetus.Fingerprint {
+	key = strings.TrimPrefix(key, keyPrefix)
+	key = strings.TrimPrefix(key, "{")
+	key = strings.TrimSuffix(key, "}")
+	return anicetus.Fingerprint(key)
+}
+
+// fencingKey is the Redis key used to generate the fencing tokens of the
+// fingerprint. Tokens only need to increase for the same gate, and keeping a
+// counter per fingerprint allows acquiring the gate atomically in a Redis
+// Cluster.
+func fencingKey(fingerprint anicetus.Fingerprint) string {
+	return fencingKeyPrefix + "{" + fingerprint.String() + "}"
+}
+
+// fencingKeyTTL is the expiration of the fencing tokens key of a fingerprint,
+// refreshed on every acquired gate. Tokens are seeded with the current time, so
+// they keep increasing after it expires.
+const fencingKeyTTL = 24 * time.Hour
+
+// keyPrefix is the scope of the gate keys.
+const keyPrefix = "anicetus:gate:"
+
+// fencingKeyPrefix is the scope of the fencing token keys.
+const fencingKeyPrefix = "anicetus:fencing:"
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -5,12 +5,16 @@ package redigo_test
 
 import (
 	"context"
+	"errors"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/rafaeljusto/anicetus/v2"
 	"github.com/rafaeljusto/anicetus/v2/storage/redigo"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
 )
 
 const defaultRedisAddress = "localhost:6379"
@@ -92,3 +96,254 @@ func TestRedis_lifecycle(t *testing.T) {
 		t.Error("fingerprint should not exists")
 	}
 }
+
+func TestRedis_fencing(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisPool := &redis.Pool{
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", redisAddress)
+		},
+	}
+
+	redisConn, err := redisPool.GetContext(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get redis connection: %v", err)
+	}
+	defer func() {
+		if err := redisConn.Close(); err != nil {
+			t.Errorf("failed to close redis connection: %v", err)
+		}
+	}()
+	_, err = redisConn.Do("FLUSHDB")
+	if err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	storage := redigo.NewRedis(redisPool)
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be acquired twice")
+	}
+
+	// the gate and its fencing key must be in the same Redis Cluster slot
+	if keys, err := redis.Strings(redisConn.Do("KEYS", "anicetus:*")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if len(keys) != 2 || hashTag(keys[0]) == "" || hashTag(keys[0]) != hashTag(keys[1]) {
+		t.Errorf("keys should share the same hash tag: %v", keys)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	newToken, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	} else if newToken <= token {
+		t.Errorf("fencing token should increase: got %d, previous %d", newToken, token)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be processed by a stale token")
+	}
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not exists")
+	}
+}
+
+func TestRedis_inspect(t *testing.T) {
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisPool := &redis.Pool{
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", redisAddress)
+		},
+	}
+
+	redisConn, err := redisPool.GetContext(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get redis connection: %v", err)
+	}
+	defer func() {
+		if err := redisConn.Close(); err != nil {
+			t.Errorf("failed to close redis connection: %v", err)
+		}
+	}()
+	_, err = redisConn.Do("FLUSHDB")
+	if err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+
+	storage := redigo.NewRedis(redisPool)
+
+	tokens := make(map[anicetus.Fingerprint]anicetus.FencingToken)
+	for _, fingerprint := range []anicetus.Fingerprint{"a", "b", "c"} {
+		token, ok, err := storage.Acquire(t.Context(), fingerprint)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !ok {
+			t.Fatal("fingerprint should be acquired")
+		}
+		tokens[fingerprint] = token
+	}
+
+	// checking the gate doesn't count as a waiter
+	for range 2 {
+		if _, err := storage.Processed(t.Context(), "a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := storage.CountWaiter(t.Context(), "a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := storage.StoreWithToken(t.Context(), "b", true, tokens["b"]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	gates := make(map[anicetus.Fingerprint]anicetus.GateState)
+	var cursor string
+	for {
+		page, next, err := storage.ListGates(t.Context(), cursor, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, gate := range page {
+			gates[gate.Fingerprint] = gate
+		}
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	if len(gates) != 3 {
+		t.Fatalf("unexpected gates: %+v", gates)
+	}
+	if gate := gates["a"]; gate.Processed || gate.Waiters != 2 || gate.Token != tokens["a"] {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+	if gate := gates["b"]; !gate.Processed || gate.Waiters != 0 || gate.Token != tokens["b"] {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+
+	if gate, ok, err := storage.InspectGate(t.Context(), "c"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("gate should exist")
+	} else if gate.LeaderAge < 0 || gate.LeaderAge > time.Minute || gate.Token != tokens["c"] {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+
+	if _, ok, err := storage.InspectGate(t.Context(), "d"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("gate should not exist")
+	}
+}
+
+func TestRedis_conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) anicetus.GatekeeperStorage {
+		return redigo.NewRedis(newRedisPool(t))
+	})
+}
+
+// hashTag returns the part of the key used by Redis Cluster to compute its
+// slot, or an empty string if the whole key is used.
+func hashTag(key string) string {
+	_, tag, ok := strings.Cut(key, "{")
+	if !ok {
+		return ""
+	}
+	tag, _, ok = strings.Cut(tag, "}")
+	if !ok {
+		return ""
+	}
+	return tag
+}
+
+// newRedisPool creates a pool for an empty Redis database.
+func newRedisPool(t *testing.T) *redis.Pool {
+	t.Helper()
+
+	redisAddress := defaultRedisAddress
+	if e := os.Getenv("REDIS_ADDRESS"); e != "" {
+		redisAddress = e
+	}
+
+	redisPool := &redis.Pool{
+		DialContext: func(ctx context.Context) (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", redisAddress)
+		},
+	}
+	t.Cleanup(func() {
+		if err := redisPool.Close(); err != nil {
+			t.Errorf("failed to close redis pool: %v", err)
+		}
+	})
+
+	redisConn, err := redisPool.GetContext(t.Context())
+	if err != nil {
+		t.Fatalf("failed to get redis connection: %v", err)
+	}
+	defer func() {
+		if err := redisConn.Close(); err != nil {
+			t.Errorf("failed to close redis connection: %v", err)
+		}
+	}()
+	if _, err := redisConn.Do("FLUSHDB"); err != nil {
+		t.Fatalf("failed to flush redis database: %v", err)
+	}
+	return redisPool
+}
@@ -0,0 +1,134 @@
+package redigo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// subscriptionHealthCheckInterval is the interval to ping the subscription
+// connection, detecting broken connections.
+const subscriptionHealthCheckInterval = time.Second
+
+// subscription receives the messages of a Redis channel in background, until
+// the connection fails or it's stopped.
+type subscription struct {
+	pool        *redis.Pool
+	channel     string
+	onSubscribe func()
+	onMessage   func(data []byte)
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// subscribe starts receiving the messages of the channel. The onSubscribe
+// callback is called once the subscription is active, and onMessage for each
+// received message, both from the receiving goroutine.
+func subscribe(pool *redis.Pool, channel string, onSubscribe func(), onMessage func(data []byte)) *subscription {
+	s := &subscription{
+		pool:        pool,
+		channel:     channel,
+		onSubscribe: onSubscribe,
+		onMessage:   onMessage,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		s.err = s.receive()
+	}()
+	return s
+}
+
+// Done returns a channel that is closed when the subscription ends.
+func (s *subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the subscription ended. It's nil if it was stopped,
+// and must only be called after Done is closed.
+func (s *subscription) Err() error {
+	return s.err
+}
+
+// Stop ends the subscription, waiting for the receiving goroutine.
+func (s *subscription) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+func (s *subscription) receive() error {
+	conn, err := s.pool.GetContext(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer func() {
+		_ = psc.Close()
+	}()
+
+	if err := psc.Subscribe(s.channel); err != nil {
+		return fmt.Errorf("failed to subscribe to redis channel: %w", err)
+	}
+
+	// the keep alive must finish before closing the connection, as it also
+	// writes to it
+	done, keepAliveDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(keepAliveDone)
+		s.keepAlive(psc, done)
+	}()
+	defer func() {
+		close(done)
+		<-keepAliveDone
+	}()
+
+	for {
+		// the keep alive pings guarantee a reply before the timeout on healthy
+		// connections
+		switch v := psc.ReceiveWithTimeout(3 * subscriptionHealthCheckInterval).(type) {
+		case redis.Message:
+			s.onMessage(v.Data)
+		case redis.Subscription:
+			switch {
+			case v.Kind == "subscribe":
+				s.onSubscribe()
+			case v.Kind == "unsubscribe" && v.Count == 0:
+				return nil
+			}
+		case error:
+			return fmt.Errorf("failed to receive redis message: %w", v)
+		}
+	}
+}
+
+// keepAlive pings the subscription connection periodically. When the
+// subscription is stopped, it unsubscribes to unblock the receiver.
+func (s *subscription) keepAlive(psc redis.PubSubConn, done <-chan struct{}) {
+	ticker := time.NewTicker(subscriptionHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.stop:
+			_ = psc.Unsubscribe()
+			return
+		case <-ticker.C:
+		}
+
+		if err := psc.Ping(""); err != nil {
+			// the receiver will time out waiting for the reply
+			return
+		}
+	}
+}
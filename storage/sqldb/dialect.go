@@ -0,0 +1,94 @@
+package sqldb
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect adapts the SQL statements to a database engine.
+type Dialect struct {
+	name string
+	// now is the SQL expression returning the current database time in unix
+	// milliseconds.
+	now string
+	// nextToken is the SQL statement returning the next fencing token.
+	nextToken string
+	// lock is the SQL statement executed in the migration transaction to avoid
+	// concurrent migrations. Empty when the database already serializes the
+	// transactions.
+	lock string
+	// migrations are the schema changes, each version in a position with its
+	// statements.
+	migrations [][]string
+	// numberedPlaceholders defines if the placeholders are $1, $2, ... instead
+	// of ?.
+	numberedPlaceholders bool
+}
+
+// List of supported dialects.
+var (
+	// DialectSQLite is the dialect for SQLite 3.35 or newer.
+	DialectSQLite = Dialect{
+		name:      "sqlite",
+		now:       "CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)",
+		nextToken: "UPDATE anicetus_fencing SET token = token + 1 WHERE id = 1 RETURNING token",
+		migrations: [][]string{{
+			`CREATE TABLE anicetus_gates (
+  fingerprint TEXT PRIMARY KEY,
+  processed BOOLEAN NOT NULL,
+  token BIGINT NOT NULL,
+  expires_at BIGINT
+)`,
+			`CREATE INDEX anicetus_gates_expires_at ON anicetus_gates (expires_at)`,
+			`CREATE TABLE anicetus_fencing (
+  id INTEGER PRIMARY KEY,
+  token BIGINT NOT NULL
+)`,
+			`INSERT INTO anicetus_fencing (id, token) VALUES (1, 0)`,
+		}},
+	}
+
+	// DialectPostgres is the dialect for PostgreSQL 9.5 or newer.
+	DialectPostgres = Dialect{
+		name:      "postgres",
+		now:       "CAST(EXTRACT(EPOCH FROM clock_timestamp()) * 1000 AS BIGINT)",
+		nextToken: "SELECT nextval('anicetus_fencing')",
+		// arbitrary key to serialize the migrations of concurrent replicas
+		lock: "SELECT pg_advisory_xact_lock(5871326904)",
+		migrations: [][]string{{
+			`CREATE TABLE anicetus_gates (
+  fingerprint TEXT PRIMARY KEY,
+  processed BOOLEAN NOT NULL,
+  token BIGINT NOT NULL,
+  expires_at BIGINT
+)`,
+			`CREATE INDEX anicetus_gates_expires_at ON anicetus_gates (expires_at)`,
+			`CREATE SEQUENCE anicetus_fencing`,
+		}},
+		numberedPlaceholders: true,
+	}
+)
+
+// String returns the name of the dialect.
+func (d Dialect) String() string {
+	return d.name
+}
+
+// rebind replaces the ? placeholders of the query with the dialect ones.
+func (d Dialect) rebind(query string) string {
+	if !d.numberedPlaceholders {
+		return query
+	}
+
+	var builder strings.Builder
+	var n int
+	for _, r := range query {
+		if r != '?' {
+			builder.WriteRune(r)
+			continue
+		}
+		n++
+		builder.WriteString("$" + strconv.Itoa(n))
+	}
+	return builder.String()
+}
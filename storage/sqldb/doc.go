@@ -0,0 +1,5 @@
+// Package sqldb provides a storage solution using a SQL database through the
+// standard database/sql package, allowing replicas to share the gates without
+// Redis. SQLite and PostgreSQL dialects are supported, and the driver must be
+// registered by the application.
+package sqldb
@@ -0,0 +1,307 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+)
+
+var _ anicetus.FencedGatekeeperStorage = &SQL{}
+
+// SQL is a SQL database storage for the fingerprints. The leader election is
+// atomic at the row level, so many replicas can share the same database.
+type SQL struct {
+	db      *sql.DB
+	dialect Dialect
+	ttl     time.Duration
+	logger  *slog.Logger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewSQL creates a new SQL storage. The schema must be created beforehand with
+// Migrate. When a TTL is configured (storage.WithTTL), expired gates are purged
+// periodically until Stop is called. The database is not closed by the
+// storage.
+func NewSQL(db *sql.DB, dialect Dialect, options ...storage.Option) *SQL {
+	o := storage.NewOptions()
+	for _, opt := range options {
+		opt(o)
+	}
+
+	s := &SQL{
+		db:      db,
+		dialect: dialect,
+		ttl:     o.TTL(),
+		logger:  o.Logger(),
+		stop:    make(chan struct{}),
+	}
+	if s.ttl > 0 {
+		s.start()
+	}
+	return s
+}
+
+// Migrate creates or updates the database schema to the latest version. It's
+// safe to be called concurrently by many replicas.
+func (s *SQL) Migrate(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) && s.logger != nil {
+			s.logger.Error("failed to rollback transaction", slog.String("error", err.Error()))
+		}
+	}()
+
+	if s.dialect.lock != "" {
+		if _, err := tx.ExecContext(ctx, s.dialect.lock); err != nil {
+			return fmt.Errorf("failed to lock migrations: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS anicetus_schema_migrations (
+  version INTEGER PRIMARY KEY
+)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM anicetus_schema_migrations").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	for ; version < len(s.dialect.migrations); version++ {
+		for _, statement := range s.dialect.migrations[version] {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("failed to migrate schema to version %d: %w", version+1, err)
+			}
+		}
+		_, err := tx.ExecContext(ctx, s.dialect.rebind("INSERT INTO anicetus_schema_migrations (version) VALUES (?)"),
+			version+1,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store schema version %d: %w", version+1, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Exists checks if the fingerprint exists in the storage.
+func (s *SQL) Exists(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	var exists int
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT 1 FROM anicetus_gates
+WHERE fingerprint = ? AND (expires_at IS NULL OR expires_at > `+s.dialect.now+`)`),
+		fingerprint.String(),
+	).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to query gate: %w", err)
+	}
+	return true, nil
+}
+
+// Processed checks if the fingerprint was processed.
+func (s *SQL) Processed(ctx context.Context, fingerprint anicetus.Fingerprint) (bool, error) {
+	var processed bool
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT processed FROM anicetus_gates
+WHERE fingerprint = ? AND (expires_at IS NULL OR expires_at > `+s.dialect.now+`)`),
+		fingerprint.String(),
+	).Scan(&processed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to query gate: %w", err)
+	}
+	return processed, nil
+}
+
+// Store stores the fingerprint in the storage.
+func (s *SQL) Store(ctx context.Context, fingerprint anicetus.Fingerprint, processed bool) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO anicetus_gates
+  (fingerprint, processed, token, expires_at) VALUES (?, ?, 0, `+s.expiresAt()+`)
+ON CONFLICT (fingerprint) DO UPDATE SET
+  processed = excluded.processed,
+  token = CASE WHEN `+s.expired("anicetus_gates")+` THEN 0 ELSE anicetus_gates.token END,
+  expires_at = excluded.expires_at`),
+		fingerprint.String(),
+		processed,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store gate: %w", err)
+	}
+	return nil
+}
+
+// Remove removes the fingerprint from the storage.
+func (s *SQL) Remove(ctx context.Context, fingerprint anicetus.Fingerprint) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind("DELETE FROM anicetus_gates WHERE fingerprint = ?"),
+		fingerprint.String(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove gate: %w", err)
+	}
+	return nil
+}
+
+// Acquire stores the fingerprint as not processed if it doesn't exist yet (or
+// if it's expired), returning a new fencing token.
+func (s *SQL) Acquire(ctx context.Context, fingerprint anicetus.Fingerprint) (anicetus.FencingToken, bool, error) {
+	var token int64
+	if err := s.db.QueryRowContext(ctx, s.dialect.nextToken).Scan(&token); err != nil {
+		return 0, false, fmt.Errorf("failed to generate fencing token: %w", err)
+	}
+
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(`INSERT INTO anicetus_gates
+  (fingerprint, processed, token, expires_at) VALUES (?, ?, ?, `+s.expiresAt()+`)
+ON CONFLICT (fingerprint) DO UPDATE SET
+  processed = excluded.processed,
+  token = excluded.token,
+  expires_at = excluded.expires_at
+WHERE `+s.expired("anicetus_gates")),
+		fingerprint.String(),
+		false,
+		token,
+	)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to acquire gate: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to check acquired gate: %w", err)
+	}
+	if affected == 0 {
+		return 0, false, nil
+	}
+	return anicetus.FencingToken(token), true, nil
+}
+
+// StoreWithToken stores the fingerprint in the storage if the token still owns
+// the gate.
+func (s *SQL) StoreWithToken(
+	ctx context.Context,
+	fingerprint anicetus.Fingerprint,
+	processed bool,
+	token anicetus.FencingToken,
+) error {
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(`UPDATE anicetus_gates
+SET processed = ?, expires_at = `+s.expiresAt()+`
+WHERE fingerprint = ? AND token = ? AND NOT (`+s.expired("anicetus_gates")+`)`),
+		processed,
+		fingerprint.String(),
+		int64(token),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store gate: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check stored gate: %w", err)
+	}
+	if affected == 0 {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	return nil
+}
+
+// RemoveWithToken removes the fingerprint from the storage if the token still
+// owns the gate.
+func (s *SQL) RemoveWithToken(ctx context.Context, fingerprint anicetus.Fingerprint, token anicetus.FencingToken) error {
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM anicetus_gates
+WHERE fingerprint = ? AND (token = ? OR `+s.expired("anicetus_gates")+`)`),
+		fingerprint.String(),
+		int64(token),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove gate: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check removed gate: %w", err)
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	// nothing was removed, the gate doesn't exist or belongs to another leader
+	exists, err := s.Exists(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return &anicetus.StaleTokenError{Fingerprint: fingerprint, Token: token}
+	}
+	return nil
+}
+
+// Purge removes all the expired gates, returning the number of removed gates.
+// It's called periodically when a TTL is configured, but can also be used by an
+// external cleanup job.
+func (s *SQL) Purge(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM anicetus_gates WHERE "+s.expired("anicetus_gates"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge gates: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to check purged gates: %w", err)
+	}
+	return purged, nil
+}
+
+// Stop stops purging the expired gates.
+func (s *SQL) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *SQL) start() {
+	go func() {
+		ticker := time.NewTicker(s.ttl)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := s.Purge(context.Background()); err != nil && s.logger != nil {
+				s.logger.Error("failed to purge expired gates", slog.String("error", err.Error()))
+			}
+		}
+	}()
+}
+
+// expiresAt returns the SQL expression of the expiration for a gate being
+// written now.
+func (s *SQL) expiresAt() string {
+	if s.ttl <= 0 {
+		return "NULL"
+	}
+	return fmt.Sprintf("(%s + %d)", s.dialect.now, s.ttl.Milliseconds())
+}
+
+// expired returns the SQL condition that checks if the gate of the table is
+// expired.
+func (s *SQL) expired(table string) string {
+	return fmt.Sprintf("(%[1]s.expires_at IS NOT NULL AND %[1]s.expires_at <= %[2]s)", table, s.dialect.now)
+}
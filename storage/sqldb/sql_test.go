@@ -0,0 +1,271 @@
+package sqldb_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+	"github.com/rafaeljusto/anicetus/v2/storage"
+	"github.com/rafaeljusto/anicetus/v2/storage/sqldb"
+	"github.com/rafaeljusto/anicetus/v2/storage/storagetest"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQL_lifecycle(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := newSQL(t, openDB(t))
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("unexpected fingerprint exists")
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("unexpected fingerprint processed")
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should exists")
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be processed")
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not exists")
+	}
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSQL_fencing(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := newSQL(t, openDB(t))
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should not be acquired twice")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	newToken, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	} else if newToken <= token {
+		t.Errorf("fencing token should increase: got %d, previous %d", newToken, token)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSQL_ttl(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	storage := newSQL(t, openDB(t), storage.WithTTL(100*time.Millisecond))
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !ok {
+		t.Fatal("fingerprint should be acquired")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if ok {
+		t.Error("fingerprint should be expired")
+	}
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be acquired after expiration")
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSQL_purge(t *testing.T) {
+	db := openDB(t)
+	storage := newSQL(t, db, storage.WithTTL(time.Hour))
+	storage.Stop()
+
+	if err := storage.Store(t.Context(), anicetus.Fingerprint("test"), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err := db.ExecContext(t.Context(), "UPDATE anicetus_gates SET expires_at = 0")
+	if err != nil {
+		t.Fatalf("failed to expire gates: %v", err)
+	}
+
+	if purged, err := storage.Purge(t.Context()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if purged != 1 {
+		t.Errorf("unexpected number of purged gates: %d", purged)
+	}
+
+	if purged, err := storage.Purge(t.Context()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if purged != 0 {
+		t.Errorf("unexpected number of purged gates: %d", purged)
+	}
+}
+
+func TestSQL_migrate(t *testing.T) {
+	db := openDB(t)
+	storage := newSQL(t, db)
+
+	if err := storage.Store(t.Context(), anicetus.Fingerprint("test"), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// migrating again must keep the existing schema and gates
+	if err := storage.Migrate(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ok, err := storage.Processed(t.Context(), anicetus.Fingerprint("test")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if !ok {
+		t.Error("fingerprint should be processed")
+	}
+}
+
+func TestSQL_singleLeader(t *testing.T) {
+	fingerprint := anicetus.Fingerprint("test")
+
+	db := openDB(t)
+	replicas := []*sqldb.SQL{newSQL(t, db), newSQL(t, db), newSQL(t, db)}
+
+	var leaders atomic.Int32
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func(storage *sqldb.SQL) {
+			defer wg.Done()
+
+			_, ok, err := storage.Acquire(t.Context(), fingerprint)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if ok {
+				leaders.Add(1)
+			}
+		}(replicas[i%len(replicas)])
+	}
+	wg.Wait()
+
+	if n := leaders.Load(); n != 1 {
+		t.Errorf("unexpected number of leaders: %d", n)
+	}
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "anicetus.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func newSQL(t *testing.T, db *sql.DB, options ...storage.Option) *sqldb.SQL {
+	t.Helper()
+
+	storage := sqldb.NewSQL(db, sqldb.DialectSQLite, options...)
+	t.Cleanup(storage.Stop)
+
+	if err := storage.Migrate(t.Context()); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return storage
+}
+
+func TestSQL_conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) anicetus.GatekeeperStorage {
+		return newSQL(t, openDB(t))
+	})
+}
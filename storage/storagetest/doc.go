@@ -0,0 +1,12 @@
+// Package storagetest provides a conformance test suite for
+// anicetus.GatekeeperStorage implementations. Optional interfaces implemented by
+// the storage, like anicetus.FencedGatekeeperStorage, are also verified.
+//
+// Usage:
+//
+//	func TestMyStorage(t *testing.T) {
+//	  storagetest.Run(t, func(t *testing.T) anicetus.GatekeeperStorage {
+//	    return NewMyStorage()
+//	  })
+//	}
+package storagetest
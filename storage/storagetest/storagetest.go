@@ -0,0 +1,380 @@
+package storagetest
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rafaeljusto/anicetus/v2"
+)
+
+// concurrentRequests is the number of requests competing for the same gate in
+// the concurrency tests.
+const concurrentRequests = 50
+
+// NewStorage creates an empty storage for each test. Resources should be
+// released using t.Cleanup.
+type NewStorage func(t *testing.T) anicetus.GatekeeperStorage
+
+// Run runs the conformance test suite against the storage. Tests for optional
+// interfaces not supported by the storage (see anicetus.As) are skipped.
+func Run(t *testing.T, newStorage NewStorage) {
+	t.Helper()
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		TestLifecycle(t, newStorage(t))
+	})
+	t.Run("RemoveIdempotent", func(t *testing.T) {
+		TestRemoveIdempotent(t, newStorage(t))
+	})
+	t.Run("Fencing", func(t *testing.T) {
+		TestFencing(t, fenced(t, newStorage(t)))
+	})
+	t.Run("SingleLeader", func(t *testing.T) {
+		TestSingleLeader(t, fenced(t, newStorage(t)))
+	})
+	t.Run("Inspect", func(t *testing.T) {
+		storage := newStorage(t)
+		inspector, ok := anicetus.As[anicetus.GatekeeperStorageInspector](storage)
+		if !ok {
+			t.Skip("storage doesn't support anicetus.GatekeeperStorageInspector")
+		}
+		TestInspect(t, fenced(t, storage), inspector)
+	})
+	t.Run("CountWaiter", func(t *testing.T) {
+		storage := newStorage(t)
+		inspector, ok := anicetus.As[anicetus.GatekeeperStorageInspector](storage)
+		if !ok {
+			t.Skip("storage doesn't support anicetus.GatekeeperStorageInspector")
+		}
+		waiterCounter, ok := anicetus.As[anicetus.GatekeeperStorageWaiterCounter](storage)
+		if !ok {
+			t.Skip("storage doesn't support anicetus.GatekeeperStorageWaiterCounter")
+		}
+		TestCountWaiter(t, fenced(t, storage), inspector, waiterCounter)
+	})
+	t.Run("Subscribe", func(t *testing.T) {
+		storage := newStorage(t)
+		subscriber, ok := anicetus.As[anicetus.GatekeeperStorageSubscriber](storage)
+		if !ok {
+			t.Skip("storage doesn't support anicetus.GatekeeperStorageSubscriber")
+		}
+		TestSubscribe(t, fenced(t, storage), subscriber)
+	})
+}
+
+// TestLifecycle checks storing, processing and removing a gate.
+func TestLifecycle(t *testing.T, storage anicetus.GatekeeperStorage) {
+	fingerprint := anicetus.Fingerprint("lifecycle")
+
+	expectGate(t, storage, fingerprint, false, false)
+
+	if err := storage.Store(t.Context(), fingerprint, false); err != nil {
+		t.Fatalf("unexpected error storing gate: %v", err)
+	}
+	expectGate(t, storage, fingerprint, true, false)
+
+	if err := storage.Store(t.Context(), fingerprint, true); err != nil {
+		t.Fatalf("unexpected error storing gate: %v", err)
+	}
+	expectGate(t, storage, fingerprint, true, true)
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Fatalf("unexpected error removing gate: %v", err)
+	}
+	expectGate(t, storage, fingerprint, false, false)
+}
+
+// TestRemoveIdempotent checks that removing a missing gate doesn't fail.
+func TestRemoveIdempotent(t *testing.T, storage anicetus.GatekeeperStorage) {
+	fingerprint := anicetus.Fingerprint("remove")
+
+	if err := storage.Remove(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error removing missing gate: %v", err)
+	}
+
+	if err := storage.Store(t.Context(), fingerprint, true); err != nil {
+		t.Fatalf("unexpected error storing gate: %v", err)
+	}
+	for range 2 {
+		if err := storage.Remove(t.Context(), fingerprint); err != nil {
+			t.Errorf("unexpected error removing gate: %v", err)
+		}
+	}
+	expectGate(t, storage, fingerprint, false, false)
+
+	if fencedStorage, ok := anicetus.As[anicetus.FencedGatekeeperStorage](storage); ok {
+		if err := fencedStorage.RemoveWithToken(t.Context(), fingerprint, 1); err != nil {
+			t.Errorf("unexpected error removing missing gate with token: %v", err)
+		}
+	}
+}
+
+// TestFencing checks that operations with stale fencing tokens are rejected.
+func TestFencing(t *testing.T, storage anicetus.FencedGatekeeperStorage) {
+	fingerprint := anicetus.Fingerprint("fencing")
+
+	token := acquire(t, storage, fingerprint)
+	expectGate(t, storage, fingerprint, true, false)
+
+	if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error acquiring gate: %v", err)
+	} else if ok {
+		t.Error("gate should not be acquired twice")
+	}
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Fatalf("unexpected error removing gate: %v", err)
+	}
+	expectGate(t, storage, fingerprint, false, false)
+
+	newToken := acquire(t, storage, fingerprint)
+	if newToken <= token {
+		t.Errorf("fencing token should increase: got %d, previous %d", newToken, token)
+	}
+
+	var staleTokenErr *anicetus.StaleTokenError
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("expected stale token error storing gate, got: %v", err)
+	} else if staleTokenErr.Fingerprint != fingerprint || staleTokenErr.Token != token {
+		t.Errorf("unexpected stale token error: %+v", staleTokenErr)
+	}
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); !errors.As(err, &staleTokenErr) {
+		t.Errorf("expected stale token error removing gate, got: %v", err)
+	}
+	expectGate(t, storage, fingerprint, true, false)
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, newToken); err != nil {
+		t.Fatalf("unexpected error storing gate: %v", err)
+	}
+	expectGate(t, storage, fingerprint, true, true)
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, newToken); err != nil {
+		t.Fatalf("unexpected error removing gate: %v", err)
+	}
+	expectGate(t, storage, fingerprint, false, false)
+}
+
+// TestSingleLeader checks that only one of many concurrent requests acquires
+// the gate.
+func TestSingleLeader(t *testing.T, storage anicetus.FencedGatekeeperStorage) {
+	fingerprint := anicetus.Fingerprint("leader")
+
+	var leaders atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range concurrentRequests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			if _, ok, err := storage.Acquire(t.Context(), fingerprint); err != nil {
+				t.Errorf("unexpected error acquiring gate: %v", err)
+			} else if ok {
+				leaders.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := leaders.Load(); n != 1 {
+		t.Errorf("unexpected number of leaders: %d", n)
+	}
+}
+
+// TestInspect checks listing and inspecting the gates.
+func TestInspect(t *testing.T, storage anicetus.FencedGatekeeperStorage, inspector anicetus.GatekeeperStorageInspector) {
+	if _, ok, err := inspector.InspectGate(t.Context(), "missing"); err != nil {
+		t.Errorf("unexpected error inspecting gate: %v", err)
+	} else if ok {
+		t.Error("missing gate should not be found")
+	}
+
+	tokens := make(map[anicetus.Fingerprint]anicetus.FencingToken)
+	for _, fingerprint := range []anicetus.Fingerprint{"a", "b", "c"} {
+		tokens[fingerprint] = acquire(t, storage, fingerprint)
+	}
+	if err := storage.StoreWithToken(t.Context(), "b", true, tokens["b"]); err != nil {
+		t.Fatalf("unexpected error storing gate: %v", err)
+	}
+
+	gate, ok, err := inspector.InspectGate(t.Context(), "b")
+	if err != nil {
+		t.Fatalf("unexpected error inspecting gate: %v", err)
+	} else if !ok {
+		t.Fatal("gate should be found")
+	}
+	if gate.Fingerprint != "b" || !gate.Processed || gate.Token != tokens["b"] || gate.LeaderAge < 0 {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+
+	listed := make(map[anicetus.Fingerprint]anicetus.GateState)
+	var cursor string
+	for range 100 {
+		var gates []anicetus.GateState
+		gates, cursor, err = inspector.ListGates(t.Context(), cursor, 1)
+		if err != nil {
+			t.Fatalf("unexpected error listing gates: %v", err)
+		}
+		for _, gate := range gates {
+			listed[gate.Fingerprint] = gate
+		}
+		if cursor == "" {
+			break
+		}
+	}
+	if cursor != "" {
+		t.Fatal("listing gates didn't finish")
+	}
+
+	if len(listed) != len(tokens) {
+		t.Errorf("unexpected listed gates: %+v", listed)
+	}
+	for fingerprint, token := range tokens {
+		gate, ok := listed[fingerprint]
+		if !ok {
+			t.Errorf("gate %q not listed", fingerprint)
+			continue
+		}
+		if gate.Token != token || gate.Processed != (fingerprint == "b") {
+			t.Errorf("unexpected listed gate state: %+v", gate)
+		}
+	}
+}
+
+// TestCountWaiter checks that only CountWaiter changes the number of waiters,
+// so checking the gate state is free of side effects. Storages may count the
+// waiters in background, so the expected count is awaited.
+func TestCountWaiter(
+	t *testing.T,
+	storage anicetus.FencedGatekeeperStorage,
+	inspector anicetus.GatekeeperStorageInspector,
+	waiterCounter anicetus.GatekeeperStorageWaiterCounter,
+) {
+	fingerprint := anicetus.Fingerprint("waiters")
+
+	if err := waiterCounter.CountWaiter(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error counting waiter of missing gate: %v", err)
+	}
+
+	acquire(t, storage, fingerprint)
+	for range 2 {
+		expectGate(t, storage, fingerprint, true, false)
+	}
+	if gate, ok, err := inspector.InspectGate(t.Context(), fingerprint); err != nil {
+		t.Fatalf("unexpected error inspecting gate: %v", err)
+	} else if !ok || gate.Waiters != 0 {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+
+	for range 2 {
+		if err := waiterCounter.CountWaiter(t.Context(), fingerprint); err != nil {
+			t.Fatalf("unexpected error counting waiter: %v", err)
+		}
+	}
+
+	var gate anicetus.GateState
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		if gate, _, err = inspector.InspectGate(t.Context(), fingerprint); err != nil {
+			t.Fatalf("unexpected error inspecting gate: %v", err)
+		} else if gate.Waiters == 2 {
+			break
+		}
+	}
+	if gate.Waiters != 2 {
+		t.Errorf("unexpected gate state: %+v", gate)
+	}
+}
+
+// TestSubscribe checks that the gate transitions are notified.
+func TestSubscribe(t *testing.T, storage anicetus.FencedGatekeeperStorage, subscriber anicetus.GatekeeperStorageSubscriber) {
+	fingerprint := anicetus.Fingerprint("subscribe")
+
+	events, err := subscriber.Subscribe(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error subscribing: %v", err)
+	}
+
+	token := acquire(t, storage, fingerprint)
+	expectGateEvent(t, events, anicetus.GateEvent{
+		Fingerprint: fingerprint,
+		Type:        anicetus.GateEventElected,
+		Token:       token,
+	})
+
+	if err := storage.StoreWithToken(t.Context(), fingerprint, true, token); err != nil {
+		t.Fatalf("unexpected error storing gate: %v", err)
+	}
+	expectGateEvent(t, events, anicetus.GateEvent{
+		Fingerprint: fingerprint,
+		Type:        anicetus.GateEventDone,
+		Token:       token,
+	})
+
+	if err := storage.RemoveWithToken(t.Context(), fingerprint, token); err != nil {
+		t.Fatalf("unexpected error removing gate: %v", err)
+	}
+	expectGateEvent(t, events, anicetus.GateEvent{
+		Fingerprint: fingerprint,
+		Type:        anicetus.GateEventCleanup,
+		Token:       token,
+	})
+}
+
+func fenced(t *testing.T, storage anicetus.GatekeeperStorage) anicetus.FencedGatekeeperStorage {
+	t.Helper()
+
+	fencedStorage, ok := anicetus.As[anicetus.FencedGatekeeperStorage](storage)
+	if !ok {
+		t.Skip("storage doesn't support anicetus.FencedGatekeeperStorage")
+	}
+	return fencedStorage
+}
+
+func acquire(t *testing.T, storage anicetus.FencedGatekeeperStorage, fingerprint anicetus.Fingerprint) anicetus.FencingToken {
+	t.Helper()
+
+	token, ok, err := storage.Acquire(t.Context(), fingerprint)
+	if err != nil {
+		t.Fatalf("unexpected error acquiring gate: %v", err)
+	} else if !ok {
+		t.Fatalf("gate %q should be acquired", fingerprint)
+	} else if token == 0 {
+		t.Fatalf("gate %q acquired without fencing token", fingerprint)
+	}
+	return token
+}
+
+func expectGate(t *testing.T, storage anicetus.GatekeeperStorage, fingerprint anicetus.Fingerprint, exists, processed bool) {
+	t.Helper()
+
+	if ok, err := storage.Exists(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error checking gate: %v", err)
+	} else if ok != exists {
+		t.Errorf("unexpected gate existence: got %v, want %v", ok, exists)
+	}
+
+	if ok, err := storage.Processed(t.Context(), fingerprint); err != nil {
+		t.Errorf("unexpected error checking processed gate: %v", err)
+	} else if ok != processed {
+		t.Errorf("unexpected gate processed flag: got %v, want %v", ok, processed)
+	}
+}
+
+func expectGateEvent(t *testing.T, events <-chan anicetus.GateEvent, want anicetus.GateEvent) {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		if event != want {
+			t.Errorf("unexpected event: got %+v, want %+v", event, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("event not received: %+v", want)
+	}
+}